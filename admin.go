@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// requireAdmin guards operator-only endpoints behind the ADMIN_TOKEN bearer
+// token. When ADMIN_TOKEN is unset every request is rejected.
+func requireAdmin(config Config) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !isAdmin(r, config) {
+				writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+func isAdmin(r *http.Request, config Config) bool {
+	token := config.AdminToken
+	if token == "" {
+		return false
+	}
+
+	provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
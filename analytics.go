@@ -0,0 +1,307 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxAnalyticsPeriod        = 366 * 24 * time.Hour
+	defaultAnalyticsStats     = 7 * 24 * time.Hour
+	defaultAnalyticsRetention = 400 * 24 * time.Hour
+	analyticsPruneInterval    = time.Hour
+	analyticsTopN             = 10
+)
+
+// PageViewRequest is the body of /analytics/event. It is small enough to be
+// sent with navigator.sendBeacon.
+type PageViewRequest struct {
+	Path        string `json:"path"`
+	Referrer    string `json:"referrer,omitempty"`
+	UTMSource   string `json:"utm_source,omitempty"`
+	UTMMedium   string `json:"utm_medium,omitempty"`
+	UTMCampaign string `json:"utm_campaign,omitempty"`
+}
+
+// PageView is a stored event. Visitor identifies a visitor for one day
+// only; see visitorHash.
+type PageView struct {
+	Time        time.Time `json:"time"`
+	Path        string    `json:"path"`
+	Referrer    string    `json:"referrer,omitempty"`
+	UTMSource   string    `json:"utm_source,omitempty"`
+	UTMMedium   string    `json:"utm_medium,omitempty"`
+	UTMCampaign string    `json:"utm_campaign,omitempty"`
+	Visitor     string    `json:"visitor"`
+}
+
+// pageViewStore keeps events in the database for the retention
+// (ANALYTICS_RETENTION), which is longer than the longest stats period.
+type pageViewStore struct {
+	db        *sql.DB
+	retention time.Duration
+	mu        sync.Mutex
+	salt      []byte
+	saltOn    string
+	prunedAt  time.Time
+}
+
+var pageViews = &pageViewStore{db: database, retention: defaultAnalyticsRetention}
+
+// visitorHash pseudonymizes a visitor as a hash of their IP address and
+// user agent under a random salt that is replaced every day and never
+// stored, so visitors can't be followed from one day to the next or
+// recovered from the stored events. A restart also starts a new salt.
+func (s *pageViewStore) visitorHash(day, ip, userAgent string) string {
+	s.mu.Lock()
+	if s.saltOn != day {
+		s.salt = make([]byte, 32)
+		rand.Read(s.salt)
+		s.saltOn = day
+	}
+	salt := s.salt
+	s.mu.Unlock()
+
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(ip + "\x00" + userAgent))
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// record stores view under the app-timezone date it happened on, which is
+// the day its visitor hash is valid for, and now and then deletes the
+// events that have outlived the retention.
+func (s *pageViewStore) record(view PageView) {
+	_, err := s.db.Exec(`INSERT INTO page_views
+		(viewed_at, day, path, referrer, utm_source, utm_medium, utm_campaign, visitor)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		view.Time.UnixMilli(), view.Time.In(appLocation).Format("2006-01-02"), view.Path,
+		view.Referrer, view.UTMSource, view.UTMMedium, view.UTMCampaign, view.Visitor)
+	if err != nil {
+		log.Printf("Error writing page view: %v", err)
+	}
+
+	s.mu.Lock()
+	due := time.Since(s.prunedAt) >= analyticsPruneInterval
+	if due {
+		s.prunedAt = time.Now()
+	}
+	s.mu.Unlock()
+	if due {
+		s.prune(time.Now().Add(-s.retention))
+	}
+}
+
+// prune deletes the events recorded before cutoff.
+func (s *pageViewStore) prune(cutoff time.Time) {
+	if _, err := s.db.Exec(`DELETE FROM page_views WHERE viewed_at < ?`, cutoff.UnixMilli()); err != nil {
+		log.Printf("Error pruning page views: %v", err)
+	}
+}
+
+// summarize aggregates the events since from, for one path or, when path
+// is empty, every path. Only the counts fields of the result are set.
+func (s *pageViewStore) summarize(from time.Time, path string) (AnalyticsStats, error) {
+	filter := `viewed_at >= ?`
+	args := []interface{}{from.UnixMilli()}
+	if path != "" {
+		filter += ` AND path = ?`
+		args = append(args, path)
+	}
+
+	var stats AnalyticsStats
+	rows, err := s.db.Query(`SELECT day, COUNT(*), COUNT(DISTINCT visitor) FROM page_views
+		WHERE `+filter+` GROUP BY day ORDER BY day`, args...)
+	if err != nil {
+		return stats, fmt.Errorf("error reading page views: %v", err)
+	}
+	defer rows.Close()
+	stats.Days = []AnalyticsDay{}
+	for rows.Next() {
+		var day AnalyticsDay
+		if err := rows.Scan(&day.Date, &day.Views, &day.Visitors); err != nil {
+			return stats, fmt.Errorf("error reading page views: %v", err)
+		}
+		stats.Days = append(stats.Days, day)
+		stats.Views += day.Views
+		stats.Visitors += day.Visitors
+	}
+	if err := rows.Err(); err != nil {
+		return stats, fmt.Errorf("error reading page views: %v", err)
+	}
+
+	top := func(column string) ([]AnalyticsCount, error) {
+		rows, err := s.db.Query(`SELECT `+column+`, COUNT(*) AS n FROM page_views
+			WHERE `+filter+` AND `+column+` != '' GROUP BY `+column+` ORDER BY n DESC, `+column+` LIMIT ?`,
+			append(args, analyticsTopN)...)
+		if err != nil {
+			return nil, fmt.Errorf("error reading page views: %v", err)
+		}
+		defer rows.Close()
+		counts := []AnalyticsCount{}
+		for rows.Next() {
+			var c AnalyticsCount
+			if err := rows.Scan(&c.Value, &c.Count); err != nil {
+				return nil, fmt.Errorf("error reading page views: %v", err)
+			}
+			counts = append(counts, c)
+		}
+		return counts, rows.Err()
+	}
+	if path == "" {
+		if stats.TopPaths, err = top("path"); err != nil {
+			return stats, err
+		}
+	}
+	if stats.TopReferrers, err = top("referrer"); err != nil {
+		return stats, err
+	}
+	stats.TopSources, err = top("utm_source")
+	return stats, err
+}
+
+// referrerHost keeps only the host of a referrer, so full URLs, which can
+// carry search terms or tokens, are never stored.
+func referrerHost(referrer string) string {
+	u, err := url.Parse(strings.TrimSpace(referrer))
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+func handleAnalyticsEvent(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var req PageViewRequest
+	if !decodeBody(w, r, &req, config) {
+		return
+	}
+
+	// Visitors who ask not to be tracked get the same response, but nothing
+	// is stored.
+	if r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Query strings and fragments are dropped for the same reason as
+	// referrer URLs.
+	path, _, _ := strings.Cut(strings.TrimSpace(req.Path), "?")
+	path, _, _ = strings.Cut(path, "#")
+	if !strings.HasPrefix(path, "/") || len(path) > 512 {
+		writeError(w, http.StatusBadRequest, "invalid_path", "Path must start with / and be at most 512 characters")
+		return
+	}
+	truncate := func(s string) string {
+		if s = strings.TrimSpace(s); len(s) > 100 {
+			return s[:100]
+		}
+		return s
+	}
+
+	now := time.Now().UTC()
+	pageViews.record(PageView{
+		Time:        now,
+		Path:        path,
+		Referrer:    referrerHost(req.Referrer),
+		UTMSource:   truncate(req.UTMSource),
+		UTMMedium:   truncate(req.UTMMedium),
+		UTMCampaign: truncate(req.UTMCampaign),
+		Visitor:     pageViews.visitorHash(now.In(appLocation).Format("2006-01-02"), clientIP(r), r.UserAgent()),
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type AnalyticsCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+type AnalyticsDay struct {
+	Date     string `json:"date"`
+	Views    int    `json:"views"`
+	Visitors int    `json:"visitors"`
+}
+
+// AnalyticsStats aggregates page views over a period. Visitors are counted
+// per day, since visitor hashes change daily, so Visitors is the sum of
+// the daily counts.
+type AnalyticsStats struct {
+	Path         string           `json:"path,omitempty"`
+	Period       string           `json:"period"`
+	From         time.Time        `json:"from"`
+	Views        int              `json:"views"`
+	Visitors     int              `json:"visitors"`
+	Days         []AnalyticsDay   `json:"days"`
+	TopPaths     []AnalyticsCount `json:"top_paths,omitempty"`
+	TopReferrers []AnalyticsCount `json:"top_referrers"`
+	TopSources   []AnalyticsCount `json:"top_utm_sources"`
+}
+
+// parseAnalyticsPeriod accepts a number of days such as "7d", or any Go
+// duration such as "12h".
+func parseAnalyticsPeriod(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultAnalyticsStats, nil
+	}
+	var d time.Duration
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(value); err != nil {
+			return 0, err
+		}
+	}
+	if d <= 0 || d > maxAnalyticsPeriod {
+		return 0, fmt.Errorf("period out of range")
+	}
+	return d, nil
+}
+
+func handleAnalyticsStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	period := r.URL.Query().Get("period")
+	d, err := parseAnalyticsPeriod(period)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_period", "Period must be a number of days such as 7d, up to 366d")
+		return
+	}
+	if period == "" {
+		period = "7d"
+	}
+	path := r.URL.Query().Get("path")
+
+	from := time.Now().UTC().Add(-d)
+	stats, err := pageViews.summarize(from, path)
+	if err != nil {
+		log.Printf("Error reading analytics: %v", err)
+		writeError(w, http.StatusInternalServerError, "analytics_unavailable", "Could not read analytics")
+		return
+	}
+	stats.Path, stats.Period, stats.From = path, period, from
+
+	writeJSON(w, http.StatusOK, stats)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pageView posts an /analytics/event from the visitor at remoteAddr with
+// userAgent.
+func pageView(remoteAddr, userAgent, body string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/analytics/event", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	req.RemoteAddr = remoteAddr
+	rec := httptest.NewRecorder()
+	handleAnalyticsEvent(rec, req, testConfig)
+	return rec
+}
+
+func analyticsStats(t *testing.T, query string) AnalyticsStats {
+	t.Helper()
+	rec := serve(handleAnalyticsStats, http.MethodGet, "/analytics/stats"+query, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("stats%s: status = %d: %s", query, rec.Code, rec.Body)
+	}
+	var stats AnalyticsStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	return stats
+}
+
+func TestAnalyticsEventIsStoredPrivately(t *testing.T) {
+	useDatabase(t)
+
+	rec := pageView("192.0.2.1:1000", "Firefox", `{"path":"/blog/post?q=secret#top","referrer":"https://www.Example.org/search?q=secret","utm_source":"newsletter"}`)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	var path, referrer, source, visitor string
+	err := database.QueryRow(`SELECT path, referrer, utm_source, visitor FROM page_views`).Scan(&path, &referrer, &source, &visitor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/blog/post" || referrer != "example.org" || source != "newsletter" {
+		t.Errorf("stored %q %q %q, want the path and referrer host without query strings", path, referrer, source)
+	}
+	if visitor == "" || strings.Contains(visitor, "192.0.2.1") {
+		t.Errorf("visitor = %q, want a hash", visitor)
+	}
+}
+
+func TestAnalyticsEventHonoursDoNotTrack(t *testing.T) {
+	useDatabase(t)
+
+	for _, header := range []string{"DNT", "Sec-GPC"} {
+		if rec := pageView("192.0.2.1:1000", "Firefox", `{"path":"/"}`, header, "1"); rec.Code != http.StatusNoContent {
+			t.Errorf("%s: status = %d, want 204", header, rec.Code)
+		}
+	}
+	if stats := analyticsStats(t, ""); stats.Views != 0 {
+		t.Errorf("views = %d, want nothing stored", stats.Views)
+	}
+}
+
+func TestAnalyticsEventRejectsBadPaths(t *testing.T) {
+	useDatabase(t)
+
+	for _, body := range []string{`{"path":""}`, `{"path":"blog"}`, `{"path":"/` + strings.Repeat("a", 512) + `"}`} {
+		rec := pageView("192.0.2.1:1000", "Firefox", body)
+		if rec.Code != http.StatusBadRequest || decodeError(t, rec).Code != "invalid_path" {
+			t.Errorf("%.40s: got %d %s, want 400 invalid_path", body, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestAnalyticsStatsAggregates(t *testing.T) {
+	useDatabase(t)
+
+	pageView("192.0.2.1:1000", "Firefox", `{"path":"/","referrer":"https://news.example.com/","utm_source":"hn"}`)
+	pageView("192.0.2.1:1000", "Firefox", `{"path":"/about"}`)
+	pageView("192.0.2.2:1000", "Safari", `{"path":"/","referrer":"https://news.example.com/"}`)
+	pageView("192.0.2.2:1000", "Safari", `{"path":"/"}`)
+
+	stats := analyticsStats(t, "?period=7d")
+	if stats.Period != "7d" || stats.Views != 4 || stats.Visitors != 2 {
+		t.Errorf("period %s: %d views by %d visitors, want 4 by 2", stats.Period, stats.Views, stats.Visitors)
+	}
+	if len(stats.Days) != 1 || stats.Days[0].Views != 4 || stats.Days[0].Visitors != 2 {
+		t.Errorf("days = %+v, want one day with 4 views by 2 visitors", stats.Days)
+	}
+	if len(stats.TopPaths) != 2 || stats.TopPaths[0] != (AnalyticsCount{"/", 3}) || stats.TopPaths[1] != (AnalyticsCount{"/about", 1}) {
+		t.Errorf("top paths = %+v", stats.TopPaths)
+	}
+	if len(stats.TopReferrers) != 1 || stats.TopReferrers[0] != (AnalyticsCount{"news.example.com", 2}) {
+		t.Errorf("top referrers = %+v, want empty referrers left out", stats.TopReferrers)
+	}
+	if len(stats.TopSources) != 1 || stats.TopSources[0] != (AnalyticsCount{"hn", 1}) {
+		t.Errorf("top sources = %+v", stats.TopSources)
+	}
+
+	about := analyticsStats(t, "?path=/about")
+	if about.Path != "/about" || about.Views != 1 || about.Visitors != 1 || about.TopPaths != nil {
+		t.Errorf("/about stats = %+v", about)
+	}
+}
+
+func TestAnalyticsStatsRejectsBadPeriods(t *testing.T) {
+	for _, period := range []string{"0d", "367d", "week", "-1h"} {
+		rec := serve(handleAnalyticsStats, http.MethodGet, "/analytics/stats?period="+period, "")
+		if rec.Code != http.StatusBadRequest || decodeError(t, rec).Code != "invalid_period" {
+			t.Errorf("period %s: got %d %s, want 400 invalid_period", period, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestAnalyticsPrunesExpiredViews(t *testing.T) {
+	useDatabase(t)
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	pageViews.record(PageView{Time: old, Path: "/old", Visitor: "a"})
+	pageViews.record(PageView{Time: time.Now(), Path: "/new", Visitor: "b"})
+
+	pageViews.prune(time.Now().Add(-24 * time.Hour))
+	if stats := analyticsStats(t, "?period=366d"); stats.Views != 1 || stats.TopPaths[0].Value != "/new" {
+		t.Errorf("stats = %+v, want only the recent view", stats)
+	}
+}
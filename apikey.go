@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAPISignatureTolerance = 5 * time.Minute
+	defaultAPISignatureMaxBytes  = 16 << 20
+)
+
+// maxNonceLength bounds X-Nonce, since every nonce is kept for the
+// signature window.
+const maxNonceLength = 128
+
+// usedSignatures and usedNonces remember accepted request signatures and
+// nonces for as long as their timestamp is acceptable, so a captured
+// request can't be replayed. Both are capped at CACHE_MAX_ENTRIES, and once
+// full they turn signed requests away rather than forget an entry that a
+// replay could then reuse.
+var (
+	usedSignatures = newDedupStore("api_signatures")
+	usedNonces     = newDedupStore("api_nonces")
+)
+
+// apiKey is one API_KEYS entry. A key with no routes may call every route
+// that requires a key.
+type apiKey struct {
+	id     string
+	secret string
+	routes []string
+}
+
+func (k apiKey) allows(path string) bool {
+	return len(k.routes) == 0 || slices.Contains(k.routes, path)
+}
+
+// parseAPIKeys parses API_KEYS, a comma-separated list of id:secret entries
+// optionally scoped to routes with a third |-separated field, such as
+// "web:s3cret:/send|/send/photo,ci:0ther". Malformed entries are skipped.
+func parseAPIKeys(value string) []apiKey {
+	var keys []apiKey
+	for _, entry := range splitList(value) {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			log.Printf("Warning: ignoring invalid API_KEYS entry for %q", parts[0])
+			continue
+		}
+		key := apiKey{id: parts[0], secret: parts[1]}
+		if len(parts) == 3 {
+			for _, route := range strings.Split(parts[2], "|") {
+				if route = strings.TrimSpace(route); route != "" {
+					key.routes = append(key.routes, route)
+				}
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// defaultAPIKeyRoutes is where API_KEYS applies unless API_KEY_ROUTES names
+// other routes: every route that posts to the Telegram chat, including
+// /edit and /batch.
+var defaultAPIKeyRoutes = []string{"/send", "/send/photo", "/send-venue", "/send-contact", "/send-media-group", "/edit", "/batch"}
+
+// requireAPIKey guards API_KEY_ROUTES with API_KEYS. A client
+// authenticates either with a static key in X-API-Key, or by signing the
+// request with X-API-Key-Id, X-Timestamp (Unix seconds) and X-Signature,
+// the hex HMAC-SHA256 of signingString under the key's secret, optionally
+// prefixed "sha256=". Signed requests older or newer than API_SIGNATURE_TOLERANCE,
+// or already seen, are rejected.
+//
+// A signed request may also carry a unique X-Nonce, required with
+// API_SIGNATURE_REQUIRE_NONCE=true, which can't be used again by the same
+// key.
+func requireAPIKey(next http.Handler, settings *Settings) http.Handler {
+	keys := settings.APIKeys
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions || !slices.Contains(settings.APIKeyRoutes, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var key *apiKey
+		if provided := r.Header.Get("X-API-Key"); provided != "" {
+			key = findStaticKey(keys, provided)
+		} else if id := r.Header.Get("X-API-Key-Id"); id != "" {
+			var ok bool
+			if key, ok = verifySignedRequest(w, r, settings, id); !ok {
+				return
+			}
+		}
+
+		if key == nil {
+			writeJSON(w, http.StatusUnauthorized, ErrorResponse{Message: "A valid API key is required", Code: "invalid_api_key"})
+			return
+		}
+		if !key.allows(r.URL.Path) {
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Message: "API key is not allowed to call this endpoint", Code: "api_key_forbidden"})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyIDKey{}, key.id)))
+	})
+}
+
+type apiKeyIDKey struct{}
+
+// apiKeyID returns the id of the API key the request authenticated with, or
+// "" on routes that don't take one.
+func apiKeyID(ctx context.Context) string {
+	id, _ := ctx.Value(apiKeyIDKey{}).(string)
+	return id
+}
+
+// findStaticKey compares provided against every key so the time taken
+// doesn't reveal which one, if any, matched.
+func findStaticKey(keys []apiKey, provided string) *apiKey {
+	var found *apiKey
+	for i := range keys {
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(keys[i].secret)) == 1 {
+			found = &keys[i]
+		}
+	}
+	return found
+}
+
+// verifySignedRequest checks the signature headers against the body, which
+// is read and put back for the handler. It writes the error response
+// itself for anything but an unknown key or a bad signature.
+func verifySignedRequest(w http.ResponseWriter, r *http.Request, settings *Settings, id string) (*apiKey, bool) {
+	keys := settings.APIKeys
+	var key *apiKey
+	for i := range keys {
+		if keys[i].id == id {
+			key = &keys[i]
+		}
+	}
+
+	ts, err := strconv.ParseInt(r.Header.Get("X-Timestamp"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Message: "X-Timestamp must be a Unix timestamp", Code: "invalid_signature"})
+		return nil, false
+	}
+	tolerance := settings.APISignatureTolerance
+	if skew := time.Since(time.Unix(ts, 0)); skew > tolerance || skew < -tolerance {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Message: "Request timestamp is outside the allowed window", Code: "signature_expired"})
+		return nil, false
+	}
+
+	nonce := r.Header.Get("X-Nonce")
+	switch {
+	case nonce == "" && settings.APISignatureRequireNonce:
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Message: "X-Nonce is required", Code: "nonce_required"})
+		return nil, false
+	case len(nonce) > maxNonceLength:
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Message: fmt.Sprintf("X-Nonce is longer than %d characters", maxNonceLength), Code: "invalid_signature"})
+		return nil, false
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(settings.APISignatureMaxBytes)))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "body_too_large", "Request body too large")
+		return nil, false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if key == nil {
+		return nil, true
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(r.Header.Get("X-Signature"), "sha256="))
+	if err != nil {
+		return nil, true
+	}
+	mac := hmac.New(sha256.New, []byte(key.secret))
+	mac.Write([]byte(signingString(r, ts, nonce)))
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return nil, true
+	}
+
+	nonceKey := key.id + " " + nonce
+	if nonce != "" {
+		reserved, full := usedNonces.reserveUnlessFull(nonceKey, 2*tolerance)
+		if full {
+			writeReplayCacheFull(w)
+			return nil, false
+		}
+		if !reserved {
+			writeJSON(w, http.StatusUnauthorized, ErrorResponse{Message: "Request nonce was already used", Code: "nonce_replayed"})
+			return nil, false
+		}
+	}
+
+	reserved, full := usedSignatures.reserveUnlessFull(key.id+" "+hex.EncodeToString(got), 2*tolerance)
+	if full {
+		if nonce != "" {
+			usedNonces.release(nonceKey)
+		}
+		writeReplayCacheFull(w)
+		return nil, false
+	}
+	if !reserved {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Message: "Request signature was already used", Code: "signature_replayed"})
+		return nil, false
+	}
+	return key, true
+}
+
+// signingString is what a signature covers ahead of the body:
+// "<method>\n<path>\n<timestamp>\n<nonce>\n", where path includes any query
+// string as sent and nonce is empty without X-Nonce. Covering the method
+// and path keeps a signature for one route from being used on another.
+func signingString(r *http.Request, ts int64, nonce string) string {
+	return r.Method + "\n" + r.URL.RequestURI() + "\n" + strconv.FormatInt(ts, 10) + "\n" + nonce + "\n"
+}
+
+// writeReplayCacheFull turns a signed request away because there is no room
+// left to remember it.
+func writeReplayCacheFull(w http.ResponseWriter) {
+	log.Printf("Warning: the signed request replay cache is full; raise CACHE_MAX_ENTRIES if this persists")
+	w.Header().Set("Retry-After", "1")
+	writeJSON(w, http.StatusServiceUnavailable, ErrorResponse{Message: "Too many signed requests, please retry later", Code: "replay_cache_full"})
+}
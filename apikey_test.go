@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signedRequest signs body for keyID with secret, including nonce in the
+// signature when it isn't empty.
+func signedRequest(path, keyID, secret, nonce, body string) *http.Request {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(http.MethodPost + "\n" + path + "\n" + ts + "\n" + nonce + "\n" + body))
+
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("X-API-Key-Id", keyID)
+	req.Header.Set("X-Timestamp", ts)
+	req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	if nonce != "" {
+		req.Header.Set("X-Nonce", nonce)
+	}
+	return req
+}
+
+func TestSignedRequestNonce(t *testing.T) {
+	settings := configWith(t, "API_KEYS", "ci:nonce-secret", "API_KEY_ROUTES", "/send").Settings
+	handler := requireAPIKey(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), settings)
+	call := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The same body with a fresh nonce passes each time.
+	run := strconv.FormatInt(time.Now().UnixNano(), 36)
+	for _, nonce := range []string{run + "-1", run + "-2"} {
+		if rec := call(signedRequest("/send", "ci", "nonce-secret", nonce, `{"message":"hi"}`)); rec.Code != http.StatusNoContent {
+			t.Fatalf("fresh nonce %s: status = %d: %s", nonce, rec.Code, rec.Body)
+		}
+	}
+
+	// Replaying a nonce fails even with a new timestamp, and so a new
+	// signature, which only the nonce store can catch.
+	time.Sleep(time.Second)
+	rec := call(signedRequest("/send", "ci", "nonce-secret", run+"-1", `{"message":"hi"}`))
+	if rec.Code != http.StatusUnauthorized || decodeError(t, rec).Code != "nonce_replayed" {
+		t.Errorf("replayed nonce: %d %s, want 401 nonce_replayed", rec.Code, rec.Body)
+	}
+
+	// A nonce that isn't covered by the signature is rejected.
+	req := signedRequest("/send", "ci", "nonce-secret", "", `{"message":"unsigned nonce"}`)
+	req.Header.Set("X-Nonce", run+"-3")
+	if rec := call(req); rec.Code != http.StatusUnauthorized || decodeError(t, rec).Code != "invalid_api_key" {
+		t.Errorf("unsigned nonce: %d %s, want 401 invalid_api_key", rec.Code, rec.Body)
+	}
+
+	settings.APISignatureRequireNonce = true
+	rec = call(signedRequest("/send", "ci", "nonce-secret", "", `{"message":"no nonce"}`))
+	if rec.Code != http.StatusUnauthorized || decodeError(t, rec).Code != "nonce_required" {
+		t.Errorf("missing nonce: %d %s, want 401 nonce_required", rec.Code, rec.Body)
+	}
+	rec = call(signedRequest("/send", "ci", "nonce-secret", strings.Repeat("n", maxNonceLength+1), `{}`))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("oversized nonce: status = %d, want 401", rec.Code)
+	}
+}
+
+func TestAPIKeyProtectsEveryTelegramSend(t *testing.T) {
+	settings := configWith(t, "API_KEYS", "site:static-secret").Settings
+	mux := http.NewServeMux()
+	noContent := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	sends := []string{"/send", "/send/photo", "/send-venue", "/send-contact", "/send-media-group", "/edit", "/batch"}
+	for _, path := range append(sends, "/subscribe") {
+		mux.HandleFunc(path, noContent)
+	}
+	handler := newHandler(mux, settings)
+
+	call := func(path, key string) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{}`))
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	for _, path := range sends {
+		if got := call(path, ""); got != http.StatusUnauthorized {
+			t.Errorf("%s without a key: status = %d, want 401", path, got)
+		}
+		if got := call(path, "static-secret"); got != http.StatusNoContent {
+			t.Errorf("%s with a key: status = %d, want 204", path, got)
+		}
+	}
+	if got := call("/subscribe", ""); got != http.StatusNoContent {
+		t.Errorf("/subscribe without a key: status = %d, want 204", got)
+	}
+}
+
+// signedAPI is a handler behind requireAPIKey for key "ci" that answers 204.
+func signedAPI(secret string, protected ...string) func(req *http.Request) *httptest.ResponseRecorder {
+	settings := testSettings()
+	settings.APIKeys, settings.APIKeyRoutes = parseAPIKeys("ci:"+secret), protected
+	handler := requireAPIKey(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), settings)
+	return func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+}
+
+func TestSignatureCoversMethodAndPath(t *testing.T) {
+	call := signedAPI("route-secret", "/send", "/edit")
+	nonce := strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	// A signature made for /send can't be used on another route.
+	req := signedRequest("/send", "ci", "route-secret", nonce, `{"message":"hi"}`)
+	req.URL.Path = "/edit"
+	if rec := call(req); rec.Code != http.StatusUnauthorized || decodeError(t, rec).Code != "invalid_api_key" {
+		t.Errorf("other path: %d %s, want 401 invalid_api_key", rec.Code, rec.Body)
+	}
+
+	req = signedRequest("/send", "ci", "route-secret", nonce, `{"message":"hi"}`)
+	req.Method = http.MethodPut
+	if rec := call(req); rec.Code != http.StatusUnauthorized {
+		t.Errorf("other method: status = %d, want 401", rec.Code)
+	}
+
+	req = signedRequest("/send?async=true", "ci", "route-secret", nonce, `{"message":"hi"}`)
+	req.URL.RawQuery = "async=false"
+	if rec := call(req); rec.Code != http.StatusUnauthorized {
+		t.Errorf("changed query string: status = %d, want 401", rec.Code)
+	}
+
+	if rec := call(signedRequest("/send?async=true", "ci", "route-secret", nonce, `{"message":"hi"}`)); rec.Code != http.StatusNoContent {
+		t.Errorf("untouched request: %d %s, want 204", rec.Code, rec.Body)
+	}
+}
+
+func TestReplayCacheRejectsWhenFull(t *testing.T) {
+	origSignatures, origNonces := usedSignatures, usedNonces
+	t.Cleanup(func() { usedSignatures, usedNonces = origSignatures, origNonces })
+	usedSignatures, usedNonces = newDedupStore("test_signatures"), newDedupStore("test_nonces")
+	origLimit := cacheMaxEntries
+	t.Cleanup(func() { cacheMaxEntries = origLimit })
+	cacheMaxEntries = 2
+	call := signedAPI("full-secret", "/send")
+	run := strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	for i := range 2 {
+		if rec := call(signedRequest("/send", "ci", "full-secret", run+strconv.Itoa(i), `{}`)); rec.Code != http.StatusNoContent {
+			t.Fatalf("request %d: %d %s", i, rec.Code, rec.Body)
+		}
+	}
+	rec := call(signedRequest("/send", "ci", "full-secret", run+"-full", `{}`))
+	if rec.Code != http.StatusServiceUnavailable || decodeError(t, rec).Code != "replay_cache_full" {
+		t.Fatalf("with the cache full: %d %s, want 503 replay_cache_full", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After")
+	}
+
+	// Nothing was evicted, so the first request still can't be replayed.
+	rec = call(signedRequest("/send", "ci", "full-secret", run+"0", `{}`))
+	if rec.Code != http.StatusUnauthorized || decodeError(t, rec).Code != "nonce_replayed" {
+		t.Errorf("replay after a full cache: %d %s, want 401 nonce_replayed", rec.Code, rec.Body)
+	}
+	if n := usedNonces.seen.stats().Size; n != 2 {
+		t.Errorf("%d nonces stored, want 2", n)
+	}
+}
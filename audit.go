@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxInMemoryAuditEvents = 10000
+
+type AuditEvent struct {
+	Time   time.Time         `json:"time"`
+	Type   string            `json:"type"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// auditStore appends events as NDJSON to AUDIT_LOG_PATH, or keeps the most
+// recent events in memory when no path is configured.
+type auditStore struct {
+	mu     sync.Mutex
+	path   string
+	events []AuditEvent
+}
+
+var auditLog = &auditStore{}
+
+func (s *auditStore) record(eventType string, fields map[string]string) {
+	event := AuditEvent{Time: time.Now().UTC(), Type: eventType, Fields: fields}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.path == "" {
+		s.events = append(s.events, event)
+		if len(s.events) > maxInMemoryAuditEvents {
+			s.events = s.events[len(s.events)-maxInMemoryAuditEvents:]
+		}
+		return
+	}
+
+	if err := appendAuditEvent(s.path, event); err != nil {
+		log.Printf("Error writing audit event: %v", err)
+	}
+}
+
+func appendAuditEvent(path string, event AuditEvent) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("error opening audit log: %v", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	if err := json.NewEncoder(w).Encode(event); err != nil {
+		return fmt.Errorf("error encoding audit event: %v", err)
+	}
+	return w.Flush()
+}
+
+// trustProxy is TRUST_PROXY, set by main.
+var trustProxy bool
+
+// clientIP returns the host part of the request's remote address.
+func clientIP(r *http.Request) string {
+	// Behind a reverse proxy RemoteAddr is the proxy itself. The last
+	// X-Forwarded-For entry is the one our proxy appended, so it is the only
+	// one a client can't forge. Proxies that only set X-Real-IP overwrite
+	// it, so it is used when there is no X-Forwarded-For.
+	if trustProxy {
+		if forwarded := splitList(r.Header.Get("X-Forwarded-For")); len(forwarded) > 0 {
+			return forwarded[len(forwarded)-1]
+		}
+		if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+			return realIP
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// hashIP pseudonymizes an IP address for storage, salted with hashSalt so
+// hashes can't be reversed by enumerating addresses.
+func hashIP(ip string) string {
+	sum := sha256.Sum256([]byte(hashSalt() + ip))
+	return hex.EncodeToString(sum[:])
+}
+
+// auditHashSalt is AUDIT_HASH_SALT, set by main.
+var auditHashSalt string
+
+// hashSaltCache holds the salt loaded from database, which tests and main
+// can replace.
+var hashSaltCache struct {
+	mu   sync.Mutex
+	db   *sql.DB
+	salt string
+}
+
+// hashSalt returns AUDIT_HASH_SALT or, without one, a random salt generated
+// on first use and kept in the database, so hashes stay comparable across
+// restarts without an operator having to pick one.
+func hashSalt() string {
+	if auditHashSalt != "" {
+		return auditHashSalt
+	}
+
+	hashSaltCache.mu.Lock()
+	defer hashSaltCache.mu.Unlock()
+	if hashSaltCache.db != database || hashSaltCache.salt == "" {
+		salt, err := loadHashSalt(database)
+		if err != nil {
+			// Hashes then only match within this process, which is still
+			// better than leaving them unsalted.
+			log.Printf("Warning: cannot load the hash salt, using one for this process: %v", err)
+			salt = newHashSalt()
+		}
+		hashSaltCache.db, hashSaltCache.salt = database, salt
+	}
+	return hashSaltCache.salt
+}
+
+// loadHashSalt reads the generated salt from db, storing a new one the
+// first time.
+func loadHashSalt(db *sql.DB) (string, error) {
+	_, err := db.Exec(`INSERT INTO settings (name, value) VALUES ('hash_salt', ?) ON CONFLICT (name) DO NOTHING`, newHashSalt())
+	if err != nil {
+		return "", err
+	}
+	var salt string
+	err = db.QueryRow(`SELECT value FROM settings WHERE name = 'hash_salt'`).Scan(&salt)
+	return salt, err
+}
+
+func newHashSalt() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// each calls fn for every event recorded within [from, to). A zero from or
+// to leaves that side of the range open.
+func (s *auditStore) each(from, to time.Time, fn func(AuditEvent) error) error {
+	inRange := func(e AuditEvent) bool {
+		return (from.IsZero() || !e.Time.Before(from)) && (to.IsZero() || e.Time.Before(to))
+	}
+
+	s.mu.Lock()
+	path := s.path
+	events := append([]AuditEvent(nil), s.events...)
+	s.mu.Unlock()
+
+	if path == "" {
+		for _, e := range events {
+			if inRange(e) {
+				if err := fn(e); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error opening audit log: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var e AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if inRange(e) {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// parseExportTime accepts RFC 3339 timestamps or plain dates. A plain date
+// used as the end of a range covers that whole day.
+func parseExportTime(value string, end bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func handleAuditExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	from, err := parseExportTime(r.URL.Query().Get("from"), false)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_date", "Invalid from date")
+		return
+	}
+	to, err := parseExportTime(r.URL.Query().Get("to"), true)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_date", "Invalid to date")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	n := 0
+	err = auditLog.each(from, to, func(e AuditEvent) error {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+		if n++; flusher != nil && n%100 == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error exporting audit log: %v", err)
+	}
+}
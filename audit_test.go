@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAuditExport(t *testing.T) {
+	defer func(orig *auditStore) { auditLog = orig }(auditLog)
+	auditLog = &auditStore{path: filepath.Join(t.TempDir(), "audit.ndjson")}
+
+	// 150 events on 2025-03-01, then one on each of the next two days.
+	day := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	for i := 0; i < 150; i++ {
+		appendAuditEvent(auditLog.path, AuditEvent{Time: day.Add(time.Duration(i) * time.Second), Type: "send", Fields: map[string]string{"n": strconv.Itoa(i)}})
+	}
+	appendAuditEvent(auditLog.path, AuditEvent{Time: day.AddDate(0, 0, 1), Type: "subscribe"})
+	appendAuditEvent(auditLog.path, AuditEvent{Time: day.AddDate(0, 0, 2), Type: "edit"})
+
+	tests := []struct {
+		query  string
+		status int
+		count  int
+	}{
+		{"", http.StatusOK, 152},
+		{"?from=2025-03-02", http.StatusOK, 2},
+		{"?to=2025-03-01", http.StatusOK, 150},
+		{"?from=2025-03-02&to=2025-03-02", http.StatusOK, 1},
+		{"?from=2025-03-01T09:02:00Z&to=2025-03-01T09:02:10Z", http.StatusOK, 10},
+		{"?from=yesterday", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := serve(handleAuditExport, http.MethodGet, "/admin/audit/export"+tt.query, "")
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+
+			n := 0
+			scanner := bufio.NewScanner(rec.Body)
+			for scanner.Scan() {
+				var e AuditEvent
+				if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+					t.Fatalf("line %d: %v", n+1, err)
+				}
+				n++
+			}
+			if n != tt.count {
+				t.Errorf("exported %d events, want %d", n, tt.count)
+			}
+			// Large exports are flushed as they go.
+			if rec.Flushed != (tt.count >= 100) {
+				t.Errorf("flushed = %v for %d events", rec.Flushed, tt.count)
+			}
+		})
+	}
+}
+
+func TestHashIPIsSaltedWithoutAuditHashSalt(t *testing.T) {
+	origSalt := auditHashSalt
+	t.Cleanup(func() { auditHashSalt = origSalt })
+	auditHashSalt = ""
+	path := filepath.Join(t.TempDir(), "api.db")
+	useDatabase(t)
+	database = mustOpenDatabase(path)
+
+	unsalted := sha256.Sum256([]byte("192.0.2.1"))
+	hash := hashIP("192.0.2.1")
+	if hash == hex.EncodeToString(unsalted[:]) {
+		t.Fatal("hashIP is unsalted")
+	}
+	if hashIP("192.0.2.1") != hash {
+		t.Error("hashIP is not stable")
+	}
+
+	// The generated salt outlives a restart.
+	database.Close()
+	database = mustOpenDatabase(path)
+	defer database.Close()
+	if hashIP("192.0.2.1") != hash {
+		t.Error("the hash changed after reopening the database")
+	}
+
+	auditHashSalt = "configured"
+	if hashIP("192.0.2.1") == hash {
+		t.Error("AUDIT_HASH_SALT is ignored")
+	}
+}
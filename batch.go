@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+const defaultMaxBatchSize = 20
+
+type BatchResult struct {
+	Index  int             `json:"index"`
+	Op     string          `json:"op"`
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// batchRecorder captures a handler's response so it can be embedded in the
+// batch result array.
+type batchRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *batchRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *batchRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *batchRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(b)
+}
+
+// runBatchOp runs a single operation through the same handler that serves
+// its standalone endpoint, so validation and behavior stay identical.
+func runBatchOp(r *http.Request, raw json.RawMessage, handlers map[string]http.HandlerFunc) BatchResult {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return batchError("", http.StatusBadRequest, "invalid_operation", "Invalid operation")
+	}
+
+	var op string
+	json.Unmarshal(fields["op"], &op)
+	delete(fields, "op")
+
+	handler, ok := handlers[op]
+	if !ok {
+		return batchError(op, http.StatusBadRequest, "unknown_operation", "Unknown operation")
+	}
+
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return batchError(op, http.StatusBadRequest, "invalid_operation", "Invalid operation")
+	}
+
+	opReq, err := http.NewRequestWithContext(r.Context(), http.MethodPost, r.URL.String(), bytes.NewReader(body))
+	if err != nil {
+		return batchError(op, http.StatusInternalServerError, "internal_error", err.Error())
+	}
+	opReq.RemoteAddr = r.RemoteAddr
+	opReq.Header = r.Header.Clone()
+	opReq.Header.Set("Content-Type", "application/json")
+	opReq.ContentLength = int64(len(body))
+
+	rec := &batchRecorder{header: make(http.Header)}
+	handler(rec, opReq)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	result := BatchResult{Op: op, Status: rec.status}
+	if trimmed := bytes.TrimSpace(rec.body.Bytes()); json.Valid(trimmed) {
+		result.Body = trimmed
+	} else {
+		result.Body, _ = json.Marshal(ErrorResponse{Message: string(trimmed), Code: "unexpected_response"})
+	}
+	return result
+}
+
+func batchError(op string, status int, code, message string) BatchResult {
+	body, _ := json.Marshal(ErrorResponse{Message: message, Code: code})
+	return BatchResult{Op: op, Status: status, Body: body}
+}
+
+func handleBatch(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var ops []json.RawMessage
+	if !decodeBodyLimit(w, r, &ops, int64(config.MaxRequestBytes)) {
+		return
+	}
+
+	if len(ops) == 0 {
+		writeError(w, http.StatusBadRequest, "batch_empty", "Batch cannot be empty")
+		return
+	}
+
+	if len(ops) > config.MaxBatchSize {
+		writeError(w, http.StatusBadRequest, "batch_too_large", "Batch is too large")
+		return
+	}
+
+	// Each operation gets the route limit and spam filter of its standalone
+	// endpoint. Idempotency and async are left to the batch as a whole.
+	handlers := map[string]http.HandlerFunc{
+		"send": withRouteLimit("/send", func(w http.ResponseWriter, r *http.Request) {
+			handleSendMessage(w, r, config)
+		}),
+		"subscribe": withRouteLimit("/subscribe", withSpamFilter("subscribe", func(w http.ResponseWriter, r *http.Request) {
+			handleSubscribe(w, r, config)
+		}, config)),
+	}
+
+	// Clients that accept NDJSON get each result as its own line as soon as
+	// it completes; everyone else gets a single JSON array at the end.
+	if flusher, ok := w.(http.Flusher); ok && strings.Contains(r.Header.Get("Accept"), "application/x-ndjson") {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		for i, raw := range ops {
+			result := runBatchOp(r, raw, handlers)
+			result.Index = i
+			enc.Encode(result)
+			flusher.Flush()
+		}
+		return
+	}
+
+	results := make([]BatchResult, 0, len(ops))
+	for i, raw := range ops {
+		result := runBatchOp(r, raw, handlers)
+		result.Index = i
+		results = append(results, result)
+	}
+
+	writeJSON(w, http.StatusOK, results)
+}
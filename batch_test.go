@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatchMixedOperations(t *testing.T) {
+	fakeTG, fakeBH := useFakeUpstreams(t)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		handleBatch(w, r, testConfig)
+	}
+
+	rec := serve(handler, http.MethodPost, "/batch", `[
+		{"op":"send","message":"batched"},
+		{"op":"subscribe","email":"batch-ok@example.com"},
+		{"op":"subscribe","email":""},
+		{"op":"delete"},
+		{"op":"send","message":"still sent"}
+	]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	var results []BatchResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		op     string
+		status int
+	}{
+		{"send", http.StatusOK},
+		{"subscribe", http.StatusOK},
+		{"subscribe", http.StatusBadRequest},
+		{"delete", http.StatusBadRequest},
+		{"send", http.StatusOK},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d: %s", len(results), len(want), rec.Body)
+	}
+	for i, w := range want {
+		if results[i].Index != i || results[i].Op != w.op || results[i].Status != w.status {
+			t.Errorf("result %d = %+v, want %s with %d", i, results[i], w.op, w.status)
+		}
+	}
+
+	// A failed operation doesn't stop the ones after it.
+	if got := sentText(t, fakeTG); got != "still sent" {
+		t.Errorf("last send = %q, want %q", got, "still sent")
+	}
+	if beehiivCalls(fakeBH, http.MethodPost, "/subscriptions") != 1 {
+		t.Errorf("Beehiiv calls = %v, want one subscription", fakeBH.Calls())
+	}
+}
+
+func TestBatchRejectsEmptyAndOversized(t *testing.T) {
+	useFakeUpstreams(t)
+	handler := bind(handleBatch, configWith(t, "MAX_BATCH_SIZE", "2"))
+
+	for _, body := range []string{`[]`, `[{"op":"send"},{"op":"send"},{"op":"send"}]`, `{"op":"send"}`} {
+		if rec := serve(handler, http.MethodPost, "/batch", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+}
+
+// gatedTelegram holds sends whose text is gated until release is closed.
+type gatedTelegram struct {
+	fakeTelegram
+	gated   string
+	release chan struct{}
+}
+
+func (g *gatedTelegram) Call(ctx context.Context, token, method, contentType string, body []byte) (json.RawMessage, error) {
+	if strings.Contains(string(body), g.gated) {
+		<-g.release
+	}
+	return g.fakeTelegram.Call(ctx, token, method, contentType, body)
+}
+
+func TestBatchStreamsNDJSON(t *testing.T) {
+	gate := &gatedTelegram{gated: "second", release: make(chan struct{})}
+	defer func(orig TelegramAPI) { telegram = orig }(telegram)
+	telegram = gate
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleBatch(w, r, testConfig)
+	}))
+	defer srv.Close()
+	released := false
+	defer func() {
+		if !released {
+			close(gate.release)
+		}
+	}()
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/batch", strings.NewReader(`[
+		{"op":"send","message":"first"},
+		{"op":"send","message":"second"},
+		{"op":"nope"}
+	]`))
+	req.Header.Set("Accept", "application/x-ndjson")
+	// Without streaming the first line would never arrive.
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q, want application/x-ndjson", ct)
+	}
+
+	// The first result arrives while the second operation is still held.
+	lines := bufio.NewScanner(resp.Body)
+	var results []BatchResult
+	for lines.Scan() {
+		var result BatchResult
+		if err := json.Unmarshal(lines.Bytes(), &result); err != nil {
+			t.Fatalf("line %q: %v", lines.Text(), err)
+		}
+		results = append(results, result)
+		if len(results) == 1 {
+			released = true
+			close(gate.release)
+		}
+	}
+	if err := lines.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []int{http.StatusOK, http.StatusOK, http.StatusBadRequest}
+	if len(results) != len(want) {
+		t.Fatalf("got %d lines, want %d", len(results), len(want))
+	}
+	for i, status := range want {
+		if results[i].Index != i || results[i].Status != status {
+			t.Errorf("line %d = %+v, want index %d with %d", i, results[i], i, status)
+		}
+	}
+}
+
+func TestBatchWithoutNDJSONReturnsArray(t *testing.T) {
+	useFakeUpstreams(t)
+	req := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(`[{"op":"send","message":"hi"}]`))
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	handleBatch(rec, req, testConfig)
+
+	var results []BatchResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil || len(results) != 1 {
+		t.Errorf("body %s (%v), want a one-element array", rec.Body, err)
+	}
+}
+
+func TestBatchOpsGetRouteLimitsAndSpamFilter(t *testing.T) {
+	_, fakeBH := useFakeUpstreams(t)
+	defer func(orig map[string]*rateLimiter) { routeRequests = orig }(routeRequests)
+	routeRequests = map[string]*rateLimiter{
+		"/subscribe": newRateLimiter("route_rate_limit:/subscribe", 2, time.Minute),
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		handleBatch(w, r, testConfig)
+	}
+
+	rec := serve(handler, http.MethodPost, "/batch", `[
+		{"op":"subscribe","email":"batch-bot@example.com","website":"http://spam.example"},
+		{"op":"subscribe","email":"batch-limit-1@example.com"},
+		{"op":"subscribe","email":"batch-limit-2@example.com"},
+		{"op":"subscribe","email":"batch-limit-3@example.com"}
+	]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var results []BatchResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+
+	// The honeypot op is rejected after it used up one of the route's
+	// requests, as it would have on /subscribe.
+	want := []int{http.StatusBadRequest, http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d: %s", len(results), len(want), rec.Body)
+	}
+	for i, status := range want {
+		if results[i].Status != status {
+			t.Errorf("result %d: status = %d, want %d: %s", i, results[i].Status, status, results[i].Body)
+		}
+	}
+	if !strings.Contains(string(results[0].Body), "spam_rejected") {
+		t.Errorf("honeypot op: %s, want spam_rejected", results[0].Body)
+	}
+	if n := beehiivCalls(fakeBH, http.MethodPost, "/subscriptions"); n != 1 {
+		t.Errorf("%d subscriptions reached Beehiiv, want 1", n)
+	}
+}
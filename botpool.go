@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type weightedBot struct {
+	token   string
+	weight  int
+	current int
+}
+
+// botPool spreads sends across several bots with smooth weighted
+// round-robin, so each bot gets its share of traffic evenly interleaved
+// rather than in bursts.
+type botPool struct {
+	mu    sync.Mutex
+	bots  []*weightedBot
+	total int
+}
+
+// parseBotPool parses a comma-separated list of bot tokens, each optionally
+// suffixed with "=weight" (default 1), e.g. "123:abc=3,456:def".
+func parseBotPool(spec string) (*botPool, error) {
+	pool := &botPool{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		token, weight := entry, 1
+		if i := strings.LastIndex(entry, "="); i >= 0 {
+			w, err := strconv.Atoi(entry[i+1:])
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("invalid weight in bot entry %d", len(pool.bots)+1)
+			}
+			token, weight = entry[:i], w
+		}
+
+		pool.bots = append(pool.bots, &weightedBot{token: token, weight: weight})
+		pool.total += weight
+	}
+
+	if len(pool.bots) == 0 {
+		return nil, fmt.Errorf("no bot tokens configured")
+	}
+	return pool, nil
+}
+
+func (p *botPool) next() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var best *weightedBot
+	for _, b := range p.bots {
+		b.current += b.weight
+		if best == nil || b.current > best.current {
+			best = b
+		}
+	}
+	best.current -= p.total
+	return best.token
+}
+
+// first returns the first configured bot, used for calls that aren't
+// spread across the pool.
+func (p *botPool) first() string {
+	return p.bots[0].token
+}
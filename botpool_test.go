@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestBotPoolDistributionMatchesWeights(t *testing.T) {
+	pool, err := parseBotPool("a=3, b, c=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every cycle of six sends, not just the total, matches the weights.
+	want := map[string]int{"a": 3, "b": 1, "c": 2}
+	for cycle := 0; cycle < 100; cycle++ {
+		counts := make(map[string]int)
+		for i := 0; i < 6; i++ {
+			counts[pool.next()]++
+		}
+		for token, n := range want {
+			if counts[token] != n {
+				t.Fatalf("cycle %d: bot %s got %d sends, want %d", cycle, token, counts[token], n)
+			}
+		}
+	}
+}
+
+func TestParseBotPoolRejectsBadWeights(t *testing.T) {
+	for _, spec := range []string{"", " , ", "a=0", "a=-1", "a=x"} {
+		if _, err := parseBotPool(spec); err == nil {
+			t.Errorf("parseBotPool(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+// tokenRecorder records the token of every Bot API call.
+type tokenRecorder struct {
+	fakeTelegram
+	tokens map[string][]string
+}
+
+func (r *tokenRecorder) Call(ctx context.Context, token, method, contentType string, body []byte) (json.RawMessage, error) {
+	r.tokens[method] = append(r.tokens[method], token)
+	return r.fakeTelegram.Call(ctx, token, method, contentType, body)
+}
+
+func TestEditsGoThroughTheSendingBot(t *testing.T) {
+	rec := &tokenRecorder{tokens: make(map[string][]string)}
+	defer func(orig TelegramAPI) { telegram = orig }(telegram)
+	telegram = rec
+
+	pool, err := parseBotPool("a,b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := testConfig
+	config.BotToken, config.ChatID, config.Bots = "", "pool-test", pool
+
+	first, err := sendTelegramMessage(context.Background(), config, "one", SendOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := sendTelegramMessage(context.Background(), config, "two", SendOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.tokens["sendMessage"]; len(got) != 2 || got[0] == got[1] {
+		t.Fatalf("sends went through %v, want one per bot", got)
+	}
+
+	// Edits don't advance the rotation and each goes to its sender.
+	for i := 0; i < 2; i++ {
+		if err := editTelegramMessage(context.Background(), config, second.MessageID, "two, edited", SendOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := editTelegramMessage(context.Background(), config, first.MessageID, "one, edited", SendOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	sends, edits := rec.tokens["sendMessage"], rec.tokens["editMessageText"]
+	want := []string{sends[1], sends[1], sends[0]}
+	for i := range want {
+		if edits[i] != want[i] {
+			t.Errorf("edit %d went through %s, want %s", i, edits[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultChatIDsRefreshInterval = 5 * time.Minute
+	defaultBroadcastMaxTargets    = 50
+	defaultBroadcastSessionTTL    = 24 * time.Hour
+	maxBroadcastSessionParts      = 100
+	maxChatIDsBytes               = 1 << 20
+)
+
+// broadcastChats is the chat list /broadcast fans out to. It is
+// BROADCAST_CHAT_IDS, or with CHAT_IDS_URL set the list last fetched from
+// there. A failed fetch falls back to BROADCAST_CHAT_IDS until the next
+// refresh succeeds.
+var broadcastChats struct {
+	mu     sync.Mutex
+	remote []string
+	loaded bool
+}
+
+// broadcastTargets returns the chats a broadcast goes to and where the list
+// came from, "remote" or "static".
+func broadcastTargets(config Config) ([]string, string) {
+	broadcastChats.mu.Lock()
+	defer broadcastChats.mu.Unlock()
+
+	if broadcastChats.loaded {
+		return slices.Clone(broadcastChats.remote), "remote"
+	}
+	return slices.Clone(config.BroadcastChatIDs), "static"
+}
+
+// refreshBroadcastChats fetches chatIDsURL, CHAT_IDS_URL, a JSON array of
+// chat IDs, and makes it the broadcast list. On failure the static list is
+// used instead.
+func refreshBroadcastChats(ctx context.Context, chatIDsURL string) error {
+	chatIDs, err := fetchChatIDs(ctx, chatIDsURL)
+
+	broadcastChats.mu.Lock()
+	defer broadcastChats.mu.Unlock()
+	if err != nil {
+		broadcastChats.remote, broadcastChats.loaded = nil, false
+		return err
+	}
+	broadcastChats.remote, broadcastChats.loaded = chatIDs, true
+	return nil
+}
+
+// validateChatIDsURL checks CHAT_IDS_URL for readSettings.
+func validateChatIDsURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("must be an absolute http or https URL")
+	}
+	return nil
+}
+
+func fetchChatIDs(ctx context.Context, chatIDsURL string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, chatIDsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching chat IDs: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newUpstreamError(resp)
+	}
+
+	var raw []json.RawMessage
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxChatIDsBytes)).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("error decoding chat IDs: %v", err)
+	}
+
+	// Numeric IDs may come as numbers or strings, channel usernames only as
+	// strings.
+	chatIDs := make([]string, 0, len(raw))
+	for i, value := range raw {
+		var chatID string
+		if err := json.Unmarshal(value, &chatID); err != nil {
+			var n json.Number
+			if json.Unmarshal(value, &n) != nil {
+				return nil, fmt.Errorf("chat ID %d is not a string or number", i)
+			}
+			chatID = n.String()
+		}
+		if chatID = strings.TrimSpace(chatID); chatID == "" {
+			return nil, fmt.Errorf("chat ID %d is empty", i)
+		}
+		chatIDs = append(chatIDs, chatID)
+	}
+	return chatIDs, nil
+}
+
+// watchBroadcastChats refreshes the broadcast list from CHAT_IDS_URL at
+// startup and then every CHAT_IDS_REFRESH_INTERVAL until ctx is done.
+func watchBroadcastChats(ctx context.Context, config Config) {
+	ticker := time.NewTicker(config.ChatIDsRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := refreshBroadcastChats(ctx, config.ChatIDsURL); err != nil && ctx.Err() == nil {
+			log.Printf("Warning: refreshing CHAT_IDS_URL failed, broadcasting to BROADCAST_CHAT_IDS: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type BroadcastRequest struct {
+	Message               string `json:"message"`
+	ParseMode             string `json:"parse_mode,omitempty"`
+	DisableWebPagePreview bool   `json:"disable_web_page_preview,omitempty"`
+	DisableNotification   bool   `json:"disable_notification,omitempty"`
+
+	// ConfirmLarge lets a broadcast reach more than BROADCAST_MAX_TARGETS
+	// chats.
+	ConfirmLarge bool `json:"confirm_large,omitempty"`
+
+	// SessionTotal starts a numbered session of that many broadcasts, this
+	// one being the first; SessionID continues one.
+	SessionTotal int    `json:"session_total,omitempty"`
+	SessionID    string `json:"session_id,omitempty"`
+}
+
+// BroadcastResult is the outcome of a broadcast to one chat.
+type BroadcastResult struct {
+	ChatID    string `json:"chat_id"`
+	Status    string `json:"status"`
+	MessageID int64  `json:"message_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type BroadcastResponse struct {
+	Status  string                `json:"status"`
+	Results []BroadcastResult     `json:"results"`
+	Session *BroadcastSessionPart `json:"session,omitempty"`
+}
+
+// BroadcastSessionPart is where a broadcast falls in its session.
+type BroadcastSessionPart struct {
+	ID    string `json:"id"`
+	Part  int    `json:"part"`
+	Total int    `json:"total"`
+}
+
+// broadcastSession numbers the parts of a multi-part announcement. Its
+// lock is held for a whole part, so parts go out one at a time and in
+// order.
+type broadcastSession struct {
+	mu    sync.Mutex
+	total int
+	sent  int
+}
+
+// broadcastSessions are kept for BROADCAST_SESSION_TTL (default 24h) after
+// their last part.
+var broadcastSessions = newTTLCache[*broadcastSession]("broadcast_sessions", 0)
+
+// numberPart prefixes message with its place in the session, e.g. "(2/3)".
+// MarkdownV2 reserves parentheses, so they are escaped there.
+func numberPart(message, parseMode string, part, total int) string {
+	if parseMode == "MarkdownV2" {
+		return fmt.Sprintf(`\(%d/%d\) %s`, part, total, message)
+	}
+	return fmt.Sprintf("(%d/%d) %s", part, total, message)
+}
+
+// handleBroadcast sends one message to every broadcast chat in turn, each
+// paced like any other send to it. A chat that fails doesn't stop the
+// rest; the request only fails when every chat did.
+//
+// A broadcast to more than BROADCAST_MAX_TARGETS chats (default 50, 0 for
+// no cap) is refused unless it sets confirm_large, so a mistaken chat list
+// can't message hundreds of chats at once.
+//
+// A broadcast with session_total starts a session whose parts are numbered
+// "(1/3)", "(2/3)" and so on; later parts pass the returned session_id.
+// A part is only counted once it reached at least one chat.
+func handleBroadcast(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var req BroadcastRequest
+	if !decodeBody(w, r, &req, config) {
+		return
+	}
+
+	req.Message = strings.TrimSpace(req.Message)
+	if req.Message == "" {
+		writeError(w, http.StatusBadRequest, "message_empty", "Message cannot be empty")
+		return
+	}
+	switch {
+	case req.SessionTotal != 0 && req.SessionID != "":
+		writeError(w, http.StatusBadRequest, "invalid_session", "Session total and session ID cannot be combined")
+		return
+	case req.SessionTotal < 0 || req.SessionTotal > maxBroadcastSessionParts:
+		writeError(w, http.StatusBadRequest, "invalid_session", fmt.Sprintf("Session total must be between 1 and %d", maxBroadcastSessionParts))
+		return
+	}
+
+	opts, msg := sendOptionsFor(MessageRequest{
+		ParseMode:             req.ParseMode,
+		DisableWebPagePreview: req.DisableWebPagePreview,
+		DisableNotification:   req.DisableNotification,
+	}, config)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", msg)
+		return
+	}
+
+	chatIDs, _ := broadcastTargets(config)
+	if len(chatIDs) == 0 {
+		writeError(w, http.StatusServiceUnavailable, "not_configured", "No broadcast chats are configured")
+		return
+	}
+	if limit := config.BroadcastMaxTargets; limit > 0 && len(chatIDs) > limit && !req.ConfirmLarge {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Broadcast would reach %d chats, more than the limit of %d; set confirm_large to send it anyway", len(chatIDs), limit),
+			Code:    "too_many_targets",
+			Details: map[string]int{"targets": len(chatIDs), "max_targets": limit},
+		})
+		return
+	}
+
+	var session *broadcastSession
+	sessionID := req.SessionID
+	switch {
+	case req.SessionTotal > 0:
+		session = &broadcastSession{total: req.SessionTotal}
+		sessionID = newRequestID()
+	case sessionID != "":
+		var ok bool
+		if session, ok = broadcastSessions.get(sessionID); !ok {
+			writeError(w, http.StatusNotFound, "session_not_found", "Broadcast session not found or expired")
+			return
+		}
+	}
+
+	message := req.Message
+	if session != nil {
+		session.mu.Lock()
+		defer session.mu.Unlock()
+		if session.sent >= session.total {
+			writeError(w, http.StatusConflict, "session_complete", fmt.Sprintf("All %d parts of this session were already sent", session.total))
+			return
+		}
+		// The parse mode is settled first so auto-detection sees the
+		// message as written.
+		opts.ParseMode = resolveParseMode(opts, message)
+		message = numberPart(message, opts.ParseMode, session.sent+1, session.total)
+	}
+
+	var errs fieldErrors
+	errs.message("message", message, maxMessageLength)
+	if errs.write(w) {
+		return
+	}
+
+	results, err := broadcast(r.Context(), config, chatIDs, message, opts)
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+
+	resp := BroadcastResponse{Status: "Broadcast sent successfully", Results: results}
+	if session != nil {
+		session.sent++
+		broadcastSessions.set(sessionID, session, config.BroadcastSessionTTL)
+		resp.Session = &BroadcastSessionPart{ID: sessionID, Part: session.sent, Total: session.total}
+	}
+	if sent := countSent(results); sent < len(results) {
+		resp.Status = fmt.Sprintf("Broadcast sent to %d of %d chats", sent, len(results))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// BroadcastPreview lists the chats a broadcast would reach right now and
+// whether they came from CHAT_IDS_URL ("remote") or BROADCAST_CHAT_IDS
+// ("static").
+type BroadcastPreview struct {
+	ChatIDs []string `json:"chat_ids"`
+	Count   int      `json:"count"`
+	Source  string   `json:"source"`
+}
+
+// handleBroadcastPreview resolves the broadcast chats without sending
+// anything, so operators can check the audience first.
+func handleBroadcastPreview(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	chatIDs, source := broadcastTargets(config)
+	if chatIDs == nil {
+		chatIDs = []string{}
+	}
+	writeJSON(w, http.StatusOK, BroadcastPreview{ChatIDs: chatIDs, Count: len(chatIDs), Source: source})
+}
+
+// broadcast sends message to each chat and reports how each went. It
+// returns an error only when no chat got the message.
+func broadcast(ctx context.Context, config Config, chatIDs []string, message string, opts SendOptions) ([]BroadcastResult, error) {
+	results := make([]BroadcastResult, len(chatIDs))
+	var errs []error
+	for i, chatID := range chatIDs {
+		config.ChatID = chatID
+		results[i] = BroadcastResult{ChatID: chatID, Status: "sent"}
+
+		sent, err := sendTelegramMessage(ctx, config, message, opts)
+		if err != nil {
+			results[i].Status = "error"
+			results[i].Error = err.Error()
+			errs = append(errs, err)
+			continue
+		}
+		results[i].MessageID = sent.MessageID
+		auditLog.record("broadcast", map[string]string{
+			"chat_id":    chatID,
+			"message_id": strconv.FormatInt(sent.MessageID, 10),
+		})
+	}
+
+	if len(errs) == len(chatIDs) {
+		return results, errors.Join(errs...)
+	}
+	return results, nil
+}
+
+func countSent(results []BroadcastResult) int {
+	sent := 0
+	for _, result := range results {
+		if result.Status == "sent" {
+			sent++
+		}
+	}
+	return sent
+}
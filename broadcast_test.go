@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// useBroadcastChats starts the test with no fetched broadcast list and
+// returns a config with BROADCAST_CHAT_IDS set to static.
+func useBroadcastChats(t *testing.T, static string) Config {
+	t.Helper()
+	reset := func() {
+		broadcastChats.mu.Lock()
+		broadcastChats.remote, broadcastChats.loaded = nil, false
+		broadcastChats.mu.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+	return configWith(t, "BROADCAST_CHAT_IDS", static)
+}
+
+func TestRefreshBroadcastChats(t *testing.T) {
+	config := useBroadcastChats(t, "10, 20")
+
+	var body string
+	var status int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	check := func(want []string, wantSource string) {
+		t.Helper()
+		got, source := broadcastTargets(config)
+		if !slices.Equal(got, want) || source != wantSource {
+			t.Errorf("targets = %v from %s, want %v from %s", got, source, want, wantSource)
+		}
+	}
+	check([]string{"10", "20"}, "static")
+
+	status, body = http.StatusOK, `["-1001", 42, " @news "]`
+	if err := refreshBroadcastChats(context.Background(), srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	check([]string{"-1001", "42", "@news"}, "remote")
+
+	failures := []struct {
+		name, body string
+		status     int
+	}{
+		{"server error", `oops`, http.StatusInternalServerError},
+		{"not a list", `{"chat_ids":["1"]}`, http.StatusOK},
+		{"empty chat ID", `["1", ""]`, http.StatusOK},
+		{"not a chat ID", `["1", true]`, http.StatusOK},
+	}
+	for _, tt := range failures {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body = http.StatusOK, `["-1001"]`
+			if err := refreshBroadcastChats(context.Background(), srv.URL); err != nil {
+				t.Fatal(err)
+			}
+
+			status, body = tt.status, tt.body
+			if err := refreshBroadcastChats(context.Background(), srv.URL); err == nil {
+				t.Error("refresh succeeded")
+			}
+			check([]string{"10", "20"}, "static")
+		})
+	}
+}
+
+func TestValidateChatIDsURL(t *testing.T) {
+	for value, ok := range map[string]bool{
+		"https://config.example.com/chats.json": true,
+		"http://localhost:8080/chats":           true,
+		"/chats.json":                           false,
+		"ftp://example.com/chats":               false,
+		"https://":                              false,
+	} {
+		if err := validateChatIDsURL(value); (err == nil) != ok {
+			t.Errorf("validateChatIDsURL(%q) = %v, want ok %v", value, err, ok)
+		}
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	useFakeUpstreams(t)
+	config := useBroadcastChats(t, "10,20,30")
+
+	var sentTo []string
+	telegram = telegramFunc(func(method string, body []byte) (json.RawMessage, error) {
+		var msg TelegramMessage
+		json.Unmarshal(body, &msg)
+		if msg.ChatID == "20" {
+			return nil, &UpstreamError{StatusCode: http.StatusForbidden, Message: "bot was blocked by the user"}
+		}
+		sentTo = append(sentTo, msg.ChatID)
+		return json.RawMessage(`{"message_id":7}`), nil
+	})
+
+	rec := serve(bind(handleBroadcast, config), http.MethodPost, "/broadcast", `{"message":" Release day "}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var resp BroadcastResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != "Broadcast sent to 2 of 3 chats" {
+		t.Errorf("status = %q", resp.Status)
+	}
+	if !slices.Equal(sentTo, []string{"10", "30"}) {
+		t.Errorf("sent to %v, want 10 and 30", sentTo)
+	}
+	want := []BroadcastResult{
+		{ChatID: "10", Status: "sent", MessageID: 7},
+		{ChatID: "20", Status: "error"},
+		{ChatID: "30", Status: "sent", MessageID: 7},
+	}
+	for i, result := range resp.Results {
+		if result.ChatID != want[i].ChatID || result.Status != want[i].Status || result.MessageID != want[i].MessageID {
+			t.Errorf("result %d = %+v, want %+v", i, result, want[i])
+		}
+	}
+	if !strings.Contains(resp.Results[1].Error, "blocked") {
+		t.Errorf("error = %q, want the upstream reason", resp.Results[1].Error)
+	}
+}
+
+func TestBroadcastFailures(t *testing.T) {
+	useFakeUpstreams(t)
+
+	t.Run("every chat fails", func(t *testing.T) {
+		config := useBroadcastChats(t, "10,20")
+		telegram = failingTelegram{errors.New("connection refused")}
+
+		rec := serve(bind(handleBroadcast, config), http.MethodPost, "/broadcast", `{"message":"hi"}`)
+		if rec.Code != http.StatusBadGateway {
+			t.Errorf("status = %d, want 502: %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("no chats", func(t *testing.T) {
+		config := useBroadcastChats(t, "")
+		rec := serve(bind(handleBroadcast, config), http.MethodPost, "/broadcast", `{"message":"hi"}`)
+		if rec.Code != http.StatusServiceUnavailable || decodeError(t, rec).Code != "not_configured" {
+			t.Errorf("status = %d, want 503 not_configured: %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("empty message", func(t *testing.T) {
+		config := useBroadcastChats(t, "10")
+		rec := serve(bind(handleBroadcast, config), http.MethodPost, "/broadcast", `{"message":"  "}`)
+		if rec.Code != http.StatusBadRequest || decodeError(t, rec).Code != "message_empty" {
+			t.Errorf("status = %d, want 400 message_empty: %s", rec.Code, rec.Body)
+		}
+	})
+}
+
+func TestBroadcastPreview(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+	useBroadcastChats(t, "10, 20")
+	config := configWith(t, "ADMIN_TOKEN", "admin-secret")
+	preview := requireAdmin(config)(bind(handleBroadcastPreview, config))
+
+	get := func(token string) (*httptest.ResponseRecorder, BroadcastPreview) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/broadcast/preview", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		preview(rec, req)
+		var resp BroadcastPreview
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+
+	if rec, _ := get(""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without a token: status = %d, want 401", rec.Code)
+	}
+
+	rec, resp := get("admin-secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if !slices.Equal(resp.ChatIDs, []string{"10", "20"}) || resp.Count != 2 || resp.Source != "static" {
+		t.Errorf("preview = %+v, want 10 and 20 from static", resp)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`["-1001", "@news", "30"]`))
+	}))
+	t.Cleanup(srv.Close)
+	if err := refreshBroadcastChats(context.Background(), srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	_, resp = get("admin-secret")
+	if !slices.Equal(resp.ChatIDs, []string{"-1001", "@news", "30"}) || resp.Count != 3 || resp.Source != "remote" {
+		t.Errorf("preview = %+v, want the fetched list", resp)
+	}
+
+	if calls := fake.Calls(); len(calls) != 0 {
+		t.Errorf("preview sent to Telegram: %v", calls)
+	}
+}
+
+func TestBroadcastTargetCap(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+	useBroadcastChats(t, "10,20,30")
+
+	tests := []struct {
+		name    string
+		max     string
+		confirm bool
+		status  int
+	}{
+		{"at the cap", "3", false, http.StatusOK},
+		{"above the cap", "2", false, http.StatusBadRequest},
+		{"above the cap, confirmed", "2", true, http.StatusOK},
+		{"at the cap, confirmed", "3", true, http.StatusOK},
+		{"no cap", "0", false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := configWith(t, "BROADCAST_MAX_TARGETS", tt.max)
+			before := len(fake.Calls())
+
+			body, _ := json.Marshal(BroadcastRequest{Message: "hi", ConfirmLarge: tt.confirm})
+			rec := serve(bind(handleBroadcast, config), http.MethodPost, "/broadcast", string(body))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+
+			sent := len(fake.Calls()) - before
+			if tt.status == http.StatusOK {
+				if sent != 3 {
+					t.Errorf("sent %d messages, want 3", sent)
+				}
+				return
+			}
+			resp := decodeError(t, rec)
+			if resp.Code != "too_many_targets" || !strings.Contains(resp.Message, "confirm_large") {
+				t.Errorf("error = %+v, want too_many_targets mentioning confirm_large", resp)
+			}
+			if details, _ := resp.Details.(map[string]any); details["targets"] != 3.0 || details["max_targets"] != 2.0 {
+				t.Errorf("details = %v, want 3 targets and a limit of 2", resp.Details)
+			}
+			if sent != 0 {
+				t.Errorf("a refused broadcast sent %d messages", sent)
+			}
+		})
+	}
+}
+
+func TestBroadcastSession(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+	config := useBroadcastChats(t, "10,20")
+
+	send := func(body string) (*httptest.ResponseRecorder, BroadcastResponse) {
+		t.Helper()
+		rec := serve(bind(handleBroadcast, config), http.MethodPost, "/broadcast", body)
+		var resp BroadcastResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+	texts := func(from int) []string {
+		var out []string
+		for _, call := range fake.Calls()[from:] {
+			var msg TelegramMessage
+			json.Unmarshal(call.Body, &msg)
+			out = append(out, msg.ChatID+" "+msg.Text)
+		}
+		return out
+	}
+
+	rec, resp := send(`{"message":"Launch is on","session_total":3}`)
+	if rec.Code != http.StatusOK || resp.Session == nil {
+		t.Fatalf("status = %d, want 200 with a session: %s", rec.Code, rec.Body)
+	}
+	id := resp.Session.ID
+	if resp.Session.Part != 1 || resp.Session.Total != 3 {
+		t.Errorf("session = %+v, want part 1 of 3", resp.Session)
+	}
+	if got, want := texts(0), []string{"10 (1/3) Launch is on", "20 (1/3) Launch is on"}; !slices.Equal(got, want) {
+		t.Errorf("sent %q, want %q", got, want)
+	}
+
+	for part := 2; part <= 3; part++ {
+		before := len(fake.Calls())
+		rec, resp := send(`{"message":"Update","session_id":"` + id + `"}`)
+		if rec.Code != http.StatusOK || resp.Session == nil || resp.Session.Part != part || resp.Session.ID != id {
+			t.Fatalf("part %d: status = %d: %s", part, rec.Code, rec.Body)
+		}
+		prefix := "(" + strconv.Itoa(part) + "/3) "
+		if got, want := texts(before), []string{"10 " + prefix + "Update", "20 " + prefix + "Update"}; !slices.Equal(got, want) {
+			t.Errorf("part %d: sent %q, want %q", part, got, want)
+		}
+	}
+
+	before := len(fake.Calls())
+	if rec, _ := send(`{"message":"One more","session_id":"` + id + `"}`); rec.Code != http.StatusConflict || decodeError(t, rec).Code != "session_complete" {
+		t.Errorf("past the total: status = %d, want 409 session_complete: %s", rec.Code, rec.Body)
+	}
+	if rec, _ := send(`{"message":"Update","session_id":"nope"}`); rec.Code != http.StatusNotFound || decodeError(t, rec).Code != "session_not_found" {
+		t.Errorf("unknown session: status = %d, want 404 session_not_found: %s", rec.Code, rec.Body)
+	}
+	for _, body := range []string{
+		`{"message":"Update","session_id":"` + id + `","session_total":2}`,
+		`{"message":"Update","session_total":-1}`,
+		`{"message":"Update","session_total":101}`,
+	} {
+		if rec, _ := send(body); rec.Code != http.StatusBadRequest || decodeError(t, rec).Code != "invalid_session" {
+			t.Errorf("%s: status = %d, want 400 invalid_session: %s", body, rec.Code, rec.Body)
+		}
+	}
+	if len(fake.Calls()) != before {
+		t.Errorf("rejected parts were sent: %q", texts(before))
+	}
+}
+
+func TestBroadcastSessionSkipsFailedParts(t *testing.T) {
+	useFakeUpstreams(t)
+	config := useBroadcastChats(t, "10")
+
+	rec := serve(bind(handleBroadcast, config), http.MethodPost, "/broadcast", `{"message":"first","session_total":2}`)
+	var resp BroadcastResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.Session == nil {
+		t.Fatalf("no session: %s", rec.Body)
+	}
+
+	// A part that reached no chat isn't counted, so the retry keeps its
+	// number.
+	telegram = failingTelegram{errors.New("connection refused")}
+	body := `{"message":"second","session_id":"` + resp.Session.ID + `"}`
+	if rec := serve(bind(handleBroadcast, config), http.MethodPost, "/broadcast", body); rec.Code == http.StatusOK {
+		t.Fatalf("failed part answered 200: %s", rec.Body)
+	}
+
+	var text string
+	telegram = telegramFunc(func(method string, body []byte) (json.RawMessage, error) {
+		var msg TelegramMessage
+		json.Unmarshal(body, &msg)
+		text = msg.Text
+		return json.RawMessage(`{"message_id":1}`), nil
+	})
+	if rec := serve(bind(handleBroadcast, config), http.MethodPost, "/broadcast", body); rec.Code != http.StatusOK {
+		t.Fatalf("retry: status = %d: %s", rec.Code, rec.Body)
+	}
+	if text != "(2/2) second" {
+		t.Errorf("retry sent %q, want (2/2) second", text)
+	}
+}
+
+func TestNumberPart(t *testing.T) {
+	if got := numberPart("*Hi*", "MarkdownV2", 1, 2); got != `\(1/2\) *Hi*` {
+		t.Errorf("MarkdownV2: %q", got)
+	}
+	if got := numberPart("<b>Hi</b>", "HTML", 2, 2); got != "(2/2) <b>Hi</b>" {
+		t.Errorf("HTML: %q", got)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const defaultCacheMaxEntries = 10000
+
+// cacheMaxEntries caps caches made without their own limit. main sets it
+// from CACHE_MAX_ENTRIES.
+var cacheMaxEntries = defaultCacheMaxEntries
+
+var (
+	cacheEntries = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "api_cache_entries",
+		Help: "Entries held by each in-memory cache.",
+	}, []string{"cache"})
+
+	cacheEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "api_cache_evictions_total",
+		Help: "Entries dropped from each in-memory cache, by reason: capacity or expired.",
+	}, []string{"cache", "reason"})
+)
+
+type cacheEntry[V any] struct {
+	key     string
+	value   V
+	expires time.Time
+}
+
+// ttlCache is a size-capped LRU whose entries may also expire. Every
+// in-memory store keyed by client input uses it so none can grow without
+// bound. Expired entries are dropped when read or when they reach the LRU
+// tail, and the least recently used entry is evicted once the cache is full.
+type ttlCache[V any] struct {
+	mu          sync.Mutex
+	name        string
+	maxEntries  int
+	ll          *list.List
+	items       map[string]*list.Element
+	evictions   uint64
+	expirations uint64
+
+	// size, evicted and expired are the cache's series of cacheEntries
+	// and cacheEvictions.
+	size    prometheus.Gauge
+	evicted prometheus.Counter
+	expired prometheus.Counter
+}
+
+type CacheStats struct {
+	Name        string `json:"name"`
+	Size        int    `json:"size"`
+	MaxEntries  int    `json:"max_entries"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
+}
+
+type cacheStatser interface {
+	stats() CacheStats
+}
+
+var (
+	cacheRegistryMu sync.Mutex
+	cacheRegistry   []cacheStatser
+)
+
+// newTTLCache registers a cache under name for /debug/caches and the
+// api_cache_* metrics. A maxEntries of 0 defers to CACHE_MAX_ENTRIES, read
+// on each insert since package-level caches are built before .env is
+// loaded.
+func newTTLCache[V any](name string, maxEntries int) *ttlCache[V] {
+	c := &ttlCache[V]{
+		name:       name,
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		size:       cacheEntries.WithLabelValues(name),
+		evicted:    cacheEvictions.WithLabelValues(name, "capacity"),
+		expired:    cacheEvictions.WithLabelValues(name, "expired"),
+	}
+
+	cacheRegistryMu.Lock()
+	cacheRegistry = append(cacheRegistry, c)
+	cacheRegistryMu.Unlock()
+	return c
+}
+
+func (c *ttlCache[V]) limit() int {
+	if c.maxEntries > 0 {
+		return c.maxEntries
+	}
+	return cacheMaxEntries
+}
+
+func (c *ttlCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	entry := el.Value.(*cacheEntry[V])
+	if c.isExpired(entry, time.Now()) {
+		c.expire(el)
+		var zero V
+		return zero, false
+	}
+	c.ll.MoveToFront(el)
+	return entry.value, true
+}
+
+// set stores value under key. A ttl of 0 means the entry only leaves the
+// cache by eviction.
+func (c *ttlCache[V]) set(key string, value V, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.store(key, value, ttl)
+}
+
+// add stores value only if key is absent or expired, and reports whether it
+// did.
+func (c *ttlCache[V]) add(key string, value V, ttl time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		if !c.isExpired(el.Value.(*cacheEntry[V]), time.Now()) {
+			return false
+		}
+		c.expire(el)
+	}
+	c.store(key, value, ttl)
+	return true
+}
+
+// addUnlessFull is add without eviction: when the cache is full of
+// unexpired entries it stores nothing and reports full.
+func (c *ttlCache[V]) addUnlessFull(key string, value V, ttl time.Duration) (added, full bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if el, ok := c.items[key]; ok {
+		if !c.isExpired(el.Value.(*cacheEntry[V]), now) {
+			return false, false
+		}
+		c.expire(el)
+	}
+	for el := c.ll.Back(); el != nil && c.isExpired(el.Value.(*cacheEntry[V]), now); el = c.ll.Back() {
+		c.expire(el)
+	}
+	if c.ll.Len() >= c.limit() {
+		return false, true
+	}
+	c.store(key, value, ttl)
+	return true, false
+}
+
+func (c *ttlCache[V]) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+}
+
+// each calls fn for every unexpired entry, most recently used first.
+func (c *ttlCache[V]) each(fn func(key string, value V, expires time.Time)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for el := c.ll.Front(); el != nil; el = el.Next() {
+		entry := el.Value.(*cacheEntry[V])
+		if !c.isExpired(entry, now) {
+			fn(entry.key, entry.value, entry.expires)
+		}
+	}
+}
+
+func (c *ttlCache[V]) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Name:        c.name,
+		Size:        c.ll.Len(),
+		MaxEntries:  c.limit(),
+		Evictions:   c.evictions,
+		Expirations: c.expirations,
+	}
+}
+
+func (c *ttlCache[V]) store(key string, value V, ttl time.Duration) {
+	now := time.Now()
+	var expires time.Time
+	if ttl > 0 {
+		expires = now.Add(ttl)
+	}
+
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*cacheEntry[V])
+		entry.value = value
+		entry.expires = expires
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	for el := c.ll.Back(); el != nil && c.isExpired(el.Value.(*cacheEntry[V]), now); el = c.ll.Back() {
+		c.expire(el)
+	}
+	for limit := c.limit(); c.ll.Len() >= limit && c.ll.Len() > 0; {
+		c.remove(c.ll.Back())
+		c.evictions++
+		c.evicted.Inc()
+	}
+
+	c.items[key] = c.ll.PushFront(&cacheEntry[V]{key: key, value: value, expires: expires})
+	c.size.Inc()
+}
+
+func (c *ttlCache[V]) isExpired(entry *cacheEntry[V], now time.Time) bool {
+	return !entry.expires.IsZero() && !now.Before(entry.expires)
+}
+
+// expire removes an entry found to have expired.
+func (c *ttlCache[V]) expire(el *list.Element) {
+	c.remove(el)
+	c.expirations++
+	c.expired.Inc()
+}
+
+func (c *ttlCache[V]) remove(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*cacheEntry[V]).key)
+	c.size.Dec()
+}
+
+func handleCacheStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	cacheRegistryMu.Lock()
+	stats := make([]CacheStats, 0, len(cacheRegistry))
+	for _, c := range cacheRegistry {
+		stats = append(stats, c.stats())
+	}
+	cacheRegistryMu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	writeJSON(w, http.StatusOK, map[string][]CacheStats{"caches": stats})
+}
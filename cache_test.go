@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTTLCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	entries, evictions := cacheEntries.WithLabelValues("test_lru"), cacheEvictions.WithLabelValues("test_lru", "capacity")
+	entriesBefore, evictionsBefore := testutil.ToFloat64(entries), testutil.ToFloat64(evictions)
+
+	c := newTTLCache[int]("test_lru", 3)
+	for i, key := range []string{"a", "b", "c"} {
+		c.set(key, i, 0)
+	}
+	// Reading a makes b the least recently used.
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("a is missing")
+	}
+	c.set("d", 3, 0)
+	c.set("e", 4, 0)
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": false, "d": true, "e": true} {
+		if _, ok := c.get(key); ok != want {
+			t.Errorf("%s cached = %v, want %v", key, ok, want)
+		}
+	}
+	if stats := c.stats(); stats.Size != 3 || stats.Evictions != 2 || stats.Expirations != 0 {
+		t.Errorf("stats = %+v, want size 3 after 2 evictions", stats)
+	}
+	if got := testutil.ToFloat64(entries) - entriesBefore; got != 3 {
+		t.Errorf("api_cache_entries grew by %v, want 3", got)
+	}
+	if got := testutil.ToFloat64(evictions) - evictionsBefore; got != 2 {
+		t.Errorf("capacity evictions grew by %v, want 2", got)
+	}
+
+	// Updating an existing key doesn't evict anything.
+	c.set("a", 10, 0)
+	if v, _ := c.get("a"); v != 10 || c.stats().Evictions != 2 {
+		t.Errorf("a = %d with %d evictions, want 10 with 2", v, c.stats().Evictions)
+	}
+}
+
+func TestTTLCacheExpiresEntries(t *testing.T) {
+	entries, expirations := cacheEntries.WithLabelValues("test_ttl"), cacheEvictions.WithLabelValues("test_ttl", "expired")
+	entriesBefore, expirationsBefore := testutil.ToFloat64(entries), testutil.ToFloat64(expirations)
+
+	c := newTTLCache[string]("test_ttl", 10)
+	c.set("short", "x", 20*time.Millisecond)
+	c.set("forever", "y", 0)
+	if c.add("short", "z", time.Minute) {
+		t.Error("add replaced an unexpired entry")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := c.get("short"); ok {
+		t.Error("an expired entry was returned")
+	}
+	if _, ok := c.get("forever"); !ok {
+		t.Error("an entry without a TTL expired")
+	}
+
+	// An expired key can be added again.
+	c.set("again", "x", 20*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	if !c.add("again", "y", time.Minute) {
+		t.Error("add refused to replace an expired entry")
+	}
+
+	if stats := c.stats(); stats.Size != 2 || stats.Expirations != 2 || stats.Evictions != 0 {
+		t.Errorf("stats = %+v, want size 2 after 2 expirations", stats)
+	}
+	if got := testutil.ToFloat64(expirations) - expirationsBefore; got != 2 {
+		t.Errorf("expired evictions grew by %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(entries) - entriesBefore; got != 2 {
+		t.Errorf("api_cache_entries grew by %v, want 2", got)
+	}
+}
+
+func TestHandleCacheStats(t *testing.T) {
+	c := newTTLCache[bool]("test_stats", 5)
+	c.set("k", true, 0)
+
+	rec := serve(handleCacheStats, http.MethodGet, "/debug/caches", "")
+	var resp struct {
+		Caches []CacheStats `json:"caches"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	for _, stats := range resp.Caches {
+		if stats.Name == "test_stats" && stats.Size >= 1 && stats.MaxEntries == 5 {
+			return
+		}
+	}
+	t.Errorf("/debug/caches = %s, want test_stats listed", rec.Body)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// useCaptchaProvider points Turnstile's siteverify at handler until the
+// test ends and returns a config protecting /send with it.
+func useCaptchaProvider(t *testing.T, handler http.HandlerFunc) Config {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	orig := captchaVerifyURLs["turnstile"]
+	t.Cleanup(func() { captchaVerifyURLs["turnstile"] = orig })
+	captchaVerifyURLs["turnstile"] = srv.URL
+	return configWith(t, "CAPTCHA_PROVIDER", "turnstile", "CAPTCHA_SECRET", "captcha-secret", "CAPTCHA_ROUTES", "/send")
+}
+
+// captchaVerdict answers siteverify with success when the token is "pass".
+func captchaVerdict(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	if r.Form.Get("secret") != "captcha-secret" {
+		http.Error(w, "bad secret", http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]bool{"success": r.Form.Get("response") == "pass"})
+}
+
+// captchaProtected is /send's body decoding behind withCaptcha. It answers
+// with the message it decoded.
+func captchaProtected(config Config) http.HandlerFunc {
+	return withCaptcha(config)(func(w http.ResponseWriter, r *http.Request) {
+		var req MessageRequest
+		if !decodeBody(w, r, &req, config) {
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": req.Message})
+	})
+}
+
+func captchaRequest(config Config, path, header, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if header != "" {
+		req.Header.Set("X-Captcha-Token", header)
+	}
+	rec := httptest.NewRecorder()
+	captchaProtected(config)(rec, req)
+	return rec
+}
+
+func TestCaptchaAcceptsVerifiedTokens(t *testing.T) {
+	config := useCaptchaProvider(t, captchaVerdict)
+
+	if rec := captchaRequest(config, "/send", "pass", `{"message":"hi"}`); rec.Code != http.StatusOK {
+		t.Errorf("header token: %d %s", rec.Code, rec.Body)
+	}
+	// The body field is removed before the handler's strict decoding.
+	if rec := captchaRequest(config, "/send", "", `{"message":"hi","captcha_token":"pass"}`); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"message":"hi"`) {
+		t.Errorf("body token: %d %s", rec.Code, rec.Body)
+	}
+	// Routes not listed in CAPTCHA_ROUTES need no token.
+	if rec := captchaRequest(config, "/subscribe", "", `{"message":"hi"}`); rec.Code != http.StatusOK {
+		t.Errorf("unprotected route: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestCaptchaRejectsMissingAndFailedTokens(t *testing.T) {
+	config := useCaptchaProvider(t, captchaVerdict)
+
+	tests := []struct {
+		name   string
+		header string
+		body   string
+		status int
+		code   string
+	}{
+		{"no token", "", `{"message":"hi"}`, http.StatusBadRequest, "captcha_required"},
+		{"failed header token", "fail", `{"message":"hi"}`, http.StatusForbidden, "captcha_failed"},
+		{"failed body token", "", `{"message":"hi","captcha_token":"fail"}`, http.StatusForbidden, "captcha_failed"},
+		{"token is not a string", "", `{"message":"hi","captcha_token":1}`, http.StatusBadRequest, "invalid_request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := captchaRequest(config, "/send", tt.header, tt.body)
+			if rec.Code != tt.status || decodeError(t, rec).Code != tt.code {
+				t.Errorf("got %d %s, want %d %s", rec.Code, rec.Body, tt.status, tt.code)
+			}
+		})
+	}
+}
+
+func TestCaptchaFailsClosed(t *testing.T) {
+	var calls atomic.Int32
+	config := useCaptchaProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "down", http.StatusInternalServerError)
+	})
+
+	rec := captchaRequest(config, "/send", "pass", `{"message":"hi"}`)
+	if rec.Code < 500 || strings.Contains(rec.Body.String(), `"message":"hi"`) {
+		t.Errorf("provider down: got %d %s, want the request refused", rec.Code, rec.Body)
+	}
+	if calls.Load() == 0 {
+		t.Error("the provider was never asked")
+	}
+
+	// A misconfigured provider refuses requests rather than letting them
+	// through unchecked.
+	config = configWith(t, "CAPTCHA_PROVIDER", "recaptcha")
+	if rec := captchaRequest(config, "/send", "pass", `{"message":"hi"}`); rec.Code < 500 {
+		t.Errorf("unknown provider: got %d %s, want the request refused", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseChatTargets parses TELEGRAM_CHATS, a comma-separated list of
+// name:chat-id entries such as "alerts:-1001234,signups:@signups", which
+// lets clients pick a chat by name instead of by ID.
+func parseChatTargets(value string) (map[string]string, error) {
+	targets := make(map[string]string)
+	for _, entry := range splitList(value) {
+		name, chatID, ok := strings.Cut(entry, ":")
+		name, chatID = strings.TrimSpace(name), strings.TrimSpace(chatID)
+		if !ok || name == "" || chatID == "" {
+			return nil, fmt.Errorf("invalid entry %q, expected name:chat_id", entry)
+		}
+		if _, dup := targets[name]; dup {
+			return nil, fmt.Errorf("target %q is listed more than once", name)
+		}
+		targets[name] = chatID
+	}
+	return targets, nil
+}
+
+// chatForTarget resolves a target name to its chat ID in TELEGRAM_CHATS.
+// An empty name falls back to TELEGRAM_DEFAULT_TARGET, and without one to
+// config.ChatID.
+func chatForTarget(name string, config Config) (string, bool) {
+	if name == "" {
+		name = config.TelegramDefaultTarget
+	}
+	if name == "" {
+		return config.ChatID, true
+	}
+	chatID, ok := config.TelegramChats[name]
+	return chatID, ok
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	defaultCircuitBreakerThreshold = 5
+	defaultCircuitBreakerCooldown  = 30 * time.Second
+)
+
+// breakerThreshold and breakerCooldown apply to every breaker. main sets
+// them from CIRCUIT_BREAKER_THRESHOLD and CIRCUIT_BREAKER_COOLDOWN.
+var (
+	breakerThreshold = defaultCircuitBreakerThreshold
+	breakerCooldown  = defaultCircuitBreakerCooldown
+)
+
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half_open"
+)
+
+var circuitState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "api_upstream_circuit_state",
+	Help: "Circuit breaker state per upstream: 0 closed, 1 half-open, 2 open.",
+}, []string{"upstream"})
+
+// CircuitOpenError is returned instead of calling an upstream whose breaker
+// is open. RetryAfter is the time left until the next trial call.
+type CircuitOpenError struct {
+	Upstream   string
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s is unavailable, circuit breaker open", e.Upstream)
+}
+
+// circuitBreaker stops calls to an upstream after
+// CIRCUIT_BREAKER_THRESHOLD consecutive failures (network errors, 429 and
+// 5xx, as counted by instrumentedTransport). Once CIRCUIT_BREAKER_COOLDOWN
+// has passed a single trial call is let through: success closes the
+// breaker, failure opens it for another cooldown.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	name      string
+	state     string
+	failures  int
+	openedAt  time.Time
+	lastError string
+	trial     bool
+}
+
+// UpstreamHealth is a breaker's state as reported by /health.
+type UpstreamHealth struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+}
+
+var (
+	breakersMu sync.Mutex
+	breakers   = make(map[string]*circuitBreaker)
+)
+
+// breakerFor returns the breaker for an upstream, as named by upstreamName.
+func breakerFor(name string) *circuitBreaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	b, ok := breakers[name]
+	if !ok {
+		b = &circuitBreaker{name: name, state: circuitClosed}
+		breakers[name] = b
+		circuitState.WithLabelValues(name).Set(0)
+	}
+	return b
+}
+
+// allow reports whether a call may go ahead. It never blocks a call when
+// CIRCUIT_BREAKER_THRESHOLD is 0.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == circuitClosed || breakerThreshold <= 0 {
+		return nil
+	}
+	if wait := breakerCooldown - time.Since(b.openedAt); b.state == circuitOpen && wait > 0 {
+		return &CircuitOpenError{Upstream: b.name, RetryAfter: wait}
+	}
+	if b.trial {
+		return &CircuitOpenError{Upstream: b.name, RetryAfter: time.Second}
+	}
+	b.setState(circuitHalfOpen)
+	b.trial = true
+	return nil
+}
+
+// record updates the breaker with a call's outcome. cause describes a
+// failure for /health.
+func (b *circuitBreaker) record(ok bool, cause string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+	if ok {
+		b.failures = 0
+		b.lastError = ""
+		b.setState(circuitClosed)
+		return
+	}
+
+	b.failures++
+	b.lastError = cause
+	if b.state == circuitHalfOpen || (breakerThreshold > 0 && b.failures >= breakerThreshold) {
+		b.openedAt = time.Now()
+		b.setState(circuitOpen)
+	}
+}
+
+// abandon ends a call without judging the upstream, so a trial call the
+// caller cancelled lets the next one through.
+func (b *circuitBreaker) abandon() {
+	b.mu.Lock()
+	b.trial = false
+	b.mu.Unlock()
+}
+
+// setState changes state and its gauge. The caller holds b.mu.
+func (b *circuitBreaker) setState(state string) {
+	b.state = state
+	value := map[string]float64{circuitClosed: 0, circuitHalfOpen: 1, circuitOpen: 2}[state]
+	circuitState.WithLabelValues(b.name).Set(value)
+}
+
+// upstreamHealth returns the state of every upstream called so far.
+func upstreamHealth() map[string]UpstreamHealth {
+	breakersMu.Lock()
+	names := make([]string, 0, len(breakers))
+	for name := range breakers {
+		names = append(names, name)
+	}
+	breakersMu.Unlock()
+	sort.Strings(names)
+
+	health := make(map[string]UpstreamHealth, len(names))
+	for _, name := range names {
+		b := breakerFor(name)
+		b.mu.Lock()
+		h := UpstreamHealth{State: b.state, ConsecutiveFailures: b.failures, LastError: b.lastError}
+		if b.state != circuitClosed {
+			openedAt := b.openedAt
+			h.OpenedAt = &openedAt
+		}
+		b.mu.Unlock()
+		health[name] = h
+	}
+	return health
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestBreaker returns a breaker for the test alone, opening after
+// threshold failures for cooldown.
+func newTestBreaker(t *testing.T, threshold int, cooldown time.Duration) *circuitBreaker {
+	t.Helper()
+	useBreakerSettings(t, threshold, cooldown)
+	name := "test:" + t.Name()
+	t.Cleanup(func() {
+		breakersMu.Lock()
+		delete(breakers, name)
+		breakersMu.Unlock()
+	})
+	return breakerFor(name)
+}
+
+// useBreakerSettings sets the breaker settings, as main does, until the
+// test ends.
+func useBreakerSettings(t *testing.T, threshold int, cooldown time.Duration) {
+	t.Helper()
+	origThreshold, origCooldown := breakerThreshold, breakerCooldown
+	t.Cleanup(func() { breakerThreshold, breakerCooldown = origThreshold, origCooldown })
+	breakerThreshold, breakerCooldown = threshold, cooldown
+}
+
+func TestCircuitBreakerOpensAfterThreshold(t *testing.T) {
+	b := newTestBreaker(t, 3, time.Hour)
+
+	for i := range 2 {
+		b.record(false, "HTTP 503")
+		if err := b.allow(); err != nil {
+			t.Fatalf("after %d failures: %v, want the breaker closed", i+1, err)
+		}
+	}
+	// A success resets the count.
+	b.record(true, "")
+	b.record(false, "HTTP 503")
+	b.record(false, "HTTP 503")
+	if err := b.allow(); err != nil {
+		t.Fatalf("failures before a success were counted: %v", err)
+	}
+
+	b.record(false, "HTTP 503")
+	var open *CircuitOpenError
+	if err := b.allow(); !errors.As(err, &open) {
+		t.Fatalf("after 3 consecutive failures: %v, want CircuitOpenError", err)
+	}
+	if open.RetryAfter <= 59*time.Minute || open.RetryAfter > time.Hour {
+		t.Errorf("retry after %v, want the rest of the cooldown", open.RetryAfter)
+	}
+	h := upstreamHealth()[b.name]
+	if h.State != circuitOpen || h.ConsecutiveFailures != 3 || h.LastError != "HTTP 503" || h.OpenedAt == nil {
+		t.Errorf("health = %+v", h)
+	}
+}
+
+func TestCircuitBreakerHalfOpenTrial(t *testing.T) {
+	b := newTestBreaker(t, 1, 20*time.Millisecond)
+	b.record(false, "timeout")
+	if err := b.allow(); err == nil {
+		t.Fatal("the breaker didn't open")
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	// One trial call goes through after the cooldown; others wait for it.
+	if err := b.allow(); err != nil {
+		t.Fatalf("trial call refused: %v", err)
+	}
+	if upstreamHealth()[b.name].State != circuitHalfOpen {
+		t.Errorf("state = %s, want half_open", upstreamHealth()[b.name].State)
+	}
+	if err := b.allow(); err == nil {
+		t.Error("a second call went through during the trial")
+	}
+
+	// A failed trial opens the breaker for another cooldown.
+	b.record(false, "timeout")
+	if err := b.allow(); err == nil {
+		t.Fatal("the breaker didn't reopen after a failed trial")
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	// A successful trial closes it.
+	if err := b.allow(); err != nil {
+		t.Fatalf("second trial refused: %v", err)
+	}
+	b.record(true, "")
+	for range 3 {
+		if err := b.allow(); err != nil {
+			t.Fatalf("after a successful trial: %v, want the breaker closed", err)
+		}
+	}
+	if h := upstreamHealth()[b.name]; h.State != circuitClosed || h.ConsecutiveFailures != 0 || h.OpenedAt != nil {
+		t.Errorf("health = %+v", h)
+	}
+}
+
+func TestCircuitBreakerAbandonedTrialLetsTheNextOneThrough(t *testing.T) {
+	b := newTestBreaker(t, 1, 10*time.Millisecond)
+	b.record(false, "timeout")
+	time.Sleep(20 * time.Millisecond)
+
+	if err := b.allow(); err != nil {
+		t.Fatal(err)
+	}
+	b.abandon()
+	if err := b.allow(); err != nil {
+		t.Errorf("after an abandoned trial: %v, want another trial", err)
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	b := newTestBreaker(t, 0, time.Hour)
+	for range 10 {
+		b.record(false, "HTTP 500")
+	}
+	if err := b.allow(); err != nil {
+		t.Errorf("CIRCUIT_BREAKER_THRESHOLD=0: %v, want calls never blocked", err)
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxCommentAuthorLength   = 100
+	defaultMaxCommentLength  = 5000
+	defaultCommentFlagLimit  = 3
+	maxCommentSlugLength     = 200
+	commentApproved          = "approved"
+	commentPending           = "pending"
+	commentNotifyPreviewSize = 500
+)
+
+// commentSlug matches the page identifiers comments are filed under, such
+// as "2024/hello-world".
+var commentSlug = regexp.MustCompile(`^[a-z0-9][a-z0-9/_-]*$`)
+
+// commentFlags counts each address once per flagged comment.
+var commentFlags = newDedupStore("comment_flags")
+
+// Comment is a stored comment. Pending comments, whether held for review
+// with COMMENTS_MODERATION=true or flagged by readers, are only shown to
+// the admin.
+type Comment struct {
+	ID        string    `json:"id"`
+	Slug      string    `json:"slug"`
+	ParentID  string    `json:"parent_id,omitempty"`
+	Author    string    `json:"author"`
+	Body      string    `json:"body"`
+	Status    string    `json:"status"`
+	Flags     int       `json:"flags"`
+	IPHash    string    `json:"ip_hash,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CommentView is a comment as shown to readers, with its replies nested.
+type CommentView struct {
+	ID        string        `json:"id"`
+	Author    string        `json:"author"`
+	Body      string        `json:"body"`
+	CreatedAt time.Time     `json:"created_at"`
+	Replies   []CommentView `json:"replies,omitempty"`
+}
+
+type CommentRequest struct {
+	Slug     string `json:"slug"`
+	ParentID string `json:"parent_id,omitempty"`
+	Author   string `json:"author"`
+	Body     string `json:"body"`
+}
+
+// commentStore keeps comments in the database.
+type commentStore struct {
+	db *sql.DB
+}
+
+var comments = &commentStore{db: database}
+
+const commentColumns = `id, slug, parent_id, author, body, status, flags, ip_hash, created_at`
+
+// add stores c. It returns false when c replies to a comment that doesn't
+// exist on the same page.
+func (s *commentStore) add(c *Comment) (bool, error) {
+	res, err := s.db.Exec(`INSERT INTO comments (`+commentColumns+`)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?
+		WHERE ? = '' OR EXISTS (SELECT 1 FROM comments WHERE id = ? AND slug = ?)`,
+		c.ID, c.Slug, c.ParentID, c.Author, c.Body, c.Status, c.Flags, c.IPHash, c.CreatedAt.UnixMilli(),
+		c.ParentID, c.ParentID, c.Slug)
+	if err != nil {
+		return false, fmt.Errorf("error saving comment: %v", err)
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// approve releases the comment with id from moderation and clears its
+// flags. It returns false if there is no such comment.
+func (s *commentStore) approve(id string) (bool, error) {
+	res, err := s.db.Exec(`UPDATE comments SET status = ?, flags = 0 WHERE id = ?`, commentApproved, id)
+	if err != nil {
+		return false, fmt.Errorf("error approving comment: %v", err)
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// flag counts a reader's flag on the comment with id, holding it for
+// review once it has threshold flags. It returns false if there is no such
+// comment.
+func (s *commentStore) flag(id string, threshold int) (bool, error) {
+	res, err := s.db.Exec(`UPDATE comments SET flags = flags + 1,
+		status = CASE WHEN flags + 1 >= ? THEN ? ELSE status END
+		WHERE id = ?`, threshold, commentPending, id)
+	if err != nil {
+		return false, fmt.Errorf("error flagging comment: %v", err)
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// remove deletes the comment with id along with its replies, which would
+// otherwise be left without a thread. It returns how many were deleted.
+func (s *commentStore) remove(id string) (int, error) {
+	res, err := s.db.Exec(`WITH RECURSIVE doomed(id) AS (
+			SELECT id FROM comments WHERE id = ?
+			UNION SELECT comments.id FROM comments JOIN doomed ON comments.parent_id = doomed.id
+		)
+		DELETE FROM comments WHERE id IN doomed`, id)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting comment: %v", err)
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// list returns the comments with status, on slug or on every page when
+// slug is empty, oldest first.
+func (s *commentStore) list(slug, status string) ([]Comment, error) {
+	rows, err := s.db.Query(`SELECT `+commentColumns+` FROM comments
+		WHERE status = ? AND (? = '' OR slug = ?) ORDER BY created_at, id`, status, slug, slug)
+	if err != nil {
+		return nil, fmt.Errorf("error reading comments: %v", err)
+	}
+	defer rows.Close()
+
+	found := []Comment{}
+	for rows.Next() {
+		var c Comment
+		var createdAt int64
+		if err := rows.Scan(&c.ID, &c.Slug, &c.ParentID, &c.Author, &c.Body, &c.Status, &c.Flags, &c.IPHash, &createdAt); err != nil {
+			return nil, fmt.Errorf("error reading comments: %v", err)
+		}
+		c.CreatedAt = fromUnixMilli(createdAt)
+		found = append(found, c)
+	}
+	return found, rows.Err()
+}
+
+// threadComments nests comments under their parents and returns how many
+// it placed. Replies to a comment that isn't in list are left out with it.
+func threadComments(list []Comment) ([]CommentView, int) {
+	children := make(map[string][]Comment)
+	for _, c := range list {
+		children[c.ParentID] = append(children[c.ParentID], c)
+	}
+	n := 0
+	var build func(parentID string) []CommentView
+	build = func(parentID string) []CommentView {
+		var views []CommentView
+		for _, c := range children[parentID] {
+			n++
+			views = append(views, CommentView{
+				ID:        c.ID,
+				Author:    c.Author,
+				Body:      c.Body,
+				CreatedAt: c.CreatedAt,
+				Replies:   build(c.ID),
+			})
+		}
+		return views
+	}
+	views := build("")
+	if views == nil {
+		views = []CommentView{}
+	}
+	return views, n
+}
+
+func validateComment(req CommentRequest, maxLength int) fieldErrors {
+	var errs fieldErrors
+	switch {
+	case req.Slug == "":
+		errs.add("slug", "Slug cannot be empty")
+	case len(req.Slug) > maxCommentSlugLength || !commentSlug.MatchString(req.Slug):
+		errs.add("slug", "Slug may only contain lowercase letters, digits, /, _ and -")
+	}
+	errs.text("author", "Author", req.Author, maxCommentAuthorLength)
+	if strings.ContainsAny(req.Author, "\r\n") {
+		errs.add("author", "Author must be a single line")
+	}
+	errs.text("body", "Comment", req.Body, maxLength)
+	return errs
+}
+
+// handleComments lists a page's comments on GET and adds one on POST. The
+// admin can list comments by status instead, e.g. ?status=pending for the
+// moderation queue.
+func handleComments(w http.ResponseWriter, r *http.Request, config Config) {
+	switch r.Method {
+	case http.MethodGet:
+		listComments(w, r, config)
+	case http.MethodPost:
+		withCaptcha(config)(withSpamFilter("comment", func(w http.ResponseWriter, r *http.Request) {
+			createComment(w, r, config)
+		}, config))(w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+func listComments(w http.ResponseWriter, r *http.Request, config Config) {
+	slug := r.URL.Query().Get("slug")
+
+	if status := r.URL.Query().Get("status"); status != "" {
+		if !isAdmin(r, config) {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+			return
+		}
+		found, err := comments.list(slug, status)
+		if err != nil {
+			log.Printf("Error listing comments: %v", err)
+			writeError(w, http.StatusInternalServerError, "comments_unavailable", "Could not read comments")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"comments": found})
+		return
+	}
+
+	if slug == "" {
+		writeError(w, http.StatusBadRequest, "slug_required", "Slug is required")
+		return
+	}
+	found, err := comments.list(slug, commentApproved)
+	if err != nil {
+		log.Printf("Error listing comments: %v", err)
+		writeError(w, http.StatusInternalServerError, "comments_unavailable", "Could not read comments")
+		return
+	}
+	thread, n := threadComments(found)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"slug":     slug,
+		"count":    n,
+		"comments": thread,
+	})
+}
+
+func createComment(w http.ResponseWriter, r *http.Request, config Config) {
+	var req CommentRequest
+	if !decodeBody(w, r, &req, config) {
+		return
+	}
+	req.Slug = strings.Trim(strings.TrimSpace(req.Slug), "/")
+	req.Author = strings.TrimSpace(req.Author)
+	req.Body = strings.TrimSpace(sanitizeControlChars(req.Body, "strip"))
+	if validateComment(req, config.CommentsMaxLength).write(w) {
+		return
+	}
+
+	c := &Comment{
+		ID:        newRequestID(),
+		Slug:      req.Slug,
+		ParentID:  req.ParentID,
+		Author:    req.Author,
+		Body:      req.Body,
+		Status:    commentApproved,
+		IPHash:    hashIP(clientIP(r)),
+		CreatedAt: time.Now().UTC(),
+	}
+	if config.CommentsModeration {
+		c.Status = commentPending
+	}
+	added, err := comments.add(c)
+	if err != nil {
+		log.Printf("Error saving comment: %v", err)
+		writeError(w, http.StatusInternalServerError, "comments_unavailable", "Could not save the comment")
+		return
+	}
+	if !added {
+		writeError(w, http.StatusBadRequest, "parent_not_found", "Parent comment does not exist on this page")
+		return
+	}
+
+	auditLog.record("comment", map[string]string{
+		"id":      c.ID,
+		"slug":    c.Slug,
+		"ip_hash": c.IPHash,
+	})
+
+	// A failed notification doesn't lose the comment, so it is only logged.
+	if config.CommentsNotify {
+		body := c.Body
+		if utf8.RuneCountInString(body) > commentNotifyPreviewSize {
+			body = string([]rune(body)[:commentNotifyPreviewSize]) + "…"
+		}
+		text, err := renderTemplate("new_comment", map[string]interface{}{
+			"slug":   c.Slug,
+			"author": c.Author,
+			"body":   body,
+			"id":     c.ID,
+			"status": c.Status,
+			"reply":  c.ParentID != "",
+		})
+		if err == nil {
+			_, err = sendTelegramMessage(r.Context(), config, text, SendOptions{ParseMode: "HTML"})
+		}
+		if err != nil {
+			log.Printf("Warning: comment notification failed: %v", err)
+		}
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]string{"id": c.ID, "status": c.Status})
+}
+
+// handleComment serves /comments/{id}: DELETE removes a comment and its
+// replies, and POST to /comments/{id}/approve releases it from moderation;
+// both need the admin token. Anyone may POST to /comments/{id}/flag, and a
+// comment with COMMENTS_FLAG_THRESHOLD flags is held for review.
+func handleComment(w http.ResponseWriter, r *http.Request, config Config) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/comments/"), "/")
+	if id == "" {
+		writeError(w, http.StatusNotFound, "comment_not_found", "Comment not found")
+		return
+	}
+
+	switch action {
+	case "":
+		if r.Method != http.MethodDelete {
+			writeMethodNotAllowed(w, http.MethodDelete)
+			return
+		}
+		if !isAdmin(r, config) {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+			return
+		}
+		n, err := comments.remove(id)
+		if err != nil {
+			log.Printf("Error deleting comment: %v", err)
+			writeError(w, http.StatusInternalServerError, "comments_unavailable", "Could not delete the comment")
+			return
+		}
+		if n == 0 {
+			writeError(w, http.StatusNotFound, "comment_not_found", "Comment not found")
+			return
+		}
+		auditLog.record("comment_deleted", map[string]string{"id": id})
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "deleted", "deleted": n})
+
+	case "approve":
+		if r.Method != http.MethodPost {
+			writeMethodNotAllowed(w, http.MethodPost)
+			return
+		}
+		if !isAdmin(r, config) {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+			return
+		}
+		ok, err := comments.approve(id)
+		if err != nil {
+			log.Printf("Error approving comment: %v", err)
+			writeError(w, http.StatusInternalServerError, "comments_unavailable", "Could not approve the comment")
+			return
+		}
+		if !ok {
+			writeError(w, http.StatusNotFound, "comment_not_found", "Comment not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"id": id, "status": commentApproved})
+
+	case "flag":
+		if r.Method != http.MethodPost {
+			writeMethodNotAllowed(w, http.MethodPost)
+			return
+		}
+		key := id + " " + hashIP(clientIP(r))
+		if !commentFlags.reserve(key, 30*24*time.Hour) {
+			writeJSON(w, http.StatusOK, map[string]string{"status": "flagged"})
+			return
+		}
+		ok, err := comments.flag(id, config.CommentsFlagThreshold)
+		if err != nil {
+			commentFlags.release(key)
+			log.Printf("Error flagging comment: %v", err)
+			writeError(w, http.StatusInternalServerError, "comments_unavailable", "Could not flag the comment")
+			return
+		}
+		if !ok {
+			commentFlags.release(key)
+			writeError(w, http.StatusNotFound, "comment_not_found", "Comment not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "flagged"})
+
+	default:
+		writeError(w, http.StatusNotFound, "not_found", "Not found")
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// postComment adds a comment and returns its id.
+func postComment(t *testing.T, config Config, body string) string {
+	t.Helper()
+	rec := serve(bind(handleComments, config), http.MethodPost, "/comments", body)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST /comments %s: status = %d: %s", body, rec.Code, rec.Body)
+	}
+	var resp map[string]string
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return resp["id"]
+}
+
+// commentAction calls /comments/{path} from remoteAddr, as the admin when
+// admin is set.
+func commentAction(config Config, method, path, remoteAddr string, admin bool) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/comments/"+path, nil)
+	req.RemoteAddr = remoteAddr
+	if admin {
+		req.Header.Set("Authorization", "Bearer test-admin")
+	}
+	rec := httptest.NewRecorder()
+	handleComment(rec, req, config)
+	return rec
+}
+
+type commentThread struct {
+	Count    int           `json:"count"`
+	Comments []CommentView `json:"comments"`
+}
+
+func commentsOn(t *testing.T, config Config, slug string) commentThread {
+	t.Helper()
+	rec := serve(bind(handleComments, config), http.MethodGet, "/comments?slug="+slug, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /comments: status = %d: %s", rec.Code, rec.Body)
+	}
+	var thread commentThread
+	if err := json.Unmarshal(rec.Body.Bytes(), &thread); err != nil {
+		t.Fatal(err)
+	}
+	return thread
+}
+
+func TestCommentsAreThreadedPerSlug(t *testing.T) {
+	useDatabase(t)
+	fake, _ := useFakeUpstreams(t)
+	config := testConfig
+
+	root := postComment(t, config, `{"slug":"/2024/hello/","author":"Ada","body":"First!"}`)
+	postComment(t, config, `{"slug":"2024/hello","parent_id":"`+root+`","author":"Grace","body":"Welcome"}`)
+	postComment(t, config, `{"slug":"2024/other","author":"Linus","body":"Elsewhere"}`)
+
+	thread := commentsOn(t, config, "2024/hello")
+	if thread.Count != 2 || len(thread.Comments) != 1 {
+		t.Fatalf("thread = %+v, want one comment with one reply", thread)
+	}
+	if c := thread.Comments[0]; c.Author != "Ada" || len(c.Replies) != 1 || c.Replies[0].Body != "Welcome" {
+		t.Errorf("thread = %+v", c)
+	}
+	if got := sentText(t, fake); !strings.Contains(got, "2024/other") {
+		t.Errorf("last notification = %q, want one per comment", got)
+	}
+	if n := len(fake.Calls()); n != 3 {
+		t.Errorf("%d notifications, want 3", n)
+	}
+
+	// A reply must be to a comment on the same page.
+	rec := serve(bind(handleComments, config), http.MethodPost, "/comments", `{"slug":"2024/other","parent_id":"`+root+`","author":"Eve","body":"Hi"}`)
+	if rec.Code != http.StatusBadRequest || decodeError(t, rec).Code != "parent_not_found" {
+		t.Errorf("cross-page reply: %d %s, want 400 parent_not_found", rec.Code, rec.Body)
+	}
+}
+
+func TestCommentModeration(t *testing.T) {
+	useDatabase(t)
+	useFakeUpstreams(t)
+	config := configWith(t, "COMMENTS_MODERATION", "true", "ADMIN_TOKEN", "test-admin")
+
+	id := postComment(t, config, `{"slug":"post","author":"Ada","body":"Held"}`)
+	if thread := commentsOn(t, config, "post"); thread.Count != 0 {
+		t.Errorf("a comment awaiting moderation is shown: %+v", thread)
+	}
+
+	// Only the admin sees the moderation queue.
+	if rec := serve(bind(handleComments, config), http.MethodGet, "/comments?status=pending", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("queue without the token: status = %d, want 401", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/comments?status=pending", nil)
+	req.Header.Set("Authorization", "Bearer test-admin")
+	rec := httptest.NewRecorder()
+	bind(handleComments, config)(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), id) {
+		t.Errorf("queue: %d %s, want the held comment", rec.Code, rec.Body)
+	}
+
+	if rec := commentAction(config, http.MethodPost, id+"/approve", "192.0.2.1:1000", false); rec.Code != http.StatusUnauthorized {
+		t.Errorf("approve without the token: status = %d, want 401", rec.Code)
+	}
+	if rec := commentAction(config, http.MethodPost, id+"/approve", "192.0.2.1:1000", true); rec.Code != http.StatusOK {
+		t.Fatalf("approve: %d %s", rec.Code, rec.Body)
+	}
+	if thread := commentsOn(t, config, "post"); thread.Count != 1 {
+		t.Errorf("the approved comment isn't shown: %+v", thread)
+	}
+	if rec := commentAction(config, http.MethodPost, "missing/approve", "192.0.2.1:1000", true); rec.Code != http.StatusNotFound {
+		t.Errorf("approving a missing comment: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestCommentFlagThreshold(t *testing.T) {
+	useDatabase(t)
+	useFakeUpstreams(t)
+	config := configWith(t, "COMMENTS_FLAG_THRESHOLD", "2")
+
+	id := postComment(t, config, `{"slug":"flagged","author":"Troll","body":"Rude"}`)
+
+	// The same reader flagging twice counts once.
+	commentAction(config, http.MethodPost, id+"/flag", "192.0.2.1:1000", false)
+	commentAction(config, http.MethodPost, id+"/flag", "192.0.2.1:1000", false)
+	if thread := commentsOn(t, config, "flagged"); thread.Count != 1 {
+		t.Fatalf("one reader's flags hid the comment: %+v", thread)
+	}
+
+	if rec := commentAction(config, http.MethodPost, id+"/flag", "192.0.2.2:1000", false); rec.Code != http.StatusOK {
+		t.Fatalf("flag: %d %s", rec.Code, rec.Body)
+	}
+	if thread := commentsOn(t, config, "flagged"); thread.Count != 0 {
+		t.Errorf("a comment with 2 flags is still shown: %+v", thread)
+	}
+	if rec := commentAction(config, http.MethodPost, "missing/flag", "192.0.2.3:1000", false); rec.Code != http.StatusNotFound {
+		t.Errorf("flagging a missing comment: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestCommentDeleteIsAdminOnly(t *testing.T) {
+	useDatabase(t)
+	useFakeUpstreams(t)
+	config := configWith(t, "ADMIN_TOKEN", "test-admin")
+
+	root := postComment(t, config, `{"slug":"deleted","author":"Ada","body":"Root"}`)
+	reply := postComment(t, config, `{"slug":"deleted","parent_id":"`+root+`","author":"Grace","body":"Reply"}`)
+	postComment(t, config, `{"slug":"deleted","parent_id":"`+reply+`","author":"Linus","body":"Nested"}`)
+	postComment(t, config, `{"slug":"deleted","author":"Ken","body":"Unrelated"}`)
+
+	if rec := commentAction(config, http.MethodDelete, root, "192.0.2.1:1000", false); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("delete without the token: status = %d, want 401", rec.Code)
+	}
+	rec := commentAction(config, http.MethodDelete, root, "192.0.2.1:1000", true)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"deleted":3`) {
+		t.Fatalf("delete: %d %s, want the comment and both replies deleted", rec.Code, rec.Body)
+	}
+	if thread := commentsOn(t, config, "deleted"); thread.Count != 1 || thread.Comments[0].Author != "Ken" {
+		t.Errorf("thread after delete = %+v", thread)
+	}
+	if rec := commentAction(config, http.MethodDelete, root, "192.0.2.1:1000", true); rec.Code != http.StatusNotFound {
+		t.Errorf("deleting again: status = %d, want 404", rec.Code)
+	}
+}
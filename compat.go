@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// telegramFieldVersions lists request fields and the Bot API version that
+// introduced them, so payloads can be downgraded for older API servers.
+var telegramFieldVersions = map[string]apiVersion{
+	"google_place_id":          {5, 2},
+	"protect_content":          {5, 6},
+	"message_thread_id":        {6, 3},
+	"link_preview_options":     {7, 0},
+	"reply_parameters":         {7, 0},
+	"reaction":                 {7, 0},
+	"business_connection_id":   {7, 2},
+	"message_effect_id":        {7, 4},
+	"show_caption_above_media": {7, 4},
+}
+
+type apiVersion struct {
+	major, minor int
+}
+
+func (v apiVersion) less(o apiVersion) bool {
+	return v.major < o.major || (v.major == o.major && v.minor < o.minor)
+}
+
+func parseAPIVersion(value string) (apiVersion, error) {
+	major, minor, _ := strings.Cut(strings.TrimSpace(value), ".")
+	var v apiVersion
+	var err error
+	if v.major, err = strconv.Atoi(major); err != nil {
+		return v, fmt.Errorf("invalid API version %q", value)
+	}
+	if minor != "" {
+		if v.minor, err = strconv.Atoi(minor); err != nil {
+			return v, fmt.Errorf("invalid API version %q", value)
+		}
+	}
+	return v, nil
+}
+
+// applyAPICompat drops fields from payload that the Bot API version in
+// TELEGRAM_API_COMPAT doesn't know about, so older mirrors and self-hosted
+// Bot API servers accept the request instead of rejecting it.
+func applyAPICompat(payload interface{}, compat apiVersion) (interface{}, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return payload, nil
+	}
+	previewOptions := fields["link_preview_options"]
+
+	for name := range fields {
+		if introduced, ok := telegramFieldVersions[name]; ok && compat.less(introduced) {
+			delete(fields, name)
+		}
+	}
+
+	// Before link_preview_options, previews were turned off with
+	// disable_web_page_preview.
+	if _, kept := fields["link_preview_options"]; !kept && previewOptions != nil {
+		var opts linkPreviewOptions
+		if json.Unmarshal(previewOptions, &opts) == nil && opts.IsDisabled {
+			fields["disable_web_page_preview"] = json.RawMessage("true")
+		}
+	}
+	return fields, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestSendStripsFieldsAboveAPICompat(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+	opts := SendOptions{BusinessConnectionID: "biz-1", DisablePreview: true}
+
+	tests := []struct {
+		compat  string
+		kept    []string
+		dropped []string
+	}{
+		{"7.2", []string{"business_connection_id", "link_preview_options"}, []string{"disable_web_page_preview"}},
+		{"7.0", []string{"link_preview_options"}, []string{"business_connection_id", "disable_web_page_preview"}},
+		{"6.9", []string{"disable_web_page_preview"}, []string{"business_connection_id", "link_preview_options"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.compat, func(t *testing.T) {
+			compat, err := parseAPIVersion(tt.compat)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config := testConfig
+			config.APICompat = &compat
+			if _, err := sendTelegramMessage(context.Background(), config, "hi", opts); err != nil {
+				t.Fatal(err)
+			}
+
+			calls := fake.Calls()
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(calls[len(calls)-1].Body, &fields); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range tt.kept {
+				if _, ok := fields[name]; !ok {
+					t.Errorf("%s was dropped", name)
+				}
+			}
+			for _, name := range tt.dropped {
+				if _, ok := fields[name]; ok {
+					t.Errorf("%s was sent", name)
+				}
+			}
+			if string(fields["text"]) != `"hi"` {
+				t.Errorf("text = %s, want \"hi\"", fields["text"])
+			}
+		})
+	}
+}
+
+func TestParseAPIVersion(t *testing.T) {
+	for _, value := range []string{"7", "7.2", " 6.9 "} {
+		if _, err := parseAPIVersion(value); err != nil {
+			t.Errorf("parseAPIVersion(%q): %v", value, err)
+		}
+	}
+	for _, value := range []string{"", "seven", "7.x"} {
+		if _, err := parseAPIVersion(value); err == nil {
+			t.Errorf("parseAPIVersion(%q) succeeded, want an error", value)
+		}
+	}
+}
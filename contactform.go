@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+)
+
+const (
+	maxContactNameLength    = 100
+	maxContactSubjectLength = 200
+	maxContactMessageLength = 5000
+)
+
+type ContactFormRequest struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Subject string `json:"subject"`
+	Message string `json:"message"`
+}
+
+// validateContactForm checks every field of a submission and normalizes
+// its email address.
+func validateContactForm(req *ContactFormRequest) fieldErrors {
+	var errs fieldErrors
+	errs.text("name", "Name", req.Name, maxContactNameLength)
+	req.Email = errs.email("email", req.Email)
+	errs.text("subject", "Subject", req.Subject, maxContactSubjectLength)
+	errs.text("message", "Message", req.Message, maxContactMessageLength)
+	// Both end up in email headers.
+	if strings.ContainsAny(req.Name, "\r\n") {
+		errs.add("name", "Name must be a single line")
+	}
+	if strings.ContainsAny(req.Subject, "\r\n") {
+		errs.add("subject", "Subject must be a single line")
+	}
+	return errs
+}
+
+// handleContactForm validates a contact form submission and emails it to
+// CONTACT_TO_EMAIL when EMAIL_PROVIDER is set, with replies going to the
+// visitor. With
+// CONTACT_MIRROR_TELEGRAM=true it is also posted to the configured chat;
+// a failed mirror is only logged unless Telegram is the sole delivery.
+func handleContactForm(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var req ContactFormRequest
+	if !decodeBody(w, r, &req, config) {
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	req.Subject = strings.TrimSpace(req.Subject)
+	req.Message = strings.TrimSpace(req.Message)
+	if validateContactForm(&req).write(w) {
+		return
+	}
+	email := req.Email
+
+	emailEnabled := config.EmailProvider != ""
+	mirrorEnabled := config.ContactMirrorTelegram
+	if !emailEnabled && !mirrorEnabled {
+		writeError(w, http.StatusServiceUnavailable, "not_configured", "Contact form is not configured")
+		return
+	}
+
+	if emailEnabled {
+		err := sendEmail(r.Context(), config, emailMessage{
+			to:      config.ContactToEmail,
+			replyTo: &mail.Address{Name: req.Name, Address: email},
+			subject: req.Subject,
+			body:    fmt.Sprintf("Name: %s\nEmail: %s\n\n%s\n", req.Name, email, req.Message),
+		})
+		if err != nil {
+			writeUpstreamError(w, err)
+			return
+		}
+	}
+
+	if mirrorEnabled {
+		text, err := renderTemplate("contact", map[string]interface{}{
+			"name":    req.Name,
+			"email":   email,
+			"subject": req.Subject,
+			"message": req.Message,
+		})
+		if err == nil {
+			_, err = sendTelegramMessage(r.Context(), config, text, SendOptions{ParseMode: "HTML"})
+		}
+		if err != nil {
+			if !emailEnabled {
+				writeUpstreamError(w, err)
+				return
+			}
+			log.Printf("Warning: cannot mirror contact form to Telegram: %v", err)
+		}
+	}
+
+	auditLog.record("contact", map[string]string{
+		"email":   email,
+		"ip_hash": hashIP(clientIP(r)),
+	})
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "Message received"})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useResend sends email through Resend, pointed at a server that passes
+// each email to emails and answers with status. Configs read after it send
+// the contact form to team@example.com.
+func useResend(t *testing.T, status int) chan map[string]any {
+	t.Helper()
+	emails := make(chan map[string]any, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var email map[string]any
+		json.NewDecoder(r.Body).Decode(&email)
+		emails <- email
+		w.WriteHeader(status)
+		w.Write([]byte(`{"id":"email_1"}`))
+	}))
+	t.Cleanup(srv.Close)
+	orig := resendAPIBaseURL
+	t.Cleanup(func() { resendAPIBaseURL = orig })
+	resendAPIBaseURL = srv.URL
+	t.Setenv("EMAIL_PROVIDER", "resend")
+	t.Setenv("EMAIL_FROM", "site@example.com")
+	t.Setenv("CONTACT_TO_EMAIL", "team@example.com")
+	return emails
+}
+
+const contactBody = `{"name":" Ada Lovelace ","email":"Ada@Example.com","subject":"Engines","message":" Hello "}`
+
+func TestContactFormEmailsTheTeam(t *testing.T) {
+	emails := useResend(t, http.StatusOK)
+
+	rec := serve(bind(handleContactForm, configWith(t)), http.MethodPost, "/contact", contactBody)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	email := <-emails
+	if email["to"].([]any)[0] != "team@example.com" || email["subject"] != "Engines" {
+		t.Errorf("email = %v", email)
+	}
+	if email["reply_to"] != "ada@example.com" {
+		t.Errorf("reply_to = %v, want the visitor's normalized address", email["reply_to"])
+	}
+	if text := email["text"].(string); !strings.Contains(text, "Name: Ada Lovelace\n") || !strings.HasSuffix(text, "\n\nHello\n") {
+		t.Errorf("text = %q, want trimmed fields", text)
+	}
+}
+
+func TestContactFormValidation(t *testing.T) {
+	useResend(t, http.StatusOK)
+	contact := bind(handleContactForm, configWith(t))
+
+	tests := []struct {
+		name  string
+		body  string
+		field string
+	}{
+		{"empty name", `{"name":"  ","email":"ada@example.com","subject":"Hi","message":"Hello"}`, "name"},
+		{"bad email", `{"name":"Ada","email":"not-an-email","subject":"Hi","message":"Hello"}`, "email"},
+		{"header injection", `{"name":"Ada","email":"ada@example.com","subject":"Hi\r\nBcc: x@example.com","message":"Hello"}`, "subject"},
+		{"multiline name", `{"name":"Ada\nBcc: x@example.com","email":"ada@example.com","subject":"Hi","message":"Hello"}`, "name"},
+		{"long message", `{"name":"Ada","email":"ada@example.com","subject":"Hi","message":"` + strings.Repeat("a", maxContactMessageLength+1) + `"}`, "message"},
+	}
+	for _, tt := range tests {
+		rec := serve(contact, http.MethodPost, "/contact", tt.body)
+		var resp struct {
+			Code    string `json:"code"`
+			Details struct {
+				Fields []FieldError `json:"fields"`
+			} `json:"details"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if rec.Code != http.StatusBadRequest || resp.Code != "validation_failed" || len(resp.Details.Fields) == 0 || resp.Details.Fields[0].Field != tt.field {
+			t.Errorf("%s: got %d %s, want 400 for %s", tt.name, rec.Code, rec.Body, tt.field)
+		}
+	}
+
+	// Every problem is reported at once.
+	rec := serve(contact, http.MethodPost, "/contact", `{}`)
+	if n := strings.Count(rec.Body.String(), `"field"`); n != 4 {
+		t.Errorf("empty form: %d field errors, want 4: %s", n, rec.Body)
+	}
+}
+
+func TestContactFormNeedsADelivery(t *testing.T) {
+	config := configWith(t, "EMAIL_PROVIDER", "", "CONTACT_MIRROR_TELEGRAM", "")
+
+	rec := serve(bind(handleContactForm, config), http.MethodPost, "/contact", contactBody)
+	if rec.Code != http.StatusServiceUnavailable || decodeError(t, rec).Code != "not_configured" {
+		t.Errorf("got %d %s, want 503 not_configured", rec.Code, rec.Body)
+	}
+}
+
+func TestContactFormMirrorsToTelegram(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+	config := configWith(t, "EMAIL_PROVIDER", "", "CONTACT_MIRROR_TELEGRAM", "true")
+
+	rec := serve(bind(handleContactForm, config), http.MethodPost, "/contact", `{"name":"<b>Ada</b>","email":"ada@example.com","subject":"Hi","message":"Hello"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if text := sentText(t, fake); !strings.Contains(text, "&lt;b&gt;Ada&lt;/b&gt;") || !strings.Contains(text, "Hello") {
+		t.Errorf("mirrored text = %q, want the fields HTML-escaped", text)
+	}
+}
+
+func TestContactFormMirrorFailure(t *testing.T) {
+	useFakeUpstreams(t)
+	telegram = failingTelegram{err: errors.New("connection refused")}
+	t.Setenv("UPSTREAM_MAX_ATTEMPTS", "1")
+	t.Setenv("CONTACT_MIRROR_TELEGRAM", "true")
+
+	// With email configured, a failed mirror is only logged.
+	emails := useResend(t, http.StatusOK)
+	if rec := serve(bind(handleContactForm, configWith(t)), http.MethodPost, "/contact", contactBody); rec.Code != http.StatusOK {
+		t.Errorf("with email: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	<-emails
+
+	// Without it, the visitor must learn the message was lost.
+	config := configWith(t, "EMAIL_PROVIDER", "")
+	if rec := serve(bind(handleContactForm, config), http.MethodPost, "/contact", contactBody); rec.Code < 500 {
+		t.Errorf("Telegram only: status = %d, want a 5xx", rec.Code)
+	}
+}
+
+func TestContactFormEmailFailure(t *testing.T) {
+	useResend(t, http.StatusInternalServerError)
+
+	if rec := serve(bind(handleContactForm, configWith(t)), http.MethodPost, "/contact", contactBody); rec.Code < 500 {
+		t.Errorf("status = %d, want a 5xx when the email can't be sent: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/rs/cors"
+)
+
+const defaultCORSDenyPaths = "/admin/,/debug/,/subscriber,/webhook/,/webhooks/,/telegram/"
+
+// corsAllowedHeaders and corsExposedHeaders cover the headers the API reads
+// and sets beyond the CORS-safelisted ones, so browsers can use captcha
+// tokens, idempotency keys, async delivery and admin tokens, and read back
+// request IDs and the deprecation of legacy routes.
+var (
+	corsAllowedHeaders = []string{
+		"Accept", "Content-Type", "X-Requested-With", "X-Request-ID", "X-Request-Timeout-Ms",
+		"X-Captcha-Token", "Idempotency-Key", "Prefer", "X-API-Key", "Authorization",
+	}
+	corsExposedHeaders = []string{"X-Request-ID", "Retry-After", "Location", "Preference-Applied", "Deprecation", "Link"}
+	corsAllowedMethods = []string{
+		http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPatch, http.MethodDelete,
+	}
+)
+
+type routeCORS struct {
+	pattern string
+	// handler is nil for routes where CORS is disabled entirely, either by
+	// CORS_DENY_PATHS or because no origins are allowed.
+	handler http.Handler
+}
+
+// withRouteCORS applies a CORS policy per route instead of one global
+// policy. Patterns ending in "/" or "/*" match by prefix, others match
+// exactly, and the longest matching pattern wins. Routes without a pattern
+// use defaultOrigins. An empty origin list disables CORS for the route
+// rather than letting rs/cors treat it as "allow all".
+//
+// Origins may contain one "*" wildcard, e.g. "https://*.example.com" for
+// every subdomain. A bare "*" allows any origin but then credentials are
+// not allowed, since rs/cors would otherwise reflect every origin back.
+func withRouteCORS(next http.Handler, defaultOrigins []string, routeOrigins map[string][]string, denied []string) http.Handler {
+	newPolicy := func(origins []string) http.Handler {
+		if len(origins) == 0 {
+			return nil
+		}
+		return cors.New(cors.Options{
+			AllowedOrigins:   origins,
+			AllowedMethods:   corsAllowedMethods,
+			AllowedHeaders:   corsAllowedHeaders,
+			ExposedHeaders:   corsExposedHeaders,
+			AllowCredentials: !slices.Contains(origins, "*"),
+		}).Handler(next)
+	}
+
+	var routes []routeCORS
+	for pattern, origins := range routeOrigins {
+		routes = append(routes, routeCORS{pattern: pattern, handler: newPolicy(origins)})
+	}
+	for _, pattern := range denied {
+		routes = append(routes, routeCORS{pattern: pattern})
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return len(routes[i].pattern) > len(routes[j].pattern)
+	})
+
+	fallback := newPolicy(defaultOrigins)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, route := range routes {
+			if matchRoute(route.pattern, r.URL.Path) {
+				if route.handler == nil {
+					next.ServeHTTP(w, r)
+					return
+				}
+				route.handler.ServeHTTP(w, r)
+				return
+			}
+		}
+		if fallback == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		fallback.ServeHTTP(w, r)
+	})
+}
+
+func matchRoute(pattern, path string) bool {
+	pattern = strings.TrimSuffix(pattern, "*")
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(path, pattern)
+	}
+	return path == pattern
+}
+
+// parseRouteOrigins parses CORS_ROUTE_ORIGINS, a semicolon-separated list
+// of path=origin[,origin...] entries, e.g.
+// "/subscribe=https://*.example.com;/admin/*=https://admin.example.com".
+func parseRouteOrigins(value string) map[string][]string {
+	routes := make(map[string][]string)
+	for _, entry := range strings.Split(value, ";") {
+		pattern, origins, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || pattern == "" {
+			continue
+		}
+		routes[pattern] = splitList(origins)
+	}
+	return routes
+}
+
+// splitList splits a comma-separated value, trimming whitespace and
+// dropping empty entries.
+func splitList(value string) []string {
+	var out []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouteCORS(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := withRouteCORS(next,
+		[]string{"https://example.com"},
+		parseRouteOrigins("/admin/*=https://admin.example.com;/subscribe=https://*.example.com"),
+		splitList(defaultCORSDenyPaths),
+	)
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		origin    string
+		allowed   bool
+		preflight bool
+	}{
+		{"subscribe preflight", http.MethodOptions, "/subscribe", "https://www.example.com", true, true},
+		{"subscribe", http.MethodPost, "/subscribe", "https://www.example.com", true, false},
+		{"subscribe from elsewhere", http.MethodPost, "/subscribe", "https://evil.test", false, false},
+		{"send uses the default origins", http.MethodPost, "/send", "https://example.com", true, false},
+		{"webhook preflight", http.MethodOptions, "/webhook/telegram", "https://example.com", false, true},
+		{"webhook", http.MethodPost, "/webhook/telegram", "https://example.com", false, false},
+		{"admin", http.MethodGet, "/admin/stats", "https://admin.example.com", true, false},
+		{"admin from the site", http.MethodGet, "/admin/stats", "https://example.com", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+				// Browsers send the header names lowercased and sorted.
+				req.Header.Set("Access-Control-Request-Headers", "content-type,x-captcha-token")
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			got := rec.Header().Values("Access-Control-Allow-Origin")
+			if !tt.allowed {
+				if len(got) != 0 {
+					t.Errorf("Access-Control-Allow-Origin = %v, want none", got)
+				}
+				return
+			}
+			if len(got) != 1 || got[0] != tt.origin {
+				t.Errorf("Access-Control-Allow-Origin = %v, want exactly [%s]", got, tt.origin)
+			}
+			if tt.preflight && len(rec.Header().Values("Access-Control-Allow-Methods")) != 1 {
+				t.Errorf("Access-Control-Allow-Methods = %v, want one", rec.Header().Values("Access-Control-Allow-Methods"))
+			}
+		})
+	}
+}
+
+func TestPreflightThroughFullChain(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/subscribe", subscribeHandler)
+	handler := newHandler(mux, configWith(t, "ALLOWED_ORIGINS", "https://example.com").Settings)
+
+	req := httptest.NewRequest(http.MethodOptions, "/subscribe", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	req.Header.Set("Access-Control-Request-Headers", "content-type")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code >= 300 {
+		t.Errorf("status = %d, want a successful preflight", rec.Code)
+	}
+	if got := rec.Header().Values("Access-Control-Allow-Origin"); len(got) != 1 || got[0] != "https://example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %v, want exactly once", got)
+	}
+}
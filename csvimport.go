@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultCSVMaxBytes    = 1 << 20
+	defaultCSVMaxMemory   = 256 << 10
+	defaultCSVMaxRows     = 1000
+	defaultCSVConcurrency = 4
+)
+
+type CSVRowResult struct {
+	Row    int    `json:"row"`
+	Email  string `json:"email,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type CSVImportResponse struct {
+	Total      int            `json:"total"`
+	Subscribed int            `json:"subscribed"`
+	Failed     int            `json:"failed"`
+	Results    []CSVRowResult `json:"results"`
+}
+
+type csvRow struct {
+	line int
+	req  SubscribeRequest
+	err  string
+}
+
+// readSubscriberCSV parses a CSV with an email column and optional
+// utm_source, utm_medium and referring_site columns. Malformed rows are kept
+// with an error so they can be reported by line number.
+func readSubscriberCSV(r io.Reader, maxRows int) ([]csvRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	var parseErr *csv.ParseError
+	switch {
+	case err == io.EOF:
+		return nil, errors.New("CSV header is missing")
+	case errors.As(err, &parseErr):
+		return nil, errors.New("CSV header is malformed")
+	case err != nil:
+		return nil, fmt.Errorf("error reading CSV: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	if _, ok := columns["email"]; !ok {
+		return nil, errors.New("CSV must have an email column")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var rows []csvRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if len(rows) >= maxRows {
+			return nil, errors.New("CSV has too many rows")
+		}
+
+		if errors.As(err, &parseErr) {
+			rows = append(rows, csvRow{line: parseErr.Line, err: "Malformed CSV row"})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading CSV: %w", err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		rows = append(rows, csvRow{line: line, req: SubscribeRequest{
+			Email:         field(record, "email"),
+			UTMSource:     field(record, "utm_source"),
+			UTMMedium:     field(record, "utm_medium"),
+			ReferringSite: field(record, "referring_site"),
+		}})
+	}
+	return rows, nil
+}
+
+// handleSubscribeCSV imports subscribers from a CSV sent either as the
+// "file" field of a multipart form or as a text/csv body. Rows are
+// subscribed by CSV_IMPORT_CONCURRENCY workers and reported individually.
+func handleSubscribeCSV(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, int64(config.CSVMaxBytes))
+
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		// Up to CSV_MAX_MEMORY of the form is held in memory and the rest
+		// spills to temp files, which are removed however the request
+		// ends, including uploads the client aborted.
+		err := r.ParseMultipartForm(int64(config.CSVMaxMemory))
+		if r.MultipartForm != nil {
+			defer r.MultipartForm.RemoveAll()
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, ErrorResponse{Message: "CSV file is too large", Code: "body_too_large"})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Message: "CSV upload is incomplete or malformed", Code: "upload_incomplete"})
+			return
+		}
+
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "file_required", "CSV file is required in the \"file\" field")
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	rows, err := readSubscriberCSV(body, config.CSVMaxRows)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, ErrorResponse{Message: "CSV file is too large", Code: "body_too_large"})
+			return
+		}
+		writeError(w, http.StatusBadRequest, "invalid_csv", err.Error())
+		return
+	}
+
+	results := make([]CSVRowResult, len(rows))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for n := max(config.CSVImportConcurrency, 1); n > 0; n-- {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = importCSVRow(r, config, rows[i])
+			}
+		}()
+	}
+	for i := range rows {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	resp := CSVImportResponse{Total: len(results), Results: results}
+	for _, result := range results {
+		if result.Status == "error" {
+			resp.Failed++
+		} else {
+			resp.Subscribed++
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func importCSVRow(r *http.Request, config Config, row csvRow) CSVRowResult {
+	result := CSVRowResult{Row: row.line, Email: row.req.Email, Status: "error"}
+	if row.err != "" {
+		result.Error = row.err
+		return result
+	}
+
+	if row.req.Email == "" {
+		result.Error = "Email cannot be empty"
+		return result
+	}
+	email, ok := normalizeEmail(row.req.Email)
+	if !ok {
+		result.Error = "Email address is invalid"
+		return result
+	}
+	row.req.Email = email
+	result.Email = email
+
+	subscriptionID, err := subscribeToBeehiiv(r.Context(), config, row.req)
+	if errors.Is(err, errAlreadySubscribed) {
+		result.Status = "already_subscribed"
+		return result
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	auditLog.record("subscribe", map[string]string{
+		"email":   email,
+		"ip_hash": hashIP(clientIP(r)),
+		"source":  "csv_import",
+	})
+	subscribers.record(row.req, subscriptionID, "csv_import")
+	result.Status = "subscribed"
+	return result
+}
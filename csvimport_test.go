@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// csvUpload returns a multipart form carrying csv as its "file" field.
+func csvUpload(t *testing.T, csv string) (body []byte, contentType string) {
+	t.Helper()
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+	part, err := form.CreateFormFile("file", "subscribers.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(part, csv)
+	form.Close()
+	return buf.Bytes(), form.FormDataContentType()
+}
+
+// abortedReader returns data and then fails as if the client went away.
+type abortedReader struct {
+	r io.Reader
+}
+
+func (a abortedReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func TestSubscribeCSVRemovesUploadTempFiles(t *testing.T) {
+	useFakeUpstreams(t)
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	// Spill every upload to a temp file.
+	config := configWith(t, "CSV_MAX_MEMORY", "1")
+
+	csv := "email\n" + strings.Repeat("csv-upload@example.com\n", 200)
+	body, contentType := csvUpload(t, csv)
+
+	tests := []struct {
+		name   string
+		body   io.Reader
+		status int
+	}{
+		{"complete", bytes.NewReader(body), http.StatusOK},
+		{"aborted", abortedReader{bytes.NewReader(body[:len(body)/2])}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/subscribe-csv", tt.body)
+			req.Header.Set("Content-Type", contentType)
+			rec := httptest.NewRecorder()
+			handleSubscribeCSV(rec, req, config)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %.200s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				if got := decodeError(t, rec).Code; got != "upload_incomplete" {
+					t.Errorf("code = %q, want upload_incomplete", got)
+				}
+			}
+
+			entries, err := os.ReadDir(tmp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 0 {
+				t.Errorf("temp files left behind: %v", entries)
+			}
+		})
+	}
+}
+
+// importCSV posts csv to /subscribe-csv as a multipart upload.
+func importCSV(t *testing.T, config Config, csv string) *httptest.ResponseRecorder {
+	t.Helper()
+	body, contentType := csvUpload(t, csv)
+	req := httptest.NewRequest(http.MethodPost, "/subscribe-csv", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	handleSubscribeCSV(rec, req, config)
+	return rec
+}
+
+func TestSubscribeCSVValid(t *testing.T) {
+	_, fake := useFakeUpstreams(t)
+
+	rec := importCSV(t, testConfig, "Email,utm_source,utm_medium\nOne@Example.com,launch,email\ntwo@example.com,,\n")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp CSVImportResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Total != 2 || resp.Subscribed != 2 || resp.Failed != 0 {
+		t.Errorf("total %d, subscribed %d, failed %d, want 2, 2, 0", resp.Total, resp.Subscribed, resp.Failed)
+	}
+	want := []CSVRowResult{
+		{Row: 2, Email: "one@example.com", Status: "subscribed"},
+		{Row: 3, Email: "two@example.com", Status: "subscribed"},
+	}
+	for i, result := range resp.Results {
+		if result != want[i] {
+			t.Errorf("row %d = %+v, want %+v", i, result, want[i])
+		}
+	}
+
+	if n := beehiivCalls(fake, http.MethodPost, "/subscriptions"); n != 2 {
+		t.Fatalf("Beehiiv got %d subscriptions, want 2", n)
+	}
+	var first SubscribeRequest
+	for _, call := range fake.Calls() {
+		if strings.Contains(string(call.Body), "one@example.com") {
+			json.Unmarshal(call.Body, &first)
+		}
+	}
+	if first.UTMSource != "launch" || first.UTMMedium != "email" {
+		t.Errorf("UTM columns not passed on: %+v", first)
+	}
+}
+
+func TestSubscribeCSVMalformedRows(t *testing.T) {
+	_, fake := useFakeUpstreams(t)
+
+	csv := "email\n" +
+		"good@example.com\n" +
+		"not-an-email\n" +
+		"\"unterminated@example.com\n"
+	rec := importCSV(t, testConfig, csv)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp CSVImportResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Total != 3 || resp.Subscribed != 1 || resp.Failed != 2 {
+		t.Errorf("total %d, subscribed %d, failed %d, want 3, 1, 2", resp.Total, resp.Subscribed, resp.Failed)
+	}
+	want := []struct {
+		row    int
+		status string
+		error  string
+	}{
+		{2, "subscribed", ""},
+		{3, "error", "Email address is invalid"},
+		{4, "error", "Malformed CSV row"},
+	}
+	for i, w := range want {
+		got := resp.Results[i]
+		if got.Row != w.row || got.Status != w.status || got.Error != w.error {
+			t.Errorf("result %d = %+v, want row %d %s %q", i, got, w.row, w.status, w.error)
+		}
+	}
+	if n := beehiivCalls(fake, http.MethodPost, "/subscriptions"); n != 1 {
+		t.Errorf("Beehiiv got %d subscriptions, want only the valid row", n)
+	}
+}
+
+func TestSubscribeCSVRejectsBadFiles(t *testing.T) {
+	useFakeUpstreams(t)
+	config := configWith(t, "CSV_MAX_ROWS", "2")
+
+	tests := []struct {
+		name string
+		csv  string
+		want string
+	}{
+		{"no email column", "name\nAda\n", "CSV must have an email column"},
+		{"empty", "", "CSV header is missing"},
+		{"too many rows", "email\na@example.com\nb@example.com\nc@example.com\n", "CSV has too many rows"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := importCSV(t, config, tt.csv)
+			if rec.Code != http.StatusBadRequest || decodeError(t, rec).Message != tt.want {
+				t.Errorf("got %d %s, want 400 %q", rec.Code, rec.Body, tt.want)
+			}
+		})
+	}
+}
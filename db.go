@@ -0,0 +1,118 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// database holds what the API keeps for itself: the subscriber mirror,
+// page views, comments, short links and generated settings such as the
+// hash salt. main opens DATABASE_PATH; until then, and without a path, it
+// is a private in-memory database that is lost on restart.
+var database = mustOpenDatabase("")
+
+// schema creates every table the stores use. Statements must be safe to
+// run against an existing database, since they run on every start.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS subscribers (
+		id INTEGER PRIMARY KEY,
+		subscribed_at INTEGER NOT NULL,
+		email TEXT NOT NULL,
+		beehiiv_id TEXT NOT NULL DEFAULT '',
+		utm_source TEXT NOT NULL DEFAULT '',
+		utm_medium TEXT NOT NULL DEFAULT '',
+		referring_site TEXT NOT NULL DEFAULT '',
+		source TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE INDEX IF NOT EXISTS subscribers_subscribed_at ON subscribers (subscribed_at)`,
+	`CREATE TABLE IF NOT EXISTS page_views (
+		id INTEGER PRIMARY KEY,
+		viewed_at INTEGER NOT NULL,
+		day TEXT NOT NULL,
+		path TEXT NOT NULL,
+		referrer TEXT NOT NULL DEFAULT '',
+		utm_source TEXT NOT NULL DEFAULT '',
+		utm_medium TEXT NOT NULL DEFAULT '',
+		utm_campaign TEXT NOT NULL DEFAULT '',
+		visitor TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS page_views_viewed_at ON page_views (viewed_at)`,
+	`CREATE INDEX IF NOT EXISTS page_views_path ON page_views (path, viewed_at)`,
+	`CREATE TABLE IF NOT EXISTS comments (
+		id TEXT PRIMARY KEY,
+		slug TEXT NOT NULL,
+		parent_id TEXT NOT NULL DEFAULT '',
+		author TEXT NOT NULL,
+		body TEXT NOT NULL,
+		status TEXT NOT NULL,
+		flags INTEGER NOT NULL DEFAULT 0,
+		ip_hash TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS comments_slug ON comments (slug, status, created_at)`,
+	`CREATE INDEX IF NOT EXISTS comments_parent ON comments (parent_id)`,
+	`CREATE TABLE IF NOT EXISTS links (
+		slug TEXT PRIMARY KEY,
+		url TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		clicks INTEGER NOT NULL DEFAULT 0,
+		last_clicked_at INTEGER
+	)`,
+	`CREATE TABLE IF NOT EXISTS link_attributions (
+		slug TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		value TEXT NOT NULL,
+		clicks INTEGER NOT NULL,
+		PRIMARY KEY (slug, kind, value)
+	)`,
+	`CREATE TABLE IF NOT EXISTS settings (
+		name TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	)`,
+}
+
+// openDatabase opens the SQLite database at path, or an in-memory one when
+// path is empty, and creates any missing tables. File databases use WAL so
+// reads don't wait for writes, and writers wait for each other rather than
+// failing with "database is locked".
+func openDatabase(path string) (*sql.DB, error) {
+	dsn := "file:" + path + "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=synchronous(NORMAL)"
+	if path == "" {
+		dsn = ":memory:"
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		// Every connection to :memory: is a separate database.
+		db.SetMaxOpenConns(1)
+		db.SetConnMaxLifetime(0)
+		db.SetConnMaxIdleTime(0)
+	}
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("error creating schema: %v", err)
+		}
+	}
+	return db, nil
+}
+
+func mustOpenDatabase(path string) *sql.DB {
+	db, err := openDatabase(path)
+	if err != nil {
+		log.Fatalf("Cannot open database: %v", err)
+	}
+	return db
+}
+
+// Times are stored as Unix milliseconds, which sort and compare as
+// integers. fromUnixMilli reads one back.
+func fromUnixMilli(ms int64) time.Time {
+	return time.UnixMilli(ms).UTC()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// useDatabase gives the test an empty in-memory database and points the
+// stores at it until the test ends.
+func useDatabase(t *testing.T) {
+	t.Helper()
+	db, err := openDatabase("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	origDB, origSubscribers, origPageViews, origComments, origLinks := database, subscribers, pageViews, comments, links
+	t.Cleanup(func() {
+		database, subscribers, pageViews, comments, links = origDB, origSubscribers, origPageViews, origComments, origLinks
+		db.Close()
+	})
+	database = db
+	subscribers = &subscriberMirror{db: db}
+	pageViews = &pageViewStore{db: db, retention: defaultAnalyticsRetention}
+	comments = &commentStore{db: db}
+	links = &linkStore{db: db}
+}
+
+func TestDatabaseFileSurvivesReopening(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.db")
+	db, err := openDatabase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	(&subscriberMirror{db: db}).record(SubscribeRequest{Email: "kept@example.com"}, "sub_1", "subscribe")
+	db.Close()
+
+	// Opening it again must not trip over the existing schema.
+	db, err = openDatabase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM subscribers WHERE email = 'kept@example.com'`).Scan(&n); err != nil || n != 1 {
+		t.Errorf("count = %d, %v, want the record written before reopening", n, err)
+	}
+}
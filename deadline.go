@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const defaultMaxRequestTimeout = 30 * time.Second
+
+// withRequestDeadline honors an X-Request-Timeout-Ms header by attaching a
+// deadline to the request context, which is then propagated to upstream
+// calls. Values that are not positive integers or exceed max are ignored.
+func withRequestDeadline(next http.Handler, max time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout, ok := parseRequestTimeout(r.Header.Get("X-Request-Timeout-Ms"), max)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func parseRequestTimeout(value string, max time.Duration) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	ms, err := strconv.Atoi(value)
+	if err != nil || ms <= 0 {
+		return 0, false
+	}
+
+	timeout := time.Duration(ms) * time.Millisecond
+	if timeout > max {
+		return 0, false
+	}
+	return timeout, true
+}
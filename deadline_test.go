@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRequestTimeout(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"250", 250 * time.Millisecond, true},
+		{"1000", time.Second, true},
+		{"", 0, false},
+		{"0", 0, false},
+		{"-5", 0, false},
+		{"1.5", 0, false},
+		{"1001", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRequestTimeout(tt.value, time.Second)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseRequestTimeout(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRequestDeadlineTimesOutSlowUpstream(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	defer func(orig TelegramAPI) { telegram = orig }(telegram)
+	telegram = newBotAPIClient(upstream.URL, http.DefaultClient)
+
+	handler := withRequestDeadline(http.HandlerFunc(sendHandler), time.Second)
+	req := httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(`{"message":"hello"}`))
+	req.Header.Set("X-Request-Timeout-Ms", "50")
+	rec := httptest.NewRecorder()
+
+	start := time.Now()
+	handler.ServeHTTP(rec, req)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("request took %v, want it cut short at 50ms", elapsed)
+	}
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503: %s", rec.Code, rec.Body)
+	}
+	if got := decodeError(t, rec).Code; got != "upstream_timeout" {
+		t.Errorf("code = %q, want upstream_timeout", got)
+	}
+}
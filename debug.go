@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type middlewareTraceKey struct{}
+
+// debugEndpointsEnabled gates development-only endpoints such as
+// /debug/echo behind APP_ENV=development.
+func (s *Settings) debugEndpointsEnabled() bool {
+	return s.AppEnv == "development"
+}
+
+// withMiddlewareTrace records, per request, the names of the middlewares
+// wrapped with traced so /debug/echo can report them.
+func withMiddlewareTrace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace := &[]string{}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), middlewareTraceKey{}, trace)))
+	})
+}
+
+func traced(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if trace, ok := r.Context().Value(middlewareTraceKey{}).(*[]string); ok {
+			*trace = append(*trace, name)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type EchoResponse struct {
+	ChatID               string   `json:"chat_id"`
+	Message              string   `json:"message"`
+	ParseMode            string   `json:"parse_mode"`
+	ResolvedParseMode    string   `json:"resolved_parse_mode"`
+	BusinessConnectionID string   `json:"business_connection_id,omitempty"`
+	GroupKey             string   `json:"group_key,omitempty"`
+	DefaultsApplied      []string `json:"defaults_applied"`
+	Middlewares          []string `json:"middlewares"`
+	DeadlineMs           int64    `json:"deadline_ms,omitempty"`
+}
+
+// handleDebugEcho parses a /send body the way handleSendMessage does and
+// reports the result without sending anything.
+func handleDebugEcho(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var req MessageRequest
+	if !decodeBodyLimit(w, r, &req, maxSendBodyBytes(config)) {
+		return
+	}
+
+	opts, msg := sendOptionsFor(req, config)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", msg)
+		return
+	}
+
+	chatID, _, msg := chatIDFor(req, config)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, "invalid_chat_target", msg)
+		return
+	}
+
+	message := sanitizeControlChars(strings.TrimSpace(req.Message), config.SanitizeControlChars)
+
+	resp := EchoResponse{
+		ChatID:               chatID,
+		Message:              message,
+		ParseMode:            opts.ParseMode,
+		ResolvedParseMode:    resolveParseMode(opts, message),
+		BusinessConnectionID: opts.BusinessConnectionID,
+		GroupKey:             req.GroupKey,
+		DefaultsApplied:      []string{},
+		Middlewares:          []string{},
+	}
+	if req.ChatID == "" {
+		resp.DefaultsApplied = append(resp.DefaultsApplied, "chat_id")
+	}
+	if req.ParseMode == "" {
+		resp.DefaultsApplied = append(resp.DefaultsApplied, "parse_mode")
+	}
+	if trace, ok := r.Context().Value(middlewareTraceKey{}).(*[]string); ok {
+		resp.Middlewares = append(resp.Middlewares, *trace...)
+	}
+	if deadline, ok := r.Context().Deadline(); ok {
+		resp.DeadlineMs = time.Until(deadline).Milliseconds()
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+const defaultDebugSlowMax = 30 * time.Second
+
+// handleDebugSlow sleeps for ?ms= milliseconds, capped at DEBUG_SLOW_MAX_MS,
+// so integrators can exercise their client timeouts and retries.
+func handleDebugSlow(w http.ResponseWriter, r *http.Request, config Config) {
+	ms, err := strconv.Atoi(r.URL.Query().Get("ms"))
+	if err != nil || ms < 0 {
+		writeError(w, http.StatusBadRequest, "invalid_delay", "ms must be a non-negative integer")
+		return
+	}
+
+	delay := time.Duration(ms) * time.Millisecond
+	if max := config.DebugSlowMax; delay > max {
+		delay = max
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-r.Context().Done():
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "ok", "slept_ms": delay.Milliseconds()})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDebugEchoReflectsDefaults(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+	handler := withMiddlewareTrace(traced("first", traced("second", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleDebugEcho(w, r, testConfig)
+	}))))
+
+	tests := []struct {
+		name      string
+		body      string
+		chatID    string
+		parseMode string
+		defaults  []string
+	}{
+		{"defaults", `{"message":"  hi  "}`, testConfig.ChatID, "HTML", []string{"chat_id", "parse_mode"}},
+		{"explicit", `{"message":"hi","chat_id":"5","parse_mode":"MarkdownV2"}`, testConfig.ChatID, "MarkdownV2", []string{}},
+		{"plain text", `{"message":"hi","parse_mode":"none"}`, testConfig.ChatID, "", []string{"chat_id"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/debug/echo", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+			}
+
+			var echo EchoResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &echo); err != nil {
+				t.Fatal(err)
+			}
+			if echo.ChatID != tt.chatID || echo.ParseMode != tt.parseMode || echo.Message != "hi" {
+				t.Errorf("echo = %+v", echo)
+			}
+			if !slices.Equal(echo.DefaultsApplied, tt.defaults) {
+				t.Errorf("defaults applied = %v, want %v", echo.DefaultsApplied, tt.defaults)
+			}
+			if !slices.Equal(echo.Middlewares, []string{"first", "second"}) {
+				t.Errorf("middlewares = %v, want [first second]", echo.Middlewares)
+			}
+		})
+	}
+
+	if len(fake.Calls()) != 0 {
+		t.Errorf("echo sent %v to Telegram", fake.Calls())
+	}
+}
+
+func TestDebugSlowHonorsAndBoundsDelay(t *testing.T) {
+	slow := bind(handleDebugSlow, configWith(t, "DEBUG_SLOW_MAX_MS", "100"))
+
+	tests := []struct {
+		query  string
+		status int
+		slept  int64
+	}{
+		{"ms=30", http.StatusOK, 30},
+		{"ms=5000", http.StatusOK, 100},
+		{"ms=-1", http.StatusBadRequest, 0},
+		{"ms=soon", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			start := time.Now()
+			rec := serve(slow, http.MethodGet, "/debug/slow?"+tt.query, "")
+			elapsed := time.Since(start)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				SleptMs int64 `json:"slept_ms"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			want := time.Duration(tt.slept) * time.Millisecond
+			if resp.SleptMs != tt.slept || elapsed < want || elapsed > want+time.Second {
+				t.Errorf("slept %dms in %v, want %v", resp.SleptMs, elapsed, want)
+			}
+		})
+	}
+}
+
+func TestDebugSlowStopsWhenClientLeaves(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/debug/slow?ms=10000", nil).WithContext(ctx)
+
+	start := time.Now()
+	handleDebugSlow(httptest.NewRecorder(), req, testConfig)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("kept sleeping for %v after the client left", elapsed)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+const defaultSubscribeDedupTTL = 10 * time.Minute
+
+// dedupStore remembers keys for a TTL window so repeated submissions can be
+// recognized without calling upstream again. A key can carry the outcome of
+// the submission that reserved it, for repeats to report.
+type dedupStore struct {
+	seen *ttlCache[dedupOutcome]
+}
+
+// dedupOutcome is the response a completed submission got, which repeats of
+// it get too. The zero value means it is still being processed.
+type dedupOutcome struct {
+	Status  int
+	Message string
+	ID      string
+}
+
+var subscribeDedup = newDedupStore("subscribe_dedup")
+
+func newDedupStore(name string) *dedupStore {
+	return &dedupStore{seen: newTTLCache[dedupOutcome](name, 0)}
+}
+
+// reserve records key and reports whether it was not already seen within ttl.
+func (s *dedupStore) reserve(key string, ttl time.Duration) bool {
+	return s.seen.add(key, dedupOutcome{}, ttl)
+}
+
+// reserveUnlessFull is reserve for stores that must not forget a key
+// early, such as replay protection. When the store is full of unexpired
+// keys it records nothing and reports full instead of evicting one.
+func (s *dedupStore) reserveUnlessFull(key string, ttl time.Duration) (reserved, full bool) {
+	return s.seen.addUnlessFull(key, dedupOutcome{}, ttl)
+}
+
+// complete records the outcome of the submission that reserved key, keeping
+// it for ttl.
+func (s *dedupStore) complete(key string, outcome dedupOutcome, ttl time.Duration) {
+	s.seen.set(key, outcome, ttl)
+}
+
+// outcome returns what complete recorded for key. It is the zero value
+// while the submission is still being processed.
+func (s *dedupStore) outcome(key string) dedupOutcome {
+	outcome, _ := s.seen.get(key)
+	return outcome
+}
+
+// release forgets key, e.g. after the upstream call failed and a retry with
+// the same key should be allowed through.
+func (s *dedupStore) release(key string) {
+	s.seen.delete(key)
+}
+
+// contentHash returns the content_hash reported by /send so clients can
+// dedupe on what was actually sent. It is the hex SHA-256 of the chat ID, a
+// newline, and the message after trimming and sanitizing, with CRLF and CR
+// line endings normalized to LF. This format is part of the API and must
+// not change.
+func contentHash(chatID, message string) string {
+	message = strings.ReplaceAll(message, "\r\n", "\n")
+	message = strings.ReplaceAll(message, "\r", "\n")
+	sum := sha256.Sum256([]byte(chatID + "\n" + message))
+	return hex.EncodeToString(sum[:])
+}
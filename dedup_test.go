@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestContentHash(t *testing.T) {
+	// The format is part of the API, so pin one value.
+	if got, want := contentHash("-100", "hello"), "4a2a92231e0c19be901601d566460565ed93e7887d7313978a2a445f200bb75f"; got != want {
+		t.Errorf("contentHash(-100, hello) = %s, want %s", got, want)
+	}
+
+	same := [][2][2]string{
+		{{"-100", "hello"}, {"-100", "hello"}},
+		{{"-100", "one\r\ntwo"}, {"-100", "one\ntwo"}},
+		{{"-100", "one\rtwo"}, {"-100", "one\ntwo"}},
+	}
+	for _, pair := range same {
+		a, b := pair[0], pair[1]
+		if contentHash(a[0], a[1]) != contentHash(b[0], b[1]) {
+			t.Errorf("contentHash%q != contentHash%q, want them equal", a, b)
+		}
+	}
+
+	different := [][2][2]string{
+		{{"-100", "hello"}, {"-100", "hello!"}},
+		{{"-100", "hello"}, {"-200", "hello"}},
+		{{"-100", "Hello"}, {"-100", "hello"}},
+	}
+	for _, pair := range different {
+		a, b := pair[0], pair[1]
+		if contentHash(a[0], a[1]) == contentHash(b[0], b[1]) {
+			t.Errorf("contentHash%q == contentHash%q, want them to differ", a, b)
+		}
+	}
+}
+
+func TestSendReturnsContentHash(t *testing.T) {
+	useFakeUpstreams(t)
+
+	hashOf := func(body string) string {
+		t.Helper()
+		rec := serve(sendHandler, http.MethodPost, "/send", body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+		var resp struct {
+			ContentHash string `json:"content_hash"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.ContentHash
+	}
+
+	first := hashOf(`{"message":"hash me"}`)
+	if first != contentHash(testConfig.ChatID, "hash me") {
+		t.Errorf("content_hash = %s, want contentHash of the chat and message", first)
+	}
+	if again := hashOf(`{"message":"hash me"}`); again != first {
+		t.Errorf("resending gave content_hash %s, want %s", again, first)
+	}
+	if other := hashOf(`{"message":"hash me too"}`); other == first {
+		t.Error("a different message gave the same content_hash")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const defaultDigestWindow = 24 * time.Hour
+
+// scheduledTasksFor declares the recurring tasks; see runScheduler.
+func scheduledTasksFor(config Config) []*scheduledTask {
+	return []*scheduledTask{
+		{name: "digest", schedule: config.DigestSchedule, run: func(ctx context.Context) error {
+			return sendDigest(ctx, config)
+		}},
+	}
+}
+
+// sendDigest posts a summary of the last DIGEST_WINDOW to the chat: new
+// subscribers, contact form and comment volume from the audit log, and
+// page views from the analytics store.
+func sendDigest(ctx context.Context, config Config) error {
+	window := config.DigestWindow
+	to := time.Now().UTC()
+	from := to.Add(-window)
+
+	counts := make(map[string]int)
+	err := auditLog.each(from, to, func(e AuditEvent) error {
+		counts[e.Type]++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	views, err := pageViews.summarize(from, "")
+	if err != nil {
+		return err
+	}
+	topPaths := views.TopPaths
+	if len(topPaths) > 3 {
+		topPaths = topPaths[:3]
+	}
+
+	text, err := renderTemplate("digest", map[string]interface{}{
+		"period":       fmt.Sprintf("%s – %s", from.In(appLocation).Format("Jan 2 15:04"), to.In(appLocation).Format("Jan 2 15:04 MST")),
+		"subscribes":   counts["subscribe"],
+		"pending":      counts["subscribe_pending"],
+		"unsubscribes": counts["unsubscribe"],
+		"contacts":     counts["contact"],
+		"comments":     counts["comment"],
+		"messages":     counts["send"] + counts["send_photo"],
+		"views":        views.Views,
+		"visitors":     views.Visitors,
+		"top_paths":    topPaths,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = sendTelegramMessage(ctx, config, text, SendOptions{ParseMode: "HTML"})
+	return err
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const defaultSubscribeConfirmTTL = 48 * time.Hour
+
+var (
+	errConfirmTokenInvalid = errors.New("confirmation link is invalid")
+	errConfirmTokenExpired = errors.New("confirmation link has expired")
+)
+
+// confirmTokens remembers the nonces of confirmed tokens until they expire,
+// so a confirmation link works once.
+var confirmTokens = newDedupStore("subscribe_confirmations")
+
+// confirmToken is the signed content of a confirmation link. It carries the
+// whole subscribe request, so nothing has to be stored until the link is
+// used and pending signups survive restarts.
+type confirmToken struct {
+	Request SubscribeRequest `json:"req"`
+	Expires int64            `json:"exp"`
+	Nonce   string           `json:"nonce"`
+}
+
+// doubleOptInError reports what DOUBLE_OPT_IN=true is missing, or "" when
+// it is fully configured.
+func (s *Settings) doubleOptInError() string {
+	required := []struct{ name, value string }{
+		{"SUBSCRIBE_TOKEN_SECRET", s.SubscribeTokenSecret},
+		{"SUBSCRIBE_CONFIRM_URL", s.SubscribeConfirmURL},
+		{"EMAIL_PROVIDER", s.EmailProvider},
+	}
+	for _, setting := range required {
+		if setting.value == "" {
+			return "DOUBLE_OPT_IN requires " + setting.name
+		}
+	}
+	return ""
+}
+
+func signConfirmToken(payload, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newConfirmToken encodes req as "<payload>.<signature>", both base64url,
+// valid for SUBSCRIBE_CONFIRM_TTL.
+func newConfirmToken(req SubscribeRequest, config Config) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("error generating token: %v", err)
+	}
+
+	data, err := json.Marshal(confirmToken{
+		Request: req,
+		Expires: time.Now().Add(config.SubscribeConfirmTTL).Unix(),
+		Nonce:   hex.EncodeToString(nonce),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error encoding token: %v", err)
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + signConfirmToken(payload, config.SubscribeTokenSecret), nil
+}
+
+func parseConfirmToken(token, secret string) (confirmToken, error) {
+	var ct confirmToken
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signConfirmToken(payload, secret))) {
+		return ct, errConfirmTokenInvalid
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil || json.Unmarshal(data, &ct) != nil {
+		return ct, errConfirmTokenInvalid
+	}
+	if time.Now().Unix() >= ct.Expires {
+		return ct, errConfirmTokenExpired
+	}
+	return ct, nil
+}
+
+func sendConfirmationEmail(r *http.Request, req SubscribeRequest, config Config) error {
+	token, err := newConfirmToken(req, config)
+	if err != nil {
+		return err
+	}
+
+	link, err := url.Parse(config.SubscribeConfirmURL)
+	if err != nil {
+		return fmt.Errorf("error parsing SUBSCRIBE_CONFIRM_URL: %v", err)
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	subject := config.SubscribeConfirmSubject
+	if subject == "" {
+		subject = "Please confirm your subscription"
+	}
+	return sendEmail(r.Context(), config, emailMessage{
+		to:      req.Email,
+		subject: subject,
+		body: fmt.Sprintf("Please confirm your subscription by opening this link:\n\n%s\n\n"+
+			"If you didn't sign up, you can ignore this email.\n", link),
+	})
+}
+
+// handleSubscribeConfirm completes a double opt-in signup from the link in
+// the confirmation email. On success it redirects to
+// SUBSCRIBE_CONFIRM_REDIRECT_URL when set, since the link is opened in a
+// browser.
+func handleSubscribeConfirm(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	ct, err := parseConfirmToken(r.URL.Query().Get("token"), config.SubscribeTokenSecret)
+	if errors.Is(err, errConfirmTokenExpired) {
+		writeJSON(w, http.StatusGone, ErrorResponse{Message: "Confirmation link has expired", Code: "confirmation_expired"})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_confirmation", "Confirmation link is invalid")
+		return
+	}
+
+	if !confirmTokens.reserve(ct.Nonce, time.Until(time.Unix(ct.Expires, 0))) {
+		writeJSON(w, http.StatusConflict, ErrorResponse{Message: "Subscription is already confirmed", Code: "already_confirmed"})
+		return
+	}
+
+	req := ct.Request
+	subscriptionID, err := subscribeToBeehiiv(r.Context(), config, req)
+	if err != nil && !errors.Is(err, errAlreadySubscribed) {
+		confirmTokens.release(ct.Nonce)
+		writeUpstreamError(w, err)
+		return
+	}
+
+	if err == nil {
+		auditLog.record("subscribe", map[string]string{
+			"email":   req.Email,
+			"ip_hash": hashIP(clientIP(r)),
+			"source":  "double_opt_in",
+		})
+		subscribers.record(req, subscriptionID, "double_opt_in")
+
+		notify := config.NotifyOnSubscribe
+		if req.NotifyTeam != nil {
+			notify = bool(*req.NotifyTeam)
+		}
+		if signupFeedPoster != nil && notify {
+			signupFeedPoster.add(req)
+		}
+	}
+
+	if redirect := config.SubscribeConfirmRedirectURL; redirect != "" {
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	resp := map[string]string{"status": "Subscription confirmed"}
+	if subscriptionID != "" {
+		resp["id"] = subscriptionID
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
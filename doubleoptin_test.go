@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// confirmLink returns the confirmation path for req, signed with the test
+// secret.
+func confirmLink(t *testing.T, config Config, req SubscribeRequest) string {
+	t.Helper()
+	token, err := newConfirmToken(req, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "/subscribe/confirm?token=" + url.QueryEscape(token)
+}
+
+func TestSubscribeConfirmSubscribesOnce(t *testing.T) {
+	useDatabase(t)
+	_, fakeBH := useFakeUpstreams(t)
+	config := configWith(t, "SUBSCRIBE_TOKEN_SECRET", "confirm-secret")
+	confirm := bind(handleSubscribeConfirm, config)
+
+	link := confirmLink(t, config, SubscribeRequest{Email: "ada@example.com", UTMSource: "newsletter"})
+	rec := serve(confirm, http.MethodGet, link, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Status string `json:"status"`
+		ID     string `json:"id"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.Status != "Subscription confirmed" || resp.ID == "" {
+		t.Errorf("response = %s", rec.Body)
+	}
+	calls := fakeBH.Calls()
+	if len(calls) != 1 || !strings.Contains(string(calls[0].Body), `"ada@example.com"`) || !strings.Contains(string(calls[0].Body), `"newsletter"`) {
+		t.Errorf("Beehiiv calls = %+v, want the request from the token", calls)
+	}
+	var source string
+	database.QueryRow(`SELECT source FROM subscribers WHERE email = 'ada@example.com'`).Scan(&source)
+	if source != "double_opt_in" {
+		t.Errorf("mirrored source = %q, want double_opt_in", source)
+	}
+
+	rec = serve(confirm, http.MethodGet, link, "")
+	if rec.Code != http.StatusConflict || decodeError(t, rec).Code != "already_confirmed" {
+		t.Errorf("second use: %d %s, want 409 already_confirmed", rec.Code, rec.Body)
+	}
+	if n := len(fakeBH.Calls()); n != 1 {
+		t.Errorf("%d Beehiiv calls, want the link used once", n)
+	}
+}
+
+func TestSubscribeConfirmRejectsBadTokens(t *testing.T) {
+	_, fakeBH := useFakeUpstreams(t)
+	config := configWith(t, "SUBSCRIBE_TOKEN_SECRET", "confirm-secret")
+	confirm := bind(handleSubscribeConfirm, config)
+
+	link := confirmLink(t, config, SubscribeRequest{Email: "ada@example.com"})
+	token, _ := url.QueryUnescape(strings.TrimPrefix(link, "/subscribe/confirm?token="))
+	payload, _, _ := strings.Cut(token, ".")
+
+	// A token for someone else, signed with our key but long expired.
+	expired, _ := json.Marshal(confirmToken{
+		Request: SubscribeRequest{Email: "late@example.com"},
+		Expires: time.Now().Add(-time.Minute).Unix(),
+		Nonce:   "expired",
+	})
+	expiredPayload := base64.RawURLEncoding.EncodeToString(expired)
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+		code   string
+	}{
+		{"missing", "", http.StatusBadRequest, "invalid_confirmation"},
+		{"unsigned", payload, http.StatusBadRequest, "invalid_confirmation"},
+		{"forged", payload + "." + base64.RawURLEncoding.EncodeToString([]byte("forged")), http.StatusBadRequest, "invalid_confirmation"},
+		{"not a token", "abc.def", http.StatusBadRequest, "invalid_confirmation"},
+		{"expired", expiredPayload + "." + signConfirmToken(expiredPayload, config.SubscribeTokenSecret), http.StatusGone, "confirmation_expired"},
+	}
+	for _, tt := range tests {
+		rec := serve(confirm, http.MethodGet, "/subscribe/confirm?token="+url.QueryEscape(tt.token), "")
+		if rec.Code != tt.status || decodeError(t, rec).Code != tt.code {
+			t.Errorf("%s: got %d %s, want %d %s", tt.name, rec.Code, rec.Body, tt.status, tt.code)
+		}
+	}
+
+	// A link signed with an old secret stops working when it is rotated.
+	rotated := configWith(t, "SUBSCRIBE_TOKEN_SECRET", "rotated")
+	if rec := serve(bind(handleSubscribeConfirm, rotated), http.MethodGet, link, ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("after rotating the secret: status = %d, want 400", rec.Code)
+	}
+	if n := len(fakeBH.Calls()); n != 0 {
+		t.Errorf("%d Beehiiv calls for invalid links", n)
+	}
+	if rec := serve(confirm, http.MethodPost, link, ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status = %d, want 405", rec.Code)
+	}
+}
+
+func TestSubscribeConfirmCanBeRetriedAfterUpstreamFailure(t *testing.T) {
+	useDatabase(t)
+	useFakeUpstreams(t)
+	config := configWith(t, "SUBSCRIBE_TOKEN_SECRET", "confirm-secret", "UPSTREAM_MAX_ATTEMPTS", "1")
+	confirm := bind(handleSubscribeConfirm, config)
+
+	link := confirmLink(t, config, SubscribeRequest{Email: "ada@example.com"})
+	fakeBH := beehiiv
+	beehiiv = failingBeehiiv{err: errors.New("connection refused")}
+	if rec := serve(confirm, http.MethodGet, link, ""); rec.Code < 500 {
+		t.Fatalf("with Beehiiv down: status = %d, want a 5xx", rec.Code)
+	}
+
+	beehiiv = fakeBH
+	if rec := serve(confirm, http.MethodGet, link, ""); rec.Code != http.StatusOK {
+		t.Errorf("retry: status = %d, want the link still usable: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestSubscribeConfirmRedirects(t *testing.T) {
+	useDatabase(t)
+	useFakeUpstreams(t)
+	config := configWith(t, "SUBSCRIBE_TOKEN_SECRET", "confirm-secret", "SUBSCRIBE_CONFIRM_REDIRECT_URL", "https://example.com/welcome")
+
+	rec := serve(bind(handleSubscribeConfirm, config), http.MethodGet, confirmLink(t, config, SubscribeRequest{Email: "ada@example.com"}), "")
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "https://example.com/welcome" {
+		t.Errorf("got %d to %q, want 303 to the redirect URL", rec.Code, rec.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const maxTrackedMessages = 10000
+
+type EditRequest struct {
+	MessageID int64  `json:"message_id"`
+	Message   string `json:"message"`
+}
+
+type TelegramEditMessage struct {
+	ChatID               string `json:"chat_id"`
+	MessageID            int64  `json:"message_id"`
+	Text                 string `json:"text"`
+	ParseMode            string `json:"parse_mode,omitempty"`
+	BusinessConnectionID string `json:"business_connection_id,omitempty"`
+}
+
+// messageTracker remembers the current text of messages we've sent or
+// edited, so edits can be recorded with both the old and the new text, and
+// which bot sent each one, since only that bot can edit it. /recent lists
+// them back.
+type messageTracker struct {
+	messages *ttlCache[trackedMessage]
+}
+
+type trackedMessage struct {
+	chatID    string
+	messageID int64
+	text      string
+	bot       string
+	sent      time.Time
+}
+
+var sentMessages = &messageTracker{messages: newTTLCache[trackedMessage]("sent_messages", maxTrackedMessages)}
+
+func messageKey(chatID string, messageID int64) string {
+	return chatID + ":" + strconv.FormatInt(messageID, 10)
+}
+
+// remember records a message's text and the token of the bot that sent it.
+// An edit keeps the time the message was first sent.
+func (t *messageTracker) remember(chatID string, messageID int64, text, bot string) {
+	key := messageKey(chatID, messageID)
+	sent := time.Now()
+	if msg, ok := t.messages.get(key); ok {
+		sent = msg.sent
+	}
+	t.messages.set(key, trackedMessage{chatID: chatID, messageID: messageID, text: text, bot: bot, sent: sent}, 0)
+}
+
+func (t *messageTracker) text(chatID string, messageID int64) string {
+	msg, _ := t.messages.get(messageKey(chatID, messageID))
+	return msg.text
+}
+
+// bot returns the token of the bot that sent a message, or "" when the
+// message isn't tracked.
+func (t *messageTracker) bot(chatID string, messageID int64) string {
+	msg, _ := t.messages.get(messageKey(chatID, messageID))
+	return msg.bot
+}
+
+// editTelegramMessage edits a message through the bot that sent it, when
+// it is tracked.
+func editTelegramMessage(ctx context.Context, config Config, messageID int64, message string, opts SendOptions) error {
+	if bot := sentMessages.bot(config.ChatID, messageID); bot != "" {
+		config = config.withBot(bot)
+	}
+	_, err := callTelegram(ctx, config, "editMessageText", TelegramEditMessage{
+		ChatID:               config.ChatID,
+		MessageID:            messageID,
+		Text:                 message,
+		ParseMode:            resolveParseMode(opts, message),
+		BusinessConnectionID: opts.BusinessConnectionID,
+	})
+	if err != nil {
+		return err
+	}
+
+	oldText := sentMessages.text(config.ChatID, messageID)
+	sentMessages.remember(config.ChatID, messageID, message, config.botToken("editMessageText"))
+
+	auditLog.record("edit", map[string]string{
+		"chat_id":    config.ChatID,
+		"message_id": strconv.FormatInt(messageID, 10),
+		"old_text":   oldText,
+		"new_text":   message,
+	})
+	return nil
+}
+
+// editLocks marks messages with an edit in flight. A second edit to the same
+// message is refused rather than queued, since whichever finished last
+// would silently win.
+type editLocks struct {
+	mu     sync.Mutex
+	active map[string]bool
+}
+
+var messageEdits = &editLocks{active: make(map[string]bool)}
+
+func (l *editLocks) tryLock(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active[key] {
+		return false
+	}
+	l.active[key] = true
+	return true
+}
+
+func (l *editLocks) unlock(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.active, key)
+}
+
+func handleEditMessage(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var req EditRequest
+	if !decodeBody(w, r, &req, config) {
+		return
+	}
+
+	if req.MessageID <= 0 {
+		writeError(w, http.StatusBadRequest, "message_id_required", "Message ID is required")
+		return
+	}
+
+	if req.Message == "" {
+		writeError(w, http.StatusBadRequest, "message_empty", "Message cannot be empty")
+		return
+	}
+
+	var errs fieldErrors
+	errs.message("message", req.Message, maxMessageLength)
+	if errs.write(w) {
+		return
+	}
+
+	key := messageKey(config.ChatID, req.MessageID)
+	if !messageEdits.tryLock(key) {
+		writeJSON(w, http.StatusConflict, ErrorResponse{Message: "Another edit to this message is in progress", Code: "edit_conflict"})
+		return
+	}
+	defer messageEdits.unlock(key)
+
+	if err := editTelegramMessage(r.Context(), config, req.MessageID, req.Message, SendOptions{ParseMode: "HTML"}); err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "Message edited successfully"})
+}
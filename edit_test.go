@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestEditHistoryIsAudited(t *testing.T) {
+	useFakeUpstreams(t)
+	edit := func(w http.ResponseWriter, r *http.Request) { handleEditMessage(w, r, testConfig) }
+
+	sent, err := sendTelegramMessage(context.Background(), testConfig, "Service X down", SendOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := len(auditEvents(t, "edit"))
+
+	for _, text := range []string{"Service X degraded", "Service X resolved"} {
+		body := fmt.Sprintf(`{"message_id":%d,"message":%q}`, sent.MessageID, text)
+		if rec := serve(edit, http.MethodPost, "/edit", body); rec.Code != http.StatusOK {
+			t.Fatalf("edit: %d %s", rec.Code, rec.Body)
+		}
+	}
+
+	events := auditEvents(t, "edit")[before:]
+	want := [][2]string{
+		{"Service X down", "Service X degraded"},
+		{"Service X degraded", "Service X resolved"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("edit events = %v, want %d", events, len(want))
+	}
+	for i, e := range events {
+		if e.Fields["old_text"] != want[i][0] || e.Fields["new_text"] != want[i][1] {
+			t.Errorf("edit %d: %q -> %q, want %q -> %q", i, e.Fields["old_text"], e.Fields["new_text"], want[i][0], want[i][1])
+		}
+		if e.Fields["message_id"] != strconv.FormatInt(sent.MessageID, 10) || e.Time.IsZero() {
+			t.Errorf("edit %d = %+v", i, e)
+		}
+	}
+}
+
+func TestEditMessageValidation(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+	edit := func(w http.ResponseWriter, r *http.Request) { handleEditMessage(w, r, testConfig) }
+
+	for _, body := range []string{`{"message":"x"}`, `{"message_id":1}`, `{"message_id":1,"message":"x","extra":true}`} {
+		if rec := serve(edit, http.MethodPost, "/edit", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+	if calls := fake.Calls(); len(calls) != 0 {
+		t.Errorf("invalid edits were sent: %v", calls)
+	}
+}
+
+func TestConcurrentEditsConflict(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+	edit := func(w http.ResponseWriter, r *http.Request) { handleEditMessage(w, r, testConfig) }
+
+	sent, err := sendTelegramMessage(context.Background(), testConfig, "Deploy started", SendOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first edit holds Telegram until released.
+	started, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	telegram = telegramFunc(func(method string, body []byte) (json.RawMessage, error) {
+		if method == "editMessageText" {
+			once.Do(func() {
+				close(started)
+				<-release
+			})
+		}
+		return fake.Call(context.Background(), testConfig.BotToken, method, "application/json", body)
+	})
+
+	body := fmt.Sprintf(`{"message_id":%d,"message":%q}`, sent.MessageID, "Deploy 50%")
+	first := make(chan int, 1)
+	go func() { first <- serve(edit, http.MethodPost, "/edit", body).Code }()
+	<-started
+
+	rec := serve(edit, http.MethodPost, "/edit", fmt.Sprintf(`{"message_id":%d,"message":%q}`, sent.MessageID, "Deploy failed"))
+	if rec.Code != http.StatusConflict || decodeError(t, rec).Code != "edit_conflict" {
+		t.Errorf("concurrent edit: %d %s, want 409 edit_conflict", rec.Code, rec.Body)
+	}
+
+	close(release)
+	if code := <-first; code != http.StatusOK {
+		t.Errorf("first edit: status = %d, want 200", code)
+	}
+	if got := sentText(t, fake); got != "Deploy 50%" {
+		t.Errorf("message reads %q, want the first edit", got)
+	}
+
+	// Once the first edit finishes the message can be edited again.
+	if rec := serve(edit, http.MethodPost, "/edit", fmt.Sprintf(`{"message_id":%d,"message":%q}`, sent.MessageID, "Deploy done")); rec.Code != http.StatusOK {
+		t.Errorf("edit after the first finished: %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Email provider API roots, variables for the same reason as the Telegram
+// and Beehiiv ones.
+var (
+	resendAPIBaseURL   = "https://api.resend.com"
+	sendgridAPIBaseURL = "https://api.sendgrid.com"
+)
+
+// normalizeEmail trims and lowercases email and reports whether it is a bare
+// RFC 5322 address. Display-name forms such as "Jo <jo@example.com>" are
+// rejected since Beehiiv expects the address alone, as are addresses over
+// the RFC 5321 length limits and IP literal domains, which mail providers
+// won't deliver to.
+func normalizeEmail(email string) (string, bool) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return email, false
+	}
+	at := strings.LastIndexByte(email, '@')
+	if len(email) > 254 || at > 64 || strings.HasPrefix(email[at+1:], "[") {
+		return email, false
+	}
+	return email, true
+}
+
+// emailMessage is a plain text email sent from EMAIL_FROM. replyTo is
+// optional.
+type emailMessage struct {
+	to      string
+	replyTo *mail.Address
+	subject string
+	body    string
+}
+
+// sendEmail delivers email through EMAIL_PROVIDER: "smtp", "resend" or
+// "sendgrid". Sends are not retried, since a timeout after the provider
+// accepted the message would deliver it twice.
+func sendEmail(ctx context.Context, config Config, email emailMessage) error {
+	from := config.EmailFrom
+	switch provider := config.EmailProvider; provider {
+	case "smtp":
+		return sendSMTP(config, from, email)
+	case "resend":
+		payload := map[string]interface{}{
+			"from":    from,
+			"to":      []string{email.to},
+			"subject": email.subject,
+			"text":    email.body,
+		}
+		if email.replyTo != nil {
+			payload["reply_to"] = email.replyTo.Address
+		}
+		return postEmailAPI(ctx, resendAPIBaseURL+"/emails", config.ResendAPIKey, payload)
+	case "sendgrid":
+		payload := map[string]interface{}{
+			"personalizations": []map[string]interface{}{{"to": []map[string]string{{"email": email.to}}}},
+			"from":             map[string]string{"email": from},
+			"subject":          email.subject,
+			"content":          []map[string]string{{"type": "text/plain", "value": email.body}},
+		}
+		if email.replyTo != nil {
+			payload["reply_to"] = map[string]string{"email": email.replyTo.Address, "name": email.replyTo.Name}
+		}
+		return postEmailAPI(ctx, sendgridAPIBaseURL+"/v3/mail/send", config.SendGridAPIKey, payload)
+	default:
+		return fmt.Errorf("unknown email provider %q", provider)
+	}
+}
+
+func postEmailAPI(ctx context.Context, endpoint, apiKey string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error marshaling payload: %v", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("error sending email: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newUpstreamError(resp)
+	}
+	return nil
+}
+
+// sendSMTP sends through SMTP_HOST:SMTP_PORT (default 587), using
+// STARTTLS when the server offers it and PLAIN auth when SMTP_USERNAME is
+// set.
+func sendSMTP(config Config, from string, email emailMessage) error {
+	host := config.SMTPHost
+	port := config.SMTPPort
+	if port == "" {
+		port = "587"
+	}
+
+	var auth smtp.Auth
+	if config.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", config.SMTPUsername, config.SMTPPassword, host)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", email.to)
+	if email.replyTo != nil {
+		fmt.Fprintf(&msg, "Reply-To: %s\r\n", email.replyTo.String())
+	}
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", email.subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&msg)
+	qp.Write([]byte(email.body))
+	qp.Close()
+
+	if err := smtp.SendMail(net.JoinHostPort(host, port), auth, from, []string{email.to}, msg.Bytes()); err != nil {
+		return fmt.Errorf("error sending email: %w", err)
+	}
+	return nil
+}
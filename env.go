@@ -0,0 +1,640 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/redis/go-redis/v9"
+)
+
+// loadDotEnv loads .env if present. A missing file is only a warning, but a
+// file that exists and fails to parse is fatal so a broken local setup
+// doesn't silently run with half its configuration.
+func loadDotEnv(path string) {
+	err := godotenv.Load(path)
+	if err == nil {
+		return
+	}
+
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Warning: %s file not found", path)
+		return
+	}
+
+	if line := malformedEnvLine(path); line > 0 {
+		log.Fatalf("Error parsing %s at line %d: %v", path, line, err)
+	}
+	log.Fatalf("Error parsing %s: %v", path, err)
+}
+
+// malformedEnvLine returns the first line that is neither blank, a comment,
+// nor a KEY=value (or KEY: value) assignment, or 0 if none is found.
+func malformedEnvLine(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	inQuote := false
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if inQuote {
+			// Continuation of a multi-line quoted value.
+			inQuote = strings.Count(line, `"`)%2 == 0
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.ContainsAny(line, "=:") {
+			return n
+		}
+		inQuote = strings.Count(line, `"`)%2 == 1
+	}
+	return 0
+}
+
+// Settings is the service's configuration, read from the environment once
+// at startup by loadSettings and handed to handlers in Config. Unset
+// variables take their defaults; see readSettings.
+type Settings struct {
+	// Server
+	Port                    int
+	AllowedOrigins          []string
+	CORSDenyPaths           []string
+	CORSRouteOrigins        map[string][]string
+	MaxHeaderBytes          int
+	ReadHeaderTimeout       time.Duration
+	ReadTimeout             time.Duration
+	WriteTimeout            time.Duration
+	IdleTimeout             time.Duration
+	ShutdownTimeout         time.Duration
+	WarmupTimeout           time.Duration
+	MaxConnections          int
+	MaxInFlightRequests     int
+	MaxRequestBytes         int
+	MaxBodyBytes            int
+	RequestTimeoutMax       time.Duration
+	MetricsAddr             string
+	AppEnv                  string
+	Location                *time.Location
+	TrustProxy              bool
+	UserAgentFilter         bool
+	UserAgentBlocklist      []string
+	LegacyRoutes            bool
+	MaintenanceMode         bool
+	MaintenanceBypassSecret string
+	RecordFixtures          bool
+	FixturesDir             string
+	DebugSlowMax            time.Duration
+
+	// Storage
+	DatabasePath       string
+	AuditLogPath       string
+	AuditHashSalt      string
+	JobsDir            string
+	JobsWorkers        int
+	JobsRetention      time.Duration
+	OutboxDir          string
+	OutboxPollInterval time.Duration
+	TemplatesDir       string
+	CacheMaxEntries    int
+
+	// Upstreams
+	APIMode                 string
+	HTTPClientTimeout       time.Duration
+	TelegramAPIBaseURL      string
+	BeehiivAPIBaseURL       string
+	SpotifyAccountsBaseURL  string
+	SpotifyAPIBaseURL       string
+	GitHubAPIBaseURL        string
+	AkismetAPIBaseURL       string
+	StripeAPIBaseURL        string
+	CircuitBreakerThreshold int
+	CircuitBreakerCooldown  time.Duration
+	HealthUpstreamTimeout   time.Duration
+	ReadinessProbeInterval  time.Duration
+	retryPolicies           map[string]retryPolicy
+
+	// Access
+	AdminToken               string
+	APIKeys                  []apiKey
+	APIKeyRoutes             []string
+	APISignatureTolerance    time.Duration
+	APISignatureRequireNonce bool
+	APISignatureMaxBytes     int
+	RateLimitPerMinute       int
+	RateLimitRoutes          string
+	RateLimitRedis           *redis.Options
+	SubscriberLookupLimit    int
+	CaptchaRoutes            []string
+	CaptchaProvider          string
+	CaptchaSecret            string
+	IdempotencyMaxBytes      int
+	IdempotencyTTL           time.Duration
+
+	// Telegram
+	TelegramBotToken          string
+	TelegramChatID            string
+	TelegramBots              *botPool
+	TelegramAPICompat         *apiVersion
+	TelegramSOCKS5Proxy       string
+	TelegramChats             map[string]string
+	TelegramDefaultTarget     string
+	TelegramAllowedChatIDs    []string
+	ChatOverridePolicy        string
+	TelegramBusinessMode      bool
+	AllowWhitespaceMessages   bool
+	SanitizeControlChars      string
+	AlertGroupWindow          time.Duration
+	ChatMinInterval           time.Duration
+	MaxBatchSize              int
+	MaxDocumentBytes          int
+	MaxPhotoBytes             int
+	MaxMediaGroupBytes        int
+	CaptionOverflow           string
+	TelegramWebhookSecret     string
+	TelegramAutoReply         bool
+	TelegramAutoReplyTemplate string
+	TelegramAckEdit           bool
+	TelegramAdminChatIDs      []string
+	SelfTestMessage           string
+	BroadcastChatIDs          []string
+	ChatIDsURL                string
+	ChatIDsRefreshInterval    time.Duration
+	BroadcastMaxTargets       int
+	BroadcastSessionTTL       time.Duration
+	SignupFeedChatID          string
+	SignupFeedWindow          time.Duration
+	SignupFeedDetailLimit     int
+	DigestSchedule            string
+	DigestWindow              time.Duration
+	DiscordWebhookURL         string
+	SlackWebhookURL           string
+	PaymentsNotifyChannel     string
+
+	// Subscriptions
+	BeehiivSandbox              bool
+	BeehiivAPIKey               string
+	BeehiivPublicationID        string
+	BeehiivAPIVersion           string
+	BeehiivMaxRedirects         int
+	VerifyMX                    bool
+	MXCacheTTL                  time.Duration
+	RequireConsent              bool
+	SubscribeDedupTTL           time.Duration
+	DuplicateSubscribeResponse  string
+	NotifyOnSubscribe           bool
+	DoubleOptIn                 bool
+	SubscribeTokenSecret        string
+	SubscribeConfirmURL         string
+	SubscribeConfirmSubject     string
+	SubscribeConfirmRedirectURL string
+	SubscribeConfirmTTL         time.Duration
+	SubscribeCustomFields       []string
+	SubscribeTags               []string
+	UnsubscribeURL              string
+	SubscriberTokenTTL          time.Duration
+	CSVMaxBytes                 int
+	CSVMaxMemory                int
+	CSVMaxRows                  int
+	CSVImportConcurrency        int
+
+	// Email and the contact form
+	EmailProvider         string
+	EmailFrom             string
+	ResendAPIKey          string
+	SendGridAPIKey        string
+	SMTPHost              string
+	SMTPPort              string
+	SMTPUsername          string
+	SMTPPassword          string
+	ContactToEmail        string
+	ContactMirrorTelegram bool
+
+	// Spam filtering
+	SpamHoneypotField     string
+	SpamMinSubmitTime     time.Duration
+	SpamBlockDisposable   bool
+	SpamDisposableDomains []string
+	SpamKeywords          []string
+	AkismetAPIKey         string
+	AkismetBlogURL        string
+
+	// Comments and analytics
+	CommentsMaxLength     int
+	CommentsModeration    bool
+	CommentsNotify        bool
+	CommentsFlagThreshold int
+	AnalyticsRetention    time.Duration
+
+	// GitHub, Stripe and Spotify
+	GitHubToken            string
+	GitHubUsername         string
+	GitHubStatsCacheTTL    time.Duration
+	GitHubWebhookSecret    string
+	GitHubWebhookMaxBytes  int
+	GitHubEvents           []string
+	StripeSecretKey        string
+	StripeWebhookSecret    string
+	StripeWebhookTolerance time.Duration
+	StripeEvents           []string
+	SponsorsWebhookSecret  string
+	SpotifyRefreshToken    string
+	SpotifyClientID        string
+	SpotifyClientSecret    string
+	NowPlayingCacheTTL     time.Duration
+}
+
+// loadSettings reads the settings and checks them as a whole, returning
+// every problem found, so a deployment can be fixed in one pass instead of
+// one restart, or one failed request, per variable.
+func loadSettings() (*Settings, []string) {
+	s, problems := readSettings()
+	problems = append(problems, s.check()...)
+	sort.Strings(problems)
+	return s, problems
+}
+
+// readSettings reads every setting from the environment. A value that is
+// set but doesn't parse is reported and replaced by its default.
+func readSettings() (*Settings, []string) {
+	var e envReader
+	s := &Settings{
+		Port:                    e.number("PORT", 4000),
+		AllowedOrigins:          e.list("ALLOWED_ORIGINS", nil),
+		CORSRouteOrigins:        parseRouteOrigins(os.Getenv("CORS_ROUTE_ORIGINS")),
+		MaxHeaderBytes:          e.number("MAX_HEADER_BYTES", http.DefaultMaxHeaderBytes),
+		ReadHeaderTimeout:       e.duration("SERVER_READ_HEADER_TIMEOUT", defaultReadHeaderTimeout),
+		ReadTimeout:             e.duration("SERVER_READ_TIMEOUT", defaultServerReadTimeout),
+		WriteTimeout:            e.duration("SERVER_WRITE_TIMEOUT", defaultServerWriteTimeout),
+		IdleTimeout:             e.duration("SERVER_IDLE_TIMEOUT", defaultServerIdleTimeout),
+		ShutdownTimeout:         e.duration("SHUTDOWN_TIMEOUT", defaultShutdownTimeout),
+		WarmupTimeout:           e.duration("WARMUP_TIMEOUT", 0),
+		MaxConnections:          e.number("MAX_CONNECTIONS", 0),
+		MaxInFlightRequests:     e.number("MAX_IN_FLIGHT_REQUESTS", 0),
+		MaxRequestBytes:         e.number("MAX_REQUEST_BYTES", defaultMaxRequestBytes),
+		MaxBodyBytes:            e.number("MAX_BODY_BYTES", defaultMaxBodyBytes),
+		RequestTimeoutMax:       e.milliseconds("REQUEST_TIMEOUT_MAX_MS", defaultMaxRequestTimeout),
+		MetricsAddr:             os.Getenv("METRICS_ADDR"),
+		AppEnv:                  os.Getenv("APP_ENV"),
+		Location:                time.UTC,
+		TrustProxy:              e.flag("TRUST_PROXY", false),
+		UserAgentFilter:         e.flag("USER_AGENT_FILTER", false),
+		UserAgentBlocklist:      parseUserAgentBlocklist(os.Getenv("USER_AGENT_BLOCKLIST")),
+		LegacyRoutes:            e.flag("LEGACY_ROUTES", true),
+		MaintenanceMode:         e.flag("MAINTENANCE_MODE", false),
+		MaintenanceBypassSecret: os.Getenv("MAINTENANCE_BYPASS_SECRET"),
+		RecordFixtures:          e.flag("RECORD_FIXTURES", false),
+		FixturesDir:             e.text("FIXTURES_DIR", "testdata/fixtures"),
+		DebugSlowMax:            e.milliseconds("DEBUG_SLOW_MAX_MS", defaultDebugSlowMax),
+
+		DatabasePath:       os.Getenv("DATABASE_PATH"),
+		AuditLogPath:       os.Getenv("AUDIT_LOG_PATH"),
+		AuditHashSalt:      os.Getenv("AUDIT_HASH_SALT"),
+		JobsDir:            os.Getenv("JOBS_DIR"),
+		JobsWorkers:        e.number("JOBS_WORKERS", defaultJobWorkers),
+		JobsRetention:      e.duration("JOBS_RETENTION", defaultJobRetention),
+		OutboxDir:          os.Getenv("OUTBOX_DIR"),
+		OutboxPollInterval: e.duration("OUTBOX_POLL_INTERVAL", defaultOutboxPollInterval),
+		TemplatesDir:       os.Getenv("TEMPLATES_DIR"),
+		CacheMaxEntries:    e.number("CACHE_MAX_ENTRIES", defaultCacheMaxEntries),
+
+		APIMode:                 e.text("API_MODE", "live"),
+		HTTPClientTimeout:       e.duration("HTTP_CLIENT_TIMEOUT", defaultHTTPClientTimeout),
+		TelegramAPIBaseURL:      e.baseURL("TELEGRAM_API_BASE_URL", telegramAPIBaseURL),
+		BeehiivAPIBaseURL:       e.baseURL("BEEHIIV_API_BASE_URL", beehiivAPIBaseURL),
+		SpotifyAccountsBaseURL:  e.baseURL("SPOTIFY_ACCOUNTS_BASE_URL", spotifyAccountsBaseURL),
+		SpotifyAPIBaseURL:       e.baseURL("SPOTIFY_API_BASE_URL", spotifyAPIBaseURL),
+		GitHubAPIBaseURL:        e.baseURL("GITHUB_API_BASE_URL", githubAPIBaseURL),
+		AkismetAPIBaseURL:       e.baseURL("AKISMET_API_BASE_URL", akismetAPIBaseURL),
+		StripeAPIBaseURL:        e.baseURL("STRIPE_API_BASE_URL", stripeAPIBaseURL),
+		CircuitBreakerThreshold: e.number("CIRCUIT_BREAKER_THRESHOLD", defaultCircuitBreakerThreshold),
+		CircuitBreakerCooldown:  e.duration("CIRCUIT_BREAKER_COOLDOWN", defaultCircuitBreakerCooldown),
+		HealthUpstreamTimeout:   e.duration("HEALTH_UPSTREAM_TIMEOUT", defaultHealthUpstreamTimeout),
+		ReadinessProbeInterval:  e.duration("READINESS_PROBE_INTERVAL", defaultReadinessProbeInterval),
+		retryPolicies:           readRetryPolicies(&e),
+
+		AdminToken:               os.Getenv("ADMIN_TOKEN"),
+		APIKeys:                  parseAPIKeys(os.Getenv("API_KEYS")),
+		APIKeyRoutes:             e.list("API_KEY_ROUTES", defaultAPIKeyRoutes),
+		APISignatureTolerance:    e.duration("API_SIGNATURE_TOLERANCE", defaultAPISignatureTolerance),
+		APISignatureRequireNonce: e.flag("API_SIGNATURE_REQUIRE_NONCE", false),
+		APISignatureMaxBytes:     e.number("API_SIGNATURE_MAX_BYTES", defaultAPISignatureMaxBytes),
+		RateLimitPerMinute:       e.number("RATE_LIMIT_PER_MINUTE", defaultRateLimitPerMinute),
+		RateLimitRoutes:          os.Getenv("RATE_LIMIT_ROUTES"),
+		SubscriberLookupLimit:    e.number("SUBSCRIBER_LOOKUP_LIMIT", defaultSubscriberLookupLimit),
+		CaptchaRoutes:            e.list("CAPTCHA_ROUTES", nil),
+		CaptchaProvider:          os.Getenv("CAPTCHA_PROVIDER"),
+		CaptchaSecret:            os.Getenv("CAPTCHA_SECRET"),
+		IdempotencyMaxBytes:      e.number("IDEMPOTENCY_MAX_BYTES", defaultIdempotencyMaxBytes),
+		IdempotencyTTL:           e.duration("IDEMPOTENCY_TTL", defaultIdempotencyTTL),
+
+		TelegramBotToken:          os.Getenv("TELEGRAM_BOT_TOKEN"),
+		TelegramChatID:            os.Getenv("TELEGRAM_CHAT_ID"),
+		TelegramSOCKS5Proxy:       os.Getenv("TELEGRAM_SOCKS5_PROXY"),
+		TelegramDefaultTarget:     os.Getenv("TELEGRAM_DEFAULT_TARGET"),
+		TelegramAllowedChatIDs:    e.list("TELEGRAM_ALLOWED_CHAT_IDS", nil),
+		ChatOverridePolicy:        os.Getenv("CHAT_OVERRIDE_POLICY"),
+		TelegramBusinessMode:      e.flag("TELEGRAM_BUSINESS_MODE", false),
+		AllowWhitespaceMessages:   e.flag("ALLOW_WHITESPACE_MESSAGES", false),
+		SanitizeControlChars:      os.Getenv("SANITIZE_CONTROL_CHARS"),
+		AlertGroupWindow:          e.duration("ALERT_GROUP_WINDOW", defaultAlertGroupWindow),
+		ChatMinInterval:           e.duration("CHAT_MIN_INTERVAL", 0),
+		MaxBatchSize:              e.number("MAX_BATCH_SIZE", defaultMaxBatchSize),
+		MaxDocumentBytes:          e.number("MAX_DOCUMENT_BYTES", defaultMaxDocumentBytes),
+		MaxPhotoBytes:             e.number("MAX_PHOTO_BYTES", defaultMaxPhotoBytes),
+		MaxMediaGroupBytes:        e.number("MAX_MEDIA_GROUP_BYTES", defaultMaxMediaGroupBytes),
+		CaptionOverflow:           os.Getenv("CAPTION_OVERFLOW"),
+		TelegramWebhookSecret:     os.Getenv("TELEGRAM_WEBHOOK_SECRET"),
+		TelegramAutoReply:         e.flag("TELEGRAM_AUTO_REPLY", false),
+		TelegramAutoReplyTemplate: os.Getenv("TELEGRAM_AUTO_REPLY_TEMPLATE"),
+		TelegramAckEdit:           e.flag("TELEGRAM_ACK_EDIT", false),
+		TelegramAdminChatIDs:      e.list("TELEGRAM_ADMIN_CHAT_IDS", nil),
+		SelfTestMessage:           e.text("SELFTEST_MESSAGE", defaultSelfTestMessage),
+		BroadcastChatIDs:          e.list("BROADCAST_CHAT_IDS", nil),
+		ChatIDsURL:                os.Getenv("CHAT_IDS_URL"),
+		ChatIDsRefreshInterval:    e.duration("CHAT_IDS_REFRESH_INTERVAL", defaultChatIDsRefreshInterval),
+		BroadcastMaxTargets:       e.number("BROADCAST_MAX_TARGETS", defaultBroadcastMaxTargets),
+		BroadcastSessionTTL:       e.duration("BROADCAST_SESSION_TTL", defaultBroadcastSessionTTL),
+		SignupFeedChatID:          os.Getenv("SIGNUP_FEED_CHAT_ID"),
+		SignupFeedWindow:          e.duration("SIGNUP_FEED_WINDOW", defaultSignupFeedWindow),
+		SignupFeedDetailLimit:     e.number("SIGNUP_FEED_DETAIL_LIMIT", defaultSignupFeedDetailLimit),
+		DigestSchedule:            os.Getenv("DIGEST_SCHEDULE"),
+		DigestWindow:              e.duration("DIGEST_WINDOW", defaultDigestWindow),
+		DiscordWebhookURL:         os.Getenv("DISCORD_WEBHOOK_URL"),
+		SlackWebhookURL:           os.Getenv("SLACK_WEBHOOK_URL"),
+		PaymentsNotifyChannel:     os.Getenv("PAYMENTS_NOTIFY_CHANNEL"),
+
+		BeehiivSandbox:              e.flag("BEEHIIV_SANDBOX", false),
+		BeehiivAPIKey:               os.Getenv("BEEHIIV_API_KEY"),
+		BeehiivPublicationID:        os.Getenv("BEEHIIV_PUBLICATION_ID"),
+		BeehiivAPIVersion:           os.Getenv("BEEHIIV_API_VERSION"),
+		BeehiivMaxRedirects:         e.number("BEEHIIV_MAX_REDIRECTS", 0),
+		VerifyMX:                    e.flag("VERIFY_MX", false),
+		MXCacheTTL:                  e.duration("MX_CACHE_TTL", defaultMXCacheTTL),
+		RequireConsent:              e.flag("REQUIRE_CONSENT", false),
+		SubscribeDedupTTL:           e.duration("SUBSCRIBE_DEDUP_TTL", defaultSubscribeDedupTTL),
+		DuplicateSubscribeResponse:  os.Getenv("DUPLICATE_SUBSCRIBE_RESPONSE"),
+		NotifyOnSubscribe:           e.flag("NOTIFY_ON_SUBSCRIBE", true),
+		DoubleOptIn:                 e.flag("DOUBLE_OPT_IN", false),
+		SubscribeTokenSecret:        os.Getenv("SUBSCRIBE_TOKEN_SECRET"),
+		SubscribeConfirmURL:         os.Getenv("SUBSCRIBE_CONFIRM_URL"),
+		SubscribeConfirmSubject:     os.Getenv("SUBSCRIBE_CONFIRM_SUBJECT"),
+		SubscribeConfirmRedirectURL: os.Getenv("SUBSCRIBE_CONFIRM_REDIRECT_URL"),
+		SubscribeConfirmTTL:         e.duration("SUBSCRIBE_CONFIRM_TTL", defaultSubscribeConfirmTTL),
+		SubscribeCustomFields:       e.list("SUBSCRIBE_CUSTOM_FIELDS", nil),
+		SubscribeTags:               e.list("SUBSCRIBE_TAGS", nil),
+		UnsubscribeURL:              os.Getenv("UNSUBSCRIBE_URL"),
+		SubscriberTokenTTL:          e.duration("SUBSCRIBER_TOKEN_TTL", defaultSubscriberTokenTTL),
+		CSVMaxBytes:                 e.number("CSV_MAX_BYTES", defaultCSVMaxBytes),
+		CSVMaxMemory:                e.number("CSV_MAX_MEMORY", defaultCSVMaxMemory),
+		CSVMaxRows:                  e.number("CSV_MAX_ROWS", defaultCSVMaxRows),
+		CSVImportConcurrency:        e.number("CSV_IMPORT_CONCURRENCY", defaultCSVConcurrency),
+
+		EmailProvider:         os.Getenv("EMAIL_PROVIDER"),
+		EmailFrom:             os.Getenv("EMAIL_FROM"),
+		ResendAPIKey:          os.Getenv("RESEND_API_KEY"),
+		SendGridAPIKey:        os.Getenv("SENDGRID_API_KEY"),
+		SMTPHost:              os.Getenv("SMTP_HOST"),
+		SMTPPort:              os.Getenv("SMTP_PORT"),
+		SMTPUsername:          os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:          os.Getenv("SMTP_PASSWORD"),
+		ContactToEmail:        os.Getenv("CONTACT_TO_EMAIL"),
+		ContactMirrorTelegram: e.flag("CONTACT_MIRROR_TELEGRAM", false),
+
+		SpamHoneypotField:     e.text("SPAM_HONEYPOT_FIELD", defaultSpamHoneypotField),
+		SpamMinSubmitTime:     e.duration("SPAM_MIN_SUBMIT_TIME", defaultSpamMinSubmitTime),
+		SpamBlockDisposable:   e.flag("SPAM_BLOCK_DISPOSABLE", true),
+		SpamDisposableDomains: splitList(strings.ToLower(os.Getenv("SPAM_DISPOSABLE_DOMAINS"))),
+		SpamKeywords:          e.list("SPAM_KEYWORDS", nil),
+		AkismetAPIKey:         os.Getenv("AKISMET_API_KEY"),
+		AkismetBlogURL:        os.Getenv("AKISMET_BLOG_URL"),
+
+		CommentsMaxLength:     e.number("COMMENTS_MAX_LENGTH", defaultMaxCommentLength),
+		CommentsModeration:    e.flag("COMMENTS_MODERATION", false),
+		CommentsNotify:        e.flag("COMMENTS_NOTIFY", true),
+		CommentsFlagThreshold: e.number("COMMENTS_FLAG_THRESHOLD", defaultCommentFlagLimit),
+		AnalyticsRetention:    e.duration("ANALYTICS_RETENTION", defaultAnalyticsRetention),
+
+		GitHubToken:            os.Getenv("GITHUB_TOKEN"),
+		GitHubUsername:         os.Getenv("GITHUB_USERNAME"),
+		GitHubStatsCacheTTL:    e.duration("GITHUB_STATS_CACHE_TTL", defaultGitHubStatsCacheTTL),
+		GitHubWebhookSecret:    os.Getenv("GITHUB_WEBHOOK_SECRET"),
+		GitHubWebhookMaxBytes:  e.number("GITHUB_WEBHOOK_MAX_BYTES", defaultGitHubWebhookMaxBytes),
+		GitHubEvents:           e.list("GITHUB_EVENTS", defaultGitHubEvents),
+		StripeSecretKey:        os.Getenv("STRIPE_SECRET_KEY"),
+		StripeWebhookSecret:    os.Getenv("STRIPE_WEBHOOK_SECRET"),
+		StripeWebhookTolerance: e.duration("STRIPE_WEBHOOK_TOLERANCE", defaultStripeWebhookTolerance),
+		StripeEvents:           e.list("STRIPE_EVENTS", defaultStripeEvents),
+		SponsorsWebhookSecret:  os.Getenv("SPONSORS_WEBHOOK_SECRET"),
+		SpotifyRefreshToken:    os.Getenv("SPOTIFY_REFRESH_TOKEN"),
+		SpotifyClientID:        os.Getenv("SPOTIFY_CLIENT_ID"),
+		SpotifyClientSecret:    os.Getenv("SPOTIFY_CLIENT_SECRET"),
+		NowPlayingCacheTTL:     e.duration("NOW_PLAYING_CACHE_TTL", defaultNowPlayingCacheTTL),
+	}
+
+	// CORS_DENY_PATHS set to "" turns the default list off.
+	corsDenyPaths, ok := os.LookupEnv("CORS_DENY_PATHS")
+	if !ok {
+		corsDenyPaths = defaultCORSDenyPaths
+	}
+	s.CORSDenyPaths = splitList(corsDenyPaths)
+
+	e.parse("APP_TIMEZONE", func(v string) error {
+		loc, err := time.LoadLocation(v)
+		if err == nil {
+			s.Location = loc
+		}
+		return err
+	})
+	e.parse("TELEGRAM_BOT_TOKENS", func(v string) (err error) {
+		s.TelegramBots, err = parseBotPool(v)
+		return err
+	})
+	e.parse("TELEGRAM_API_COMPAT", func(v string) error {
+		version, err := parseAPIVersion(v)
+		if err == nil {
+			s.TelegramAPICompat = &version
+		}
+		return err
+	})
+	e.parse("TELEGRAM_SOCKS5_PROXY", func(v string) error { _, err := newSOCKS5Client(v, 0); return err })
+	e.parse("RATE_LIMIT_REDIS_URL", func(v string) (err error) {
+		s.RateLimitRedis, err = redis.ParseURL(v)
+		return err
+	})
+	e.parse("TELEGRAM_CHATS", func(v string) (err error) {
+		s.TelegramChats, err = parseChatTargets(v)
+		return err
+	})
+	e.parse("DIGEST_SCHEDULE", func(v string) error { _, err := parseCron(v); return err })
+	e.parse("CHAT_IDS_URL", validateChatIDsURL)
+	return s, e.problems
+}
+
+// check reports what the settings are missing as a whole: required values,
+// and values that only make sense together.
+func (s *Settings) check() []string {
+	var problems []string
+	require := func(pairs ...string) {
+		for i := 0; i < len(pairs); i += 2 {
+			if pairs[i+1] == "" {
+				problems = append(problems, pairs[i]+" is required")
+			}
+		}
+	}
+
+	if s.TelegramBotToken == "" && s.TelegramBots == nil {
+		problems = append(problems, "TELEGRAM_BOT_TOKEN (or TELEGRAM_BOT_TOKENS) is required")
+	}
+	require("TELEGRAM_CHAT_ID", s.TelegramChatID)
+	switch s.APIMode {
+	case "live":
+		if !s.BeehiivSandbox {
+			require("BEEHIIV_API_KEY", s.BeehiivAPIKey, "BEEHIIV_PUBLICATION_ID", s.BeehiivPublicationID)
+		}
+	case "dry-run":
+	default:
+		problems = append(problems, fmt.Sprintf("API_MODE must be live or dry-run, got %q", s.APIMode))
+	}
+
+	if s.DoubleOptIn {
+		if msg := s.doubleOptInError(); msg != "" {
+			problems = append(problems, msg)
+		}
+	}
+	if s.GitHubToken != "" {
+		require("GITHUB_USERNAME", s.GitHubUsername)
+	}
+	if s.AkismetAPIKey != "" {
+		require("AKISMET_BLOG_URL", s.AkismetBlogURL)
+	}
+	if s.SpotifyRefreshToken != "" {
+		require("SPOTIFY_CLIENT_ID", s.SpotifyClientID, "SPOTIFY_CLIENT_SECRET", s.SpotifyClientSecret)
+	}
+	if s.PaymentsNotifyChannel != "" {
+		if _, msg := notifiersFor(s.PaymentsNotifyChannel, Config{Settings: s}); msg != "" {
+			problems = append(problems, "PAYMENTS_NOTIFY_CHANNEL is invalid: "+msg)
+		}
+	}
+	if s.TelegramDefaultTarget != "" && s.TelegramChats != nil {
+		if _, ok := s.TelegramChats[s.TelegramDefaultTarget]; !ok {
+			problems = append(problems, fmt.Sprintf("TELEGRAM_DEFAULT_TARGET %q is not listed in TELEGRAM_CHATS", s.TelegramDefaultTarget))
+		}
+	}
+	if len(s.CaptchaRoutes) > 0 {
+		if _, ok := captchaVerifyURLs[s.CaptchaProvider]; !ok || s.CaptchaSecret == "" {
+			problems = append(problems, "CAPTCHA_ROUTES requires CAPTCHA_PROVIDER (turnstile or hcaptcha) and CAPTCHA_SECRET")
+		}
+	}
+	return problems
+}
+
+// envReader reads typed values from the environment for readSettings,
+// noting a problem for each value that is set but doesn't parse.
+type envReader struct {
+	problems []string
+}
+
+func (e *envReader) problem(format string, args ...interface{}) {
+	e.problems = append(e.problems, fmt.Sprintf(format, args...))
+}
+
+func (e *envReader) text(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// list reads a comma-separated value; see splitList.
+func (e *envReader) list(name string, def []string) []string {
+	if items := splitList(os.Getenv(name)); len(items) > 0 {
+		return items
+	}
+	return def
+}
+
+// baseURL reads an upstream's base URL, without a trailing slash.
+func (e *envReader) baseURL(name, def string) string {
+	return strings.TrimSuffix(e.text(name, def), "/")
+}
+
+func (e *envReader) number(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		e.problem("%s must be an integer, got %q", name, value)
+		return def
+	}
+	return n
+}
+
+// milliseconds reads a whole, positive number of milliseconds, for the
+// settings named _MS.
+func (e *envReader) milliseconds(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	ms, err := strconv.Atoi(value)
+	if err != nil || ms <= 0 {
+		e.problem("%s must be a positive number of milliseconds, got %q", name, value)
+		return def
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// duration reads a Go duration string such as "10m".
+func (e *envReader) duration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		e.problem("%s must be a duration such as 30s, got %q", name, value)
+		return def
+	}
+	return d
+}
+
+func (e *envReader) flag(name string, def bool) bool {
+	switch value := os.Getenv(name); value {
+	case "":
+		return def
+	case "true":
+		return true
+	case "false":
+		return false
+	default:
+		e.problem("%s must be true or false, got %q", name, value)
+		return def
+	}
+}
+
+// parse hands a set value to parse, which stores what it parsed, and
+// notes the error it returns.
+func (e *envReader) parse(name string, parse func(string) error) {
+	if value := os.Getenv(name); value != "" {
+		if err := parse(value); err != nil {
+			e.problem("%s is invalid: %v", name, err)
+		}
+	}
+}
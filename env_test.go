@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeEnvFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDotEnv(t *testing.T) {
+	// A missing file only warns.
+	loadDotEnv(filepath.Join(t.TempDir(), ".env"))
+
+	t.Cleanup(func() { os.Unsetenv("DOTENV_TEST_VALUE") })
+	loadDotEnv(writeEnvFile(t, "# local settings\n\nDOTENV_TEST_VALUE=loaded\n"))
+	if got := os.Getenv("DOTENV_TEST_VALUE"); got != "loaded" {
+		t.Errorf("DOTENV_TEST_VALUE = %q, want loaded", got)
+	}
+}
+
+func TestMalformedEnvLine(t *testing.T) {
+	tests := []struct {
+		content string
+		want    int
+	}{
+		{"A=1\nB: 2\n", 0},
+		{"A=1\n\n# comment\nnot an assignment\n", 4},
+		{"A=\"multi\nline value\"\nB=2\n", 0},
+	}
+	for _, tt := range tests {
+		if got := malformedEnvLine(writeEnvFile(t, tt.content)); got != tt.want {
+			t.Errorf("malformedEnvLine(%q) = %d, want %d", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestLoadDotEnvMalformedIsFatal(t *testing.T) {
+	if path := os.Getenv("DOTENV_TEST_MALFORMED"); path != "" {
+		loadDotEnv(path)
+		return
+	}
+
+	path := writeEnvFile(t, "A=1\nBROKEN\n")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLoadDotEnvMalformedIsFatal$")
+	cmd.Env = append(os.Environ(), "DOTENV_TEST_MALFORMED="+path)
+	out, err := cmd.CombinedOutput()
+	if _, ok := err.(*exec.ExitError); !ok {
+		t.Fatalf("loading a malformed .env didn't exit: %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "at line 2") {
+		t.Errorf("output doesn't name the bad line:\n%s", out)
+	}
+}
+
+func TestReadSettingsRequestTimeoutMax(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		problem bool
+	}{
+		{"", defaultMaxRequestTimeout, false},
+		{"2500", 2500 * time.Millisecond, false},
+		{"0", defaultMaxRequestTimeout, true},
+		{"-5", defaultMaxRequestTimeout, true},
+		{"abc", defaultMaxRequestTimeout, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("REQUEST_TIMEOUT_MAX_MS", tt.value)
+		settings, problems := readSettings()
+		if settings.RequestTimeoutMax != tt.want {
+			t.Errorf("REQUEST_TIMEOUT_MAX_MS=%q: RequestTimeoutMax = %v, want %v", tt.value, settings.RequestTimeoutMax, tt.want)
+		}
+		reported := strings.Contains(strings.Join(problems, "\n"), "REQUEST_TIMEOUT_MAX_MS")
+		if reported != tt.problem {
+			t.Errorf("REQUEST_TIMEOUT_MAX_MS=%q: problems = %v", tt.value, problems)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type Fixture struct {
+	Endpoint   string          `json:"endpoint"`
+	RecordedAt time.Time       `json:"recorded_at"`
+	Request    FixtureRequest  `json:"request"`
+	Response   FixtureResponse `json:"response"`
+}
+
+type FixtureRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Query   string            `json:"query,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    interface{}       `json:"body,omitempty"`
+}
+
+type FixtureResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    interface{}       `json:"body,omitempty"`
+}
+
+const redacted = "[REDACTED]"
+
+var fixtureSeq uint64
+
+// responseRecorder captures the status and body written by the wrapped
+// handler while still passing everything through to the client.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *responseRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// recordFixtures wraps next so that every request/response pair is written to
+// dir as a sanitized JSON fixture for the frontend contract tests, with
+// secrets scrubbed.
+func recordFixtures(next http.Handler, dir string, secrets []string) http.Handler {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Printf("Warning: cannot create fixtures directory %s: %v", dir, err)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqBody, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Printf("Warning: cannot read request body for fixture: %v", err)
+		}
+		r.Body = io.NopCloser(bytes.NewReader(reqBody))
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		fixture := Fixture{
+			Endpoint:   r.URL.Path,
+			RecordedAt: time.Now().UTC(),
+			Request: FixtureRequest{
+				Method:  r.Method,
+				Path:    r.URL.Path,
+				Query:   sanitizeQuery(r.URL.RawQuery, secrets),
+				Headers: sanitizeHeaders(r.Header, secrets),
+				Body:    sanitizeBody(reqBody, secrets),
+			},
+			Response: FixtureResponse{
+				Status:  rec.status,
+				Headers: sanitizeHeaders(w.Header(), secrets),
+				Body:    sanitizeBody(rec.body.Bytes(), secrets),
+			},
+		}
+
+		if err := writeFixture(dir, fixture); err != nil {
+			log.Printf("Warning: cannot write fixture: %v", err)
+		}
+	})
+}
+
+// fixtureSecrets lists the configured secrets to scrub from fixtures: the
+// value of every setting named like a secret, such as TELEGRAM_BOT_TOKEN or
+// ADMIN_TOKEN, and each key in API_KEYS and bot in TELEGRAM_BOT_TOKENS.
+func fixtureSecrets(settings *Settings) []string {
+	var secrets []string
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if value != "" && isSecretName(name) {
+			secrets = append(secrets, value)
+		}
+	}
+	for _, key := range settings.APIKeys {
+		secrets = append(secrets, key.secret)
+	}
+	if pool := settings.TelegramBots; pool != nil {
+		for _, bot := range pool.bots {
+			secrets = append(secrets, bot.token)
+		}
+	}
+	// Scrub longer secrets first, so one that contains another is replaced
+	// whole.
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	return secrets
+}
+
+// sanitizeQuery redacts query parameters named like secrets, such as the
+// signed ?token= of confirmation and unsubscribe links, and scrubs known
+// secrets from the rest.
+func sanitizeQuery(rawQuery string, secrets []string) string {
+	params := strings.Split(rawQuery, "&")
+	for i, param := range params {
+		name, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil && isSecretName(unescaped) {
+			params[i] = name + "=" + redacted
+			continue
+		}
+		params[i] = sanitizeString(param, secrets)
+	}
+	return strings.Join(params, "&")
+}
+
+func writeFixture(dir string, fixture Fixture) error {
+	name := strings.ReplaceAll(strings.Trim(fixture.Endpoint, "/"), "/", "_")
+	if name == "" {
+		name = "root"
+	}
+	seq := atomic.AddUint64(&fixtureSeq, 1)
+	path := filepath.Join(dir, fmt.Sprintf("%s_%s_%d.json", name, fixture.RecordedAt.Format("20060102T150405"), seq))
+
+	data, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling fixture: %v", err)
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+func isSecretName(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range []string{"authorization", "cookie", "token", "secret", "password", "signature", "api_key", "api-key", "apikey"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func sanitizeHeaders(h http.Header, secrets []string) map[string]string {
+	if len(h) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(h))
+	for name, values := range h {
+		if isSecretName(name) {
+			out[name] = redacted
+			continue
+		}
+		out[name] = sanitizeString(strings.Join(values, ", "), secrets)
+	}
+	return out
+}
+
+func sanitizeString(s string, secrets []string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, redacted)
+		}
+	}
+	return s
+}
+
+// sanitizeBody returns the body as decoded JSON with secret-looking fields
+// redacted, or as a plain string when it isn't JSON.
+func sanitizeBody(body []byte, secrets []string) interface{} {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return sanitizeString(string(body), secrets)
+	}
+	return sanitizeValue(v, secrets)
+}
+
+func sanitizeValue(v interface{}, secrets []string) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, child := range val {
+			if isSecretName(k) {
+				val[k] = redacted
+				continue
+			}
+			val[k] = sanitizeValue(child, secrets)
+		}
+		return val
+	case []interface{}:
+		for i, child := range val {
+			val[i] = sanitizeValue(child, secrets)
+		}
+		return val
+	case string:
+		return sanitizeString(val, secrets)
+	default:
+		return val
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordFixturesWritesSanitizedFixture(t *testing.T) {
+	settings := configWith(t,
+		"TELEGRAM_BOT_TOKEN", "123:bot-secret",
+		"ADMIN_TOKEN", "admin-token-value",
+		"API_KEYS", "web:web-key-secret:/send,ci:ci-key-secret",
+		"TELEGRAM_BOT_TOKENS", "456:pool-one=2,789:pool-two",
+	).Settings
+	dir := t.TempDir()
+
+	handler := recordFixtures(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "sent with 123:bot-secret"})
+	}), dir, fixtureSecrets(settings))
+
+	req := httptest.NewRequest(http.MethodPost, "/send?token=abc&lang=en&note=ci-key-secret", strings.NewReader(`{"message":"hi admin-token-value 789:pool-two","api_key":"k-123"}`))
+	req.Header.Set("X-Debug", "456:pool-one web-key-secret")
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "123:bot-secret") {
+		t.Fatalf("the client's response changed: %d %s", rec.Code, rec.Body)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "send_*.json"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("fixtures = %v (%v), want one", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"123:bot-secret", "admin-secret", "k-123", "abc", "admin-token-value", "web-key-secret", "ci-key-secret", "456:pool-one", "789:pool-two"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("fixture contains %q:\n%s", secret, data)
+		}
+	}
+
+	var fixture Fixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		t.Fatal(err)
+	}
+	if fixture.Endpoint != "/send" || fixture.Request.Method != http.MethodPost || fixture.Response.Status != http.StatusOK {
+		t.Errorf("fixture = %+v", fixture)
+	}
+	if body, _ := fixture.Request.Body.(map[string]interface{}); body["message"] != "hi "+redacted+" "+redacted || body["api_key"] != redacted {
+		t.Errorf("request body = %v, want message kept and api_key redacted", fixture.Request.Body)
+	}
+	if want := "token=" + redacted + "&lang=en&note=" + redacted; fixture.Request.Query != want {
+		t.Errorf("query = %q, want %q", fixture.Request.Query, want)
+	}
+	if fixture.Request.Headers["Authorization"] != redacted {
+		t.Errorf("Authorization header = %q, want it redacted", fixture.Request.Headers["Authorization"])
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// FlexBool is a boolean that also accepts the string forms HTML forms tend
+// to submit, such as "true", "false", "on", "off", "1" and "0".
+type FlexBool bool
+
+func (b *FlexBool) UnmarshalJSON(data []byte) error {
+	var v bool
+	if err := json.Unmarshal(data, &v); err == nil {
+		*b = FlexBool(v)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid boolean %s", data)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "true", "on", "yes", "1":
+		*b = true
+	case "false", "off", "no", "0", "":
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean %q", s)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFlexBoolUnmarshal(t *testing.T) {
+	tests := []struct {
+		json string
+		want FlexBool
+	}{
+		{`true`, true},
+		{`false`, false},
+		{`"true"`, true},
+		{`"false"`, false},
+		{`"on"`, true},
+		{`" Off "`, false},
+		{`"1"`, true},
+		{`"0"`, false},
+		{`""`, false},
+	}
+	for _, tt := range tests {
+		var got FlexBool
+		if err := json.Unmarshal([]byte(tt.json), &got); err != nil {
+			t.Errorf("%s: %v", tt.json, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.json, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{`"maybe"`, `1`, `{}`} {
+		var got FlexBool
+		if err := json.Unmarshal([]byte(bad), &got); err == nil {
+			t.Errorf("%s unmarshaled to %v, want an error", bad, got)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultGitHubStatsCacheTTL = time.Hour
+	maxGitHubRepoPages         = 10
+	recentContributionDays     = 30
+)
+
+// GitHubStats is the /github/stats response. Contributions cover the last
+// year, RecentContributions the last 30 days.
+type GitHubStats struct {
+	Login               string                   `json:"login"`
+	PublicRepos         int                      `json:"public_repos"`
+	TotalStars          int                      `json:"total_stars"`
+	Followers           int                      `json:"followers"`
+	Contributions       int                      `json:"contributions"`
+	RecentContributions GitHubRecentContribution `json:"recent_contributions"`
+	FetchedAt           time.Time                `json:"fetched_at"`
+}
+
+type GitHubRecentContribution struct {
+	Days         int `json:"days"`
+	Total        int `json:"total"`
+	Commits      int `json:"commits"`
+	PullRequests int `json:"pull_requests"`
+	Issues       int `json:"issues"`
+	Reviews      int `json:"reviews"`
+}
+
+const gitHubStatsQuery = `query($login: String!, $after: String, $since: DateTime!) {
+  user(login: $login) {
+    login
+    followers { totalCount }
+    repositories(ownerAffiliations: OWNER, privacy: PUBLIC, first: 100, after: $after) {
+      totalCount
+      nodes { stargazerCount }
+      pageInfo { hasNextPage endCursor }
+    }
+    contributionsCollection { contributionCalendar { totalContributions } }
+    recent: contributionsCollection(from: $since) {
+      contributionCalendar { totalContributions }
+      totalCommitContributions
+      totalPullRequestContributions
+      totalIssueContributions
+      totalPullRequestReviewContributions
+    }
+  }
+}`
+
+type gitHubStatsResult struct {
+	Data struct {
+		User *struct {
+			Login     string `json:"login"`
+			Followers struct {
+				TotalCount int `json:"totalCount"`
+			} `json:"followers"`
+			Repositories struct {
+				TotalCount int `json:"totalCount"`
+				Nodes      []struct {
+					StargazerCount int `json:"stargazerCount"`
+				} `json:"nodes"`
+				PageInfo struct {
+					HasNextPage bool   `json:"hasNextPage"`
+					EndCursor   string `json:"endCursor"`
+				} `json:"pageInfo"`
+			} `json:"repositories"`
+			Contributions struct {
+				Calendar struct {
+					Total int `json:"totalContributions"`
+				} `json:"contributionCalendar"`
+			} `json:"contributionsCollection"`
+			Recent struct {
+				Calendar struct {
+					Total int `json:"totalContributions"`
+				} `json:"contributionCalendar"`
+				Commits      int `json:"totalCommitContributions"`
+				PullRequests int `json:"totalPullRequestContributions"`
+				Issues       int `json:"totalIssueContributions"`
+				Reviews      int `json:"totalPullRequestReviewContributions"`
+			} `json:"recent"`
+		} `json:"user"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// gitHubStatsCache holds the last stats fetched. They are refreshed once
+// older than GITHUB_STATS_CACHE_TTL, and served stale if GitHub can't be
+// reached, since a slightly old star count beats an error on the homepage.
+// After a failure GitHub is left alone for a minute, so a rate limit isn't
+// prolonged by every page view.
+var gitHubStatsCache struct {
+	mu       sync.Mutex
+	stats    *GitHubStats
+	failedAt time.Time
+}
+
+// fetchGitHubStats runs the stats query for GITHUB_USERNAME, paging through
+// repositories to sum their stars.
+func fetchGitHubStats(ctx context.Context, config Config) (*GitHubStats, error) {
+	now := time.Now().UTC()
+	stats := &GitHubStats{FetchedAt: now}
+	variables := map[string]interface{}{
+		"login": config.GitHubUsername,
+		"since": now.AddDate(0, 0, -recentContributionDays).Format(time.RFC3339),
+	}
+
+	for page := 0; page < maxGitHubRepoPages; page++ {
+		payload, err := json.Marshal(map[string]interface{}{"query": gitHubStatsQuery, "variables": variables})
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling query: %v", err)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, githubAPIBaseURL+"/graphql", bytes.NewReader(payload))
+		if err != nil {
+			return nil, fmt.Errorf("error creating request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+config.GitHubToken)
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("error querying GitHub: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			upErr := newUpstreamError(resp)
+			resp.Body.Close()
+			return nil, upErr
+		}
+		var result gitHubStatsResult
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error decoding GitHub response: %v", err)
+		}
+		if len(result.Errors) > 0 {
+			return nil, fmt.Errorf("GitHub query failed: %s", result.Errors[0].Message)
+		}
+		user := result.Data.User
+		if user == nil {
+			return nil, fmt.Errorf("GitHub user %q not found", variables["login"])
+		}
+
+		if page == 0 {
+			stats.Login = user.Login
+			stats.PublicRepos = user.Repositories.TotalCount
+			stats.Followers = user.Followers.TotalCount
+			stats.Contributions = user.Contributions.Calendar.Total
+			stats.RecentContributions = GitHubRecentContribution{
+				Days:         recentContributionDays,
+				Total:        user.Recent.Calendar.Total,
+				Commits:      user.Recent.Commits,
+				PullRequests: user.Recent.PullRequests,
+				Issues:       user.Recent.Issues,
+				Reviews:      user.Recent.Reviews,
+			}
+		}
+		for _, repo := range user.Repositories.Nodes {
+			stats.TotalStars += repo.StargazerCount
+		}
+		if !user.Repositories.PageInfo.HasNextPage {
+			break
+		}
+		variables["after"] = user.Repositories.PageInfo.EndCursor
+	}
+	return stats, nil
+}
+
+func handleGitHubStats(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	ttl := config.GitHubStatsCacheTTL
+
+	gitHubStatsCache.mu.Lock()
+	stats := gitHubStatsCache.stats
+	if (stats == nil || time.Since(stats.FetchedAt) >= ttl) && time.Since(gitHubStatsCache.failedAt) >= time.Minute {
+		fresh, err := fetchGitHubStats(r.Context(), config)
+		if err != nil {
+			gitHubStatsCache.failedAt = time.Now()
+		}
+		switch {
+		case err == nil:
+			gitHubStatsCache.stats = fresh
+			stats = fresh
+		case stats != nil:
+			log.Printf("Warning: serving stale GitHub stats: %v", err)
+		default:
+			gitHubStatsCache.mu.Unlock()
+			writeUpstreamError(w, err)
+			return
+		}
+	}
+	gitHubStatsCache.mu.Unlock()
+	if stats == nil {
+		writeJSON(w, http.StatusServiceUnavailable, ErrorResponse{Message: "GitHub stats are unavailable, please retry later", Code: "upstream_unavailable"})
+		return
+	}
+
+	maxAge := ttl - time.Since(stats.FetchedAt)
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+	w.Header().Set("Last-Modified", stats.FetchedAt.Format(http.TimeFormat))
+	writeJSON(w, http.StatusOK, stats)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// gitHubQuery is what the fake GraphQL endpoint sees of a stats query.
+type gitHubQuery struct {
+	Variables struct {
+		Login string `json:"login"`
+		After string `json:"after"`
+		Since string `json:"since"`
+	} `json:"variables"`
+	Auth string
+}
+
+// useGitHub points the GraphQL API at respond, counting calls, and starts
+// with an empty stats cache.
+func useGitHub(t *testing.T, respond func(w http.ResponseWriter, q gitHubQuery)) *atomic.Int32 {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var q gitHubQuery
+		json.NewDecoder(r.Body).Decode(&q)
+		q.Auth = r.Header.Get("Authorization")
+		respond(w, q)
+	}))
+	t.Cleanup(srv.Close)
+
+	orig := githubAPIBaseURL
+	reset := func() {
+		gitHubStatsCache.stats, gitHubStatsCache.failedAt = nil, time.Time{}
+	}
+	t.Cleanup(func() {
+		githubAPIBaseURL = orig
+		reset()
+	})
+	githubAPIBaseURL = srv.URL
+	reset()
+	t.Setenv("GITHUB_USERNAME", "octocat")
+	t.Setenv("GITHUB_TOKEN", "gh-token")
+	return &calls
+}
+
+// gitHubStatsPage answers with a user whose repositories page holds stars,
+// continuing to next when it is set.
+func gitHubStatsPage(w http.ResponseWriter, next string, stars ...int) {
+	var nodes []map[string]int
+	for _, s := range stars {
+		nodes = append(nodes, map[string]int{"stargazerCount": s})
+	}
+	nodesJSON, _ := json.Marshal(nodes)
+	fmt.Fprintf(w, `{"data":{"user":{"login":"octocat","followers":{"totalCount":7},
+		"repositories":{"totalCount":3,"nodes":%s,"pageInfo":{"hasNextPage":%t,"endCursor":%q}},
+		"contributionsCollection":{"contributionCalendar":{"totalContributions":500}},
+		"recent":{"contributionCalendar":{"totalContributions":40},"totalCommitContributions":30,
+			"totalPullRequestContributions":5,"totalIssueContributions":3,"totalPullRequestReviewContributions":2}}}}`,
+		nodesJSON, next != "", next)
+}
+
+func gitHubStats(t *testing.T) (GitHubStats, *httptest.ResponseRecorder) {
+	t.Helper()
+	rec := serve(bind(handleGitHubStats, configWith(t)), http.MethodGet, "/github/stats", "")
+	var stats GitHubStats
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return stats, rec
+}
+
+func TestGitHubStatsSumsStarsAcrossPages(t *testing.T) {
+	calls := useGitHub(t, func(w http.ResponseWriter, q gitHubQuery) {
+		if q.Auth != "Bearer gh-token" || q.Variables.Login != "octocat" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if q.Variables.After == "" {
+			gitHubStatsPage(w, "cursor-1", 10, 5)
+		} else {
+			gitHubStatsPage(w, "", 1)
+		}
+	})
+
+	stats, rec := gitHubStats(t)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if stats.Login != "octocat" || stats.PublicRepos != 3 || stats.TotalStars != 16 || stats.Followers != 7 || stats.Contributions != 500 {
+		t.Errorf("stats = %+v", stats)
+	}
+	want := GitHubRecentContribution{Days: 30, Total: 40, Commits: 30, PullRequests: 5, Issues: 3, Reviews: 2}
+	if stats.RecentContributions != want {
+		t.Errorf("recent = %+v, want %+v", stats.RecentContributions, want)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "public, max-age=3599" && cc != "public, max-age=3600" {
+		t.Errorf("Cache-Control = %q", cc)
+	}
+	if rec.Header().Get("Last-Modified") == "" {
+		t.Error("no Last-Modified")
+	}
+
+	// Cached until GITHUB_STATS_CACHE_TTL.
+	gitHubStats(t)
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d GitHub calls, want 2 pages fetched once", n)
+	}
+}
+
+func TestGitHubStatsStopsPagingAtTheLimit(t *testing.T) {
+	calls := useGitHub(t, func(w http.ResponseWriter, q gitHubQuery) {
+		gitHubStatsPage(w, "more", 1)
+	})
+
+	if stats, _ := gitHubStats(t); stats.TotalStars != maxGitHubRepoPages {
+		t.Errorf("stars = %d, want %d pages counted", stats.TotalStars, maxGitHubRepoPages)
+	}
+	if n := calls.Load(); n != maxGitHubRepoPages {
+		t.Errorf("%d GitHub calls, want %d", n, maxGitHubRepoPages)
+	}
+}
+
+func TestGitHubStatsServesStaleOnFailure(t *testing.T) {
+	var failing atomic.Bool
+	calls := useGitHub(t, func(w http.ResponseWriter, q gitHubQuery) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		gitHubStatsPage(w, "", 42)
+	})
+	gitHubStats(t)
+
+	// Expire the cache and break GitHub.
+	gitHubStatsCache.stats.FetchedAt = time.Now().Add(-2 * time.Hour)
+	failing.Store(true)
+	stats, rec := gitHubStats(t)
+	if rec.Code != http.StatusOK || stats.TotalStars != 42 {
+		t.Fatalf("got %d %s, want the stale stats", rec.Code, rec.Body)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "public, max-age=0" {
+		t.Errorf("Cache-Control = %q, want stale stats not cached", cc)
+	}
+
+	// GitHub is left alone for a minute after failing.
+	gitHubStats(t)
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d GitHub calls, want none during the backoff", n)
+	}
+}
+
+func TestGitHubStatsWithoutCache(t *testing.T) {
+	calls := useGitHub(t, func(w http.ResponseWriter, q gitHubQuery) {
+		w.Write([]byte(`{"data":{"user":null},"errors":[{"message":"Could not resolve to a User"}]}`))
+	})
+
+	if _, rec := gitHubStats(t); rec.Code < 500 {
+		t.Errorf("GraphQL error: status = %d, want a 5xx", rec.Code)
+	}
+	_, rec := gitHubStats(t)
+	if rec.Code != http.StatusServiceUnavailable || decodeError(t, rec).Code != "upstream_unavailable" {
+		t.Errorf("during the backoff: got %d %s, want 503 upstream_unavailable", rec.Code, rec.Body)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d GitHub calls, want 1", n)
+	}
+}
+
+func TestGitHubStatsUnknownUser(t *testing.T) {
+	useGitHub(t, func(w http.ResponseWriter, q gitHubQuery) {
+		w.Write([]byte(`{"data":{"user":null}}`))
+	})
+
+	if _, rec := gitHubStats(t); rec.Code < 500 {
+		t.Errorf("status = %d, want a 5xx: %s", rec.Code, rec.Body)
+	}
+}
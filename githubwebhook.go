@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// GitHub payloads embed whole issue and pull request bodies, so they get a
+// larger limit than MAX_BODY_BYTES.
+const defaultGitHubWebhookMaxBytes = 1 << 20
+
+var defaultGitHubEvents = []string{"star", "issues", "pull_request", "release"}
+
+type gitHubUser struct {
+	Login string `json:"login"`
+}
+
+type gitHubRepository struct {
+	FullName        string `json:"full_name"`
+	HTMLURL         string `json:"html_url"`
+	StargazersCount int    `json:"stargazers_count"`
+}
+
+type gitHubEvent struct {
+	Action     string           `json:"action"`
+	Sender     gitHubUser       `json:"sender"`
+	Repository gitHubRepository `json:"repository"`
+	Issue      *struct {
+		Number  int    `json:"number"`
+		Title   string `json:"title"`
+		HTMLURL string `json:"html_url"`
+	} `json:"issue,omitempty"`
+	PullRequest *struct {
+		Number  int    `json:"number"`
+		Title   string `json:"title"`
+		HTMLURL string `json:"html_url"`
+		Merged  bool   `json:"merged"`
+	} `json:"pull_request,omitempty"`
+	Release *struct {
+		TagName    string `json:"tag_name"`
+		Name       string `json:"name"`
+		HTMLURL    string `json:"html_url"`
+		Prerelease bool   `json:"prerelease"`
+	} `json:"release,omitempty"`
+}
+
+// validGitHubSignature checks an X-Hub-Signature-256 header against the
+// webhook's secret.
+func validGitHubSignature(body []byte, header, secret string) bool {
+	sig, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// handleGitHubWebhook posts star, issue, pull request and release events
+// from a GitHub webhook signed with GITHUB_WEBHOOK_SECRET to the configured
+// chat. Event types missing from GITHUB_EVENTS, and actions not worth a
+// message such as labelling an issue, are acknowledged without sending.
+func handleGitHubWebhook(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(config.GitHubWebhookMaxBytes)))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "body_too_large", "Request body too large")
+		return
+	}
+	if !validGitHubSignature(body, r.Header.Get("X-Hub-Signature-256"), config.GitHubWebhookSecret) {
+		writeError(w, http.StatusUnauthorized, "invalid_signature", "Invalid signature")
+		return
+	}
+
+	eventType := r.Header.Get("X-GitHub-Event")
+	if eventType == "ping" {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "pong"})
+		return
+	}
+	if !slices.Contains(config.GitHubEvents, eventType) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ignored"})
+		return
+	}
+
+	var event gitHubEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	text := formatGitHubEvent(eventType, event)
+	if text == "" {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ignored"})
+		return
+	}
+
+	sent, err := sendTelegramMessage(r.Context(), config, text, SendOptions{ParseMode: "HTML"})
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":     "Message sent",
+		"message_id": sent.MessageID,
+	})
+}
+
+// formatGitHubEvent renders event as an HTML message, or returns "" for
+// actions that are not announced.
+func formatGitHubEvent(eventType string, event gitHubEvent) string {
+	link := func(url, text string) string {
+		return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(url), html.EscapeString(text))
+	}
+	repo := link(event.Repository.HTMLURL, event.Repository.FullName)
+	sender := html.EscapeString(event.Sender.Login)
+
+	switch eventType {
+	case "star":
+		if event.Action != "created" {
+			return ""
+		}
+		return fmt.Sprintf("⭐ %s starred %s (%d stars)", sender, repo, event.Repository.StargazersCount)
+
+	case "issues":
+		issue := event.Issue
+		if issue == nil {
+			return ""
+		}
+		switch event.Action {
+		case "opened", "closed", "reopened":
+		default:
+			return ""
+		}
+		return fmt.Sprintf("<b>Issue %s</b> in %s by %s\n%s",
+			event.Action, repo, sender, link(issue.HTMLURL, fmt.Sprintf("#%d %s", issue.Number, issue.Title)))
+
+	case "pull_request":
+		pr := event.PullRequest
+		if pr == nil {
+			return ""
+		}
+		action := event.Action
+		switch {
+		case action == "closed" && pr.Merged:
+			action = "merged"
+		case action == "opened", action == "closed", action == "reopened":
+		default:
+			return ""
+		}
+		return fmt.Sprintf("<b>Pull request %s</b> in %s by %s\n%s",
+			action, repo, sender, link(pr.HTMLURL, fmt.Sprintf("#%d %s", pr.Number, pr.Title)))
+
+	case "release":
+		release := event.Release
+		if release == nil || event.Action != "published" {
+			return ""
+		}
+		name := release.Name
+		if name == "" {
+			name = release.TagName
+		}
+		kind := "Release"
+		if release.Prerelease {
+			kind = "Pre-release"
+		}
+		return fmt.Sprintf("<b>%s published</b> in %s\n%s", kind, repo, link(release.HTMLURL, name))
+	}
+	return ""
+}
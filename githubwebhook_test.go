@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func gitHubSignature(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidGitHubSignature(t *testing.T) {
+	body := `{"action":"created"}`
+	good := gitHubSignature("gh-secret", body)
+
+	tests := []struct {
+		name   string
+		body   string
+		header string
+		secret string
+		want   bool
+	}{
+		{"valid", body, good, "gh-secret", true},
+		{"wrong secret", body, gitHubSignature("other", body), "gh-secret", false},
+		{"tampered body", body + " ", good, "gh-secret", false},
+		{"sha1 header", body, "sha1=" + strings.TrimPrefix(good, "sha256="), "gh-secret", false},
+		{"no prefix", body, strings.TrimPrefix(good, "sha256="), "gh-secret", false},
+		{"not hex", body, "sha256=zz", "gh-secret", false},
+		{"truncated", body, good[:len(good)-2], "gh-secret", false},
+		{"empty", body, "", "gh-secret", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validGitHubSignature([]byte(tt.body), tt.header, tt.secret); got != tt.want {
+				t.Errorf("validGitHubSignature(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitHubWebhookChecksSignature(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+	config := configWith(t, "GITHUB_WEBHOOK_SECRET", "gh-secret")
+	body := `{"zen":"Keep it logically awesome."}`
+
+	post := func(signature string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/github", strings.NewReader(body))
+		req.Header.Set("X-GitHub-Event", "ping")
+		req.Header.Set("X-Hub-Signature-256", signature)
+		rec := httptest.NewRecorder()
+		handleGitHubWebhook(rec, req, config)
+		return rec
+	}
+
+	if rec := post(gitHubSignature("wrong", body)); rec.Code != http.StatusUnauthorized || decodeError(t, rec).Code != "invalid_signature" {
+		t.Errorf("bad signature: got %d %s, want 401 invalid_signature", rec.Code, rec.Body)
+	}
+	if rec := post(gitHubSignature("gh-secret", body)); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "pong") {
+		t.Errorf("valid ping: got %d %s, want pong", rec.Code, rec.Body)
+	}
+	if n := len(fake.Calls()); n != 0 {
+		t.Errorf("%d messages sent for a ping", n)
+	}
+}
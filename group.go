@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultAlertGroupWindow = 5 * time.Minute
+
+// alertGroup tracks the Telegram message that identical alerts sharing a
+// group_key are folded into.
+type alertGroup struct {
+	mu        sync.Mutex
+	message   string
+	messageID int64
+	count     int
+	expires   time.Time
+}
+
+type alertGrouper struct {
+	mu     sync.Mutex
+	groups map[string]*alertGroup
+}
+
+var alertGroups = &alertGrouper{groups: make(map[string]*alertGroup)}
+
+func (g *alertGrouper) get(key string) *alertGroup {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	for k, grp := range g.groups {
+		if k != key && now.After(grp.expires) {
+			delete(g.groups, k)
+		}
+	}
+
+	grp, ok := g.groups[key]
+	if !ok {
+		grp = &alertGroup{}
+		g.groups[key] = grp
+	}
+	return grp
+}
+
+// sendGrouped sends message once per window for key; identical messages
+// arriving within the window edit the original to show a repeat count
+// instead of sending again. It returns the message ID and the count so far.
+//
+// The repeat edit takes the same per-message lock as /edit. If an /edit to
+// the grouped message is in flight, the alert starts a new group rather
+// than racing it.
+func sendGrouped(ctx context.Context, config Config, key, message string, opts SendOptions, window time.Duration) (int64, int, error) {
+	grp := alertGroups.get(config.ChatID + ":" + key)
+	grp.mu.Lock()
+	defer grp.mu.Unlock()
+
+	now := time.Now()
+	if grp.count > 0 && grp.message == message && now.Before(grp.expires) {
+		edited, err := editGroup(ctx, config, grp, opts)
+		if err != nil {
+			return 0, 0, err
+		}
+		if edited {
+			return grp.messageID, grp.count, nil
+		}
+	}
+
+	sent, err := sendTelegramMessage(ctx, config, message, opts)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	grp.message = message
+	grp.messageID = sent.MessageID
+	grp.count = 1
+	grp.expires = now.Add(window)
+	return grp.messageID, grp.count, nil
+}
+
+// editGroup bumps the repeat count on grp's message. It reports false,
+// without editing, when an /edit to that message is in flight.
+func editGroup(ctx context.Context, config Config, grp *alertGroup, opts SendOptions) (bool, error) {
+	key := messageKey(config.ChatID, grp.messageID)
+	if !messageEdits.tryLock(key) {
+		return false, nil
+	}
+	defer messageEdits.unlock(key)
+
+	text := fmt.Sprintf("%s (x%d)", grp.message, grp.count+1)
+	if err := editTelegramMessage(ctx, config, grp.messageID, text, opts); err != nil {
+		return false, err
+	}
+	grp.count++
+	return true, nil
+}
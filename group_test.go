@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSendGroupedWithinWindow(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+	defer func(orig *alertGrouper) { alertGroups = orig }(alertGroups)
+	alertGroups = &alertGrouper{groups: make(map[string]*alertGroup)}
+	ctx := context.Background()
+	send := func(key, message string, window time.Duration) (int64, int) {
+		t.Helper()
+		id, count, err := sendGrouped(ctx, testConfig, key, message, SendOptions{}, window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id, count
+	}
+
+	first, _ := send("svc-x", "Service X down", time.Minute)
+	for want := 2; want <= 3; want++ {
+		id, count := send("svc-x", "Service X down", time.Minute)
+		if id != first || count != want {
+			t.Errorf("repeat: message %d x%d, want %d x%d", id, count, first, want)
+		}
+	}
+
+	var methods []string
+	for _, call := range fake.Calls() {
+		methods = append(methods, call.Method)
+	}
+	if len(methods) != 3 || methods[0] != "sendMessage" || methods[1] != "editMessageText" || methods[2] != "editMessageText" {
+		t.Fatalf("calls = %v, want one send and two edits", methods)
+	}
+	var edit TelegramEditMessage
+	json.Unmarshal(fake.Calls()[2].Body, &edit)
+	if edit.MessageID != first || edit.Text != "Service X down (x3)" {
+		t.Errorf("last edit = %+v", edit)
+	}
+
+	// A different message or another key starts a new group.
+	if id, count := send("svc-x", "Service X up", time.Minute); id == first || count != 1 {
+		t.Errorf("new message: message %d x%d, want a new message", id, count)
+	}
+	if _, count := send("svc-y", "Service X down", time.Minute); count != 1 {
+		t.Errorf("other key: count %d, want 1", count)
+	}
+
+	// Once the window has passed the alert is sent again.
+	expired, _ := send("svc-z", "Service Z down", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if id, count := send("svc-z", "Service Z down", time.Minute); id == expired || count != 1 {
+		t.Errorf("after the window: message %d x%d, want a new message", id, count)
+	}
+}
+
+func TestSendGroupedDuringEdit(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+	defer func(orig *alertGrouper) { alertGroups = orig }(alertGroups)
+	alertGroups = &alertGrouper{groups: make(map[string]*alertGroup)}
+	ctx := context.Background()
+
+	first, _, err := sendGrouped(ctx, testConfig, "svc-x", "Service X down", SendOptions{}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An /edit to the grouped message holds its lock, so the repeat is sent
+	// as a new message instead of editing underneath it.
+	key := messageKey(testConfig.ChatID, first)
+	if !messageEdits.tryLock(key) {
+		t.Fatal("message is already locked")
+	}
+	id, count, err := sendGrouped(ctx, testConfig, "svc-x", "Service X down", SendOptions{}, time.Minute)
+	messageEdits.unlock(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == first || count != 1 {
+		t.Errorf("during an edit: message %d x%d, want a new message", id, count)
+	}
+	for _, call := range fake.Calls() {
+		if call.Method == "editMessageText" {
+			t.Errorf("edited a message that /edit holds: %s", call.Body)
+		}
+	}
+
+	// The new message is the group now.
+	if again, count, err := sendGrouped(ctx, testConfig, "svc-x", "Service X down", SendOptions{}, time.Minute); err != nil || again != id || count != 2 {
+		t.Errorf("after the edit: message %d x%d (%v), want %d x2", again, count, err, id)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+const defaultHealthUpstreamTimeout = 2 * time.Second
+
+// warmedUp stays false while warmUp is running so /health keeps the
+// instance out of rotation.
+var warmedUp atomic.Bool
+
+var startedAt = time.Now()
+
+// warmUp retries Telegram's getMe until it succeeds or timeout elapses, then
+// marks the instance ready either way so a slow upstream can't keep it out
+// of rotation forever.
+func warmUp(config Config, timeout time.Duration) {
+	defer warmedUp.Store(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for {
+		if _, err := callTelegram(ctx, config, "getMe", struct{}{}); err == nil {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("Warning: startup checks did not pass within %s, accepting traffic anyway", timeout)
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+type HealthResponse struct {
+	Status   string          `json:"status"`
+	Env      map[string]bool `json:"env"`
+	Telegram string          `json:"telegram,omitempty"`
+	Store    string          `json:"store,omitempty"`
+
+	Upstreams map[string]UpstreamHealth `json:"upstreams,omitempty"`
+	Runtime   *RuntimeInfo              `json:"runtime,omitempty"`
+}
+
+type RuntimeInfo struct {
+	Version       string  `json:"version"`
+	GoVersion     string  `json:"go_version"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	Goroutines    int     `json:"goroutines"`
+	Memory        struct {
+		AllocBytes      uint64 `json:"alloc_bytes"`
+		TotalAllocBytes uint64 `json:"total_alloc_bytes"`
+		SysBytes        uint64 `json:"sys_bytes"`
+		HeapObjects     uint64 `json:"heap_objects"`
+		NumGC           uint32 `json:"num_gc"`
+	} `json:"memory"`
+}
+
+// buildVersion reports the VCS revision stamped by go build, falling back to
+// the module version.
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return info.Main.Version
+}
+
+func runtimeInfo() *RuntimeInfo {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	info := &RuntimeInfo{
+		Version:       buildVersion(),
+		GoVersion:     runtime.Version(),
+		UptimeSeconds: time.Since(startedAt).Round(time.Second).Seconds(),
+		Goroutines:    runtime.NumGoroutine(),
+	}
+	info.Memory.AllocBytes = mem.Alloc
+	info.Memory.TotalAllocBytes = mem.TotalAlloc
+	info.Memory.SysBytes = mem.Sys
+	info.Memory.HeapObjects = mem.HeapObjects
+	info.Memory.NumGC = mem.NumGC
+	return info
+}
+
+// handleHealth answers 503 "starting" until WARMUP_TIMEOUT's startup checks
+// finish. It is cheap by default so probes can hit it every few seconds.
+// With ?upstream=true it also calls Telegram's getMe, bounded by
+// HEALTH_UPSTREAM_TIMEOUT, and answers 503 if that fails. ?verbose=true adds
+// build and runtime details and requires the admin token.
+//
+// Upstreams lists the circuit breaker of every upstream called so far. An
+// open breaker marks the status "degraded" but keeps the 200, since taking
+// every instance out of rotation wouldn't bring the upstream back.
+// Likewise a JOBS_DIR that can't be written and read back reports the
+// store "unavailable" and the status "degraded".
+func handleHealth(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	verbose := r.URL.Query().Get("verbose") == "true"
+	if verbose && !isAdmin(r, config) {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+
+	resp := HealthResponse{
+		Status: "ok",
+		Env: map[string]bool{
+			"TELEGRAM_BOT_TOKEN": config.BotToken != "" || config.Bots != nil,
+			"TELEGRAM_CHAT_ID":   config.ChatID != "",
+		},
+	}
+	status := http.StatusOK
+	if !warmedUp.Load() {
+		resp.Status = "starting"
+		status = http.StatusServiceUnavailable
+	}
+	for _, present := range resp.Env {
+		if !present {
+			resp.Status = "misconfigured"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	resp.Upstreams = upstreamHealth()
+	for _, h := range resp.Upstreams {
+		if h.State != circuitClosed && resp.Status == "ok" {
+			resp.Status = "degraded"
+		}
+	}
+
+	if deliveryQueue != nil && deliveryQueue.dir != "" {
+		resp.Store = "ok"
+		if err := deliveryQueue.checkStore(); err != nil {
+			log.Printf("Warning: jobs store health check failed: %v", err)
+			resp.Store = "unavailable"
+			if resp.Status == "ok" {
+				resp.Status = "degraded"
+			}
+		}
+	}
+
+	if r.URL.Query().Get("upstream") == "true" {
+		ctx, cancel := context.WithTimeout(r.Context(), config.HealthUpstreamTimeout)
+		defer cancel()
+
+		if _, err := callTelegram(ctx, config, "getMe", struct{}{}); err != nil {
+			resp.Status = "degraded"
+			resp.Telegram = "unreachable"
+			status = http.StatusServiceUnavailable
+		} else {
+			resp.Telegram = "ok"
+		}
+	}
+
+	if verbose {
+		resp.Runtime = runtimeInfo()
+	}
+
+	writeJSON(w, status, resp)
+}
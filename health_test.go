@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHealthChecksStore(t *testing.T) {
+	origQueue, origWarmedUp := deliveryQueue, warmedUp.Load()
+	t.Cleanup(func() {
+		deliveryQueue = origQueue
+		warmedUp.Store(origWarmedUp)
+	})
+	warmedUp.Store(true)
+
+	dir := t.TempDir()
+	notADir := filepath.Join(dir, "file")
+	if err := os.WriteFile(notADir, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		dir    string
+		status string
+		store  string
+	}{
+		{"in memory", "", "ok", ""},
+		{"healthy", dir, "ok", "ok"},
+		{"failing", notADir, "degraded", "unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deliveryQueue = &jobQueue{jobs: make(map[string]*Job), dir: tt.dir}
+			rec := serve(func(w http.ResponseWriter, r *http.Request) {
+				handleHealth(w, r, testConfig)
+			}, http.MethodGet, "/health", "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+			}
+
+			var resp HealthResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Status != tt.status || resp.Store != tt.store {
+				t.Errorf("status = %q, store = %q, want %q and %q", resp.Status, resp.Store, tt.status, tt.store)
+			}
+		})
+	}
+
+	// The sentinel doesn't outlive the check.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("jobs directory holds %v after the check, want only the test file", entries)
+	}
+}
+
+func TestHealthStartingThenReady(t *testing.T) {
+	origQueue, origWarmedUp := deliveryQueue, warmedUp.Load()
+	t.Cleanup(func() {
+		deliveryQueue = origQueue
+		warmedUp.Store(origWarmedUp)
+	})
+	deliveryQueue = nil
+	defer func(orig TelegramAPI) { telegram = orig }(telegram)
+
+	health := func() (int, string) {
+		t.Helper()
+		rec := serve(func(w http.ResponseWriter, r *http.Request) {
+			handleHealth(w, r, testConfig)
+		}, http.MethodGet, "/health", "")
+		var resp HealthResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, resp.Status
+	}
+
+	tests := []struct {
+		name    string
+		checks  error
+		timeout time.Duration
+	}{
+		{"checks pass", nil, time.Minute},
+		{"checks time out", &UpstreamError{StatusCode: http.StatusUnauthorized}, 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			telegram = telegramFunc(func(method string, body []byte) (json.RawMessage, error) {
+				<-release
+				if tt.checks != nil {
+					return nil, tt.checks
+				}
+				return json.RawMessage(`{"id":1,"is_bot":true}`), nil
+			})
+
+			warmedUp.Store(false)
+			done := make(chan struct{})
+			go func() {
+				warmUp(testConfig, tt.timeout)
+				close(done)
+			}()
+
+			if code, status := health(); code != http.StatusServiceUnavailable || status != "starting" {
+				t.Errorf("during warm-up: %d %q, want 503 starting", code, status)
+			}
+
+			close(release)
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("warm-up never finished")
+			}
+			if code, status := health(); code != http.StatusOK || status != "ok" {
+				t.Errorf("after warm-up: %d %q, want 200 ok", code, status)
+			}
+		})
+	}
+}
+
+func TestHealthVerboseRequiresAuth(t *testing.T) {
+	origQueue, origWarmedUp := deliveryQueue, warmedUp.Load()
+	t.Cleanup(func() {
+		deliveryQueue = origQueue
+		warmedUp.Store(origWarmedUp)
+	})
+	deliveryQueue = nil
+	warmedUp.Store(true)
+	config := configWith(t, "ADMIN_TOKEN", "admin-secret")
+
+	tests := []struct {
+		name    string
+		query   string
+		token   string
+		status  int
+		runtime bool
+	}{
+		{"default", "", "", http.StatusOK, false},
+		{"default with auth", "", "admin-secret", http.StatusOK, false},
+		{"verbose without auth", "?verbose=true", "", http.StatusUnauthorized, false},
+		{"verbose with a wrong token", "?verbose=true", "guess", http.StatusUnauthorized, false},
+		{"verbose with auth", "?verbose=true", "admin-secret", http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/health"+tt.query, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			handleHealth(rec, req, config)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+
+			var resp map[string]json.RawMessage
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			raw, ok := resp["runtime"]
+			if ok != tt.runtime {
+				t.Fatalf("runtime present = %v, want %v: %s", ok, tt.runtime, rec.Body)
+			}
+			if !ok {
+				return
+			}
+			var info RuntimeInfo
+			if err := json.Unmarshal(raw, &info); err != nil {
+				t.Fatal(err)
+			}
+			if info.Version == "" || info.GoVersion == "" || info.UptimeSeconds <= 0 || info.Goroutines <= 0 || info.Memory.AllocBytes == 0 || info.Memory.SysBytes == 0 {
+				t.Errorf("runtime = %+v, want every field filled in", info)
+			}
+		})
+	}
+}
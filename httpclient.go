@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+const defaultHTTPClientTimeout = 10 * time.Second
+
+// httpClient is shared by every outbound Telegram and Beehiiv call so idle
+// connections are pooled and a hung upstream can't pin a goroutine forever.
+// main replaces it once HTTP_CLIENT_TIMEOUT has been read.
+var httpClient = newHTTPClient(defaultHTTPClientTimeout)
+
+func newHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: instrumentedTransport{next: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   5 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   10,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		}},
+	}
+}
+
+// beehiivClient shares httpClient's transport but only follows redirects as
+// far as beehiivCheckRedirect allows.
+var beehiivClient = newBeehiivClient(httpClient, 0)
+
+func newBeehiivClient(base *http.Client, maxRedirects int) *http.Client {
+	client := *base
+	client.CheckRedirect = beehiivCheckRedirect(maxRedirects)
+	return &client
+}
+
+// beehiivCheckRedirect follows at most maxRedirects redirects, from
+// BEEHIIV_MAX_REDIRECTS (none by default). Redirects that would turn a
+// POST or PATCH into a GET are never followed since the write would be
+// silently dropped; the 3xx is surfaced as an UpstreamError naming the new
+// location instead.
+func beehiivCheckRedirect(maxRedirects int) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > maxRedirects {
+			return http.ErrUseLastResponse
+		}
+		if via[0].Method != http.MethodGet && req.Method != via[0].Method {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+}
+
+// telegramClient is used for Telegram calls only, so TELEGRAM_SOCKS5_PROXY
+// doesn't also route Beehiiv traffic through the proxy.
+var telegramClient = httpClient
+
+// newTelegramClient returns base, or when socksProxy
+// (TELEGRAM_SOCKS5_PROXY) is set a client with base's timeout that dials
+// through that proxy.
+func newTelegramClient(base *http.Client, socksProxy string) (*http.Client, error) {
+	if socksProxy == "" {
+		return base, nil
+	}
+	return newSOCKS5Client(socksProxy, base.Timeout)
+}
+
+// newSOCKS5Client returns a client that dials through the SOCKS5 proxy in
+// value, given as host:port with optional user:password@ and socks5://.
+func newSOCKS5Client(value string, timeout time.Duration) (*http.Client, error) {
+	if !strings.Contains(value, "://") {
+		value = "socks5://" + value
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing proxy URL: %v", err)
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+
+	var auth *proxy.Auth
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth = &proxy.Auth{User: u.User.Username(), Password: password}
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", u.Host, auth, proxy.Direct)
+	if err != nil {
+		return nil, fmt.Errorf("error creating SOCKS5 dialer: %v", err)
+	}
+	contextDialer, ok := dialer.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("SOCKS5 dialer does not support contexts")
+	}
+
+	client := newHTTPClient(timeout)
+	transport := client.Transport.(instrumentedTransport).next.(*http.Transport)
+	transport.Proxy = nil
+	transport.DialContext = contextDialer.DialContext
+	return client, nil
+}
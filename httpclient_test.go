@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// socks5Server is a minimal SOCKS5 proxy accepting username/password auth
+// and CONNECT, recording what each client sent.
+type socks5Server struct {
+	ln       net.Listener
+	user     string
+	password string
+	targets  chan string
+}
+
+func startSOCKS5Server(t *testing.T) *socks5Server {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &socks5Server{ln: ln, targets: make(chan string, 10)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *socks5Server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	// Greeting: pick username/password auth.
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, head[1])); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 2})
+
+	// RFC 1929 username/password subnegotiation.
+	readString := func() string {
+		n := make([]byte, 1)
+		io.ReadFull(conn, n)
+		b := make([]byte, n[0])
+		io.ReadFull(conn, b)
+		return string(b)
+	}
+	io.ReadFull(conn, make([]byte, 1))
+	s.user, s.password = readString(), readString()
+	conn.Write([]byte{1, 0})
+
+	// CONNECT request to an IPv4 address.
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil || req[3] != 1 {
+		return
+	}
+	addr := make([]byte, 6)
+	io.ReadFull(conn, addr)
+	target := net.JoinHostPort(net.IP(addr[:4]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(addr[4:]))))
+	s.targets <- target
+
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+func TestTelegramClientDialsThroughSOCKS5(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer target.Close()
+	proxy := startSOCKS5Server(t)
+
+	client, err := newTelegramClient(httpClient, "socks5://bot:s3cret@"+proxy.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client == httpClient {
+		t.Fatal("TELEGRAM_SOCKS5_PROXY did not configure a proxied client")
+	}
+
+	resp, err := client.Get(target.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+	select {
+	case got := <-proxy.targets:
+		if got != target.Listener.Addr().String() {
+			t.Errorf("proxy connected to %s, want %s", got, target.Listener.Addr())
+		}
+	default:
+		t.Fatal("the request didn't go through the proxy")
+	}
+	if proxy.user != "bot" || proxy.password != "s3cret" {
+		t.Errorf("proxy auth = %q:%q, want bot:s3cret", proxy.user, proxy.password)
+	}
+}
+
+func TestTelegramClientDirectWithoutProxy(t *testing.T) {
+	client, err := newTelegramClient(httpClient, "")
+	if err != nil || client != httpClient {
+		t.Errorf("newTelegramClient = %p, %v, want the shared client", client, err)
+	}
+
+	if _, err := newTelegramClient(httpClient, "http://proxy.example.com:8080"); err == nil {
+		t.Error("an HTTP proxy was accepted as SOCKS5")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultIdempotencyTTL      = 24 * time.Hour
+	defaultIdempotencyMaxBytes = 16 << 20
+)
+
+// idempotencyEntry holds the response to the first request seen with a key.
+// done is closed once the entry is final; a nil header means the attempt
+// failed and the key was released, so a waiter has to try again. bodyHash
+// fingerprints the request the key was first used with.
+type idempotencyEntry struct {
+	done     chan struct{}
+	bodyHash [sha256.Size]byte
+	status   int
+	header   http.Header
+	body     []byte
+}
+
+var idempotentResponses = newTTLCache[*idempotencyEntry]("idempotency", 0)
+
+// withIdempotency replays the stored response for a repeated
+// Idempotency-Key within IDEMPOTENCY_TTL instead of running next again.
+// Keys are scoped to the client, its API key or else its IP, and to the
+// route, so two clients that pick the same key never see each other's
+// responses. A repeat that arrives while the first request is still
+// running waits for it.
+//
+// 5xx responses and panics are not stored so the client can retry them;
+// of the requests waiting on a failed attempt, one runs next again and
+// the rest wait for it in turn. Reusing a key with a different body is
+// rejected with 422, since replaying the first response would silently
+// drop the second request.
+func withIdempotency(next http.HandlerFunc, config Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > 255 {
+			writeError(w, http.StatusBadRequest, "idempotency_key_too_long", "Idempotency-Key is too long")
+			return
+		}
+		client := "ip:" + clientIP(r)
+		if id := apiKeyID(r.Context()); id != "" {
+			client = "key:" + id
+		}
+		key = client + " " + r.URL.Path + " " + key
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(config.IdempotencyMaxBytes)))
+		if err != nil {
+			writeJSON(w, http.StatusRequestEntityTooLarge, ErrorResponse{Message: "Request body is too large", Code: "body_too_large"})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		entry := &idempotencyEntry{done: make(chan struct{}), bodyHash: sha256.Sum256(body)}
+		for !idempotentResponses.add(key, entry, config.IdempotencyTTL) {
+			existing, ok := idempotentResponses.get(key)
+			if !ok {
+				continue
+			}
+			if existing.bodyHash != entry.bodyHash {
+				writeJSON(w, http.StatusUnprocessableEntity, ErrorResponse{Message: "Idempotency-Key was already used with a different request body", Code: "idempotency_key_reused"})
+				return
+			}
+
+			select {
+			case <-existing.done:
+			case <-r.Context().Done():
+				writeUpstreamError(w, r.Context().Err())
+				return
+			}
+			if existing.header == nil {
+				// The attempt failed and released the key. Race the other
+				// waiters to add it again.
+				continue
+			}
+
+			for name, values := range existing.header {
+				if name != "X-Request-Id" {
+					w.Header()[name] = values
+				}
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(existing.status)
+			w.Write(existing.body)
+			return
+		}
+
+		// The key is released before done is closed, on failure and on
+		// panic alike, so woken waiters find it free.
+		defer func() {
+			if entry.header == nil {
+				idempotentResponses.delete(key)
+			}
+			close(entry.done)
+		}()
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if rec.status >= 500 {
+			return
+		}
+		entry.status = rec.status
+		entry.body = rec.body.Bytes()
+		entry.header = w.Header().Clone()
+	}
+}
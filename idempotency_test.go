@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// idempotentRequest calls handler with body under an Idempotency-Key unique
+// to the test run, so repeated runs don't replay each other.
+func idempotentRequest(handler http.HandlerFunc, key, remoteAddr, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", key)
+	req.RemoteAddr = remoteAddr
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func uniqueKey(t *testing.T) string {
+	return t.Name() + "-" + time.Now().Format(time.RFC3339Nano)
+}
+
+func TestIdempotencyReplaysResponse(t *testing.T) {
+	var calls atomic.Int32
+	handler := withIdempotency(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("X-Call", strconv.Itoa(int(n)))
+		writeJSON(w, http.StatusCreated, map[string]int32{"call": n})
+	}, testConfig)
+	key := uniqueKey(t)
+
+	first := idempotentRequest(handler, key, "192.0.2.1:1000", `{"message":"once"}`)
+	second := idempotentRequest(handler, key, "192.0.2.1:2000", `{"message":"once"}`)
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("handler ran %d times, want 1", n)
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() {
+		t.Errorf("replay = %d %s, want %d %s", second.Code, second.Body, first.Code, first.Body)
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" || second.Header().Get("X-Call") != "1" {
+		t.Errorf("replay headers = %v", second.Header())
+	}
+	if first.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("the first response is marked as replayed")
+	}
+}
+
+func TestIdempotencyKeyReusedWithDifferentBody(t *testing.T) {
+	var calls atomic.Int32
+	handler := withIdempotency(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}, testConfig)
+	key := uniqueKey(t)
+
+	idempotentRequest(handler, key, "192.0.2.1:1000", `{"message":"one"}`)
+	rec := idempotentRequest(handler, key, "192.0.2.1:1000", `{"message":"two"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want 422: %s", rec.Code, rec.Body)
+	}
+	if resp := decodeError(t, rec); resp.Code != "idempotency_key_reused" {
+		t.Errorf("code = %q, want idempotency_key_reused", resp.Code)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("handler ran %d times, want 1", n)
+	}
+}
+
+func TestIdempotencyKeysAreScopedToTheClient(t *testing.T) {
+	var calls atomic.Int32
+	handler := withIdempotency(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		writeJSON(w, http.StatusOK, map[string]string{"client": r.RemoteAddr})
+	}, testConfig)
+	key := uniqueKey(t)
+
+	idempotentRequest(handler, key, "192.0.2.1:1000", `{}`)
+	rec := idempotentRequest(handler, key, "198.51.100.7:1000", `{}`)
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("handler ran %d times, want once per client", n)
+	}
+	if rec.Header().Get("Idempotent-Replayed") != "" || !strings.Contains(rec.Body.String(), "198.51.100.7") {
+		t.Errorf("the second client got the first one's response: %s", rec.Body)
+	}
+
+	// A client with an API key keeps its keys when its IP changes.
+	keyed := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(`{}`))
+		req.Header.Set("Idempotency-Key", key)
+		req.RemoteAddr = remoteAddr
+		req = req.WithContext(context.WithValue(req.Context(), apiKeyIDKey{}, "ci"))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+	keyed("192.0.2.1:1000")
+	if rec := keyed("203.0.113.9:1000"); rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("the same API key from another IP was not replayed")
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("handler ran %d times, want 3", n)
+	}
+}
+
+// blockingHandler blocks every call until release is closed and answers
+// the first failures calls with 503.
+type blockingHandler struct {
+	calls    atomic.Int32
+	failures int32
+	started  chan struct{}
+	release  chan struct{}
+}
+
+func newBlockingHandler(failures int32) *blockingHandler {
+	return &blockingHandler{failures: failures, started: make(chan struct{}, 10), release: make(chan struct{})}
+}
+
+func (h *blockingHandler) serve(w http.ResponseWriter, r *http.Request) {
+	n := h.calls.Add(1)
+	h.started <- struct{}{}
+	<-h.release
+	if n <= h.failures {
+		writeError(w, http.StatusServiceUnavailable, "unavailable", "Try again")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int32{"call": n})
+}
+
+// concurrently sends n identical requests through handler, starting the
+// rest once the first is running, and returns their responses.
+func concurrently(t *testing.T, h *blockingHandler, handler http.HandlerFunc, n int) []*httptest.ResponseRecorder {
+	t.Helper()
+	key := uniqueKey(t)
+	recs := make([]*httptest.ResponseRecorder, n)
+	var wg sync.WaitGroup
+	for i := range recs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recs[i] = idempotentRequest(handler, key, "192.0.2.1:1000", `{"message":"concurrent"}`)
+		}()
+		if i == 0 {
+			<-h.started
+		}
+	}
+	// Let the others reach the wait before the first one finishes.
+	time.Sleep(50 * time.Millisecond)
+	close(h.release)
+	wg.Wait()
+	return recs
+}
+
+func TestIdempotencyConcurrentRequestsCallOnce(t *testing.T) {
+	h := newBlockingHandler(0)
+	recs := concurrently(t, h, withIdempotency(h.serve, testConfig), 3)
+
+	if n := h.calls.Load(); n != 1 {
+		t.Fatalf("handler ran %d times, want 1", n)
+	}
+	for i, rec := range recs {
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"call":1`) {
+			t.Errorf("response %d = %d %s, want the first call's", i, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestIdempotencyRetriesOnceAfterFailure(t *testing.T) {
+	h := newBlockingHandler(1)
+	recs := concurrently(t, h, withIdempotency(h.serve, testConfig), 3)
+
+	// The failed first attempt is followed by exactly one more, whose
+	// response the remaining waiter replays.
+	if n := h.calls.Load(); n != 2 {
+		t.Fatalf("handler ran %d times, want 2", n)
+	}
+	statuses := map[int]int{}
+	for _, rec := range recs {
+		statuses[rec.Code]++
+		if rec.Code == http.StatusOK && !strings.Contains(rec.Body.String(), `"call":2`) {
+			t.Errorf("success = %s, want the second call's", rec.Body)
+		}
+	}
+	if statuses[http.StatusServiceUnavailable] != 1 || statuses[http.StatusOK] != 2 {
+		t.Errorf("statuses = %v, want one 503 and two 200s", statuses)
+	}
+}
+
+func TestIdempotencyReleasesKeyOnPanic(t *testing.T) {
+	var calls atomic.Int32
+	handler := withIdempotency(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			panic("boom")
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}, testConfig)
+	key := uniqueKey(t)
+
+	func() {
+		defer func() { recover() }()
+		idempotentRequest(handler, key, "192.0.2.1:1000", `{}`)
+	}()
+	if rec := idempotentRequest(handler, key, "192.0.2.1:1000", `{}`); rec.Code != http.StatusOK || rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("retry after a panic = %d %v, want it run", rec.Code, rec.Header())
+	}
+	if rec := idempotentRequest(handler, key, "192.0.2.1:1000", `{}`); rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("the successful retry was not stored")
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("handler ran %d times, want 2", n)
+	}
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultJobWorkers     = 2
+	defaultJobMaxAttempts = 5
+	defaultJobRetryBase   = 5 * time.Second
+	defaultJobRetention   = 24 * time.Hour
+	maxJobRetryDelay      = 10 * time.Minute
+
+	// jobCancelGrace is how long wait gives cancelled deliveries to record
+	// their outcome once it stops waiting for them to finish.
+	jobCancelGrace = 5 * time.Second
+)
+
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobRetrying  = "retrying"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+// asyncHeaders are the request headers kept with a job, since the handlers
+// read them for the client IP, UTM attribution and the response's request
+// ID.
+var asyncHeaders = []string{"Content-Type", "User-Agent", "Referer", "X-Forwarded-For", "X-Real-IP", "X-Request-ID"}
+
+// deliveryQueue runs requests accepted with "Prefer: respond-async". It is
+// set up in main once the handlers it runs are available.
+var deliveryQueue *jobQueue
+
+// Job is a request accepted for background delivery, as reported by
+// /jobs/{id}. Result holds the handler's response body once it succeeded.
+type Job struct {
+	ID          string          `json:"id"`
+	Kind        string          `json:"kind"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	LastStatus  int             `json:"last_status,omitempty"`
+	LastError   string          `json:"last_error,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	NextAttempt *time.Time      `json:"next_attempt_at,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+
+	Request *jobRequest `json:"request,omitempty"`
+}
+
+type jobRequest struct {
+	URL        string      `json:"url"`
+	RemoteAddr string      `json:"remote_addr"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+}
+
+// jobQueue holds jobs in memory and, when dir is set, as one JSON file per
+// job so queued jobs survive a restart. Workers scan for the oldest job
+// that is due, which keeps retry scheduling simple at the queue sizes this
+// service sees. Jobs are retried by policy and finished ones are kept for
+// retention.
+type jobQueue struct {
+	mu           sync.Mutex
+	jobs         map[string]*Job
+	dir          string
+	policy       retryPolicy
+	retention    time.Duration
+	maxBodyBytes int
+	wake         chan struct{}
+	handlers     map[string]http.HandlerFunc
+	workers      sync.WaitGroup
+
+	// deliveries is the context of every attempt, cancelled by wait when
+	// it gives up on them.
+	deliveries       context.Context
+	cancelDeliveries context.CancelFunc
+}
+
+// newJobQueue returns the queue for JOBS_DIR, retrying by the JOBS_ policy
+// and keeping finished jobs for JOBS_RETENTION.
+func newJobQueue(settings *Settings, handlers map[string]http.HandlerFunc) *jobQueue {
+	q := &jobQueue{
+		jobs:         make(map[string]*Job),
+		dir:          settings.JobsDir,
+		policy:       settings.retryPolicyFor("JOBS"),
+		retention:    settings.JobsRetention,
+		maxBodyBytes: settings.MaxBodyBytes,
+		wake:         make(chan struct{}, 1),
+		handlers:     handlers,
+	}
+	q.deliveries, q.cancelDeliveries = context.WithCancel(context.Background())
+	if q.dir != "" {
+		q.load()
+	}
+	return q
+}
+
+// load restores persisted jobs. A job that was running when the process
+// stopped is queued again, so delivery is at least once.
+func (q *jobQueue) load() {
+	if err := os.MkdirAll(q.dir, 0o755); err != nil {
+		log.Printf("Warning: cannot create jobs directory: %v", err)
+		return
+	}
+
+	paths, err := filepath.Glob(filepath.Join(q.dir, "*.json"))
+	if err != nil {
+		log.Printf("Warning: cannot read jobs directory: %v", err)
+		return
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Warning: cannot read job %s: %v", filepath.Base(path), err)
+			continue
+		}
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil || job.Request == nil {
+			log.Printf("Warning: skipping unreadable job %s", filepath.Base(path))
+			continue
+		}
+		if job.Status == jobRunning {
+			job.Status = jobQueued
+		}
+		q.jobs[job.ID] = &job
+	}
+}
+
+// persist writes job to dir. The caller holds q.mu.
+func (q *jobQueue) persist(job *Job) {
+	if q.dir == "" {
+		return
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		log.Printf("Warning: cannot encode job %s: %v", job.ID, err)
+		return
+	}
+	path := filepath.Join(q.dir, job.ID+".json")
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		log.Printf("Warning: cannot save job %s: %v", job.ID, err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Printf("Warning: cannot save job %s: %v", job.ID, err)
+	}
+}
+
+// checkStore confirms the jobs directory is writable by writing, reading
+// back and removing a sentinel file. A queue without a directory has
+// nothing to check.
+func (q *jobQueue) checkStore() error {
+	if q.dir == "" {
+		return nil
+	}
+
+	path := filepath.Join(q.dir, ".health-"+newRequestID())
+	want := []byte(time.Now().UTC().Format(time.RFC3339Nano))
+	if err := os.WriteFile(path, want, 0o600); err != nil {
+		return err
+	}
+	got, readErr := os.ReadFile(path)
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	if readErr != nil {
+		return readErr
+	}
+	if !bytes.Equal(got, want) {
+		return errors.New("sentinel file read back differently")
+	}
+	return nil
+}
+
+func (q *jobQueue) enqueue(kind string, req *jobRequest) *Job {
+	now := time.Now()
+	job := &Job{
+		ID:          newRequestID(),
+		Kind:        kind,
+		Status:      jobQueued,
+		MaxAttempts: q.policy.attempts,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Request:     req,
+	}
+
+	q.mu.Lock()
+	q.jobs[job.ID] = job
+	q.persist(job)
+	status := *job
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return &status
+}
+
+// get returns a copy of the job without its request, which holds the
+// submitted body.
+func (q *jobQueue) get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	status := *job
+	status.Request = nil
+	return status, true
+}
+
+// run starts workers delivering jobs until ctx is done. A job that is
+// running at that point carries on until wait gives up on it.
+func (q *jobQueue) run(ctx context.Context, workers int) {
+	if workers <= 0 {
+		workers = defaultJobWorkers
+	}
+	for i := 0; i < workers; i++ {
+		q.workers.Add(1)
+		go func() {
+			defer q.workers.Done()
+			q.work(ctx)
+		}()
+	}
+}
+
+// wait blocks until every worker has stopped after run's ctx is done, and
+// reports whether they did before ctx expired. When ctx expires first the
+// attempts still running are cancelled, and given jobCancelGrace to requeue
+// or fail their jobs.
+func (q *jobQueue) wait(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+	}
+
+	q.cancelDeliveries()
+	select {
+	case <-done:
+	case <-time.After(jobCancelGrace):
+	}
+	return false
+}
+
+func (q *jobQueue) work(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		for ctx.Err() == nil {
+			job := q.claim()
+			if job == nil {
+				break
+			}
+			q.execute(job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-ticker.C:
+			q.prune()
+		}
+	}
+}
+
+// claim marks the oldest due job as running and returns it, or nil when
+// nothing is due.
+func (q *jobQueue) claim() *Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	var next *Job
+	for _, job := range q.jobs {
+		if job.Status != jobQueued && job.Status != jobRetrying {
+			continue
+		}
+		if job.NextAttempt != nil && job.NextAttempt.After(now) {
+			continue
+		}
+		if next == nil || job.CreatedAt.Before(next.CreatedAt) {
+			next = job
+		}
+	}
+	if next != nil {
+		next.Status = jobRunning
+		next.NextAttempt = nil
+		next.UpdatedAt = now
+		q.persist(next)
+	}
+	return next
+}
+
+// execute runs one attempt of job through the handler that serves its
+// endpoint, and retries it with exponential backoff while it fails with a
+// status in the JOBS_ retry policy.
+//
+// An attempt cancelled by wait is requeued as it was when it never reached
+// an upstream call. Once it has, the upstream may have acted on it before
+// the cancellation, so the job fails rather than risk a duplicate.
+func (q *jobQueue) execute(job *Job) {
+	attempt := &jobAttempt{}
+	ctx := context.WithValue(q.deliveries, jobAttemptKey{}, attempt)
+	status, header, body := q.deliver(ctx, job)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	job.UpdatedAt = now
+	if status >= 300 && q.deliveries.Err() != nil {
+		if !attempt.calledUpstream.Load() {
+			job.Status = jobQueued
+			log.Printf("Job %s (%s) was cancelled before reaching the upstream, requeued", job.ID, job.Kind)
+		} else {
+			job.Attempts++
+			job.LastStatus = status
+			job.LastError = "Cancelled after the upstream call started, not retried in case it was delivered"
+			job.Status = jobFailed
+			jobsFinished.WithLabelValues(job.Kind, job.Status).Inc()
+			log.Printf("Warning: job %s (%s) was cancelled mid-send and may or may not have been delivered", job.ID, job.Kind)
+		}
+		q.persist(job)
+		return
+	}
+
+	job.Attempts++
+	job.LastStatus = status
+
+	if status < 300 {
+		job.Status = jobSucceeded
+		job.LastError = ""
+		if json.Valid(body) {
+			job.Result = json.RawMessage(body)
+		}
+		jobsFinished.WithLabelValues(job.Kind, job.Status).Inc()
+		q.persist(job)
+		return
+	}
+
+	var errResp ErrorResponse
+	if json.Unmarshal(body, &errResp) == nil && errResp.Message != "" {
+		job.LastError = errResp.Message
+	} else {
+		job.LastError = http.StatusText(status)
+	}
+
+	policy := q.policy
+	if job.Attempts >= job.MaxAttempts || !slices.Contains(policy.statuses, status) {
+		job.Status = jobFailed
+		jobsFinished.WithLabelValues(job.Kind, job.Status).Inc()
+		log.Printf("Warning: job %s (%s) failed after %d attempts: %s", job.ID, job.Kind, job.Attempts, job.LastError)
+		q.persist(job)
+		return
+	}
+
+	backoff := min(policy.base<<(job.Attempts-1), maxJobRetryDelay)
+	delay := policy.delay(backoff)
+	if seconds, err := strconv.Atoi(header.Get("Retry-After")); err == nil && seconds > 0 {
+		delay = min(time.Duration(seconds)*time.Second, maxJobRetryDelay)
+	}
+	next := now.Add(delay)
+	job.Status = jobRetrying
+	job.NextAttempt = &next
+	q.persist(job)
+}
+
+func (q *jobQueue) deliver(ctx context.Context, job *Job) (int, http.Header, []byte) {
+	handler, ok := q.handlers[job.Kind]
+	if !ok {
+		return http.StatusInternalServerError, nil, nil
+	}
+
+	jr := job.Request
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, jr.URL, bytes.NewReader(jr.Body))
+	if err != nil {
+		return http.StatusInternalServerError, nil, nil
+	}
+	req.RemoteAddr = jr.RemoteAddr
+	req.Header = jr.Header.Clone()
+
+	rec := &batchRecorder{header: make(http.Header)}
+	rec.header.Set("X-Request-ID", jr.Header.Get("X-Request-ID"))
+	handler(rec, req)
+	return rec.status, rec.header, rec.body.Bytes()
+}
+
+// jobAttempt records whether one attempt at a job got as far as calling an
+// upstream, which retryUpstream notes through the attempt's context.
+type jobAttempt struct {
+	calledUpstream atomic.Bool
+}
+
+type jobAttemptKey struct{}
+
+// noteUpstreamCall marks the job attempt running under ctx, if any, as
+// having called an upstream.
+func noteUpstreamCall(ctx context.Context) {
+	if attempt, ok := ctx.Value(jobAttemptKey{}).(*jobAttempt); ok {
+		attempt.calledUpstream.Store(true)
+	}
+}
+
+// prune forgets finished jobs older than the retention.
+func (q *jobQueue) prune() {
+	cutoff := time.Now().Add(-q.retention)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for id, job := range q.jobs {
+		if (job.Status == jobSucceeded || job.Status == jobFailed) && job.UpdatedAt.Before(cutoff) {
+			delete(q.jobs, id)
+			if q.dir != "" {
+				os.Remove(filepath.Join(q.dir, id+".json"))
+			}
+		}
+	}
+}
+
+// withAsync accepts a POST carrying "Prefer: respond-async" as a job for
+// deliveryQueue and answers 202 with its ID instead of waiting for the
+// upstream call. Other requests go straight to next.
+func withAsync(kind string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if deliveryQueue == nil || r.Method != http.MethodPost || !prefersAsync(r) {
+			next(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(deliveryQueue.maxBodyBytes)))
+		if err != nil {
+			writeError(w, http.StatusRequestEntityTooLarge, "body_too_large", "Request body too large")
+			return
+		}
+
+		jr := &jobRequest{
+			URL:        r.URL.RequestURI(),
+			RemoteAddr: r.RemoteAddr,
+			Header:     make(http.Header),
+			Body:       body,
+		}
+		for _, name := range asyncHeaders {
+			if value := r.Header.Get(name); value != "" {
+				jr.Header.Set(name, value)
+			}
+		}
+		jr.Header.Set("X-Request-ID", w.Header().Get("X-Request-ID"))
+
+		job := deliveryQueue.enqueue(kind, jr)
+		w.Header().Set("Preference-Applied", "respond-async")
+		w.Header().Set("Location", "/jobs/"+job.ID)
+		writeJSON(w, http.StatusAccepted, map[string]string{
+			"status": job.Status,
+			"job_id": job.ID,
+		})
+	}
+}
+
+func prefersAsync(r *http.Request) bool {
+	for _, value := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(pref), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleJob reports the status of a job by the ID returned when it was
+// accepted. IDs are random, so knowing one is what grants access.
+func handleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	job, ok := Job{}, false
+	if deliveryQueue != nil {
+		job, ok = deliveryQueue.get(id)
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "job_not_found", "Job not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
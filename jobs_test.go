@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingTelegram signals started on each call and then blocks until the
+// call's context is cancelled.
+type blockingTelegram struct {
+	started chan struct{}
+}
+
+func (b blockingTelegram) Call(ctx context.Context, token, method, contentType string, body []byte) (json.RawMessage, error) {
+	b.started <- struct{}{}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestJobCancelledByShutdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		midSend  bool
+		status   string
+		attempts int
+	}{
+		{"before send", false, jobQueued, 0},
+		{"mid-send", true, jobFailed, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, _ := useFakeUpstreams(t)
+			started := make(chan struct{}, 1)
+
+			// Before the send the attempt waits for shutdown ahead of
+			// calling Telegram; mid-send Telegram itself blocks.
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				started <- struct{}{}
+				<-r.Context().Done()
+				sendHandler(w, r)
+			}
+			if tt.midSend {
+				telegram = blockingTelegram{started}
+				handler = sendHandler
+			}
+
+			dir := t.TempDir()
+			q := newJobQueue(configWith(t, "JOBS_DIR", dir).Settings, map[string]http.HandlerFunc{"send": handler})
+			ctx, stop := context.WithCancel(context.Background())
+			q.run(ctx, 1)
+			job := q.enqueue("send", &jobRequest{URL: "/send", Header: http.Header{"Content-Type": {"application/json"}}, Body: []byte(`{"message":"shutdown"}`)})
+
+			select {
+			case <-started:
+			case <-time.After(2 * time.Second):
+				t.Fatal("the job never started")
+			}
+			stop()
+			expired, cancel := context.WithCancel(context.Background())
+			cancel()
+			if q.wait(expired) {
+				t.Error("wait reported the workers stopped in time")
+			}
+
+			got, _ := q.get(job.ID)
+			if got.Status != tt.status || got.Attempts != tt.attempts {
+				t.Errorf("job = %s after %d attempts, want %s after %d", got.Status, got.Attempts, tt.status, tt.attempts)
+			}
+			if tt.midSend && !strings.Contains(got.LastError, "Cancelled") {
+				t.Errorf("last_error = %q, want it to say the attempt was cancelled", got.LastError)
+			}
+			if !tt.midSend && len(fake.Calls()) != 0 {
+				t.Errorf("Telegram got %d calls for a job cancelled before sending", len(fake.Calls()))
+			}
+
+			// The outcome is what a restart picks up.
+			data, err := os.ReadFile(filepath.Join(dir, job.ID+".json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var saved Job
+			if err := json.Unmarshal(data, &saved); err != nil {
+				t.Fatal(err)
+			}
+			if saved.Status != tt.status {
+				t.Errorf("saved status = %s, want %s", saved.Status, tt.status)
+			}
+		})
+	}
+}
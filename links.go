@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	generatedSlugLength = 6
+	maxLinkAttributions = 100
+	linkFlushInterval   = time.Second
+)
+
+var linkSlug = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ShortLink maps a slug to its destination and counts the clicks on it, by
+// referrer host and utm_source of the short URL.
+type ShortLink struct {
+	Slug          string         `json:"slug"`
+	URL           string         `json:"url"`
+	CreatedAt     time.Time      `json:"created_at"`
+	Clicks        int            `json:"clicks"`
+	LastClickedAt *time.Time     `json:"last_clicked_at,omitempty"`
+	Referrers     map[string]int `json:"referrers,omitempty"`
+	Sources       map[string]int `json:"utm_sources,omitempty"`
+}
+
+type ShortLinkRequest struct {
+	URL  string `json:"url"`
+	Slug string `json:"slug,omitempty"`
+}
+
+// linkStore keeps links in the database. Clicks are counted in memory and
+// written in batches by run, so a redirect never waits on a disk write.
+type linkStore struct {
+	db      *sql.DB
+	mu      sync.Mutex
+	pending map[string]*linkClicks
+}
+
+// linkClicks are the clicks on one link since the last flush.
+type linkClicks struct {
+	clicks    int
+	last      time.Time
+	referrers map[string]int
+	sources   map[string]int
+}
+
+var links = &linkStore{db: database}
+
+// add stores link unless its slug is taken.
+func (s *linkStore) add(link *ShortLink) (bool, error) {
+	res, err := s.db.Exec(`INSERT INTO links (slug, url, created_at) VALUES (?, ?, ?) ON CONFLICT (slug) DO NOTHING`,
+		link.Slug, link.URL, link.CreatedAt.UnixMilli())
+	if err != nil {
+		return false, fmt.Errorf("error saving link: %v", err)
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// lookup returns slug's destination.
+func (s *linkStore) lookup(slug string) (string, bool, error) {
+	var target string
+	err := s.db.QueryRow(`SELECT url FROM links WHERE slug = ?`, slug).Scan(&target)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("error reading link: %v", err)
+	}
+	return target, true, nil
+}
+
+// click counts a visit to slug, to be written by the next flush.
+func (s *linkStore) click(slug, referrer, source string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending == nil {
+		s.pending = make(map[string]*linkClicks)
+	}
+	c, ok := s.pending[slug]
+	if !ok {
+		c = &linkClicks{}
+		s.pending[slug] = c
+	}
+	c.clicks++
+	c.last = time.Now().UTC()
+	c.referrers = countAttribution(c.referrers, referrer)
+	c.sources = countAttribution(c.sources, source)
+}
+
+// countAttribution adds a click for value to counts. Values come from the
+// visitor, so past maxLinkAttributions distinct ones they are counted as
+// "other" to keep a link's counts bounded.
+func countAttribution(counts map[string]int, value string) map[string]int {
+	if value == "" {
+		return counts
+	}
+	if counts == nil {
+		counts = make(map[string]int)
+	}
+	if len(value) > 100 {
+		value = value[:100]
+	}
+	if _, seen := counts[value]; !seen && len(counts) >= maxLinkAttributions {
+		value = "other"
+	}
+	counts[value]++
+	return counts
+}
+
+// run flushes the counted clicks every linkFlushInterval until ctx is
+// done. main flushes once more after the server has stopped.
+func (s *linkStore) run(ctx context.Context) {
+	ticker := time.NewTicker(linkFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.flush()
+		}
+	}
+}
+
+// flush writes the clicks counted since the last flush in one transaction.
+func (s *linkStore) flush() {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	err := func() error {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		for slug, c := range pending {
+			_, err := tx.Exec(`UPDATE links SET clicks = clicks + ?, last_clicked_at = MAX(COALESCE(last_clicked_at, 0), ?) WHERE slug = ?`,
+				c.clicks, c.last.UnixMilli(), slug)
+			if err != nil {
+				return err
+			}
+			for kind, counts := range map[string]map[string]int{"referrer": c.referrers, "utm_source": c.sources} {
+				for value, n := range counts {
+					if err := addAttribution(tx, slug, kind, value, n); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return tx.Commit()
+	}()
+	if err != nil {
+		log.Printf("Error saving link clicks: %v", err)
+	}
+}
+
+// addAttribution adds n clicks for value to slug's counts of kind, under
+// the same maxLinkAttributions cap as countAttribution.
+func addAttribution(tx *sql.Tx, slug, kind, value string, n int) error {
+	var known bool
+	var distinct int
+	err := tx.QueryRow(`SELECT COALESCE(MAX(value = ?), 0), COUNT(*) FROM link_attributions WHERE slug = ? AND kind = ?`,
+		value, slug, kind).Scan(&known, &distinct)
+	if err != nil {
+		return err
+	}
+	if !known && distinct >= maxLinkAttributions {
+		value = "other"
+	}
+	_, err = tx.Exec(`INSERT INTO link_attributions (slug, kind, value, clicks) VALUES (?, ?, ?, ?)
+		ON CONFLICT (slug, kind, value) DO UPDATE SET clicks = clicks + excluded.clicks`, slug, kind, value, n)
+	return err
+}
+
+// list flushes the pending clicks and returns every link, most clicked
+// first.
+func (s *linkStore) list() ([]ShortLink, error) {
+	s.flush()
+
+	rows, err := s.db.Query(`SELECT slug, url, created_at, clicks, last_clicked_at FROM links ORDER BY clicks DESC, slug`)
+	if err != nil {
+		return nil, fmt.Errorf("error reading links: %v", err)
+	}
+	defer rows.Close()
+	all := []ShortLink{}
+	bySlug := make(map[string]*ShortLink)
+	for rows.Next() {
+		var link ShortLink
+		var createdAt int64
+		var lastClickedAt sql.NullInt64
+		if err := rows.Scan(&link.Slug, &link.URL, &createdAt, &link.Clicks, &lastClickedAt); err != nil {
+			return nil, fmt.Errorf("error reading links: %v", err)
+		}
+		link.CreatedAt = fromUnixMilli(createdAt)
+		if lastClickedAt.Valid {
+			t := fromUnixMilli(lastClickedAt.Int64)
+			link.LastClickedAt = &t
+		}
+		all = append(all, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading links: %v", err)
+	}
+	for i := range all {
+		bySlug[all[i].Slug] = &all[i]
+	}
+
+	rows, err = s.db.Query(`SELECT slug, kind, value, clicks FROM link_attributions`)
+	if err != nil {
+		return nil, fmt.Errorf("error reading links: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var slug, kind, value string
+		var n int
+		if err := rows.Scan(&slug, &kind, &value, &n); err != nil {
+			return nil, fmt.Errorf("error reading links: %v", err)
+		}
+		link, ok := bySlug[slug]
+		if !ok {
+			continue
+		}
+		counts := &link.Referrers
+		if kind == "utm_source" {
+			counts = &link.Sources
+		}
+		if *counts == nil {
+			*counts = make(map[string]int)
+		}
+		(*counts)[value] = n
+	}
+	return all, rows.Err()
+}
+
+// newLinkSlug returns a random slug from an alphabet without look-alike
+// characters, so links read aloud or retyped still work.
+func newLinkSlug() string {
+	const alphabet = "23456789abcdefghjkmnpqrstuvwxyz"
+	b := make([]byte, generatedSlugLength)
+	for i := range b {
+		n, _ := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		b[i] = alphabet[n.Int64()]
+	}
+	return string(b)
+}
+
+func handleCreateLink(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var req ShortLinkRequest
+	if !decodeBody(w, r, &req, config) {
+		return
+	}
+	u, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		writeError(w, http.StatusBadRequest, "invalid_url", "URL must be an http or https URL")
+		return
+	}
+	if req.Slug != "" && !linkSlug.MatchString(req.Slug) {
+		writeError(w, http.StatusBadRequest, "invalid_slug", "Slug may only contain letters, digits, _ and -, up to 64 characters")
+		return
+	}
+
+	link := &ShortLink{Slug: req.Slug, URL: u.String(), CreatedAt: time.Now().UTC()}
+	generated := link.Slug == ""
+	for {
+		// Retry a generated slug on the rare collision with an existing one.
+		if generated {
+			link.Slug = newLinkSlug()
+		}
+		added, err := links.add(link)
+		if err != nil {
+			log.Printf("Error creating link: %v", err)
+			writeError(w, http.StatusInternalServerError, "links_unavailable", "Could not save the link")
+			return
+		}
+		if added {
+			break
+		}
+		if !generated {
+			writeJSON(w, http.StatusConflict, ErrorResponse{Message: "Slug is already taken", Code: "slug_taken"})
+			return
+		}
+	}
+
+	auditLog.record("link_created", map[string]string{"slug": link.Slug, "url": link.URL})
+	writeJSON(w, http.StatusCreated, map[string]string{"slug": link.Slug, "url": link.URL, "short_path": "/l/" + link.Slug})
+}
+
+// handleRedirect sends a short link's visitor on with a 302, counting the
+// click. The redirect isn't cacheable, so every click reaches the counter.
+func handleRedirect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	slug := strings.TrimPrefix(r.URL.Path, "/l/")
+	target, ok, err := links.lookup(slug)
+	if err != nil {
+		log.Printf("Error reading link: %v", err)
+		writeError(w, http.StatusInternalServerError, "links_unavailable", "Could not read the link")
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "link_not_found", "Link not found")
+		return
+	}
+	if r.Method != http.MethodHead {
+		links.click(slug, referrerHost(r.Referer()), strings.TrimSpace(r.URL.Query().Get("utm_source")))
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+func handleListLinks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	all, err := links.list()
+	if err != nil {
+		log.Printf("Error listing links: %v", err)
+		writeError(w, http.StatusInternalServerError, "links_unavailable", "Could not read links")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"links": all, "count": len(all)})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func createLink(t *testing.T, body string) map[string]string {
+	t.Helper()
+	rec := serve(bind(handleCreateLink, testConfig), http.MethodPost, "/links", body)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST /links %s: status = %d: %s", body, rec.Code, rec.Body)
+	}
+	var resp map[string]string
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return resp
+}
+
+// visit follows a short link with the given referrer.
+func visit(method, path, referrer string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if referrer != "" {
+		req.Header.Set("Referer", referrer)
+	}
+	rec := httptest.NewRecorder()
+	handleRedirect(rec, req)
+	return rec
+}
+
+func listLinks(t *testing.T) []ShortLink {
+	t.Helper()
+	rec := serve(handleListLinks, http.MethodGet, "/admin/links", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /admin/links: status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Links []ShortLink `json:"links"`
+		Count int         `json:"count"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Count != len(resp.Links) {
+		t.Errorf("count = %d for %d links", resp.Count, len(resp.Links))
+	}
+	return resp.Links
+}
+
+func TestCreateLink(t *testing.T) {
+	useDatabase(t)
+
+	named := createLink(t, `{"url":"https://example.com/post","slug":"launch"}`)
+	if named["slug"] != "launch" || named["short_path"] != "/l/launch" || named["url"] != "https://example.com/post" {
+		t.Errorf("named link = %v", named)
+	}
+	generated := createLink(t, `{"url":"https://example.com/other"}`)
+	if len(generated["slug"]) != generatedSlugLength {
+		t.Errorf("generated slug = %q", generated["slug"])
+	}
+
+	tests := []struct {
+		body   string
+		status int
+		code   string
+	}{
+		{`{"url":"https://example.com/again","slug":"launch"}`, http.StatusConflict, "slug_taken"},
+		{`{"url":"javascript:alert(1)"}`, http.StatusBadRequest, "invalid_url"},
+		{`{"url":"https://example.com","slug":"no spaces"}`, http.StatusBadRequest, "invalid_slug"},
+	}
+	for _, tt := range tests {
+		rec := serve(bind(handleCreateLink, testConfig), http.MethodPost, "/links", tt.body)
+		if rec.Code != tt.status || decodeError(t, rec).Code != tt.code {
+			t.Errorf("%s: got %d %s, want %d %s", tt.body, rec.Code, rec.Body, tt.status, tt.code)
+		}
+	}
+}
+
+func TestRedirectCountsClicks(t *testing.T) {
+	useDatabase(t)
+	createLink(t, `{"url":"https://example.com/post","slug":"news"}`)
+
+	rec := visit(http.MethodGet, "/l/news?utm_source=newsletter", "https://www.Mail.example.org/inbox?id=1")
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://example.com/post" {
+		t.Fatalf("redirect = %d to %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store so every click is counted", cc)
+	}
+	visit(http.MethodGet, "/l/news", "")
+	if rec := visit(http.MethodHead, "/l/news", ""); rec.Code != http.StatusFound {
+		t.Errorf("HEAD: status = %d, want 302", rec.Code)
+	}
+	if rec := visit(http.MethodGet, "/l/missing", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown slug: status = %d, want 404", rec.Code)
+	}
+
+	all := listLinks(t)
+	if len(all) != 1 {
+		t.Fatalf("links = %+v", all)
+	}
+	link := all[0]
+	if link.Clicks != 2 || link.LastClickedAt == nil {
+		t.Errorf("clicks = %d, last at %v, want the two GETs counted", link.Clicks, link.LastClickedAt)
+	}
+	if len(link.Referrers) != 1 || link.Referrers["mail.example.org"] != 1 {
+		t.Errorf("referrers = %v, want the referrer host only", link.Referrers)
+	}
+	if len(link.Sources) != 1 || link.Sources["newsletter"] != 1 {
+		t.Errorf("sources = %v", link.Sources)
+	}
+}
+
+func TestLinkClicksAreWrittenInBatches(t *testing.T) {
+	useDatabase(t)
+	createLink(t, `{"url":"https://example.com/","slug":"batched"}`)
+
+	visit(http.MethodGet, "/l/batched", "")
+	var clicks int
+	database.QueryRow(`SELECT clicks FROM links WHERE slug = 'batched'`).Scan(&clicks)
+	if clicks != 0 {
+		t.Errorf("clicks = %d before a flush, want the redirect not to write", clicks)
+	}
+	links.flush()
+	database.QueryRow(`SELECT clicks FROM links WHERE slug = 'batched'`).Scan(&clicks)
+	if clicks != 1 {
+		t.Errorf("clicks = %d after a flush, want 1", clicks)
+	}
+}
+
+func TestLinkAttributionsAreCapped(t *testing.T) {
+	useDatabase(t)
+	createLink(t, `{"url":"https://example.com/","slug":"popular"}`)
+
+	// Spread over two flushes, so both the pending counts and the stored
+	// ones are capped.
+	for i := range maxLinkAttributions + 5 {
+		visit(http.MethodGet, fmt.Sprintf("/l/popular?utm_source=s%d", i), "")
+		if i == maxLinkAttributions/2 {
+			links.flush()
+		}
+	}
+	link := listLinks(t)[0]
+	if len(link.Sources) != maxLinkAttributions+1 || link.Sources["other"] != 5 {
+		t.Errorf("%d sources with other = %d, want %d distinct and 5 counted as other",
+			len(link.Sources), link.Sources["other"], maxLinkAttributions)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// shedLoad answers new requests with 503 once more than maxInFlight are
+// already being served. Paths in exempt (e.g. health checks) are always
+// served so the orchestrator doesn't mistake overload for a dead process.
+func shedLoad(next http.Handler, maxInFlight int64, exempt ...string) http.Handler {
+	var inFlight int64
+	skip := make(map[string]bool, len(exempt))
+	for _, path := range exempt {
+		skip[path] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if skip[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if atomic.AddInt64(&inFlight, 1) > maxInFlight {
+			atomic.AddInt64(&inFlight, -1)
+			w.Header().Set("Retry-After", "1")
+			writeJSON(w, http.StatusServiceUnavailable, ErrorResponse{Message: "Server is overloaded, please retry", Code: "overloaded"})
+			return
+		}
+		defer atomic.AddInt64(&inFlight, -1)
+
+		next.ServeHTTP(w, r)
+	})
+}
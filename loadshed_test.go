@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestShedLoadAboveThreshold(t *testing.T) {
+	const maxInFlight = 3
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := shedLoad(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}), maxInFlight, "/health")
+
+	request := func(path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	var wg sync.WaitGroup
+	statuses := make([]int, maxInFlight)
+	for i := range statuses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i] = request("/slow")
+		}()
+		<-started
+	}
+
+	if got := request("/send"); got != http.StatusServiceUnavailable {
+		t.Errorf("request over the threshold: status = %d, want 503", got)
+	}
+	if got := request("/health"); got != http.StatusNoContent {
+		t.Errorf("/health while overloaded: status = %d, want 204", got)
+	}
+
+	close(release)
+	wg.Wait()
+	for i, status := range statuses {
+		if status != http.StatusNoContent {
+			t.Errorf("in-flight request %d: status = %d, want 204", i, status)
+		}
+	}
+	if got := request("/send"); got != http.StatusNoContent {
+		t.Errorf("request after the load dropped: status = %d, want 204", got)
+	}
+}
+
+func TestShedLoadExemptsProbes(t *testing.T) {
+	settings := configWith(t, "MAX_IN_FLIGHT_REQUESTS", "1").Settings
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+	for _, path := range []string{"/send", "/health", "/healthz", "/readyz"} {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+	handler := newHandler(mux, settings)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+	}()
+	<-started
+	defer func() {
+		close(release)
+		<-done
+	}()
+
+	for path, want := range map[string]int{
+		"/send":    http.StatusServiceUnavailable,
+		"/health":  http.StatusNoContent,
+		"/healthz": http.StatusNoContent,
+		"/readyz":  http.StatusNoContent,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("%s while overloaded: status = %d, want %d", path, rec.Code, want)
+		}
+	}
+}
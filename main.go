@@ -1,34 +1,138 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
-	"github.com/joho/godotenv"
-	"github.com/rs/cors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/net/netutil"
 )
 
+// Upstream API roots. They are variables so tests can point them at an
+// httptest.Server; TELEGRAM_API_BASE_URL, BEEHIIV_API_BASE_URL and the
+// SPOTIFY_*_BASE_URL, GITHUB_API_BASE_URL, AKISMET_API_BASE_URL and
+// STRIPE_API_BASE_URL settings override them at startup, e.g. for a
+// self-hosted Bot API server.
+var (
+    telegramAPIBaseURL     = "https://api.telegram.org"
+    beehiivAPIBaseURL      = "https://api.beehiiv.com"
+    spotifyAccountsBaseURL = "https://accounts.spotify.com"
+    spotifyAPIBaseURL      = "https://api.spotify.com"
+    githubAPIBaseURL       = "https://api.github.com"
+    akismetAPIBaseURL      = "https://rest.akismet.com"
+    stripeAPIBaseURL       = "https://api.stripe.com"
+)
+
+// Config is what handlers send with: the settings, and the bot and chat
+// to use, which a request may narrow down.
 type Config struct {
+    *Settings
+
     BotToken string
     ChatID   string
+    Bots     *botPool
+
+    // APICompat, when set, is the oldest Bot API version requests must be
+    // understood by.
+    APICompat *apiVersion
+}
+
+// botToken returns the token to call method with. New messages rotate
+// through the weighted bot pool when several bots are configured; other
+// methods, such as edits, stay on TELEGRAM_BOT_TOKEN or the pool's first
+// bot, since a bot can only act on its own messages.
+func (c Config) botToken(method string) string {
+    if c.Bots == nil {
+        return c.BotToken
+    }
+    if isSendMethod(method) {
+        return c.Bots.next()
+    }
+    if c.BotToken != "" {
+        return c.BotToken
+    }
+    return c.Bots.first()
+}
+
+// withBot returns c pinned to a single bot, e.g. the one that sent the
+// message being edited.
+func (c Config) withBot(token string) Config {
+    c.BotToken = token
+    c.Bots = nil
+    return c
+}
+
+// isSendMethod reports whether a Bot API method posts a new message.
+func isSendMethod(method string) bool {
+    return strings.HasPrefix(method, "send") || method == "copyMessage" || method == "forwardMessage"
 }
 
 type TelegramMessage struct {
     ChatID string `json:"chat_id"`
     Text   string `json:"text"`
-	ParseMode string `json:"parse_mode"`
+	ParseMode string `json:"parse_mode,omitempty"`
+    BusinessConnectionID string `json:"business_connection_id,omitempty"`
+    LinkPreviewOptions  *linkPreviewOptions `json:"link_preview_options,omitempty"`
+    DisableNotification bool                `json:"disable_notification,omitempty"`
+    ReplyMarkup         *inlineKeyboard     `json:"reply_markup,omitempty"`
 }
 
 type MessageRequest struct {
-    Message string `json:"message"`
+    Message   string `json:"message"`
+    GroupKey  string `json:"group_key,omitempty"`
+    ParseMode string `json:"parse_mode,omitempty"`
+    ChatID    string `json:"chat_id,omitempty"`
+    Target    string `json:"target,omitempty"`
+    BusinessConnectionID string `json:"business_connection_id,omitempty"`
+    Channel   string `json:"channel,omitempty"`
+    Verbose   bool   `json:"verbose,omitempty"`
+
+    // Template, when set, renders the message from Data instead of taking
+    // it from Message.
+    Template string                 `json:"template,omitempty"`
+    Data     map[string]interface{} `json:"data,omitempty"`
+
+    DisableWebPagePreview bool             `json:"disable_web_page_preview,omitempty"`
+    DisableNotification   bool             `json:"disable_notification,omitempty"`
+    Buttons               [][]InlineButton `json:"buttons,omitempty"`
+
+    // At most one attachment, sent with Message as its caption.
+    PhotoURL       string `json:"photo_url,omitempty"`
+    DocumentURL    string `json:"document_url,omitempty"`
+    DocumentBase64 string `json:"document_base64,omitempty"`
+    DocumentName   string `json:"document_name,omitempty"`
+}
+
+// SendOptions carries the per-request settings for a Telegram send.
+type SendOptions struct {
+    ParseMode            string
+    BusinessConnectionID string
+    DisablePreview       bool
+    Silent               bool
+    Buttons              [][]InlineButton
 }
 
+// ErrorResponse is the body of every error. Code is a stable machine
+// readable identifier that every error path sets; Message is for people.
 type ErrorResponse struct {
-    Error string `json:"error"`
+    Message   string      `json:"message"`
+    Code      string      `json:"code,omitempty"`
+    Details   interface{} `json:"details,omitempty"`
+    RequestID string      `json:"request_id,omitempty"`
 }
 
 type SubscribeRequest struct {
@@ -36,6 +140,17 @@ type SubscribeRequest struct {
     UTMSource     string `json:"utm_source,omitempty"`
     UTMMedium     string `json:"utm_medium,omitempty"`
     ReferringSite string `json:"referring_site,omitempty"`
+    DedupKey      string `json:"dedup_key,omitempty"`
+    ConsentVersion string `json:"consent_version,omitempty"`
+    Consent        *FlexBool `json:"consent,omitempty"`
+    NotifyTeam     *FlexBool `json:"notify_team,omitempty"`
+
+    // Passed through to Beehiiv; see validateSubscribeOptions.
+    CustomFields       []BeehiivCustomField `json:"custom_fields,omitempty"`
+    Tags               []string             `json:"tags,omitempty"`
+    Tier               string               `json:"tier,omitempty"`
+    SendWelcomeEmail   *FlexBool            `json:"send_welcome_email,omitempty"`
+    ReactivateExisting *FlexBool            `json:"reactivate_existing,omitempty"`
 }
 
 type BeehiivResponse struct {
@@ -44,71 +159,374 @@ type BeehiivResponse struct {
     } `json:"data"`
 }
 
-func sendTelegramMessage(config Config, message string) error {
-    baseURL := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", config.BotToken)
-    
+type TelegramResponse struct {
+    OK          bool            `json:"ok"`
+    Result      json.RawMessage `json:"result"`
+    Description string          `json:"description,omitempty"`
+}
+
+type TelegramSentMessage struct {
+    MessageID       int64 `json:"message_id"`
+    MessageThreadID int64 `json:"message_thread_id,omitempty"`
+
+    // Raw is the full Message object Telegram returned.
+    Raw json.RawMessage `json:"-"`
+}
+
+func sendTelegramMessage(ctx context.Context, config Config, message string, opts SendOptions) (*TelegramSentMessage, error) {
     telegramMsg := TelegramMessage{
         ChatID: config.ChatID,
         Text:   message,
-		ParseMode: "HTML",
+		ParseMode: resolveParseMode(opts, message),
+        BusinessConnectionID: opts.BusinessConnectionID,
+        DisableNotification:  opts.Silent,
+        ReplyMarkup:          replyMarkup(opts.Buttons),
+    }
+    if opts.DisablePreview {
+        telegramMsg.LinkPreviewOptions = &linkPreviewOptions{IsDisabled: true}
     }
     
-    jsonData, err := json.Marshal(telegramMsg)
+    if err := paceChat(ctx, config); err != nil {
+        return nil, err
+    }
+
+    // Pin the bot so the message can later be edited through it.
+    config = config.withBot(config.botToken("sendMessage"))
+    result, err := callTelegram(ctx, config, "sendMessage", telegramMsg)
     if err != nil {
-        return fmt.Errorf("error marshaling message: %v", err)
+        return nil, err
+    }
+
+    var sent TelegramSentMessage
+    if err := json.Unmarshal(result, &sent); err != nil {
+        return nil, fmt.Errorf("error decoding response: %v", err)
+    }
+    sent.Raw = result
+    sentMessages.remember(config.ChatID, sent.MessageID, message, config.BotToken)
+
+    return &sent, nil
+}
+
+// callTelegram invokes a Bot API method and returns the raw "result" field
+// of the response.
+func callTelegram(ctx context.Context, config Config, method string, payload interface{}) (json.RawMessage, error) {
+    if config.APICompat != nil {
+        compatPayload, err := applyAPICompat(payload, *config.APICompat)
+        if err != nil {
+            return nil, fmt.Errorf("error marshaling message: %v", err)
+        }
+        payload = compatPayload
     }
     
-    resp, err := http.Post(
-        baseURL,
-        "application/json",
-        strings.NewReader(string(jsonData)),
-    )
+    jsonData, err := json.Marshal(payload)
     if err != nil {
-        return fmt.Errorf("error sending message: %v", err)
+        return nil, fmt.Errorf("error marshaling message: %v", err)
     }
-    defer resp.Body.Close()
-    
-    if resp.StatusCode != http.StatusOK {
-        return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+
+    return postTelegram(ctx, config, method, "application/json", jsonData)
+}
+
+// postTelegram sends an already encoded request body to a Bot API method,
+// retrying transient failures.
+func postTelegram(ctx context.Context, config Config, method, contentType string, body []byte) (json.RawMessage, error) {
+    defer timingsFrom(ctx).track("upstream")()
+
+    // Retries go through the same bot as the first attempt.
+    token := config.botToken(method)
+    var result json.RawMessage
+    err := retryUpstream(ctx, config.retryPolicyFor("TELEGRAM"), func() error {
+        var err error
+        result, err = telegram.Call(ctx, token, method, contentType, body)
+        return err
+    })
+
+    return result, err
+}
+
+// sendOptionsFor validates the per-request send settings and fills in
+// defaults. It returns a non-empty message when the request is invalid.
+func sendOptionsFor(req MessageRequest, config Config) (SendOptions, string) {
+    // In business mode every send must be made on behalf of a connected
+    // Telegram Business account.
+    if config.TelegramBusinessMode && req.BusinessConnectionID == "" {
+        return SendOptions{}, "Business connection ID is required"
     }
-    
-    return nil
+
+    // An omitted parse_mode keeps the historical HTML default; "none" sends
+    // plain text.
+    opts := SendOptions{ParseMode: "HTML", BusinessConnectionID: req.BusinessConnectionID}
+    switch req.ParseMode {
+    case "":
+    case "none":
+        opts.ParseMode = ""
+    case "HTML", "MarkdownV2", "Markdown", parseModeAuto:
+        opts.ParseMode = req.ParseMode
+    default:
+        return SendOptions{}, "Invalid parse mode"
+    }
+
+    if msg := validateButtons(req.Buttons); msg != "" {
+        return SendOptions{}, msg
+    }
+    opts.DisablePreview = req.DisableWebPagePreview
+    opts.Silent = req.DisableNotification
+    opts.Buttons = req.Buttons
+
+    return opts, ""
+}
+
+// chatIDFor returns the chat a send should go to: the chat named by
+// req.Target in TELEGRAM_CHATS, the default target or configured chat, or
+// req.ChatID when it is that chat or listed in TELEGRAM_ALLOWED_CHAT_IDS so
+// clients can't use the bot to message arbitrary chats. A denied override
+// is rejected, or with CHAT_OVERRIDE_POLICY=fallback sent to the configured
+// chat with a warning for the response.
+func chatIDFor(req MessageRequest, config Config) (chatID, warning, errMsg string) {
+    if req.Target != "" && req.ChatID != "" {
+        return "", "", "Target and chat ID cannot be combined"
+    }
+    if req.ChatID == "" {
+        chatID, ok := chatForTarget(req.Target, config)
+        if !ok {
+            return "", "", "Unknown target"
+        }
+        return chatID, "", ""
+    }
+    if req.ChatID == config.ChatID {
+        return config.ChatID, "", ""
+    }
+    for _, allowed := range config.TelegramAllowedChatIDs {
+        if req.ChatID == allowed {
+            return req.ChatID, "", ""
+        }
+    }
+    if config.ChatOverridePolicy == "fallback" {
+        return config.ChatID, "Chat ID is not allowed, sent to the default chat instead", ""
+    }
+    return "", "", "Chat ID is not allowed"
 }
 
 func handleSendMessage(w http.ResponseWriter, r *http.Request, config Config) {
     if r.Method != http.MethodPost {
-        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        writeMethodNotAllowed(w, http.MethodPost)
         return
     }
     
+    ctx, timings := withTimings(r.Context())
+    r = r.WithContext(ctx)
+    stopValidation := timings.track("validation")
+
     var req MessageRequest
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+    if !decodeBodyLimit(w, r, &req, maxSendBodyBytes(config)) {
         return
     }
+
+    // Templates always render HTML with every value escaped.
+    if req.Template != "" {
+        if req.Message != "" {
+            writeError(w, http.StatusBadRequest, "message_and_template", "Message and template cannot be combined")
+            return
+        }
+        rendered, err := renderTemplate(req.Template, req.Data)
+        if errors.Is(err, errUnknownTemplate) {
+            writeJSON(w, http.StatusBadRequest, ErrorResponse{Message: "Unknown template", Code: "unknown_template"})
+            return
+        }
+        if err != nil {
+            writeJSON(w, http.StatusBadRequest, ErrorResponse{Message: err.Error(), Code: "template_error"})
+            return
+        }
+        req.Message = rendered
+        req.ParseMode = "HTML"
+    }
     
-    if req.Message == "" {
-        json.NewEncoder(w).Encode(ErrorResponse{Error: "Message cannot be empty"})
+    // Whitespace-only messages are rejected unless explicitly allowed;
+    // otherwise surrounding whitespace is trimmed before sending.
+    // An attachment may be sent without a caption.
+    if trimmed := strings.TrimSpace(req.Message); trimmed != "" {
+        req.Message = trimmed
+    } else if req.hasMedia() {
+        req.Message = ""
+    } else if req.Message == "" || !config.AllowWhitespaceMessages {
+        writeError(w, http.StatusBadRequest, "message_empty", "Message cannot be empty")
         return
     }
     
-    err := sendTelegramMessage(config, req.Message)
+    // Sanitizing can leave nothing for Telegram to send, which it would
+    // reject with an opaque "message text is empty".
+    if sanitized := sanitizeControlChars(req.Message, config.SanitizeControlChars); sanitized != req.Message {
+        if strings.TrimSpace(sanitized) == "" {
+            writeJSON(w, http.StatusBadRequest, ErrorResponse{Message: "Message is empty after processing", Code: "message_empty_after_processing"})
+            return
+        }
+        req.Message = sanitized
+    }
+
+    opts, msg := sendOptionsFor(req, config)
+    if msg != "" {
+        writeError(w, http.StatusBadRequest, "invalid_request", msg)
+        return
+    }
+
+    var warning string
+    config.ChatID, warning, msg = chatIDFor(req, config)
+    if msg != "" {
+        writeError(w, http.StatusBadRequest, "invalid_chat_target", msg)
+        return
+    }
+
+    notifiers, msg := notifiersFor(req.Channel, config)
+    if msg != "" {
+        writeError(w, http.StatusBadRequest, "invalid_channel", msg)
+        return
+    }
+    if notifiers != nil && req.GroupKey != "" {
+        writeError(w, http.StatusBadRequest, "grouping_unsupported", "Grouping is only supported on the telegram channel")
+        return
+    }
+
+    // Captions have their own, shorter limit below. A fan-out checks each
+    // target's limit as it sends, so here the message only has to fit the
+    // most generous one.
+    if !req.hasMedia() {
+        limit := maxMessageLength
+        if notifiers != nil {
+            limit = longestMessageLimit(notifiers)
+        }
+        var errs fieldErrors
+        errs.message("message", req.Message, limit)
+        if errs.write(w) {
+            return
+        }
+    }
+
+    var media struct {
+        method, field, ref string
+        data               []byte
+    }
+    if req.hasMedia() {
+        if notifiers != nil || req.GroupKey != "" {
+            writeError(w, http.StatusBadRequest, "attachments_unsupported", "Attachments are only supported on the telegram channel without grouping")
+            return
+        }
+        media.method, media.field, media.ref, media.data, msg = mediaFor(req, config.MaxDocumentBytes)
+        if msg != "" {
+            writeError(w, http.StatusBadRequest, "invalid_attachment", msg)
+            return
+        }
+        caption, _, ok := fitCaption(req.Message, config.CaptionOverflow)
+        if !ok {
+            writeJSON(w, http.StatusBadRequest, ErrorResponse{Message: fmt.Sprintf("Caption is longer than %d characters", maxCaptionLength), Code: "caption_too_long"})
+            return
+        }
+        req.Message = caption
+    }
+    stopValidation()
+
+    if notifiers != nil {
+        results, err := notifyAll(r.Context(), notifiers, req.Message, opts)
+        if err != nil {
+            writeUpstreamError(w, err)
+            return
+        }
+
+        sentCount := 0
+        for _, result := range results {
+            if result.Status != "sent" {
+                continue
+            }
+            sentCount++
+            fields := map[string]string{
+                "channel":    result.Channel,
+                "message_id": strconv.FormatInt(result.MessageID, 10),
+            }
+            if result.Channel == "telegram" {
+                fields["chat_id"] = config.ChatID
+            }
+            auditLog.record("send", fields)
+        }
+
+        resp := map[string]interface{}{
+            "status":  "Message sent successfully",
+            "results": results,
+        }
+        if sentCount < len(results) {
+            resp["status"] = fmt.Sprintf("Message sent to %d of %d channels", sentCount, len(results))
+        }
+        if warning != "" {
+            resp["warning"] = warning
+        }
+        if req.Verbose {
+            resp["timing"] = timings.breakdown()
+        }
+        w.Header().Set("X-Timing", timings.header())
+        writeJSON(w, http.StatusOK, resp)
+        return
+    }
+
+    if req.GroupKey != "" {
+        messageID, count, err := sendGrouped(r.Context(), config, req.GroupKey, req.Message, opts, config.AlertGroupWindow)
+        if err != nil {
+            writeUpstreamError(w, err)
+            return
+        }
+
+        resp := map[string]interface{}{
+            "status":       "Message sent successfully",
+            "message_id":   messageID,
+            "count":        count,
+            "content_hash": contentHash(config.ChatID, req.Message),
+        }
+        if warning != "" {
+            resp["warning"] = warning
+        }
+        if req.Verbose {
+            resp["timing"] = timings.breakdown()
+        }
+        w.Header().Set("X-Timing", timings.header())
+        writeJSON(w, http.StatusOK, resp)
+        return
+    }
+
+    var sent *TelegramSentMessage
+    var err error
+    if req.hasMedia() {
+        sent, err = sendTelegramMedia(r.Context(), config, media.method, media.field, media.ref, media.data, req.DocumentName, req.Message, opts)
+    } else {
+        sent, err = sendTelegramMessage(r.Context(), config, req.Message, opts)
+    }
     if err != nil {
-        json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+        writeUpstreamError(w, err)
         return
     }
+
+    auditLog.record("send", map[string]string{
+        "chat_id":    config.ChatID,
+        "message_id": strconv.FormatInt(sent.MessageID, 10),
+    })
     
-    json.NewEncoder(w).Encode(map[string]string{"status": "Message sent successfully"})
+    resp := map[string]interface{}{
+        "status":       "Message sent successfully",
+        "message_id":   sent.MessageID,
+        "content_hash": contentHash(config.ChatID, req.Message),
+    }
+    // Forum sends carry the topic's thread id so callers can reply in it.
+    if sent.MessageThreadID != 0 {
+        resp["message_thread_id"] = sent.MessageThreadID
+    }
+    if warning != "" {
+        resp["warning"] = warning
+    }
+    if req.Verbose {
+        resp["telegram"] = sent.Raw
+        resp["timing"] = timings.breakdown()
+    }
+    w.Header().Set("X-Timing", timings.header())
+
+    writeJSON(w, http.StatusOK, resp)
 }
 
-func subscribeToBeehiiv(req SubscribeRequest) error {
-    publicationID := os.Getenv("BEEHIIV_PUBLICATION_ID")
-    if publicationID == "" {
-        return fmt.Errorf("BEEHIIV_PUBLICATION_ID environment variable is required")
-    }
-    
-    url := fmt.Sprintf("https://api.beehiiv.com/v2/publications/%s/subscriptions", publicationID)
-    
+func subscribeToBeehiiv(ctx context.Context, config Config, req SubscribeRequest) (string, error) {
     payload := map[string]interface{}{
         "email": req.Email,
     }
@@ -123,105 +541,625 @@ func subscribeToBeehiiv(req SubscribeRequest) error {
     if req.ReferringSite != "" {
         payload["referring_site"] = req.ReferringSite
     }
-    
-    jsonData, err := json.Marshal(payload)
-    if err != nil {
-        return fmt.Errorf("error marshaling payload: %v", err)
+    var customFields []map[string]interface{}
+    for _, f := range req.CustomFields {
+        customFields = append(customFields, map[string]interface{}{"name": f.Name, "value": f.Value})
     }
-
-    httpReq, err := http.NewRequest(http.MethodPost, url, strings.NewReader(string(jsonData)))
-    if err != nil {
-        return fmt.Errorf("error creating request: %v", err)
+    if req.ConsentVersion != "" {
+        customFields = append(customFields, map[string]interface{}{"name": "consent_version", "value": req.ConsentVersion})
     }
-
-    apiKey := os.Getenv("BEEHIIV_API_KEY")
-    if apiKey == "" {
-        return fmt.Errorf("BEEHIIV_API_KEY environment variable is required")
+    if len(customFields) > 0 {
+        payload["custom_fields"] = customFields
+    }
+    if req.Tier != "" {
+        payload["tier"] = req.Tier
+    }
+    if req.SendWelcomeEmail != nil {
+        payload["send_welcome_email"] = bool(*req.SendWelcomeEmail)
+    }
+    if req.ReactivateExisting != nil {
+        payload["reactivate_existing"] = bool(*req.ReactivateExisting)
     }
 
-    httpReq.Header.Set("Content-Type", "application/json")
-    httpReq.Header.Set("Authorization", "Bearer "+apiKey)
+    var subscriptionID string
+    err := retryUpstream(ctx, config.retryPolicyFor("BEEHIIV"), func() error {
+        body, err := beehiiv.Do(ctx, http.MethodPost, "/subscriptions", payload)
+        var upErr *UpstreamError
+        if errors.As(err, &upErr) && isAlreadySubscribed(upErr) {
+            return errAlreadySubscribed
+        }
+        if err != nil {
+            return err
+        }
 
-    client := &http.Client{}
-    resp, err := client.Do(httpReq)
+        var beehiivResp BeehiivResponse
+        if err := json.Unmarshal(body, &beehiivResp); err != nil {
+            return fmt.Errorf("error decoding response: %v", err)
+        }
+        subscriptionID = beehiivResp.Data.ID
+        return nil
+    })
     if err != nil {
-        return fmt.Errorf("error sending request: %v", err)
+        return "", err
     }
-    defer resp.Body.Close()
 
-    if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-        return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+    // Tags have their own endpoint. The subscription exists by now, so a
+    // failure here is logged rather than failing the signup.
+    if len(req.Tags) > 0 && subscriptionID != "" {
+        path := "/subscriptions/" + url.PathEscape(subscriptionID) + "/tags"
+        if err := doBeehiivRequest(ctx, http.MethodPost, path, map[string]interface{}{"tags": req.Tags}); err != nil {
+            log.Printf("Warning: cannot tag subscription %s: %v", subscriptionID, err)
+        }
     }
 
-    return nil
+    return subscriptionID, nil
 }
 
-func handleSubscribe(w http.ResponseWriter, r *http.Request) {
+func handleSubscribe(w http.ResponseWriter, r *http.Request, config Config) {
     if r.Method != http.MethodPost {
-        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        writeMethodNotAllowed(w, http.MethodPost)
         return
     }
 
     var req SubscribeRequest
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+    if !decodeBody(w, r, &req, config) {
+        return
+    }
+
+    mergeQueryUTM(&req, r.URL.Query())
+
+    var errs fieldErrors
+    req.Email = errs.email("email", strings.TrimSpace(req.Email))
+    if errs.write(w) {
         return
     }
 
-    if req.Email == "" {
-        json.NewEncoder(w).Encode(ErrorResponse{Error: "Email cannot be empty"})
+    if config.VerifyMX && !domainHasMX(r.Context(), req.Email, config.MXCacheTTL) {
+        writeJSON(w, http.StatusBadRequest, ErrorResponse{Message: "Email domain does not accept mail", Code: "invalid_email_domain"})
+        return
+    }
+
+    if msg := validateSubscribeOptions(r, req, config); msg != "" {
+        writeError(w, http.StatusBadRequest, "invalid_request", msg)
+        return
+    }
+
+    if config.RequireConsent {
+        if req.Consent != nil && !*req.Consent {
+            writeError(w, http.StatusBadRequest, "consent_required", "Consent is required to subscribe")
+            return
+        }
+        if req.ConsentVersion == "" {
+            writeError(w, http.StatusBadRequest, "consent_version_required", "Consent version is required")
+            return
+        }
+    }
+
+    // A client-side dedup key suppresses retries of the same form submission,
+    // even if the payload differs slightly between attempts. Keys are scoped
+    // to the address, so two visitors' forms can't collide. A retry gets the
+    // first submission's outcome, or a conflict while it is still running.
+    dedupKey := ""
+    dedupTTL := config.SubscribeDedupTTL
+    if req.DedupKey != "" {
+        dedupKey = req.Email + "\n" + req.DedupKey
+        if !subscribeDedup.reserve(dedupKey, dedupTTL) {
+            outcome := subscribeDedup.outcome(dedupKey)
+            if outcome.Status == 0 {
+                writeError(w, http.StatusConflict, "submission_in_progress", "This submission is still being processed")
+                return
+            }
+            resp := map[string]string{"status": outcome.Message}
+            if outcome.ID != "" {
+                resp["id"] = outcome.ID
+            }
+            writeJSON(w, outcome.Status, resp)
+            return
+        }
+    }
+
+    // With double opt-in the Beehiiv subscription is only created once the
+    // address owner follows the emailed confirmation link.
+    if config.DoubleOptIn {
+        if err := sendConfirmationEmail(r, req, config); err != nil {
+            if dedupKey != "" {
+                subscribeDedup.release(dedupKey)
+            }
+            writeUpstreamError(w, err)
+            return
+        }
+
+        auditLog.record("subscribe_pending", map[string]string{
+            "email":   req.Email,
+            "ip_hash": hashIP(clientIP(r)),
+        })
+        if req.ConsentVersion != "" {
+            auditLog.record("consent", map[string]string{
+                "email":           req.Email,
+                "ip_hash":         hashIP(clientIP(r)),
+                "consent_version": req.ConsentVersion,
+            })
+        }
+
+        if dedupKey != "" {
+            subscribeDedup.complete(dedupKey, dedupOutcome{Status: http.StatusAccepted, Message: "Confirmation email sent"}, dedupTTL)
+        }
+        writeJSON(w, http.StatusAccepted, map[string]string{"status": "Confirmation email sent"})
         return
     }
 
-    err := subscribeToBeehiiv(req)
+    subscriptionID, err := subscribeToBeehiiv(r.Context(), config, req)
+    if errors.Is(err, errAlreadySubscribed) {
+        // Report an existing subscription as success by default so the
+        // signup form reads naturally; DUPLICATE_SUBSCRIBE_RESPONSE=error
+        // surfaces it as a conflict instead.
+        if config.DuplicateSubscribeResponse == "error" {
+            if dedupKey != "" {
+                subscribeDedup.release(dedupKey)
+            }
+            writeError(w, http.StatusConflict, "already_subscribed", "Email is already subscribed")
+            return
+        }
+        if dedupKey != "" {
+            subscribeDedup.complete(dedupKey, dedupOutcome{Status: http.StatusOK, Message: "already_subscribed"}, dedupTTL)
+        }
+        writeJSON(w, http.StatusOK, map[string]string{"status": "already_subscribed"})
+        return
+    }
     if err != nil {
-        json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+        if dedupKey != "" {
+            subscribeDedup.release(dedupKey)
+        }
+        writeUpstreamError(w, err)
         return
     }
 
-    json.NewEncoder(w).Encode(map[string]string{"status": "Subscription successful"})
+    auditLog.record("subscribe", map[string]string{
+        "email":   req.Email,
+        "ip_hash": hashIP(clientIP(r)),
+    })
+    subscribers.record(req, subscriptionID, "subscribe")
+
+    // The signup feed posts by default once configured; NOTIFY_ON_SUBSCRIBE
+    // changes that default and notify_team overrides it per request.
+    notify := config.NotifyOnSubscribe
+    if req.NotifyTeam != nil {
+        notify = bool(*req.NotifyTeam)
+    }
+    if signupFeedPoster != nil && notify {
+        signupFeedPoster.add(req)
+    }
+
+    if req.ConsentVersion != "" {
+        auditLog.record("consent", map[string]string{
+            "email":           req.Email,
+            "ip_hash":         hashIP(clientIP(r)),
+            "consent_version": req.ConsentVersion,
+        })
+    }
+
+    if dedupKey != "" {
+        subscribeDedup.complete(dedupKey, dedupOutcome{Status: http.StatusOK, Message: "Subscription successful", ID: subscriptionID}, dedupTTL)
+    }
+    resp := map[string]string{"status": "Subscription successful"}
+    if subscriptionID != "" {
+        resp["id"] = subscriptionID
+    }
+
+    writeJSON(w, http.StatusOK, resp)
+}
+
+const (
+    defaultShutdownTimeout    = 15 * time.Second
+    defaultReadHeaderTimeout  = 5 * time.Second
+    defaultServerReadTimeout  = 30 * time.Second
+    defaultServerWriteTimeout = 2 * time.Minute
+    defaultServerIdleTimeout  = 2 * time.Minute
+)
+
+// newServer returns the server for handler, with its limits and timeouts
+// taken from settings. Requests whose headers exceed MAX_HEADER_BYTES
+// are answered by net/http with 431 Request Header Fields Too Large before
+// reaching any handler. The write timeout covers the whole handler, so it
+// is generous enough for a streamed /batch or a CSV import; 0 disables any
+// of them.
+func newServer(addr string, handler http.Handler, settings *Settings) *http.Server {
+    return &http.Server{
+        Addr:              addr,
+        Handler:           handler,
+        MaxHeaderBytes:    settings.MaxHeaderBytes,
+        ReadHeaderTimeout: settings.ReadHeaderTimeout,
+        ReadTimeout:       settings.ReadTimeout,
+        WriteTimeout:      settings.WriteTimeout,
+        IdleTimeout:       settings.IdleTimeout,
+    }
+}
+
+// limitListener caps ln at maxConns (MAX_CONNECTIONS) open connections.
+// Connections beyond it wait in the accept backlog instead of each taking a
+// file descriptor; 0 means no cap.
+func limitListener(ln net.Listener, maxConns int) net.Listener {
+    if maxConns > 0 {
+        return netutil.LimitListener(ln, maxConns)
+    }
+    return ln
+}
+
+// newHandler wraps mux in the middleware every request goes through,
+// configured from settings, with CORS answering preflights for
+// ALLOWED_ORIGINS. The chain is built once, so each middleware runs once
+// per request.
+func newHandler(mux *http.ServeMux, settings *Settings) http.Handler {
+	var routes http.Handler = mux
+	if settings.UserAgentFilter {
+		routes = traced("user_agent_filter", filterUserAgents(routes, settings.UserAgentBlocklist))
+	}
+
+	if len(settings.APIKeys) > 0 {
+		routes = traced("api_key_auth", requireAPIKey(routes, settings))
+	}
+
+	routeRequests = parseRouteLimits(settings.RateLimitRoutes)
+	if len(routeRequests) > 0 {
+		routes = traced("route_rate_limit", limitRoutes(routes, routeRequests))
+	}
+
+	if settings.MaxInFlightRequests > 0 {
+		routes = traced("load_shedding", shedLoad(routes, int64(settings.MaxInFlightRequests), "/health", "/healthz", "/readyz"))
+	}
+
+	if settings.MaintenanceMode {
+		routes = traced("maintenance", withMaintenance(routes, settings.MaintenanceBypassSecret, "/health", "/healthz", "/readyz"))
+	}
+
+	handler := traced("cors", withRouteCORS(
+		traced("request_deadline", withRequestDeadline(routes, settings.RequestTimeoutMax)),
+		settings.AllowedOrigins,
+		settings.CORSRouteOrigins,
+		settings.CORSDenyPaths,
+	))
+
+	if settings.RecordFixtures {
+		handler = traced("record_fixtures", recordFixtures(handler, settings.FixturesDir, fixtureSecrets(settings)))
+		log.Printf("Recording request/response fixtures to %s", settings.FixturesDir)
+	}
+
+	handler = traced("body_limit", withBodyLimit(handler, settings.MaxRequestBytes))
+	handler = traced("recovery", withRecovery(handler))
+	handler = traced("request_log", withRequestLogging(handler, slog.Default()))
+	handler = withAPIVersion(withMetrics(handler, mux), mux, settings.LegacyRoutes)
+
+	if settings.debugEndpointsEnabled() {
+		handler = withMiddlewareTrace(handler)
+	}
+
+	return handler
 }
 
 func main() {
-	if err := godotenv.Load(); err != nil {
-		log.Printf("Warning: .env file not found")
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+	loadDotEnv(".env")
+	settings, problems := loadSettings()
+	if len(problems) > 0 {
+		log.Fatalf("Invalid configuration: %s", strings.Join(problems, "; "))
 	}
+	appLocation = settings.Location
+	trustProxy = settings.TrustProxy
+	cacheMaxEntries = settings.CacheMaxEntries
+	breakerThreshold, breakerCooldown = settings.CircuitBreakerThreshold, settings.CircuitBreakerCooldown
+	if settings.RateLimitRedis != nil {
+		rateLimitRedis = redis.NewClient(settings.RateLimitRedis)
+		if err := rateLimitRedis.Ping(context.Background()).Err(); err != nil {
+			log.Printf("Warning: cannot reach rate limit Redis, requests will be allowed until it is: %v", err)
+		}
+	}
+	if settings.DatabasePath != "" {
+		db, err := openDatabase(settings.DatabasePath)
+		if err != nil {
+			log.Fatalf("Cannot open DATABASE_PATH: %v", err)
+		}
+		database = db
+	} else {
+		log.Println("Warning: DATABASE_PATH is empty, the subscriber mirror, analytics, comments and links are kept in memory only")
+	}
+	auditHashSalt = settings.AuditHashSalt
+	subscribers = &subscriberMirror{db: database}
+	pageViews = &pageViewStore{db: database, retention: settings.AnalyticsRetention}
+	comments = &commentStore{db: database}
+	links = &linkStore{db: database}
 	
-    botToken := os.Getenv("TELEGRAM_BOT_TOKEN")
-    chatID := os.Getenv("TELEGRAM_CHAT_ID")
-	allowedOrigins := os.Getenv("ALLOWED_ORIGINS")
-
-	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{allowedOrigins},
-		AllowCredentials: true,
-	})
+	if len(settings.AllowedOrigins) == 0 {
+		log.Println("Warning: ALLOWED_ORIGINS is empty, cross-origin requests will be rejected")
+	}
 
 	mux := http.NewServeMux()
 
-	handler := c.Handler(mux)
-    
-    if botToken == "" || chatID == "" {
-        log.Fatal("TELEGRAM_BOT_TOKEN and TELEGRAM_CHAT_ID environment variables are required")
+	handler := newHandler(mux, settings)
+
+    auditLog.path = settings.AuditLogPath
+    telegramAPIBaseURL = settings.TelegramAPIBaseURL
+    beehiivAPIBaseURL = settings.BeehiivAPIBaseURL
+    spotifyAccountsBaseURL = settings.SpotifyAccountsBaseURL
+    spotifyAPIBaseURL = settings.SpotifyAPIBaseURL
+    githubAPIBaseURL = settings.GitHubAPIBaseURL
+    akismetAPIBaseURL = settings.AkismetAPIBaseURL
+    stripeAPIBaseURL = settings.StripeAPIBaseURL
+    httpClient = newHTTPClient(settings.HTTPClientTimeout)
+    beehiivClient = newBeehiivClient(httpClient, settings.BeehiivMaxRedirects)
+    client, err := newTelegramClient(httpClient, settings.TelegramSOCKS5Proxy)
+    if err != nil {
+        log.Fatalf("Invalid TELEGRAM_SOCKS5_PROXY: %v", err)
     }
-    
+    telegramClient = client
+
+    // API_MODE=dry-run swaps both upstreams for in-memory fakes that log
+    // each call; BEEHIIV_SANDBOX fakes Beehiiv alone.
+    telegram, beehiiv = newUpstreamAPIs(settings)
+    if settings.APIMode == "dry-run" {
+        log.Printf("API_MODE=dry-run: Telegram and Beehiiv calls are logged, not sent")
+    }
+
     config := Config{
-        BotToken: botToken,
-        ChatID:   chatID,
+        Settings:  settings,
+        BotToken:  settings.TelegramBotToken,
+        ChatID:    settings.TelegramChatID,
+        Bots:      settings.TelegramBots,
+        APICompat: settings.TelegramAPICompat,
+    }
+
+    if err := reloadTemplates(settings.TemplatesDir); err != nil {
+        log.Fatalf("Invalid TEMPLATES_DIR: %v", err)
     }
     
-    mux.HandleFunc("/send", func(w http.ResponseWriter, r *http.Request) {
+    if settings.SignupFeedChatID != "" {
+        signupFeedPoster = newSignupFeed(config, settings.SignupFeedChatID, settings.SignupFeedWindow)
+    }
+
+    if settings.WarmupTimeout > 0 {
+        go warmUp(config, settings.WarmupTimeout)
+    } else {
+        warmedUp.Store(true)
+    }
+
+    if settings.RateLimitPerMinute > 0 {
+        publicRequests = newRateLimiter("public_rate_limit", settings.RateLimitPerMinute, time.Minute)
+    }
+
+    // Route groups share their middleware: public routes are rate limited
+    // per IP, forms also take a captcha when CAPTCHA_ROUTES lists them, and
+    // admin routes need ADMIN_TOKEN.
+    api := newRouter(mux)
+    public := api.with(rateLimited(publicRequests))
+    forms := public.with(withCaptcha(config))
+    admin := api.with(requireAdmin(config)).requiring("adminToken")
+
+    api.handle("/", func(w http.ResponseWriter, r *http.Request) {
+        writeError(w, http.StatusNotFound, "not_found", "Not found")
+    })
+    api.handle("/health", func(w http.ResponseWriter, r *http.Request) {
+        handleHealth(w, r, config)
+    }, http.MethodGet, http.MethodHead)
+    api.handle("/healthz", handleHealthz, http.MethodGet, http.MethodHead)
+    api.handle("/readyz", handleReadyz, http.MethodGet, http.MethodHead)
+
+    subscribe := func(w http.ResponseWriter, r *http.Request) {
+        handleSubscribe(w, r, config)
+    }
+
+    deliveryQueue = newJobQueue(settings, map[string]http.HandlerFunc{
+        "send": func(w http.ResponseWriter, r *http.Request) {
+            handleSendMessage(w, r, config)
+        },
+        "subscribe": subscribe,
+    })
+
+    forms.post("/send", withIdempotency(withAsync("send", func(w http.ResponseWriter, r *http.Request) {
         handleSendMessage(w, r, config)
+    }), config))
+
+    forms.post("/send/photo", withIdempotency(func(w http.ResponseWriter, r *http.Request) {
+        handleSendPhoto(w, r, config)
+    }, config))
+
+    forms.post("/edit", func(w http.ResponseWriter, r *http.Request) {
+        handleEditMessage(w, r, config)
     })
 
-    mux.HandleFunc("/subscribe", handleSubscribe)
-    
-    port := os.Getenv("PORT")
-    if port == "" {
-        port = "4000"
+    forms.post("/send-venue", func(w http.ResponseWriter, r *http.Request) {
+        handleSendVenue(w, r, config)
+    })
+
+    forms.post("/send-contact", func(w http.ResponseWriter, r *http.Request) {
+        handleSendContact(w, r, config)
+    })
+
+    forms.post("/send-media-group", func(w http.ResponseWriter, r *http.Request) {
+        handleSendMediaGroup(w, r, config)
+    })
+
+    forms.post("/subscribe", withSpamFilter("subscribe", withIdempotency(withAsync("subscribe", subscribe), config), config))
+    public.get("/subscribe/confirm", func(w http.ResponseWriter, r *http.Request) {
+        handleSubscribeConfirm(w, r, config)
+    })
+    forms.handle("/unsubscribe", func(w http.ResponseWriter, r *http.Request) {
+        handleUnsubscribe(w, r, config)
+    }, http.MethodPost, http.MethodDelete)
+    public.get("/subscription/status", func(w http.ResponseWriter, r *http.Request) {
+        handleSubscriptionStatus(w, r, config)
+    })
+
+    forms.post("/contact", withSpamFilter("contact", withIdempotency(func(w http.ResponseWriter, r *http.Request) {
+        handleContactForm(w, r, config)
+    }, config), config))
+    forms.post("/batch", func(w http.ResponseWriter, r *http.Request) {
+        handleBatch(w, r, config)
+    })
+    public.get("/jobs/", handleJob)
+    public.post("/analytics/event", func(w http.ResponseWriter, r *http.Request) {
+        handleAnalyticsEvent(w, r, config)
+    })
+    public.handle("/comments", func(w http.ResponseWriter, r *http.Request) {
+        handleComments(w, r, config)
+    }, http.MethodGet, http.MethodPost)
+    public.handle("/comments/", func(w http.ResponseWriter, r *http.Request) {
+        handleComment(w, r, config)
+    }, http.MethodPost, http.MethodDelete)
+    admin.post("/links", func(w http.ResponseWriter, r *http.Request) {
+        handleCreateLink(w, r, config)
+    })
+    api.handle("/l/", handleRedirect, http.MethodGet, http.MethodHead)
+
+    subscriberLookups = newRateLimiter("subscriber_lookup_rate_limit", config.SubscriberLookupLimit, time.Minute)
+    admin.post("/subscribe-csv", func(w http.ResponseWriter, r *http.Request) {
+        handleSubscribeCSV(w, r, config)
+    })
+    admin.get("/subscriber", handleGetSubscriber)
+    admin.handle("/subscriber/update", func(w http.ResponseWriter, r *http.Request) {
+        handleUpdateSubscriber(w, r, config)
+    }, http.MethodPatch)
+    admin.get("/debug/ratelimit", handleRateLimitDebug)
+    admin.get("/debug/caches", handleCacheStats)
+
+    // Metrics go on METRICS_ADDR when set, keeping them off the public
+    // port; otherwise /metrics is served here behind the admin token.
+    if config.MetricsAddr != "" {
+        go serveMetrics(config.MetricsAddr)
+    } else {
+        admin.get("/metrics", promhttp.Handler().ServeHTTP)
+    }
+    api.get("/limits", func(w http.ResponseWriter, r *http.Request) {
+        handleLimits(w, r, config)
+    })
+
+    if settings.debugEndpointsEnabled() {
+        api.post("/debug/echo", func(w http.ResponseWriter, r *http.Request) {
+            handleDebugEcho(w, r, config)
+        })
+        api.handle("/debug/slow", func(w http.ResponseWriter, r *http.Request) {
+            handleDebugSlow(w, r, config)
+        })
+    }
+    admin.get("/admin/audit/export", handleAuditExport)
+    admin.get("/admin/subscribers/export", handleSubscriberExport)
+    admin.get("/admin/links", handleListLinks)
+    admin.get("/analytics/stats", handleAnalyticsStats)
+    admin.post("/admin/selftest", func(w http.ResponseWriter, r *http.Request) {
+        handleSelfTest(w, r, config)
+    })
+    admin.post("/broadcast", func(w http.ResponseWriter, r *http.Request) {
+        handleBroadcast(w, r, config)
+    })
+    admin.get("/broadcast/preview", func(w http.ResponseWriter, r *http.Request) {
+        handleBroadcastPreview(w, r, config)
+    })
+    admin.get("/recent", handleRecentMessages)
+
+    // The webhook is only served with a secret, since the secret is the
+    // only thing telling Telegram's requests apart from anyone else's.
+    if config.TelegramWebhookSecret != "" {
+        api.post("/telegram/webhook", func(w http.ResponseWriter, r *http.Request) {
+            handleTelegramWebhook(w, r, config)
+        })
+    }
+    if config.GitHubWebhookSecret != "" {
+        api.post("/webhooks/github", func(w http.ResponseWriter, r *http.Request) {
+            handleGitHubWebhook(w, r, config)
+        })
+    }
+    if config.StripeWebhookSecret != "" {
+        api.post("/webhooks/stripe", func(w http.ResponseWriter, r *http.Request) {
+            handleStripeWebhook(w, r, config)
+        })
+    }
+    if config.SponsorsWebhookSecret != "" {
+        api.post("/webhooks/sponsors", func(w http.ResponseWriter, r *http.Request) {
+            handleSponsorsWebhook(w, r, config)
+        })
+    }
+    if config.SpotifyRefreshToken != "" {
+        public.handle("/now-playing", func(w http.ResponseWriter, r *http.Request) {
+            handleNowPlaying(w, r, config)
+        }, http.MethodGet, http.MethodHead)
     }
+    if config.GitHubToken != "" {
+        public.handle("/github/stats", func(w http.ResponseWriter, r *http.Request) {
+            handleGitHubStats(w, r, config)
+        }, http.MethodGet, http.MethodHead)
+    }
+    api.get("/openapi.json", handleOpenAPI(api.routes, config))
+    api.get("/docs", handleDocs)
+    
+    port := strconv.Itoa(settings.Port)
     
-    fmt.Printf("Server running on port %s...\n", port)
-    if err := http.ListenAndServe(":"+port, c.Handler(handler)); err != nil {
+    srv := newServer(":"+port, handler, settings)
+
+    ln, err := net.Listen("tcp", srv.Addr)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    ln = limitListener(ln, settings.MaxConnections)
+
+    // The first SIGINT or SIGTERM starts a graceful shutdown and a second
+    // one cuts it short.
+    signals := make(chan os.Signal, 1)
+    signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+    ctx, stop := context.WithCancel(context.Background())
+    defer stop()
+
+    // SIGHUP reloads TEMPLATES_DIR.
+    hangups := make(chan os.Signal, 1)
+    signal.Notify(hangups, syscall.SIGHUP)
+    go reloadTemplatesOn(ctx, hangups, settings.TemplatesDir)
+
+    if settings.OutboxDir != "" {
+        go watchOutbox(ctx, config, settings.OutboxDir, settings.OutboxPollInterval)
+    }
+    deliveryQueue.run(ctx, settings.JobsWorkers)
+    runScheduler(ctx, scheduledTasksFor(config))
+    go watchReadiness(ctx, config)
+    if settings.ChatIDsURL != "" {
+        go watchBroadcastChats(ctx, config)
+    }
+    go links.run(ctx)
+
+    serveErr := make(chan error, 1)
+    go func() {
+        slog.Info("Server running", "port", port)
+        serveErr <- srv.Serve(ln)
+    }()
+
+    select {
+    case err := <-serveErr:
         log.Fatal(err)
+    case <-signals:
+    }
+    stop()
+
+    timeout := settings.ShutdownTimeout
+    log.Printf("Shutting down, waiting up to %s for in-flight requests; signal again to stop immediately", timeout)
+
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+    switch err := shutdownServer(shutdownCtx, srv, signals); {
+    case errors.Is(err, errShutdownInterrupted):
+        log.Println("Stopped without waiting for in-flight requests")
+        return
+    case err != nil:
+        log.Printf("Shutdown timed out: %v", err)
+    default:
+        log.Println("Shutdown complete")
+    }
+
+    // Background deliveries that are mid-attempt get the rest of the
+    // shutdown timeout to finish, then are cancelled; anything still queued
+    // stays in JOBS_DIR.
+    if !deliveryQueue.wait(shutdownCtx) {
+        log.Printf("Shutdown timed out waiting for background jobs")
+    }
+
+    if signupFeedPoster != nil {
+        signupFeedPoster.flush()
     }
+    links.flush()
 }
@@ -0,0 +1,674 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testConfig = Config{Settings: testSettings(), BotToken: "1:test", ChatID: "5"}
+
+// testSettings reads the settings the tests start from: the defaults,
+// unless the environment running them says otherwise.
+func testSettings() *Settings {
+	settings, _ := readSettings()
+	return settings
+}
+
+// configWith sets env, given as name and value pairs, for the rest of the
+// test and returns testConfig with the settings read from it, as main
+// would at startup.
+func configWith(t *testing.T, env ...string) Config {
+	t.Helper()
+	for i := 0; i+1 < len(env); i += 2 {
+		t.Setenv(env[i], env[i+1])
+	}
+	settings, problems := readSettings()
+	if len(problems) > 0 {
+		t.Fatalf("readSettings() problems: %v", problems)
+	}
+	config := testConfig
+	config.Settings = settings
+	return config
+}
+
+// bind gives handler config, as main does when it registers a route.
+func bind(handler func(http.ResponseWriter, *http.Request, Config), config Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r, config)
+	}
+}
+
+// useFakeUpstreams swaps the Telegram and Beehiiv clients for in-memory
+// fakes until the test ends.
+func useFakeUpstreams(t *testing.T) (*fakeTelegram, *fakeBeehiiv) {
+	t.Helper()
+	origTelegram, origBeehiiv := telegram, beehiiv
+	t.Cleanup(func() { telegram, beehiiv = origTelegram, origBeehiiv })
+
+	fakeTG, fakeBH := newFakeTelegram(), newFakeBeehiiv()
+	telegram, beehiiv = fakeTG, fakeBH
+	return fakeTG, fakeBH
+}
+
+// serve calls handler with a request for method and path carrying body as
+// JSON.
+func serve(handler http.HandlerFunc, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// sendHandler is /send without the route's middleware.
+func sendHandler(w http.ResponseWriter, r *http.Request) {
+	handleSendMessage(w, r, testConfig)
+}
+
+// subscribeHandler is /subscribe without the route's middleware.
+func subscribeHandler(w http.ResponseWriter, r *http.Request) {
+	handleSubscribe(w, r, testConfig)
+}
+
+// decodeError decodes an error response body.
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) ErrorResponse {
+	t.Helper()
+	var resp ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("error response %q: %v", rec.Body, err)
+	}
+	return resp
+}
+
+// sentText returns the text of the last message sent through fake.
+func sentText(t *testing.T, fake *fakeTelegram) string {
+	t.Helper()
+	calls := fake.Calls()
+	if len(calls) == 0 {
+		t.Fatal("nothing was sent to Telegram")
+	}
+	var msg TelegramMessage
+	if err := json.Unmarshal(calls[len(calls)-1].Body, &msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg.Text
+}
+
+// failingTelegram and failingBeehiiv answer every call with err.
+type failingTelegram struct{ err error }
+
+func (f failingTelegram) Call(ctx context.Context, token, method, contentType string, body []byte) (json.RawMessage, error) {
+	return nil, f.err
+}
+
+type failingBeehiiv struct{ err error }
+
+func (f failingBeehiiv) Do(ctx context.Context, method, path string, payload interface{}) ([]byte, error) {
+	return nil, f.err
+}
+
+// telegramFunc answers every Bot API call with a function of its method
+// and body.
+type telegramFunc func(method string, body []byte) (json.RawMessage, error)
+
+func (f telegramFunc) Call(ctx context.Context, token, method, contentType string, body []byte) (json.RawMessage, error) {
+	return f(method, body)
+}
+
+func TestErrorStatusCodes(t *testing.T) {
+	useFakeUpstreams(t)
+	upstreamDown := &UpstreamError{StatusCode: http.StatusInternalServerError}
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		body     string
+		upstream error
+		status   int
+	}{
+		{"send, malformed JSON", sendHandler, `{"message":`, nil, http.StatusBadRequest},
+		{"send, empty message", sendHandler, `{"message":""}`, nil, http.StatusBadRequest},
+		{"send, Telegram fails", sendHandler, `{"message":"hi"}`, upstreamDown, http.StatusBadGateway},
+		{"subscribe, malformed JSON", subscribeHandler, `{"email":`, nil, http.StatusBadRequest},
+		{"subscribe, empty email", subscribeHandler, `{"email":""}`, nil, http.StatusBadRequest},
+		{"subscribe, Beehiiv fails", subscribeHandler, `{"email":"status-codes@example.com"}`, upstreamDown, http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.upstream != nil {
+				defer func(tg TelegramAPI, bh BeehiivAPI) { telegram, beehiiv = tg, bh }(telegram, beehiiv)
+				telegram, beehiiv = failingTelegram{tt.upstream}, failingBeehiiv{tt.upstream}
+			}
+			rec := serve(tt.handler, http.MethodPost, "/", tt.body)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if resp := decodeError(t, rec); resp.Message == "" {
+				t.Errorf("body %s has no error message", rec.Body)
+			}
+		})
+	}
+}
+
+func TestSendMessageReturnsThreadID(t *testing.T) {
+	tests := []struct {
+		name   string
+		result string
+		want   int64
+	}{
+		{"forum topic", `{"message_id":7,"message_thread_id":42,"is_topic_message":true}`, 42},
+		{"plain chat", `{"message_id":7}`, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(orig TelegramAPI) { telegram = orig }(telegram)
+			telegram = telegramFunc(func(method string, body []byte) (json.RawMessage, error) {
+				return json.RawMessage(tt.result), nil
+			})
+
+			rec := serve(sendHandler, http.MethodPost, "/send", `{"message":"hello topic"}`)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			var resp struct {
+				MessageID       int64  `json:"message_id"`
+				MessageThreadID *int64 `json:"message_thread_id"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tt.want == 0 && resp.MessageThreadID != nil:
+				t.Errorf("message_thread_id = %d, want it omitted", *resp.MessageThreadID)
+			case tt.want != 0 && (resp.MessageThreadID == nil || *resp.MessageThreadID != tt.want):
+				t.Errorf("response %s, want message_thread_id %d", rec.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendMessageWithTemplate(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{"template and message", `{"template":"new_signup","data":{"email":"a@example.com"},"message":"hi"}`, http.StatusBadRequest, "message_and_template"},
+		{"unknown template", `{"template":"nope"}`, http.StatusBadRequest, "unknown_template"},
+		{"template only", `{"template":"new_signup","data":{"email":"<a@example.com>"}}`, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(fake.Calls())
+			rec := serve(sendHandler, http.MethodPost, "/send", tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.code != "" {
+				if got := decodeError(t, rec).Code; got != tt.code {
+					t.Errorf("code = %q, want %q", got, tt.code)
+				}
+				if len(fake.Calls()) != before {
+					t.Error("a rejected request was sent to Telegram")
+				}
+			}
+		})
+	}
+
+	// The rendered template escapes its data.
+	if got, want := sentText(t, fake), "<b>New signup</b>\n&lt;a@example.com&gt;"; got != want {
+		t.Errorf("sent %q, want %q", got, want)
+	}
+}
+
+// beehiivCalls counts the calls fake received for method and path.
+func beehiivCalls(fake *fakeBeehiiv, method, path string) int {
+	n := 0
+	for _, call := range fake.Calls() {
+		if call.Method == method && call.Path == path {
+			n++
+		}
+	}
+	return n
+}
+
+func TestSubscribeDedupKey(t *testing.T) {
+	_, fake := useFakeUpstreams(t)
+	t.Cleanup(func() {
+		for _, key := range []string{"form-1", "form-2", "form-3", "form-4"} {
+			for _, email := range []string{"dedup@example.com", "other@example.com"} {
+				subscribeDedup.release(email + "\n" + key)
+			}
+		}
+	})
+
+	tests := []struct {
+		name  string
+		first string
+		retry string
+		calls int
+	}{
+		{"same key", `{"email":"dedup@example.com","dedup_key":"form-1"}`, `{"email":"Dedup@Example.com","dedup_key":"form-1","utm_source":"retry"}`, 1},
+		{"different keys", `{"email":"dedup@example.com","dedup_key":"form-2"}`, `{"email":"dedup@example.com","dedup_key":"form-3"}`, 2},
+		{"different emails", `{"email":"dedup@example.com","dedup_key":"form-4"}`, `{"email":"other@example.com","dedup_key":"form-4"}`, 2},
+		{"no key", `{"email":"dedup@example.com"}`, `{"email":"dedup@example.com"}`, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := beehiivCalls(fake, http.MethodPost, "/subscriptions")
+			var bodies []string
+			for _, body := range []string{tt.first, tt.retry} {
+				rec := serve(subscribeHandler, http.MethodPost, "/subscribe", body)
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+				}
+				var resp struct {
+					Status string `json:"status"`
+					ID     string `json:"id"`
+				}
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				bodies = append(bodies, resp.Status+" "+resp.ID)
+			}
+			if got := beehiivCalls(fake, http.MethodPost, "/subscriptions") - before; got != tt.calls {
+				t.Errorf("Beehiiv was called %d times, want %d", got, tt.calls)
+			}
+			if tt.calls == 1 && bodies[0] != bodies[1] {
+				t.Errorf("retry got %q, want the first response %q", bodies[1], bodies[0])
+			}
+		})
+	}
+}
+
+func TestSubscribeDedupKeyWhileFirstIsRunning(t *testing.T) {
+	useFakeUpstreams(t)
+	key := "dedup@example.com\n" + uniqueKey(t)
+	t.Cleanup(func() { subscribeDedup.release(key) })
+	subscribeDedup.reserve(key, time.Minute)
+
+	_, dedupKey, _ := strings.Cut(key, "\n")
+	rec := serve(subscribeHandler, http.MethodPost, "/subscribe", `{"email":"dedup@example.com","dedup_key":"`+dedupKey+`"}`)
+	if rec.Code != http.StatusConflict || decodeError(t, rec).Code != "submission_in_progress" {
+		t.Errorf("got %d %s, want 409 submission_in_progress rather than a success", rec.Code, rec.Body)
+	}
+}
+
+func TestSubscribeDedupKeyReleasedOnFailure(t *testing.T) {
+	useFakeUpstreams(t)
+	subscribe := bind(handleSubscribe, configWith(t, "UPSTREAM_MAX_ATTEMPTS", "1"))
+	dedupKey := uniqueKey(t)
+	body := `{"email":"dedup@example.com","dedup_key":"` + dedupKey + `"}`
+	t.Cleanup(func() { subscribeDedup.release("dedup@example.com\n" + dedupKey) })
+
+	fakeBH := beehiiv
+	beehiiv = failingBeehiiv{err: errors.New("connection refused")}
+	if rec := serve(subscribe, http.MethodPost, "/subscribe", body); rec.Code < 500 {
+		t.Fatalf("with Beehiiv down: status = %d, want a 5xx", rec.Code)
+	}
+	beehiiv = fakeBH
+	if rec := serve(subscribe, http.MethodPost, "/subscribe", body); rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "already") {
+		t.Errorf("retry: %d %s, want it subscribed", rec.Code, rec.Body)
+	}
+}
+
+// auditEvents returns the recorded audit events of eventType.
+func auditEvents(t *testing.T, eventType string) []AuditEvent {
+	t.Helper()
+	var events []AuditEvent
+	err := auditLog.each(time.Time{}, time.Time{}, func(e AuditEvent) error {
+		if e.Type == eventType {
+			events = append(events, e)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestSubscribeConsent(t *testing.T) {
+	_, fake := useFakeUpstreams(t)
+
+	tests := []struct {
+		name    string
+		require string
+		body    string
+		status  int
+	}{
+		{"optional, not given", "false", `{"email":"consent-1@example.com"}`, http.StatusOK},
+		{"optional, given", "false", `{"email":"consent-2@example.com","consent_version":"v1"}`, http.StatusOK},
+		{"required, not given", "true", `{"email":"consent-3@example.com"}`, http.StatusBadRequest},
+		{"required, declined", "true", `{"email":"consent-4@example.com","consent_version":"v1","consent":false}`, http.StatusBadRequest},
+		{"required, declined as string", "true", `{"email":"consent-6@example.com","consent_version":"v1","consent":"false"}`, http.StatusBadRequest},
+		{"required, given", "true", `{"email":"consent-5@example.com","consent_version":"v2","consent":"true"}`, http.StatusOK},
+		{"required, given as bool", "true", `{"email":"consent-7@example.com","consent_version":"v2","consent":true}`, http.StatusOK},
+		{"invalid consent", "true", `{"email":"consent-8@example.com","consent_version":"v2","consent":"maybe"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := configWith(t, "REQUIRE_CONSENT", tt.require)
+			before := len(auditEvents(t, "consent"))
+
+			rec := serve(bind(handleSubscribe, config), http.MethodPost, "/subscribe", tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+
+			var req SubscribeRequest
+			json.Unmarshal([]byte(tt.body), &req)
+			events := auditEvents(t, "consent")[before:]
+			if rec.Code != http.StatusOK || req.ConsentVersion == "" {
+				if len(events) != 0 {
+					t.Errorf("recorded consent %v for a request without it", events)
+				}
+				return
+			}
+			if len(events) != 1 {
+				t.Fatalf("consent events = %v, want one", events)
+			}
+			fields := events[0].Fields
+			if fields["email"] != req.Email || fields["consent_version"] != req.ConsentVersion || fields["ip_hash"] == "" {
+				t.Errorf("consent event = %v", fields)
+			}
+			if events[0].Time.IsZero() {
+				t.Error("consent event has no timestamp")
+			}
+
+			calls := fake.Calls()
+			if !strings.Contains(string(calls[len(calls)-1].Body), `{"name":"consent_version","value":"`+req.ConsentVersion+`"}`) {
+				t.Errorf("consent_version wasn't sent to Beehiiv: %s", calls[len(calls)-1].Body)
+			}
+		})
+	}
+}
+
+func TestSubscribeDuplicate(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"errors":[{"message":"Email is already subscribed"}]}`))
+	}))
+	defer upstream.Close()
+
+	defer func(orig BeehiivAPI) { beehiiv = orig }(beehiiv)
+	beehiiv = newBeehiivAPIClient(upstream.URL, "", "pub_test", "key", upstream.Client())
+
+	tests := []struct {
+		setting string
+		status  int
+		body    string
+	}{
+		{"", http.StatusOK, `"status":"already_subscribed"`},
+		{"error", http.StatusConflict, `"message":"Email is already subscribed","code":"already_subscribed"`},
+	}
+	for _, tt := range tests {
+		t.Run("DUPLICATE_SUBSCRIBE_RESPONSE="+tt.setting, func(t *testing.T) {
+			config := configWith(t, "DUPLICATE_SUBSCRIBE_RESPONSE", tt.setting)
+			rec := serve(bind(handleSubscribe, config), http.MethodPost, "/subscribe", `{"email":"dup@example.com"}`)
+			if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.body) {
+				t.Errorf("got %d %s, want %d with %s", rec.Code, rec.Body, tt.status, tt.body)
+			}
+		})
+	}
+}
+
+func TestServerRejectsOversizedHeaders(t *testing.T) {
+	settings := configWith(t, "MAX_HEADER_BYTES", "1024").Settings
+	srv := newServer("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), settings)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		size   int
+		status int
+	}{
+		{100, http.StatusNoContent},
+		// net/http allows 4KB of slack over the limit.
+		{16 << 10, http.StatusRequestHeaderFieldsTooLarge},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+ln.Addr().String()+"/", nil)
+		req.Header.Set("X-Padding", strings.Repeat("a", tt.size))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%d byte header: status = %d, want %d", tt.size, resp.StatusCode, tt.status)
+		}
+	}
+}
+
+func TestLimitListenerQueuesExcessConnections(t *testing.T) {
+	entered := make(chan struct{}, 2)
+	release := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(limitListener(ln, 1))
+	defer srv.Close()
+
+	request := func() net.Conn {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n"))
+		return conn
+	}
+	first := request()
+	defer first.Close()
+	<-entered
+
+	// The second connection is made but not accepted while the first
+	// holds the only slot.
+	second := request()
+	defer second.Close()
+	select {
+	case <-entered:
+		t.Fatal("second connection was served beyond MAX_CONNECTIONS")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	io.Copy(io.Discard, first)
+	select {
+	case <-entered:
+	case <-time.After(2 * time.Second):
+		t.Fatal("queued connection was never served")
+	}
+}
+
+func TestSendMessageWhitespace(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+
+	tests := []struct {
+		name    string
+		allow   string
+		message string
+		status  int
+		sent    string
+	}{
+		{"normal", "", "  hello\n", http.StatusOK, "hello"},
+		{"whitespace only", "", " \n\t ", http.StatusBadRequest, ""},
+		{"whitespace only, allowed", "true", " \n\t ", http.StatusOK, " \n\t "},
+		{"empty, allowed", "true", "", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := configWith(t, "ALLOW_WHITESPACE_MESSAGES", tt.allow)
+			before := len(fake.Calls())
+			body, _ := json.Marshal(MessageRequest{Message: tt.message, ParseMode: "none"})
+			rec := serve(bind(handleSendMessage, config), http.MethodPost, "/send", string(body))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				if len(fake.Calls()) != before {
+					t.Error("a rejected message was sent")
+				}
+				return
+			}
+			if got := sentText(t, fake); got != tt.sent {
+				t.Errorf("sent %q, want %q", got, tt.sent)
+			}
+		})
+	}
+}
+
+func TestSendMessageBusinessConnection(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+
+	tests := []struct {
+		name   string
+		mode   string
+		body   string
+		status int
+		sent   string
+	}{
+		{"forwarded", "", `{"message":"hi","business_connection_id":"biz-1"}`, http.StatusOK, "biz-1"},
+		{"not given", "", `{"message":"hi"}`, http.StatusOK, ""},
+		{"required, given", "true", `{"message":"hi","business_connection_id":"biz-2"}`, http.StatusOK, "biz-2"},
+		{"required, not given", "true", `{"message":"hi"}`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := configWith(t, "TELEGRAM_BUSINESS_MODE", tt.mode)
+			before := len(fake.Calls())
+			rec := serve(bind(handleSendMessage, config), http.MethodPost, "/send", tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			calls := fake.Calls()
+			if tt.status != http.StatusOK {
+				if len(calls) != before {
+					t.Error("a rejected message was sent")
+				}
+				return
+			}
+			var msg TelegramMessage
+			if err := json.Unmarshal(calls[len(calls)-1].Body, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.BusinessConnectionID != tt.sent {
+				t.Errorf("business_connection_id = %q, want %q", msg.BusinessConnectionID, tt.sent)
+			}
+		})
+	}
+}
+
+func TestSendMessageVerbose(t *testing.T) {
+	useFakeUpstreams(t)
+
+	tests := []struct {
+		name    string
+		verbose bool
+	}{
+		{"compact", false},
+		{"verbose", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(MessageRequest{Message: "hi", Verbose: tt.verbose})
+			rec := serve(sendHandler, http.MethodPost, "/send", string(body))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+			}
+
+			var resp struct {
+				MessageID int64                      `json:"message_id"`
+				Telegram  map[string]json.RawMessage `json:"telegram"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.verbose {
+				if resp.Telegram != nil {
+					t.Errorf("compact response includes the Telegram result: %s", rec.Body)
+				}
+				return
+			}
+			for _, field := range []string{"message_id", "date", "chat", "text"} {
+				if _, ok := resp.Telegram[field]; !ok {
+					t.Errorf("Telegram result has no %s: %s", field, rec.Body)
+				}
+			}
+			if got := string(resp.Telegram["message_id"]); got != strconv.FormatInt(resp.MessageID, 10) {
+				t.Errorf("Telegram message_id = %s, want %d", got, resp.MessageID)
+			}
+		})
+	}
+}
+
+func TestSendMessageDeniedChatOverride(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+	t.Setenv("TELEGRAM_ALLOWED_CHAT_IDS", "777")
+
+	tests := []struct {
+		policy  string
+		chatID  string
+		status  int
+		sentTo  string
+		warning bool
+	}{
+		{"", "666", http.StatusBadRequest, "", false},
+		{"reject", "666", http.StatusBadRequest, "", false},
+		{"fallback", "666", http.StatusOK, testConfig.ChatID, true},
+		{"fallback", "777", http.StatusOK, "777", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy+"/"+tt.chatID, func(t *testing.T) {
+			config := configWith(t, "CHAT_OVERRIDE_POLICY", tt.policy)
+			before := len(fake.Calls())
+
+			rec := serve(bind(handleSendMessage, config), http.MethodPost, "/send", `{"message":"override","chat_id":"`+tt.chatID+`"}`)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				if got := decodeError(t, rec).Message; got != "Chat ID is not allowed" {
+					t.Errorf("error = %q", got)
+				}
+				if len(fake.Calls()) != before {
+					t.Error("a denied override was sent")
+				}
+				return
+			}
+
+			var resp struct {
+				Warning string `json:"warning"`
+			}
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if (resp.Warning != "") != tt.warning {
+				t.Errorf("warning = %q, want one: %v", resp.Warning, tt.warning)
+			}
+			var msg TelegramMessage
+			json.Unmarshal(fake.Calls()[len(fake.Calls())-1].Body, &msg)
+			if msg.ChatID != tt.sentTo {
+				t.Errorf("sent to chat %q, want %q", msg.ChatID, tt.sentTo)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"slices"
+)
+
+// withMaintenance answers every request with 503 while MAINTENANCE_MODE is
+// on, except the paths in exempt (health probes) and requests carrying
+// X-Maintenance-Bypass equal to secret, MAINTENANCE_BYPASS_SECRET, so
+// internal callers can still send critical alerts. Each bypass is logged.
+// Without a secret nothing can bypass.
+func withMaintenance(next http.Handler, secret string, exempt ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(exempt, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if secret != "" {
+			provided := r.Header.Get("X-Maintenance-Bypass")
+			if provided != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(secret)) == 1 {
+				log.Printf("Maintenance bypass: %s %s from %s", r.Method, r.URL.Path, clientIP(r))
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		w.Header().Set("Retry-After", "60")
+		writeError(w, http.StatusServiceUnavailable, "maintenance", "Service is down for maintenance, please retry later")
+	})
+}
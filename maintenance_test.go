@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMaintenanceBypass(t *testing.T) {
+	settings := configWith(t, "MAINTENANCE_MODE", "true", "MAINTENANCE_BYPASS_SECRET", "let-me-in").Settings
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	mux := http.NewServeMux()
+	for _, path := range []string{"/send", "/healthz"} {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+	handler := newHandler(mux, settings)
+
+	tests := []struct {
+		name   string
+		path   string
+		bypass string
+		status int
+	}{
+		{"no header", "/send", "", http.StatusServiceUnavailable},
+		{"wrong token", "/send", "let-me-out", http.StatusServiceUnavailable},
+		{"token prefix", "/send", "let-me", http.StatusServiceUnavailable},
+		{"correct token", "/send", "let-me-in", http.StatusNoContent},
+		{"health probe", "/healthz", "", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.Reset()
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			if tt.bypass != "" {
+				req.Header.Set("X-Maintenance-Bypass", tt.bypass)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if rec.Code == http.StatusServiceUnavailable {
+				if code := decodeError(t, rec).Code; code != "maintenance" {
+					t.Errorf("code = %q, want maintenance", code)
+				}
+				if rec.Header().Get("Retry-After") == "" {
+					t.Error("no Retry-After")
+				}
+			}
+
+			logged := strings.Contains(logs.String(), "Maintenance bypass: POST "+tt.path)
+			if bypassed := tt.bypass == "let-me-in"; logged != bypassed {
+				t.Errorf("bypass logged = %v, want %v: %q", logged, bypassed, logs.String())
+			}
+		})
+	}
+}
+
+func TestMaintenanceBypassNeedsSecret(t *testing.T) {
+	settings := configWith(t, "MAINTENANCE_MODE", "true", "MAINTENANCE_BYPASS_SECRET", "").Settings
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/send", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := newHandler(mux, settings)
+
+	for _, bypass := range []string{"", " "} {
+		req := httptest.NewRequest(http.MethodPost, "/send", nil)
+		req.Header.Set("X-Maintenance-Bypass", bypass)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("bypass %q without a secret: status = %d, want 503", bypass, rec.Code)
+		}
+	}
+
+	settings = configWith(t, "MAINTENANCE_MODE", "false").Settings
+	rec := httptest.NewRecorder()
+	newHandler(mux, settings).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/send", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("outside maintenance: status = %d, want 204", rec.Code)
+	}
+}
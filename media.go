@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Telegram accepts documents of up to 50 MB from bots; the default keeps
+// /send bodies closer to what /send/photo accepts.
+const defaultMaxDocumentBytes = 10 << 20
+
+// maxSendBodyBytes is the /send body limit, which leaves room for a base64
+// document_base64 attachment on top of MAX_BODY_BYTES.
+func maxSendBodyBytes(config Config) int64 {
+	return int64(base64.StdEncoding.EncodedLen(config.MaxDocumentBytes) + config.MaxBodyBytes)
+}
+
+// InlineButton is one button of an inline keyboard. Exactly one of URL and
+// CallbackData is set.
+type InlineButton struct {
+	Text         string `json:"text"`
+	URL          string `json:"url,omitempty"`
+	CallbackData string `json:"callback_data,omitempty"`
+}
+
+type inlineKeyboard struct {
+	InlineKeyboard [][]InlineButton `json:"inline_keyboard"`
+}
+
+type linkPreviewOptions struct {
+	IsDisabled bool `json:"is_disabled"`
+}
+
+// replyMarkup returns the keyboard for rows, or nil when there are none.
+func replyMarkup(rows [][]InlineButton) *inlineKeyboard {
+	if len(rows) == 0 {
+		return nil
+	}
+	return &inlineKeyboard{InlineKeyboard: rows}
+}
+
+// validateButtons checks an inline keyboard against Telegram's rules, so a
+// bad button is reported per field instead of as an opaque Bot API error.
+func validateButtons(rows [][]InlineButton) string {
+	for _, row := range rows {
+		if len(row) == 0 {
+			return "Button rows cannot be empty"
+		}
+		for _, b := range row {
+			switch {
+			case b.Text == "":
+				return "Button text cannot be empty"
+			case (b.URL == "") == (b.CallbackData == ""):
+				return "Each button needs exactly one of url or callback_data"
+			case len(b.CallbackData) > 64:
+				return "Button callback_data is longer than 64 bytes"
+			}
+			if b.URL != "" {
+				u, err := url.Parse(b.URL)
+				if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "tg") {
+					return "Button url must be an http, https or tg URL"
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// hasMedia reports whether req sends a photo or document, with Message as
+// its caption.
+func (req MessageRequest) hasMedia() bool {
+	return req.PhotoURL != "" || req.DocumentURL != "" || req.DocumentBase64 != ""
+}
+
+// mediaFor validates the attachment fields of req and returns the Bot API
+// method, the form field the file goes in, the file reference (a URL) and
+// the decoded upload, if any, of at most maxDocumentBytes.
+func mediaFor(req MessageRequest, maxDocumentBytes int) (method, field, ref string, data []byte, errMsg string) {
+	set := 0
+	for _, v := range []string{req.PhotoURL, req.DocumentURL, req.DocumentBase64} {
+		if v != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return "", "", "", nil, "Only one of photo_url, document_url or document_base64 can be set"
+	}
+
+	validURL := func(raw string) bool {
+		u, err := url.Parse(raw)
+		return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+	}
+
+	switch {
+	case req.PhotoURL != "":
+		if !validURL(req.PhotoURL) {
+			return "", "", "", nil, "Photo URL must be an http or https URL"
+		}
+		return "sendPhoto", "photo", req.PhotoURL, nil, ""
+
+	case req.DocumentURL != "":
+		if !validURL(req.DocumentURL) {
+			return "", "", "", nil, "Document URL must be an http or https URL"
+		}
+		return "sendDocument", "document", req.DocumentURL, nil, ""
+	}
+
+	encoded := req.DocumentBase64
+	if strings.HasPrefix(encoded, "data:") {
+		if _, after, ok := strings.Cut(encoded, ","); ok {
+			encoded = after
+		}
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(decoded) == 0 {
+		return "", "", "", nil, "Document is not valid base64"
+	}
+	if len(decoded) > maxDocumentBytes {
+		return "", "", "", nil, "Document is too large"
+	}
+	return "sendDocument", "document", "", decoded, ""
+}
+
+// sendTelegramMedia sends a photo or document with caption. A file given by
+// reference is fetched by Telegram; data is uploaded as multipart form data
+// under filename.
+func sendTelegramMedia(ctx context.Context, config Config, method, field, ref string, data []byte, filename, caption string, opts SendOptions) (*TelegramSentMessage, error) {
+	if err := paceChat(ctx, config); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]interface{}{"chat_id": config.ChatID}
+	if caption != "" {
+		fields["caption"] = caption
+		if parseMode := resolveParseMode(opts, caption); parseMode != "" {
+			fields["parse_mode"] = parseMode
+		}
+	}
+	if opts.BusinessConnectionID != "" {
+		fields["business_connection_id"] = opts.BusinessConnectionID
+	}
+	if opts.Silent {
+		fields["disable_notification"] = true
+	}
+	if markup := replyMarkup(opts.Buttons); markup != nil {
+		fields["reply_markup"] = markup
+	}
+
+	var result json.RawMessage
+	var err error
+	if data == nil {
+		fields[field] = ref
+		result, err = callTelegram(ctx, config, method, fields)
+	} else {
+		result, err = uploadTelegramFile(ctx, config, method, field, data, filename, fields)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var sent TelegramSentMessage
+	if err := json.Unmarshal(result, &sent); err != nil {
+		return nil, fmt.Errorf("error decoding response: %v", err)
+	}
+	sent.Raw = result
+	return &sent, nil
+}
+
+// uploadFile is a file attached to a multipart Bot API request under field.
+type uploadFile struct {
+	field    string
+	filename string
+	data     []byte
+}
+
+// uploadTelegramFile posts fields as multipart form data with data attached
+// as field. Strings are sent as-is and anything else, like the keyboard, as
+// JSON, which is what the Bot API expects of form fields.
+func uploadTelegramFile(ctx context.Context, config Config, method, field string, data []byte, filename string, fields map[string]interface{}) (json.RawMessage, error) {
+	return uploadTelegramFiles(ctx, config, method, []uploadFile{{field: field, filename: filename, data: data}}, fields)
+}
+
+// uploadTelegramFiles is uploadTelegramFile for any number of files.
+func uploadTelegramFiles(ctx context.Context, config Config, method string, files []uploadFile, fields map[string]interface{}) (json.RawMessage, error) {
+	var payload interface{} = fields
+	if config.APICompat != nil {
+		var err error
+		if payload, err = applyAPICompat(fields, *config.APICompat); err != nil {
+			return nil, fmt.Errorf("error creating upload: %v", err)
+		}
+	}
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error creating upload: %v", err)
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &values); err != nil {
+		return nil, fmt.Errorf("error creating upload: %v", err)
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range values {
+		var s string
+		if json.Unmarshal(value, &s) == nil {
+			form.WriteField(name, s)
+		} else {
+			form.WriteField(name, string(value))
+		}
+	}
+
+	for _, file := range files {
+		filename := path.Base(file.filename)
+		if filename == "." || filename == "/" {
+			filename = file.field
+		}
+		part, err := form.CreateFormFile(file.field, filename)
+		if err != nil {
+			return nil, fmt.Errorf("error creating upload: %v", err)
+		}
+		part.Write(file.data)
+	}
+	if err := form.Close(); err != nil {
+		return nil, fmt.Errorf("error creating upload: %v", err)
+	}
+
+	return postTelegram(ctx, config, method, form.FormDataContentType(), body.Bytes())
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidateButtons(t *testing.T) {
+	tests := []struct {
+		rows [][]InlineButton
+		ok   bool
+	}{
+		{nil, true},
+		{[][]InlineButton{{{Text: "Open", URL: "https://example.com"}, {Text: "Ack", CallbackData: "ack"}}}, true},
+		{[][]InlineButton{{{Text: "Chat", URL: "tg://resolve?domain=example"}}}, true},
+		{[][]InlineButton{{}}, false},
+		{[][]InlineButton{{{URL: "https://example.com"}}}, false},
+		{[][]InlineButton{{{Text: "Neither"}}}, false},
+		{[][]InlineButton{{{Text: "Both", URL: "https://example.com", CallbackData: "x"}}}, false},
+		{[][]InlineButton{{{Text: "Long", CallbackData: strings.Repeat("x", 65)}}}, false},
+		{[][]InlineButton{{{Text: "Script", URL: "javascript:alert(1)"}}}, false},
+	}
+	for _, tt := range tests {
+		if msg := validateButtons(tt.rows); (msg == "") != tt.ok {
+			t.Errorf("%+v: %q, want ok = %t", tt.rows, msg, tt.ok)
+		}
+	}
+}
+
+func TestMediaFor(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString([]byte("report"))
+
+	tests := []struct {
+		name   string
+		req    MessageRequest
+		method string
+		ref    string
+		data   string
+		ok     bool
+	}{
+		{"photo", MessageRequest{PhotoURL: "https://example.com/a.png"}, "sendPhoto", "https://example.com/a.png", "", true},
+		{"document URL", MessageRequest{DocumentURL: "http://example.com/a.pdf"}, "sendDocument", "http://example.com/a.pdf", "", true},
+		{"upload", MessageRequest{DocumentBase64: encoded}, "sendDocument", "", "report", true},
+		{"data URL", MessageRequest{DocumentBase64: "data:text/plain;base64," + encoded}, "sendDocument", "", "report", true},
+		{"two attachments", MessageRequest{PhotoURL: "https://example.com/a.png", DocumentURL: "https://example.com/a.pdf"}, "", "", "", false},
+		{"file URL", MessageRequest{PhotoURL: "file:///etc/passwd"}, "", "", "", false},
+		{"no host", MessageRequest{DocumentURL: "https:///a.pdf"}, "", "", "", false},
+		{"not base64", MessageRequest{DocumentBase64: "not base64!"}, "", "", "", false},
+		{"too large", MessageRequest{DocumentBase64: base64.StdEncoding.EncodeToString([]byte("too large"))}, "", "", "", false},
+	}
+	for _, tt := range tests {
+		method, _, ref, data, msg := mediaFor(tt.req, 8)
+		if (msg == "") != tt.ok || method != tt.method || ref != tt.ref || string(data) != tt.data {
+			t.Errorf("%s: got %s %q %q %q", tt.name, method, ref, data, msg)
+		}
+	}
+}
+
+func TestSendPhotoByURL(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+
+	rec := serve(sendHandler, http.MethodPost, "/send", `{"message":"Look","photo_url":"https://example.com/a.png",
+		"buttons":[[{"text":"Open","url":"https://example.com"}]]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	calls := fake.Calls()
+	if len(calls) != 1 || calls[0].Method != "sendPhoto" {
+		t.Fatalf("calls = %+v", calls)
+	}
+	var sent struct {
+		ChatID      string         `json:"chat_id"`
+		Photo       string         `json:"photo"`
+		Caption     string         `json:"caption"`
+		ReplyMarkup inlineKeyboard `json:"reply_markup"`
+	}
+	json.Unmarshal(calls[0].Body, &sent)
+	if sent.ChatID != testConfig.ChatID || sent.Photo != "https://example.com/a.png" || sent.Caption != "Look" {
+		t.Errorf("sent = %s", calls[0].Body)
+	}
+	if len(sent.ReplyMarkup.InlineKeyboard) != 1 || sent.ReplyMarkup.InlineKeyboard[0][0].Text != "Open" {
+		t.Errorf("reply_markup = %+v", sent.ReplyMarkup)
+	}
+}
+
+func TestSendRejectsBadAttachments(t *testing.T) {
+	useFakeUpstreams(t)
+
+	tests := []struct {
+		body string
+		code string
+	}{
+		{`{"message":"x","photo_url":"ftp://example.com/a.png"}`, "invalid_attachment"},
+		{`{"message":"x","document_base64":"%%%"}`, "invalid_attachment"},
+		{`{"message":"` + strings.Repeat("x", maxCaptionLength+1) + `","photo_url":"https://example.com/a.png"}`, "caption_too_long"},
+	}
+	for _, tt := range tests {
+		rec := serve(sendHandler, http.MethodPost, "/send", tt.body)
+		if rec.Code != http.StatusBadRequest || decodeError(t, rec).Code != tt.code {
+			t.Errorf("%.60s: got %d %s, want 400 %s", tt.body, rec.Code, rec.Body, tt.code)
+		}
+	}
+}
+
+// uploadRecorder keeps the content type and body of the Bot API calls it
+// answers.
+type uploadRecorder struct {
+	contentType string
+	body        []byte
+}
+
+func (u *uploadRecorder) Call(ctx context.Context, token, method, contentType string, body []byte) (json.RawMessage, error) {
+	u.contentType, u.body = contentType, body
+	return json.RawMessage(`{"message_id":9,"chat":{"id":5}}`), nil
+}
+
+func TestSendDocumentUpload(t *testing.T) {
+	useFakeUpstreams(t)
+	upload := &uploadRecorder{}
+	telegram = upload
+
+	data := base64.StdEncoding.EncodeToString([]byte("%PDF-1.7"))
+	rec := serve(sendHandler, http.MethodPost, "/send", `{"message":"Report","document_base64":"`+data+`",
+		"document_name":"../../etc/report.pdf","disable_notification":true,
+		"buttons":[[{"text":"Ack","callback_data":"ack"}]]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(upload.contentType)
+	if err != nil || mediaType != "multipart/form-data" {
+		t.Fatalf("content type = %q", upload.contentType)
+	}
+	form, err := multipart.NewReader(strings.NewReader(string(upload.body)), params["boundary"]).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := form.Value["chat_id"]; len(got) != 1 || got[0] != testConfig.ChatID {
+		t.Errorf("chat_id = %v", got)
+	}
+	if got := form.Value["caption"]; len(got) != 1 || got[0] != "Report" {
+		t.Errorf("caption = %v", got)
+	}
+	if got := form.Value["disable_notification"]; len(got) != 1 || got[0] != "true" {
+		t.Errorf("disable_notification = %v", got)
+	}
+	var markup inlineKeyboard
+	if err := json.Unmarshal([]byte(form.Value["reply_markup"][0]), &markup); err != nil || markup.InlineKeyboard[0][0].CallbackData != "ack" {
+		t.Errorf("reply_markup = %v, want the keyboard as JSON", form.Value["reply_markup"])
+	}
+
+	files := form.File["document"]
+	if len(files) != 1 || files[0].Filename != "report.pdf" {
+		t.Fatalf("document = %+v, want report.pdf without its directories", files)
+	}
+	f, _ := files[0].Open()
+	content, _ := io.ReadAll(f)
+	if string(content) != "%PDF-1.7" {
+		t.Errorf("document content = %q", content)
+	}
+}
+
+func TestMaxSendBodyBytesLeavesRoomForADocument(t *testing.T) {
+	config := configWith(t, "MAX_DOCUMENT_BYTES", "300", "MAX_BODY_BYTES", "1000")
+	if got := maxSendBodyBytes(config); got != 1400 {
+		t.Errorf("maxSendBodyBytes = %d, want 1000 plus 300 bytes base64-encoded", got)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Telegram sends albums of 2 to 10 items.
+const (
+	minMediaGroupSize = 2
+	maxMediaGroupSize = 10
+)
+
+// Uploads in one album share this budget, decoded.
+const defaultMaxMediaGroupBytes = 20 << 20
+
+type MediaGroupRequest struct {
+	ChatID              string           `json:"chat_id,omitempty"`
+	Target              string           `json:"target,omitempty"`
+	ParseMode           string           `json:"parse_mode,omitempty"`
+	DisableNotification bool             `json:"disable_notification,omitempty"`
+	Media               []MediaGroupItem `json:"media"`
+}
+
+// MediaGroupItem is one photo or document of an album, given by URL for
+// Telegram to fetch or as base64 to upload.
+type MediaGroupItem struct {
+	Type     string `json:"type"`
+	URL      string `json:"url,omitempty"`
+	Base64   string `json:"base64,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	Caption  string `json:"caption,omitempty"`
+}
+
+// telegramInputMedia is a MediaGroupItem as sendMediaGroup takes it. Media
+// is the URL, or attach://<field> for an upload.
+type telegramInputMedia struct {
+	Type      string `json:"type"`
+	Media     string `json:"media"`
+	Caption   string `json:"caption,omitempty"`
+	ParseMode string `json:"parse_mode,omitempty"`
+}
+
+// MediaGroupEvent is one line of a streamed /send-media-group response:
+// upload_start and upload_complete for each item in order, then the result
+// with the status and body a non-streaming client would have received.
+type MediaGroupEvent struct {
+	Event  string          `json:"event"`
+	Index  *int            `json:"index,omitempty"`
+	Bytes  int             `json:"bytes,omitempty"`
+	Status int             `json:"status,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// validateMediaGroup checks an album against Telegram's rules, so a bad
+// item is reported by index instead of as an opaque Bot API error. Base64
+// is decoded later, as each item is uploaded.
+func validateMediaGroup(items []MediaGroupItem) string {
+	if len(items) < minMediaGroupSize || len(items) > maxMediaGroupSize {
+		return fmt.Sprintf("Media group must have %d to %d items", minMediaGroupSize, maxMediaGroupSize)
+	}
+	for i, item := range items {
+		switch {
+		case item.Type != "photo" && item.Type != "document":
+			return fmt.Sprintf("Item %d: type must be photo or document", i)
+		case item.Type != items[0].Type:
+			return "Photos and documents cannot be mixed in one media group"
+		case (item.URL == "") == (item.Base64 == ""):
+			return fmt.Sprintf("Item %d: exactly one of url or base64 is required", i)
+		case item.URL != "":
+			if u, err := url.Parse(item.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Sprintf("Item %d: url must be an http or https URL", i)
+			}
+		}
+		if messageLength(item.Caption) > maxCaptionLength {
+			return fmt.Sprintf("Item %d: caption is longer than %d characters", i, maxCaptionLength)
+		}
+	}
+	return ""
+}
+
+// decodeMediaItem decodes an item's base64, accepting data URLs as
+// produced by FileReader.readAsDataURL.
+func decodeMediaItem(item MediaGroupItem) ([]byte, error) {
+	encoded := item.Base64
+	if strings.HasPrefix(encoded, "data:") {
+		if _, after, ok := strings.Cut(encoded, ","); ok {
+			encoded = after
+		}
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("not valid base64")
+	}
+	return data, nil
+}
+
+// handleSendMediaGroup sends 2 to 10 photos or documents as one album.
+// Clients that accept NDJSON get upload_start and upload_complete events
+// as each item is decoded and attached to the upload, then the result;
+// everyone else gets the result alone. Requests that fail validation get a
+// plain JSON error either way.
+func handleSendMediaGroup(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	maxBytes := config.MaxMediaGroupBytes
+	var req MediaGroupRequest
+	if !decodeBodyLimit(w, r, &req, int64(base64.StdEncoding.EncodedLen(maxBytes)+config.MaxBodyBytes)) {
+		return
+	}
+
+	if msg := validateMediaGroup(req.Media); msg != "" {
+		writeError(w, http.StatusBadRequest, "invalid_media_group", msg)
+		return
+	}
+
+	opts, msg := sendOptionsFor(MessageRequest{ParseMode: req.ParseMode, DisableNotification: req.DisableNotification}, config)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", msg)
+		return
+	}
+
+	chatID, warning, msg := chatIDFor(MessageRequest{ChatID: req.ChatID, Target: req.Target}, config)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, "invalid_chat_target", msg)
+		return
+	}
+	config.ChatID = chatID
+
+	progress := func(MediaGroupEvent) {}
+	flusher, ok := w.(http.Flusher)
+	if ok && strings.Contains(r.Header.Get("Accept"), "application/x-ndjson") {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		progress = func(event MediaGroupEvent) {
+			enc.Encode(event)
+			flusher.Flush()
+		}
+
+		// The response itself is recorded and sent as the last event.
+		rec := &batchRecorder{header: w.Header().Clone()}
+		defer func() {
+			progress(MediaGroupEvent{Event: "result", Status: rec.status, Body: json.RawMessage(strings.TrimSpace(rec.body.String()))})
+		}()
+		w = rec
+	}
+
+	messageIDs, err := sendMediaGroup(r.Context(), config, req, opts, maxBytes, progress)
+	var itemErr *mediaItemError
+	switch {
+	case errors.As(err, &itemErr):
+		writeError(w, itemErr.status, itemErr.code, itemErr.Error())
+		return
+	case err != nil:
+		writeUpstreamError(w, err)
+		return
+	}
+
+	ids := make([]string, len(messageIDs))
+	for i, id := range messageIDs {
+		ids[i] = strconv.FormatInt(id, 10)
+	}
+	auditLog.record("send_media_group", map[string]string{
+		"chat_id":     config.ChatID,
+		"message_ids": strings.Join(ids, ","),
+	})
+
+	resp := map[string]interface{}{
+		"status":      "Media group sent successfully",
+		"message_ids": messageIDs,
+	}
+	if warning != "" {
+		resp["warning"] = warning
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// mediaItemError is an item that turned out to be unusable while it was
+// being uploaded.
+type mediaItemError struct {
+	status int
+	code   string
+	index  int
+	reason string
+}
+
+func (e *mediaItemError) Error() string {
+	return fmt.Sprintf("Item %d: %s", e.index, e.reason)
+}
+
+// sendMediaGroup attaches each item in order, reporting progress, and
+// sends the album. It returns the ID of each message in the album.
+func sendMediaGroup(ctx context.Context, config Config, req MediaGroupRequest, opts SendOptions, maxBytes int, progress func(MediaGroupEvent)) ([]int64, error) {
+	media := make([]telegramInputMedia, len(req.Media))
+	var files []uploadFile
+	total := 0
+	for i, item := range req.Media {
+		progress(MediaGroupEvent{Event: "upload_start", Index: &i})
+
+		media[i] = telegramInputMedia{Type: item.Type, Media: item.URL, Caption: item.Caption}
+		if item.Caption != "" {
+			media[i].ParseMode = resolveParseMode(opts, item.Caption)
+		}
+		size := 0
+		if item.Base64 != "" {
+			data, err := decodeMediaItem(item)
+			if err != nil {
+				return nil, &mediaItemError{status: http.StatusBadRequest, code: "invalid_media_group", index: i, reason: err.Error()}
+			}
+			if total += len(data); total > maxBytes {
+				return nil, &mediaItemError{status: http.StatusRequestEntityTooLarge, code: "body_too_large", index: i, reason: "media group is too large"}
+			}
+			field := "file" + strconv.Itoa(i)
+			files = append(files, uploadFile{field: field, filename: item.Filename, data: data})
+			media[i].Media = "attach://" + field
+			size = len(data)
+		}
+
+		progress(MediaGroupEvent{Event: "upload_complete", Index: &i, Bytes: size})
+	}
+
+	if err := paceChat(ctx, config); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]interface{}{"chat_id": config.ChatID, "media": media}
+	if opts.Silent {
+		fields["disable_notification"] = true
+	}
+	var result json.RawMessage
+	var err error
+	if files == nil {
+		result, err = callTelegram(ctx, config, "sendMediaGroup", fields)
+	} else {
+		result, err = uploadTelegramFiles(ctx, config, "sendMediaGroup", files, fields)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var sent []TelegramSentMessage
+	if err := json.Unmarshal(result, &sent); err != nil {
+		return nil, fmt.Errorf("error decoding response: %v", err)
+	}
+	messageIDs := make([]int64, len(sent))
+	for i, msg := range sent {
+		messageIDs[i] = msg.MessageID
+	}
+	return messageIDs, nil
+}
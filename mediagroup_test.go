@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func mediaGroupHandler(w http.ResponseWriter, r *http.Request) {
+	handleSendMediaGroup(w, r, testConfig)
+}
+
+// serveMediaGroupStream posts body to /send-media-group asking for NDJSON
+// and returns the events.
+func serveMediaGroupStream(t *testing.T, body string) []MediaGroupEvent {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/send-media-group", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/x-ndjson")
+	rec := httptest.NewRecorder()
+	mediaGroupHandler(rec, req)
+
+	if got := rec.Header().Get("Content-Type"); got != "application/x-ndjson" {
+		t.Fatalf("Content-Type = %q, want application/x-ndjson: %s", got, rec.Body)
+	}
+	var events []MediaGroupEvent
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var event MediaGroupEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("event %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestValidateMediaGroup(t *testing.T) {
+	photo := MediaGroupItem{Type: "photo", URL: "https://example.com/a.jpg"}
+	tests := []struct {
+		name  string
+		items []MediaGroupItem
+		want  string
+	}{
+		{"valid", []MediaGroupItem{photo, {Type: "photo", Base64: "aW1n"}}, ""},
+		{"one item", []MediaGroupItem{photo}, "Media group must have 2 to 10 items"},
+		{"eleven items", make([]MediaGroupItem, 11), "Media group must have 2 to 10 items"},
+		{"bad type", []MediaGroupItem{photo, {Type: "video", URL: photo.URL}}, "Item 1: type must be photo or document"},
+		{"mixed", []MediaGroupItem{photo, {Type: "document", URL: photo.URL}}, "Photos and documents cannot be mixed in one media group"},
+		{"no source", []MediaGroupItem{photo, {Type: "photo"}}, "Item 1: exactly one of url or base64 is required"},
+		{"two sources", []MediaGroupItem{photo, {Type: "photo", URL: photo.URL, Base64: "aW1n"}}, "Item 1: exactly one of url or base64 is required"},
+		{"bad url", []MediaGroupItem{{Type: "photo", URL: "ftp://example.com/a.jpg"}, photo}, "Item 0: url must be an http or https URL"},
+		{"long caption", []MediaGroupItem{photo, {Type: "photo", URL: photo.URL, Caption: strings.Repeat("a", maxCaptionLength+1)}}, "Item 1: caption is longer than 1024 characters"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validateMediaGroup(tt.items); got != tt.want {
+				t.Errorf("validateMediaGroup() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendMediaGroup(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+
+	rec := serve(mediaGroupHandler, http.MethodPost, "/send-media-group",
+		`{"media":[{"type":"photo","url":"https://example.com/a.jpg","caption":"*a*"},{"type":"photo","url":"https://example.com/b.jpg"}],"parse_mode":"MarkdownV2"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		MessageIDs []int64 `json:"message_ids"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if len(resp.MessageIDs) != 2 {
+		t.Errorf("message_ids = %v, want two", resp.MessageIDs)
+	}
+
+	calls := fake.Calls()
+	if len(calls) != 1 || calls[0].Method != "sendMediaGroup" {
+		t.Fatalf("calls = %v, want one sendMediaGroup", calls)
+	}
+	var sent struct {
+		ChatID string               `json:"chat_id"`
+		Media  []telegramInputMedia `json:"media"`
+	}
+	json.Unmarshal(calls[0].Body, &sent)
+	if sent.ChatID != testConfig.ChatID || len(sent.Media) != 2 {
+		t.Fatalf("sent %s", calls[0].Body)
+	}
+	if sent.Media[0].ParseMode != "MarkdownV2" || sent.Media[1].ParseMode != "" {
+		t.Errorf("parse modes = %q, %q; want MarkdownV2 on the captioned item only", sent.Media[0].ParseMode, sent.Media[1].ParseMode)
+	}
+}
+
+func TestSendMediaGroupUpload(t *testing.T) {
+	useFakeUpstreams(t)
+	var upload []byte
+	telegram = telegramFunc(func(method string, body []byte) (json.RawMessage, error) {
+		upload = body
+		return json.RawMessage(`[{"message_id":7},{"message_id":8}]`), nil
+	})
+
+	rec := serve(mediaGroupHandler, http.MethodPost, "/send-media-group",
+		`{"media":[{"type":"document","base64":"aW1n","filename":"../a.txt"},{"type":"document","url":"https://example.com/b.pdf"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	for _, want := range []string{`attach://file0`, `name="file0"; filename="a.txt"`, "img"} {
+		if !bytes.Contains(upload, []byte(want)) {
+			t.Errorf("upload is missing %q:\n%s", want, upload)
+		}
+	}
+}
+
+func TestSendMediaGroupProgress(t *testing.T) {
+	useFakeUpstreams(t)
+
+	events := serveMediaGroupStream(t, `{"media":[{"type":"photo","base64":"aW1n"},{"type":"photo","url":"https://example.com/b.jpg"}]}`)
+	want := []string{"upload_start 0", "upload_complete 0", "upload_start 1", "upload_complete 1", "result"}
+	if len(events) != len(want) {
+		t.Fatalf("events = %+v, want %v", events, want)
+	}
+	for i, event := range events {
+		got := event.Event
+		if event.Index != nil {
+			got += " " + strconv.Itoa(*event.Index)
+		}
+		if got != want[i] {
+			t.Errorf("event %d = %q, want %q", i, got, want[i])
+		}
+	}
+	if events[1].Bytes != 3 {
+		t.Errorf("upload_complete bytes = %d, want 3", events[1].Bytes)
+	}
+	result := events[len(events)-1]
+	if result.Status != http.StatusOK || !bytes.Contains(result.Body, []byte(`"message_ids":[1,2]`)) {
+		t.Errorf("result = %d %s", result.Status, result.Body)
+	}
+}
+
+func TestSendMediaGroupFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		stream bool
+		status int
+		code   string
+	}{
+		{"invalid group", `{"media":[{"type":"photo","url":"https://example.com/a.jpg"}]}`, false, http.StatusBadRequest, "invalid_media_group"},
+		{"invalid group while streaming", `{"media":[{"type":"photo","url":"https://example.com/a.jpg"}]}`, true, http.StatusBadRequest, "invalid_media_group"},
+		{"bad base64", `{"media":[{"type":"photo","url":"https://example.com/a.jpg"},{"type":"photo","base64":"%%%"}]}`, false, http.StatusBadRequest, "invalid_media_group"},
+		{"bad base64 while streaming", `{"media":[{"type":"photo","url":"https://example.com/a.jpg"},{"type":"photo","base64":"%%%"}]}`, true, http.StatusBadRequest, "invalid_media_group"},
+		{"too large", `{"media":[{"type":"photo","base64":"aW1n"},{"type":"photo","base64":"aW1n"}]}`, false, http.StatusRequestEntityTooLarge, "body_too_large"},
+		{"bad parse mode", `{"media":[{"type":"photo","base64":"aW1n"},{"type":"photo","base64":"aW1n"}],"parse_mode":"BBCode"}`, false, http.StatusBadRequest, "invalid_request"},
+		{"bad target", `{"media":[{"type":"photo","base64":"aW1n"},{"type":"photo","base64":"aW1n"}],"target":"nowhere"}`, false, http.StatusBadRequest, "invalid_chat_target"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, _ := useFakeUpstreams(t)
+			config := configWith(t, "MAX_MEDIA_GROUP_BYTES", "5")
+
+			req := httptest.NewRequest(http.MethodPost, "/send-media-group", strings.NewReader(tt.body))
+			if tt.stream {
+				req.Header.Set("Accept", "application/x-ndjson")
+			}
+			rec := httptest.NewRecorder()
+			handleSendMediaGroup(rec, req, config)
+
+			// Failures found once the upload has started arrive as the
+			// result event; earlier ones as a plain response.
+			status, body := rec.Code, rec.Body.Bytes()
+			if rec.Header().Get("Content-Type") == "application/x-ndjson" {
+				lines := bytes.Split(bytes.TrimSpace(body), []byte("\n"))
+				var result MediaGroupEvent
+				json.Unmarshal(lines[len(lines)-1], &result)
+				if result.Event != "result" {
+					t.Fatalf("last event = %s, want the result", lines[len(lines)-1])
+				}
+				status, body = result.Status, result.Body
+			}
+
+			if status != tt.status {
+				t.Fatalf("status = %d, want %d: %s", status, tt.status, body)
+			}
+			var resp ErrorResponse
+			json.Unmarshal(body, &resp)
+			if resp.Code != tt.code {
+				t.Errorf("code = %q, want %q", resp.Code, tt.code)
+			}
+			if calls := fake.Calls(); len(calls) != 0 {
+				t.Errorf("a failed media group was sent to Telegram: %v", calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "api_http_requests_total",
+		Help: "HTTP requests served, by route and status code.",
+	}, []string{"path", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "api_http_request_duration_seconds",
+		Help:    "Latency of HTTP requests served, by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"path"})
+
+	upstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "api_upstream_request_duration_seconds",
+		Help:    "Latency of outbound Telegram and Beehiiv calls, by host and outcome.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"host", "status"})
+
+	upstreamCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "api_upstream_calls_total",
+		Help: "Outbound calls by upstream and result. Network errors, 429 and 5xx count as failures.",
+	}, []string{"upstream", "result"})
+
+	jobsFinished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "api_jobs_finished_total",
+		Help: "Background delivery jobs that finished, by kind and final status.",
+	}, []string{"kind", "status"})
+)
+
+// withMetrics counts and times requests by the mux pattern they matched, so
+// unknown paths all land in "other" instead of creating a series per URL. It
+// sits outside every other middleware so it sees the final status code, but
+// inside withAPIVersion so /v1 and legacy paths share a series.
+func withMetrics(next http.Handler, mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		path := "other"
+		if _, pattern := mux.Handler(r); pattern != "" {
+			path = pattern
+		}
+		httpRequests.WithLabelValues(path, strconv.Itoa(rec.status)).Inc()
+		httpRequestDuration.WithLabelValues(path).Observe(time.Since(start).Seconds())
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// instrumentedTransport records upstream latency per host and counts each
+// attempt's result per upstream, feeding the same result to the upstream's
+// circuit breaker. Paths aren't used as labels since Telegram's carry the
+// bot token.
+type instrumentedTransport struct {
+	next http.RoundTripper
+}
+
+func (t instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	name := upstreamName(req.URL.Host)
+	breaker := breakerFor(name)
+	if err := breaker.allow(); err != nil {
+		upstreamCalls.WithLabelValues(name, "circuit_open").Inc()
+		return nil, err
+	}
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+
+	status, result, cause := "error", "failure", ""
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			result = "success"
+		} else {
+			cause = "status " + status
+		}
+	} else {
+		cause = err.Error()
+	}
+	upstreamDuration.WithLabelValues(req.URL.Host, status).Observe(time.Since(start).Seconds())
+	upstreamCalls.WithLabelValues(name, result).Inc()
+
+	// A call the caller gave up on says nothing about the upstream.
+	if req.Context().Err() == nil {
+		breaker.record(result == "success", cause)
+	} else {
+		breaker.abandon()
+	}
+	return resp, err
+}
+
+// upstreamName maps a host to "telegram" or "beehiiv" when it is the
+// configured API root, so alerts keep working across base URL overrides.
+func upstreamName(host string) string {
+	for base, name := range map[string]string{
+		telegramAPIBaseURL:     "telegram",
+		beehiivAPIBaseURL:      "beehiiv",
+		spotifyAccountsBaseURL: "spotify",
+		spotifyAPIBaseURL:      "spotify",
+		githubAPIBaseURL:       "github",
+		akismetAPIBaseURL:      "akismet",
+		stripeAPIBaseURL:       "stripe",
+	} {
+		if u, err := url.Parse(base); err == nil && u.Host == host {
+			return name
+		}
+	}
+	return host
+}
+
+// serveMetrics serves /metrics on its own listener at addr, for deployments
+// that keep it off the public port.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("Error serving metrics on %s: %v", addr, err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+const defaultMXCacheTTL = 10 * time.Minute
+
+type mxLookuper interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+// mxResolver is swapped out when MX lookups need to be faked.
+var mxResolver mxLookuper = net.DefaultResolver
+
+var mxResults = newTTLCache[bool]("mx", 0)
+
+// domainHasMX reports whether the domain of email publishes MX records.
+// Temporary DNS failures are treated as "has MX" so an outage on our side
+// doesn't turn away legitimate subscribers; only definitive answers are
+// cached, for ttl.
+func domainHasMX(ctx context.Context, email string, ttl time.Duration) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+
+	if hasMX, ok := mxResults.get(domain); ok {
+		return hasMX
+	}
+
+	records, err := mxResolver.LookupMX(ctx, domain)
+	var dnsErr *net.DNSError
+	if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		return true
+	}
+	hasMX := err == nil && len(records) > 0
+
+	mxResults.set(domain, hasMX, ttl)
+
+	return hasMX
+}
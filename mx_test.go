@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// fakeResolver answers MX lookups from a fixed table and counts them.
+type fakeResolver struct {
+	records map[string][]*net.MX
+	lookups map[string]int
+}
+
+func (r *fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	r.lookups[name]++
+	switch name {
+	case "flaky.example":
+		return nil, &net.DNSError{Err: "server misbehaving", Name: name, IsTemporary: true}
+	}
+	if records, ok := r.records[name]; ok {
+		return records, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+// useResolver swaps the MX resolver and cache until the test ends.
+func useResolver(t *testing.T) *fakeResolver {
+	t.Helper()
+	origResolver, origResults := mxResolver, mxResults
+	t.Cleanup(func() { mxResolver, mxResults = origResolver, origResults })
+
+	resolver := &fakeResolver{
+		records: map[string][]*net.MX{"mail.example": {{Host: "mx.mail.example.", Pref: 10}}},
+		lookups: make(map[string]int),
+	}
+	mxResolver, mxResults = resolver, newTTLCache[bool]("mx", 0)
+	return resolver
+}
+
+func TestSubscribeVerifiesMX(t *testing.T) {
+	_, fake := useFakeUpstreams(t)
+	resolver := useResolver(t)
+	subscribe := bind(handleSubscribe, configWith(t, "VERIFY_MX", "true"))
+
+	tests := []struct {
+		name   string
+		email  string
+		status int
+		code   string
+	}{
+		{"has MX", "a@mail.example", http.StatusOK, ""},
+		{"no MX", "a@nomail.example", http.StatusBadRequest, "invalid_email_domain"},
+		{"lookup failed", "a@flaky.example", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := beehiivCalls(fake, http.MethodPost, "/subscriptions")
+			rec := serve(subscribe, http.MethodPost, "/subscribe", `{"email":"`+tt.email+`"}`)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.code != "" {
+				if got := decodeError(t, rec).Code; got != tt.code {
+					t.Errorf("code = %q, want %q", got, tt.code)
+				}
+				if beehiivCalls(fake, http.MethodPost, "/subscriptions") != before {
+					t.Error("a rejected email was subscribed")
+				}
+			}
+		})
+	}
+
+	// Definitive answers are cached; temporary failures are retried.
+	for _, email := range []string{"b@mail.example", "b@nomail.example", "b@flaky.example"} {
+		domainHasMX(context.Background(), email, defaultMXCacheTTL)
+	}
+	want := map[string]int{"mail.example": 1, "nomail.example": 1, "flaky.example": 2}
+	for domain, n := range want {
+		if resolver.lookups[domain] != n {
+			t.Errorf("%s was looked up %d times, want %d", domain, resolver.lookups[domain], n)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+const (
+	maxDiscordMessageLength = 2000
+	maxSlackMessageLength   = 40000
+)
+
+// Notifier delivers a message to one notification target. It returns the
+// target's message ID, or 0 when the target doesn't report one. MaxLength
+// is the longest message the target takes, as counted by messageLength.
+type Notifier interface {
+	Name() string
+	MaxLength() int
+	Send(ctx context.Context, message string, opts SendOptions) (int64, error)
+}
+
+type NotifyResult struct {
+	Channel   string `json:"channel"`
+	Status    string `json:"status"`
+	MessageID int64  `json:"message_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type telegramNotifier struct {
+	config Config
+}
+
+func (n telegramNotifier) Name() string { return "telegram" }
+
+func (n telegramNotifier) MaxLength() int { return maxMessageLength }
+
+func (n telegramNotifier) Send(ctx context.Context, message string, opts SendOptions) (int64, error) {
+	sent, err := sendTelegramMessage(ctx, n.config, message, opts)
+	if err != nil {
+		return 0, err
+	}
+	return sent.MessageID, nil
+}
+
+// webhookNotifier posts to an incoming webhook URL such as Discord's or
+// Slack's. The message is sent as plain text; parse modes only apply to
+// Telegram. Failed posts are retried by policy.
+type webhookNotifier struct {
+	name      string
+	url       string
+	maxLength int
+	policy    retryPolicy
+	payload   func(message string) interface{}
+}
+
+func (n webhookNotifier) Name() string { return n.name }
+
+func (n webhookNotifier) MaxLength() int { return n.maxLength }
+
+func (n webhookNotifier) Send(ctx context.Context, message string, opts SendOptions) (int64, error) {
+	body, err := json.Marshal(n.payload(message))
+	if err != nil {
+		return 0, fmt.Errorf("error marshaling payload: %v", err)
+	}
+	defer timingsFrom(ctx).track("upstream")()
+
+	err = retryUpstream(ctx, n.policy, func() error {
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("error creating request: %v", err)
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+
+		resp, err := httpClient.Do(httpReq)
+		if err != nil {
+			// The webhook URL embeds its secret token, so keep it out of
+			// the error.
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				err = urlErr.Err
+			}
+			return fmt.Errorf("error sending message: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return newUpstreamError(resp)
+		}
+		return nil
+	})
+	return 0, err
+}
+
+// webhookNotifiers returns the webhook targets whose URL is configured, in
+// a fixed order.
+func webhookNotifiers(config Config) []Notifier {
+	var notifiers []Notifier
+	if webhook := config.DiscordWebhookURL; webhook != "" {
+		notifiers = append(notifiers, webhookNotifier{name: "discord", url: webhook, maxLength: maxDiscordMessageLength, policy: config.retryPolicyFor("DISCORD"), payload: func(message string) interface{} {
+			// Public input must not be able to ping @everyone or roles.
+			return map[string]interface{}{
+				"content":          message,
+				"allowed_mentions": map[string][]string{"parse": {}},
+			}
+		}})
+	}
+	if webhook := config.SlackWebhookURL; webhook != "" {
+		notifiers = append(notifiers, webhookNotifier{name: "slack", url: webhook, maxLength: maxSlackMessageLength, policy: config.retryPolicyFor("SLACK"), payload: func(message string) interface{} {
+			return map[string]string{"text": message}
+		}})
+	}
+	return notifiers
+}
+
+// notifiersFor resolves a /send channel to the notifiers it fans out to.
+// Telegram alone ("" or "telegram") returns nil so /send keeps its direct
+// path; "all" is Telegram plus every configured webhook. It returns a
+// non-empty message when the channel is unknown or not configured.
+func notifiersFor(channel string, config Config) ([]Notifier, string) {
+	switch channel {
+	case "", "telegram":
+		return nil, ""
+	case "all":
+		return append([]Notifier{telegramNotifier{config: config}}, webhookNotifiers(config)...), ""
+	}
+
+	for _, n := range webhookNotifiers(config) {
+		if n.Name() == channel {
+			return []Notifier{n}, ""
+		}
+	}
+	if channel == "discord" || channel == "slack" {
+		return nil, fmt.Sprintf("Channel %s is not configured", channel)
+	}
+	return nil, "Unknown channel"
+}
+
+// longestMessageLimit returns the largest MaxLength among notifiers.
+func longestMessageLimit(notifiers []Notifier) int {
+	limit := 0
+	for _, n := range notifiers {
+		limit = max(limit, n.MaxLength())
+	}
+	return limit
+}
+
+// notifyAll sends message through every notifier concurrently. A target
+// whose limit the message exceeds fails on its own without being called,
+// so the others still get it. It returns an error only when all of them
+// failed, so a partial delivery is still reported per channel.
+func notifyAll(ctx context.Context, notifiers []Notifier, message string, opts SendOptions) ([]NotifyResult, error) {
+	results := make([]NotifyResult, len(notifiers))
+	errs := make([]error, len(notifiers))
+	var wg sync.WaitGroup
+	for i, n := range notifiers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = NotifyResult{Channel: n.Name(), Status: "sent"}
+			if limit := n.MaxLength(); messageLength(message) > limit {
+				errs[i] = fmt.Errorf("message is longer than %d characters", limit)
+			} else {
+				results[i].MessageID, errs[i] = n.Send(ctx, message, opts)
+			}
+			if errs[i] != nil {
+				results[i].Status = "error"
+				results[i].Error = errs[i].Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err == nil {
+			return results, nil
+		}
+	}
+	return results, errors.Join(errs...)
+}
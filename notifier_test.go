@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSendFanOutAppliesEachTargetsLimit(t *testing.T) {
+	fakeTG, _ := useFakeUpstreams(t)
+
+	var discordCalls, slackCalls atomic.Int32
+	discord := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		discordCalls.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer discord.Close()
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slackCalls.Add(1)
+		w.Write([]byte("ok"))
+	}))
+	defer slack.Close()
+	send := bind(handleSendMessage, configWith(t, "DISCORD_WEBHOOK_URL", discord.URL, "SLACK_WEBHOOK_URL", slack.URL))
+
+	tests := []struct {
+		name   string
+		length int
+		status int
+		sent   map[string]bool
+	}{
+		{"fits all", 100, http.StatusOK, map[string]bool{"telegram": true, "discord": true, "slack": true}},
+		{"too long for Discord", 3000, http.StatusOK, map[string]bool{"telegram": true, "discord": false, "slack": true}},
+		{"only fits Slack", 5000, http.StatusOK, map[string]bool{"telegram": false, "discord": false, "slack": true}},
+		{"fits none", maxSlackMessageLength + 1, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			telegramBefore, discordBefore, slackBefore := len(fakeTG.Calls()), discordCalls.Load(), slackCalls.Load()
+
+			body, _ := json.Marshal(MessageRequest{Message: strings.Repeat("a", tt.length), Channel: "all"})
+			rec := serve(send, http.MethodPost, "/send", string(body))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %.200s", rec.Code, tt.status, rec.Body)
+			}
+
+			called := map[string]bool{
+				"telegram": len(fakeTG.Calls()) > telegramBefore,
+				"discord":  discordCalls.Load() > discordBefore,
+				"slack":    slackCalls.Load() > slackBefore,
+			}
+			if tt.status != http.StatusOK {
+				for channel, ok := range called {
+					if ok {
+						t.Errorf("%s was called for a rejected message", channel)
+					}
+				}
+				return
+			}
+
+			var resp struct {
+				Results []NotifyResult `json:"results"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			for _, result := range resp.Results {
+				want := tt.sent[result.Channel]
+				if (result.Status == "sent") != want || called[result.Channel] != want {
+					t.Errorf("%s: status %q, called %v, want sent = %v", result.Channel, result.Status, called[result.Channel], want)
+				}
+			}
+			if len(resp.Results) != len(tt.sent) {
+				t.Errorf("results = %+v, want one per target", resp.Results)
+			}
+		})
+	}
+}
+
+func TestNotifiersUseTheirOwnRetryPolicies(t *testing.T) {
+	useFakeUpstreams(t)
+	var telegramCalls atomic.Int32
+	defer func(orig TelegramAPI) { telegram = orig }(telegram)
+	telegram = telegramFunc(func(method string, body []byte) (json.RawMessage, error) {
+		telegramCalls.Add(1)
+		return nil, &UpstreamError{StatusCode: http.StatusServiceUnavailable}
+	})
+
+	var discordCalls, slackCalls atomic.Int32
+	discord := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		discordCalls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer discord.Close()
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slackCalls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer slack.Close()
+	useBreakerSettings(t, 0, defaultCircuitBreakerCooldown)
+	t.Setenv("DISCORD_WEBHOOK_URL", discord.URL)
+	t.Setenv("SLACK_WEBHOOK_URL", slack.URL)
+	t.Setenv("UPSTREAM_RETRY_BASE", "1ms")
+	t.Setenv("TELEGRAM_MAX_ATTEMPTS", "2")
+	t.Setenv("DISCORD_MAX_ATTEMPTS", "4")
+	t.Setenv("SLACK_MAX_ATTEMPTS", "3")
+
+	tests := []struct {
+		name          string
+		slackStatuses string
+		want          map[string]int32
+	}{
+		// Slack's 500 isn't retryable by default.
+		{"default statuses", "", map[string]int32{"telegram": 2, "discord": 4, "slack": 1}},
+		{"Slack retries 500", "500", map[string]int32{"telegram": 2, "discord": 4, "slack": 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := configWith(t, "SLACK_RETRY_STATUSES", tt.slackStatuses)
+			telegramCalls.Store(0)
+			discordCalls.Store(0)
+			slackCalls.Store(0)
+
+			rec := serve(bind(handleSendMessage, config), http.MethodPost, "/send", `{"message":"retry me","channel":"all"}`)
+			if rec.Code < 500 {
+				t.Errorf("status = %d, want a failure when every target fails", rec.Code)
+			}
+			got := map[string]int32{"telegram": telegramCalls.Load(), "discord": discordCalls.Load(), "slack": slackCalls.Load()}
+			for target, want := range tt.want {
+				if got[target] != want {
+					t.Errorf("%s was tried %d times, want %d", target, got[target], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"go/token"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiOperation documents one method of a registered route for
+// /openapi.json. Request and Response are zero values of the Go types the
+// handler decodes and encodes, so their schemas follow the code; handlers
+// that build their response as a map are described by a doc struct below.
+type apiOperation struct {
+	Method string
+	Route  string // the registered pattern, e.g. "/comments/"
+	Path   string // the documented path when it differs, e.g. "/comments/{id}"
+
+	ID      string
+	Summary string
+	Query   []string
+	Headers []string
+
+	Request     any
+	RequestType string // defaults to application/json
+
+	Status       int // defaults to 200
+	Response     any
+	ResponseType string // defaults to application/json
+
+	// Async operations can also answer 202 with a job; see withAsync.
+	Async bool
+
+	// Auth names the security scheme of operations that check it in the
+	// handler rather than through their route group.
+	Auth string
+}
+
+// Doc structs for responses the handlers build as maps.
+type (
+	statusResponse struct {
+		Status string `json:"status"`
+	}
+	mediaGroupResponse struct {
+		Status     string  `json:"status"`
+		MessageIDs []int64 `json:"message_ids"`
+		Warning    string  `json:"warning,omitempty"`
+	}
+	sendResponse struct {
+		Status          string `json:"status"`
+		MessageID       int64  `json:"message_id,omitempty"`
+		MessageThreadID int64  `json:"message_thread_id,omitempty"`
+		Count           int    `json:"count,omitempty"`
+		ContentHash     string `json:"content_hash,omitempty"`
+		Results         []any  `json:"results,omitempty"`
+		Warning         string `json:"warning,omitempty"`
+		Timing          any    `json:"timing,omitempty"`
+		Telegram        any    `json:"telegram,omitempty"`
+	}
+	photoResponse struct {
+		Status           string `json:"status"`
+		MessageID        int64  `json:"message_id"`
+		CaptionTruncated bool   `json:"caption_truncated,omitempty"`
+	}
+	subscribeResponse struct {
+		Status string `json:"status"`
+		ID     string `json:"id,omitempty"`
+	}
+	subscriptionStatusResponse struct {
+		Email  string `json:"email"`
+		Status string `json:"status"`
+	}
+	jobAcceptedResponse struct {
+		Status string `json:"status"`
+		JobID  string `json:"job_id"`
+	}
+	unsubscribeRequest struct {
+		Email string `json:"email"`
+		Token string `json:"token,omitempty"`
+	}
+	batchOperation struct {
+		Op string `json:"op"`
+	}
+	commentThreadResponse struct {
+		Slug     string        `json:"slug,omitempty"`
+		Count    int           `json:"count,omitempty"`
+		Comments []CommentView `json:"comments"`
+	}
+	commentStatusResponse struct {
+		ID      string `json:"id,omitempty"`
+		Status  string `json:"status"`
+		Deleted int    `json:"deleted,omitempty"`
+	}
+	shortLinkResponse struct {
+		Slug      string `json:"slug"`
+		URL       string `json:"url"`
+		ShortPath string `json:"short_path"`
+	}
+	shortLinksResponse struct {
+		Links []ShortLink `json:"links"`
+		Count int         `json:"count"`
+	}
+	cachesResponse struct {
+		Caches []CacheStats `json:"caches"`
+	}
+)
+
+var apiOperations = []apiOperation{
+	{Method: http.MethodGet, Route: "/health", ID: "getHealth", Summary: "Service health and upstream circuit breakers", Query: []string{"verbose", "upstream"}, Response: HealthResponse{}},
+	{Method: http.MethodGet, Route: "/healthz", ID: "getLiveness", Summary: "Liveness probe", Response: statusResponse{}},
+	{Method: http.MethodGet, Route: "/readyz", ID: "getReadiness", Summary: "Readiness probe; 503 while not ready", Response: ReadinessResponse{}},
+
+	{Method: http.MethodPost, Route: "/send", ID: "sendMessage", Summary: "Send a Telegram message", Headers: []string{"Idempotency-Key", "Prefer"}, Request: MessageRequest{}, Response: sendResponse{}, Async: true},
+	{Method: http.MethodPost, Route: "/send/photo", ID: "sendPhoto", Summary: "Send a photo by URL or base64", Headers: []string{"Idempotency-Key"}, Request: PhotoRequest{}, Response: photoResponse{}},
+	{Method: http.MethodPost, Route: "/edit", ID: "editMessage", Summary: "Edit a sent message", Request: EditRequest{}, Response: statusResponse{}},
+	{Method: http.MethodPost, Route: "/send-venue", ID: "sendVenue", Summary: "Send a venue", Request: VenueRequest{}, Response: statusResponse{}},
+	{Method: http.MethodPost, Route: "/send-media-group", ID: "sendMediaGroup", Summary: "Send photos or documents as an album; with Accept: application/x-ndjson, streams upload progress first", Request: MediaGroupRequest{}, Response: mediaGroupResponse{}},
+	{Method: http.MethodPost, Route: "/send-contact", ID: "sendContact", Summary: "Send a contact card", Request: ContactRequest{}, Response: statusResponse{}},
+	{Method: http.MethodPost, Route: "/batch", ID: "sendBatch", Summary: "Run several send and subscribe operations", Request: []batchOperation{}, Response: []BatchResult{}},
+	{Method: http.MethodGet, Route: "/recent", ID: "listRecentMessages", Summary: "Recently sent messages, newest first", Query: []string{"limit", "offset"}, Response: RecentMessagesResponse{}},
+	{Method: http.MethodGet, Route: "/jobs/", Path: "/jobs/{id}", ID: "getJob", Summary: "Status of an async delivery job", Response: Job{}},
+
+	{Method: http.MethodPost, Route: "/subscribe", ID: "subscribe", Summary: "Subscribe an email address", Headers: []string{"Idempotency-Key", "Prefer"}, Request: SubscribeRequest{}, Response: subscribeResponse{}, Async: true},
+	{Method: http.MethodGet, Route: "/subscribe/confirm", ID: "confirmSubscription", Summary: "Confirm a double opt-in subscription", Query: []string{"token"}, Response: subscribeResponse{}},
+	{Method: http.MethodPost, Route: "/unsubscribe", ID: "unsubscribe", Summary: "Unsubscribe an email address", Request: unsubscribeRequest{}, Response: statusResponse{}},
+	{Method: http.MethodDelete, Route: "/unsubscribe", ID: "deleteSubscription", Summary: "Unsubscribe an email address", Query: []string{"email", "token"}, Response: statusResponse{}},
+	{Method: http.MethodGet, Route: "/subscription/status", ID: "getSubscriptionStatus", Summary: "Subscription status of an email address", Query: []string{"email", "token"}, Response: subscriptionStatusResponse{}},
+	{Method: http.MethodPost, Route: "/contact", ID: "submitContactForm", Summary: "Submit the contact form", Headers: []string{"Idempotency-Key"}, Request: ContactFormRequest{}, Response: statusResponse{}},
+
+	{Method: http.MethodPost, Route: "/analytics/event", ID: "recordPageView", Summary: "Record a page view", Request: PageViewRequest{}, Status: http.StatusNoContent},
+	{Method: http.MethodGet, Route: "/analytics/stats", ID: "getAnalyticsStats", Summary: "Page view statistics", Query: []string{"period", "path"}, Response: AnalyticsStats{}},
+
+	{Method: http.MethodGet, Route: "/comments", ID: "listComments", Summary: "Approved comments for a page, or with ?status= (admin) the moderation queue", Query: []string{"slug", "status"}, Response: commentThreadResponse{}},
+	{Method: http.MethodPost, Route: "/comments", ID: "createComment", Summary: "Post a comment", Request: CommentRequest{}, Status: http.StatusCreated, Response: commentStatusResponse{}},
+	{Method: http.MethodDelete, Route: "/comments/", Path: "/comments/{id}", ID: "deleteComment", Summary: "Delete a comment and its replies", Auth: "adminToken", Response: commentStatusResponse{}},
+	{Method: http.MethodPost, Route: "/comments/", Path: "/comments/{id}/approve", ID: "approveComment", Summary: "Approve a pending comment", Auth: "adminToken", Response: commentStatusResponse{}},
+	{Method: http.MethodPost, Route: "/comments/", Path: "/comments/{id}/flag", ID: "flagComment", Summary: "Flag a comment for moderation", Response: statusResponse{}},
+
+	{Method: http.MethodPost, Route: "/links", ID: "createShortLink", Summary: "Create a short link", Request: ShortLinkRequest{}, Status: http.StatusCreated, Response: shortLinkResponse{}},
+	{Method: http.MethodGet, Route: "/l/", Path: "/l/{slug}", ID: "followShortLink", Summary: "Redirect to a short link's target", Query: []string{"utm_source"}, Status: http.StatusFound},
+	{Method: http.MethodGet, Route: "/admin/links", ID: "listShortLinks", Summary: "Short links with click counts", Response: shortLinksResponse{}},
+
+	{Method: http.MethodPost, Route: "/subscribe-csv", ID: "importSubscribers", Summary: "Import subscribers from CSV", Request: "", RequestType: "text/csv", Response: CSVImportResponse{}},
+	{Method: http.MethodGet, Route: "/subscriber", ID: "getSubscriber", Summary: "Look up a subscriber", Query: []string{"email"}, Response: BeehiivSubscriber{}},
+	{Method: http.MethodPatch, Route: "/subscriber/update", ID: "updateSubscriber", Summary: "Update a subscriber's custom fields and tags", Request: SubscriberUpdateRequest{}, Response: subscribeResponse{}},
+	{Method: http.MethodGet, Route: "/admin/subscribers/export", ID: "exportSubscribers", Summary: "Subscriber mirror as CSV", Query: []string{"from", "to"}, Response: "", ResponseType: "text/csv"},
+	{Method: http.MethodGet, Route: "/admin/audit/export", ID: "exportAuditLog", Summary: "Audit log as NDJSON", Query: []string{"from", "to"}, Response: "", ResponseType: "application/x-ndjson"},
+	{Method: http.MethodPost, Route: "/broadcast", ID: "broadcast", Summary: "Send a message to every broadcast chat", Request: BroadcastRequest{}, Response: BroadcastResponse{}},
+	{Method: http.MethodGet, Route: "/broadcast/preview", ID: "previewBroadcast", Summary: "Chats a broadcast would be sent to", Response: BroadcastPreview{}},
+	{Method: http.MethodPost, Route: "/admin/selftest", ID: "runSelfTest", Summary: "Send a canary message through the pipeline", Query: []string{"dry_run"}, Response: SelfTestResponse{}},
+
+	{Method: http.MethodGet, Route: "/limits", ID: "getLimits", Summary: "Rate and size limits clients should respect", Response: LimitsResponse{}},
+	{Method: http.MethodGet, Route: "/debug/ratelimit", ID: "getRateLimitState", Summary: "Rate limiter state", Response: map[string]LimiterState{}},
+	{Method: http.MethodGet, Route: "/debug/caches", ID: "getCacheStats", Summary: "Cache statistics", Response: cachesResponse{}},
+	{Method: http.MethodPost, Route: "/debug/echo", ID: "echoMessage", Summary: "Show how a message would be sent (development only)", Request: MessageRequest{}, Response: EchoResponse{}},
+	{Method: http.MethodGet, Route: "/metrics", ID: "getMetrics", Summary: "Prometheus metrics", Response: "", ResponseType: "text/plain"},
+
+	{Method: http.MethodPost, Route: "/telegram/webhook", ID: "receiveTelegramUpdate", Summary: "Telegram Bot API webhook", Headers: []string{"X-Telegram-Bot-Api-Secret-Token"}, Request: TelegramUpdate{}},
+	{Method: http.MethodPost, Route: "/webhooks/github", ID: "receiveGitHubEvent", Summary: "GitHub webhook", Headers: []string{"X-GitHub-Event", "X-Hub-Signature-256"}, Request: map[string]any{}, Response: statusResponse{}},
+	{Method: http.MethodPost, Route: "/webhooks/stripe", ID: "receiveStripeEvent", Summary: "Stripe webhook for payment notifications", Headers: []string{"Stripe-Signature"}, Request: map[string]any{}, Response: statusResponse{}},
+	{Method: http.MethodPost, Route: "/webhooks/sponsors", ID: "receiveSponsorshipEvent", Summary: "GitHub Sponsors webhook", Headers: []string{"X-GitHub-Event", "X-Hub-Signature-256"}, Request: map[string]any{}, Response: statusResponse{}},
+	{Method: http.MethodGet, Route: "/now-playing", ID: "getNowPlaying", Summary: "Currently playing Spotify track", Response: NowPlaying{}},
+	{Method: http.MethodGet, Route: "/github/stats", ID: "getGitHubStats", Summary: "GitHub profile statistics", Response: GitHubStats{}},
+
+	{Method: http.MethodGet, Route: "/openapi.json", ID: "getOpenAPI", Summary: "This OpenAPI document", Response: json.RawMessage{}},
+	{Method: http.MethodGet, Route: "/docs", ID: "getDocs", Summary: "API reference", Response: "", ResponseType: "text/html"},
+}
+
+// handleOpenAPI serves the OpenAPI 3 document for routes, built on first
+// use so it covers every route registered by then.
+func handleOpenAPI(routes *[]registeredRoute, config Config) http.HandlerFunc {
+	spec := sync.OnceValues(func() ([]byte, error) {
+		return json.MarshalIndent(buildOpenAPISpec(*routes, config), "", "  ")
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := spec()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "internal_error", "Failed to encode the OpenAPI document")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
+
+// docsPage renders the spec next to it with Redoc. The spec URL is
+// relative so the page works at both /docs and /v1/docs.
+const docsPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>API reference</title>
+</head>
+<body>
+<redoc spec-url="openapi.json"></redoc>
+<script src="https://cdn.jsdelivr.net/npm/redoc@2.1.5/bundles/redoc.standalone.js"></script>
+</body>
+</html>
+`
+
+func handleDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(docsPage))
+}
+
+var pathParamPattern = regexp.MustCompile(`\{(\w+)\}`)
+
+// buildOpenAPISpec describes routes as they were registered. A route
+// method without an apiOperation still appears, with a generic response,
+// so nothing registered is missing from the document. Routes that allow
+// any method, such as the 404 fallback, and HEAD are left out. API key
+// and captcha requirements follow API_KEY_ROUTES and CAPTCHA_ROUTES.
+func buildOpenAPISpec(routes []registeredRoute, config Config) map[string]any {
+	schemas := &schemaBuilder{components: map[string]any{
+		"ResponseMeta": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"request_id": map[string]any{"type": "string"},
+				"timestamp":  map[string]any{"type": "string", "format": "date-time"},
+			},
+		},
+	}}
+	errorSchema := schemas.schema(reflect.TypeOf(ErrorResponse{}))
+
+	var apiKeyProtected []string
+	if len(config.APIKeys) > 0 {
+		apiKeyProtected = config.APIKeyRoutes
+	}
+	captchaProtected := config.CaptchaRoutes
+
+	paths := map[string]map[string]any{}
+	for _, route := range routes {
+		for _, method := range route.methods {
+			if method == http.MethodHead {
+				continue
+			}
+			ops := slices.DeleteFunc(slices.Clone(apiOperations), func(op apiOperation) bool {
+				return op.Method != method || op.Route != route.path
+			})
+			if len(ops) == 0 {
+				ops = []apiOperation{{Method: method, Route: route.path, Response: map[string]any{}}}
+			}
+
+			for _, op := range ops {
+				path := op.Path
+				if path == "" {
+					path = strings.TrimSuffix(route.path, "/")
+				}
+
+				var params []any
+				for _, m := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+					params = append(params, map[string]any{"name": m[1], "in": "path", "required": true, "schema": map[string]any{"type": "string"}})
+				}
+				for _, name := range op.Query {
+					params = append(params, map[string]any{"name": name, "in": "query", "schema": map[string]any{"type": "string"}})
+				}
+				headers := op.Headers
+				if slices.Contains(captchaProtected, route.path) {
+					headers = append(slices.Clone(headers), "X-Captcha-Token")
+				}
+				for _, name := range headers {
+					params = append(params, map[string]any{"name": name, "in": "header", "schema": map[string]any{"type": "string"}})
+				}
+
+				status := op.Status
+				if status == 0 {
+					status = http.StatusOK
+				}
+				responses := map[string]any{
+					strconv.Itoa(status): schemas.response(status, op.Response, op.ResponseType),
+					"default": map[string]any{
+						"description": "Error",
+						"content":     map[string]any{"application/json": map[string]any{"schema": errorSchema}},
+					},
+				}
+				if op.Async {
+					responses[strconv.Itoa(http.StatusAccepted)] = schemas.response(http.StatusAccepted, jobAcceptedResponse{}, "")
+				}
+
+				operation := map[string]any{
+					"operationId": op.ID,
+					"summary":     op.Summary,
+					"responses":   responses,
+				}
+				if op.ID == "" {
+					operation["operationId"] = operationID(method, path)
+				}
+				if len(params) > 0 {
+					operation["parameters"] = params
+				}
+				if op.Request != nil {
+					contentType := op.RequestType
+					if contentType == "" {
+						contentType = "application/json"
+					}
+					operation["requestBody"] = map[string]any{
+						"required": true,
+						"content":  map[string]any{contentType: map[string]any{"schema": schemas.schema(reflect.TypeOf(op.Request))}},
+					}
+				}
+				var security []any
+				if auth := cmp.Or(route.auth, op.Auth); auth != "" {
+					security = append(security, map[string]any{auth: []string{}})
+				}
+				if slices.Contains(apiKeyProtected, route.path) {
+					security = append(security, map[string]any{"apiKey": []string{}})
+				}
+				if security != nil {
+					operation["security"] = security
+				}
+
+				if paths[path] == nil {
+					paths[path] = map[string]any{}
+				}
+				paths[path][strings.ToLower(method)] = operation
+			}
+		}
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "API",
+			"version":     "1",
+			"description": "Successful JSON object responses also carry request_id and timestamp. Unversioned paths are deprecated aliases of /v1.",
+		},
+		"servers": []any{map[string]any{"url": apiVersionPrefix}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": schemas.components,
+			"securitySchemes": map[string]any{
+				"adminToken": map[string]any{"type": "http", "scheme": "bearer"},
+				"apiKey":     map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+	}
+}
+
+// operationID names an undocumented operation from its method and path,
+// e.g. "postWebhooksGithub".
+func operationID(method, path string) string {
+	id := strings.ToLower(method)
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9')
+	}) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+// schemaOverrides are types whose JSON form isn't what reflection on
+// their Go type would suggest.
+var schemaOverrides = map[reflect.Type]map[string]any{
+	reflect.TypeOf(time.Time{}):       {"type": "string", "format": "date-time"},
+	reflect.TypeOf(json.RawMessage{}): {},
+	reflect.TypeOf(FlexBool(false)):   {"oneOf": []any{map[string]any{"type": "boolean"}, map[string]any{"type": "string"}}},
+}
+
+// schemaBuilder turns Go types into OpenAPI schemas the way encoding/json
+// would encode them. Exported named structs go into components and are
+// referenced, which also lets recursive types such as CommentView work.
+type schemaBuilder struct {
+	components map[string]any
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]any {
+	if s, ok := schemaOverrides[t]; ok {
+		return s
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.schema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if !token.IsExported(t.Name()) {
+			return b.object(t)
+		}
+		ref := map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := b.components[t.Name()]; !ok {
+			b.components[t.Name()] = map[string]any{}
+			b.components[t.Name()] = b.object(t)
+		}
+		return ref
+	}
+	return map[string]any{}
+}
+
+func (b *schemaBuilder) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = b.schema(field.Type)
+		if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+
+	s := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// response describes a success response. JSON objects are combined with
+// ResponseMeta, since writeJSON adds request_id and timestamp to them.
+func (b *schemaBuilder) response(status int, body any, contentType string) map[string]any {
+	resp := map[string]any{"description": http.StatusText(status)}
+	if body == nil {
+		return resp
+	}
+	if contentType == "" {
+		contentType = "application/json"
+	}
+
+	t := reflect.TypeOf(body)
+	s := b.schema(t)
+	if contentType == "application/json" && (t.Kind() == reflect.Struct || t.Kind() == reflect.Map) {
+		s = map[string]any{"allOf": []any{s, map[string]any{"$ref": "#/components/schemas/ResponseMeta"}}}
+	}
+	resp["content"] = map[string]any{contentType: map[string]any{"schema": s}}
+	return resp
+}
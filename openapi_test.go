@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// openAPISpec builds the document for routes and decodes it as a client
+// would see it.
+func openAPISpec(t *testing.T, config Config, routes []registeredRoute) map[string]any {
+	t.Helper()
+	data, err := json.Marshal(buildOpenAPISpec(routes, config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec map[string]any
+	if err := json.Unmarshal(data, &spec); err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
+
+// dig follows keys through nested JSON objects, returning nil when one is
+// missing.
+func dig(v any, keys ...string) any {
+	for _, key := range keys {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+func TestOpenAPIDocumentsRegisteredRoutes(t *testing.T) {
+	spec := openAPISpec(t, testConfig, []registeredRoute{
+		{path: "/send", methods: []string{http.MethodPost}},
+		{path: "/comments/", methods: []string{http.MethodPost, http.MethodDelete}},
+		{path: "/l/", methods: []string{http.MethodGet, http.MethodHead}},
+		{path: "/webhooks/custom", methods: []string{http.MethodPost}},
+		{path: "/links", methods: []string{http.MethodPost}, auth: "adminToken"},
+	})
+
+	if spec["openapi"] != "3.0.3" {
+		t.Errorf("openapi = %v", spec["openapi"])
+	}
+	send := dig(spec, "paths", "/send", "post")
+	if dig(send, "operationId") != "sendMessage" {
+		t.Errorf("/send = %v", send)
+	}
+	if ref := dig(send, "requestBody", "content", "application/json", "schema", "$ref"); ref != "#/components/schemas/MessageRequest" {
+		t.Errorf("/send request schema = %v", ref)
+	}
+	if dig(send, "responses", "202") == nil {
+		t.Error("/send doesn't document its async 202")
+	}
+
+	// One registered route can hold several documented paths.
+	if dig(spec, "paths", "/comments/{id}", "delete", "operationId") != "deleteComment" ||
+		dig(spec, "paths", "/comments/{id}/flag", "post", "operationId") != "flagComment" {
+		t.Errorf("comment paths = %v", dig(spec, "paths"))
+	}
+	params, _ := dig(spec, "paths", "/comments/{id}", "delete", "parameters").([]any)
+	if len(params) != 1 || dig(params[0], "name") != "id" || dig(params[0], "in") != "path" {
+		t.Errorf("/comments/{id} parameters = %v", params)
+	}
+	if security := dig(spec, "paths", "/comments/{id}", "delete", "security"); security == nil {
+		t.Error("deleting a comment isn't documented as needing the admin token")
+	}
+
+	// Undocumented routes still appear, and HEAD is left out.
+	if id := dig(spec, "paths", "/webhooks/custom", "post", "operationId"); id != "postWebhooksCustom" {
+		t.Errorf("undocumented route operationId = %v", id)
+	}
+	if dig(spec, "paths", "/l", "head") != nil {
+		t.Error("HEAD is documented")
+	}
+	if security, _ := dig(spec, "paths", "/links", "post", "security").([]any); len(security) != 1 || dig(security[0], "adminToken") == nil {
+		t.Errorf("/links security = %v", security)
+	}
+}
+
+func TestOpenAPIFollowsConfiguredProtection(t *testing.T) {
+	config := configWith(t, "API_KEYS", "ci:secret", "API_KEY_ROUTES", "/send", "CAPTCHA_ROUTES", "/send")
+	spec := openAPISpec(t, config, []registeredRoute{{path: "/send", methods: []string{http.MethodPost}}})
+
+	send := dig(spec, "paths", "/send", "post")
+	if security, _ := dig(send, "security").([]any); len(security) != 1 || dig(security[0], "apiKey") == nil {
+		t.Errorf("security = %v, want apiKey", security)
+	}
+	var headers []string
+	params, _ := dig(send, "parameters").([]any)
+	for _, p := range params {
+		if dig(p, "in") == "header" {
+			headers = append(headers, dig(p, "name").(string))
+		}
+	}
+	if !slices.Contains(headers, "X-Captcha-Token") || !slices.Contains(headers, "Idempotency-Key") {
+		t.Errorf("headers = %v, want X-Captcha-Token with the documented ones", headers)
+	}
+}
+
+func TestOpenAPISchemas(t *testing.T) {
+	spec := openAPISpec(t, testConfig, []registeredRoute{{path: "/comments", methods: []string{http.MethodGet}}})
+	schemas := dig(spec, "components", "schemas")
+
+	errorProps := dig(schemas, "ErrorResponse", "properties")
+	if dig(errorProps, "message", "type") != "string" || dig(errorProps, "code", "type") != "string" {
+		t.Errorf("ErrorResponse = %v", dig(schemas, "ErrorResponse"))
+	}
+	if required, _ := dig(schemas, "ErrorResponse", "required").([]any); !slices.Contains(required, any("message")) || slices.Contains(required, any("code")) {
+		t.Errorf("ErrorResponse required = %v, want message but not the omitempty code", required)
+	}
+
+	// CommentView refers to itself through its replies.
+	replies := dig(schemas, "CommentView", "properties", "replies", "items", "$ref")
+	if replies != "#/components/schemas/CommentView" {
+		t.Errorf("CommentView replies = %v", replies)
+	}
+	if dig(schemas, "CommentView", "properties", "created_at", "format") != "date-time" {
+		t.Errorf("times aren't documented as date-time: %v", dig(schemas, "CommentView"))
+	}
+}
+
+func TestOpenAPIHandlerServesTheDocument(t *testing.T) {
+	routes := []registeredRoute{{path: "/limits", methods: []string{http.MethodGet}}}
+	rec := serve(handleOpenAPI(&routes, testConfig), http.MethodGet, "/openapi.json", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var spec map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatal(err)
+	}
+	if dig(spec, "paths", "/limits", "get") == nil {
+		t.Errorf("paths = %v", spec["paths"])
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultOutboxPollInterval = 5 * time.Second
+
+// watchOutbox polls dir for *.json files holding /send request bodies, for
+// systems that can only drop files. Each file is sent through
+// handleSendMessage and then moved to dir/sent or dir/failed. Writers should
+// create files under another name and rename them to *.json once complete,
+// so a half-written file is never picked up.
+func watchOutbox(ctx context.Context, config Config, dir string, interval time.Duration) {
+	for _, sub := range []string{"sent", "failed"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			log.Printf("Warning: cannot create outbox directory: %v", err)
+			return
+		}
+	}
+
+	if interval <= 0 {
+		interval = defaultOutboxPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		processOutbox(ctx, config, dir)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func processOutbox(ctx context.Context, config Config, dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("Warning: cannot read outbox: %v", err)
+		return
+	}
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		outcome := "sent"
+		if status, body := sendOutboxFile(ctx, config, path); status >= 300 {
+			log.Printf("Warning: outbox file %s failed with status %d: %s", entry.Name(), status, bytes.TrimSpace(body))
+			outcome = "failed"
+		}
+		if err := os.Rename(path, filepath.Join(dir, outcome, entry.Name())); err != nil {
+			log.Printf("Warning: cannot move outbox file %s: %v", entry.Name(), err)
+		}
+	}
+}
+
+// sendOutboxFile runs the file's contents through the /send handler, so
+// outbox messages get exactly the validation and behavior of API sends.
+func sendOutboxFile(ctx context.Context, config Config, path string) (int, []byte) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return http.StatusInternalServerError, []byte(err.Error())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/send", bytes.NewReader(data))
+	if err != nil {
+		return http.StatusInternalServerError, []byte(err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rec := &batchRecorder{header: make(http.Header)}
+	handleSendMessage(rec, req, config)
+	return rec.status, rec.body.Bytes()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOutboxSendsAndMovesFiles(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+	dir := t.TempDir()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watchOutbox(ctx, testConfig, dir, 10*time.Millisecond)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	files := map[string]string{
+		"good.json":    `{"message":"from the outbox"}`,
+		"empty.json":   `{"message":""}`,
+		"garbage.json": `{"message":`,
+	}
+	want := map[string]string{"good.json": "sent", "empty.json": "failed", "garbage.json": "failed"}
+	for name, body := range files {
+		// Write under another name and rename, as watchOutbox asks writers to.
+		tmp := filepath.Join(dir, name+".tmp")
+		if err := os.WriteFile(tmp, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for name, outcome := range want {
+		moved := filepath.Join(dir, outcome, name)
+		for {
+			if _, err := os.Stat(moved); err == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s was not moved to %s/", name, outcome)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s is still in the outbox: %v", name, err)
+		}
+	}
+
+	if got := sentText(t, fake); got != "from the outbox" {
+		t.Errorf("sent %q, want the outbox message", got)
+	}
+	if n := len(fake.Calls()); n != 1 {
+		t.Errorf("%d Telegram calls, want 1 for the one valid file", n)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// chatPacer enforces a minimum gap between sends to the same chat by handing
+// out consecutive time slots and making callers wait for theirs.
+type chatPacer struct {
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+var chatPacing = &chatPacer{next: make(map[string]time.Time)}
+
+func (p *chatPacer) wait(ctx context.Context, chatID string, interval time.Duration) error {
+	if interval <= 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	now := time.Now()
+	for id, slot := range p.next {
+		if slot.Before(now) {
+			delete(p.next, id)
+		}
+	}
+	slot, ok := p.next[chatID]
+	if !ok || slot.Before(now) {
+		slot = now
+	}
+	p.next[chatID] = slot.Add(interval)
+	p.mu.Unlock()
+
+	delay := slot.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// paceChat delays until config.ChatID may receive another message according
+// to CHAT_MIN_INTERVAL (disabled by default).
+func paceChat(ctx context.Context, config Config) error {
+	defer timingsFrom(ctx).track("rate_limit")()
+	return chatPacing.wait(ctx, config.ChatID, config.ChatMinInterval)
+}
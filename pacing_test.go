@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestChatPacerSpacesSendsToOneChat(t *testing.T) {
+	const interval = 50 * time.Millisecond
+	pacer := &chatPacer{next: make(map[string]time.Time)}
+
+	start := time.Now()
+	var sent []time.Duration
+	for i := 0; i < 3; i++ {
+		if err := pacer.wait(context.Background(), "a", interval); err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, time.Since(start))
+	}
+	// Slots are fixed, so a send that wakes late doesn't push back the next
+	// one; only going before its slot is wrong.
+	for i, at := range sent {
+		if slot := time.Duration(i) * interval; at < slot {
+			t.Errorf("send %d came %v after the first wait started, want at least %v", i, at, slot)
+		}
+	}
+
+	// Other chats have their own slots.
+	before := time.Now()
+	if err := pacer.wait(context.Background(), "b", interval); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(before); waited > interval/2 {
+		t.Errorf("first send to another chat waited %v", waited)
+	}
+}
+
+func TestChatPacerGivesUpWhenCancelled(t *testing.T) {
+	pacer := &chatPacer{next: make(map[string]time.Time)}
+	if err := pacer.wait(context.Background(), "a", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := pacer.wait(ctx, "a", time.Hour); err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
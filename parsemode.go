@@ -0,0 +1,52 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+const parseModeAuto = "auto"
+
+var (
+	htmlTagPattern = regexp.MustCompile(`(?i)</?(b|strong|i|em|u|ins|s|strike|del|a|code|pre|span|tg-spoiler|blockquote)(\s[^>]*)?>`)
+	// Bold and italic markers only count at word boundaries, so identifiers
+	// like snake_case_name and arithmetic like 2*3*4 stay plain text.
+	markdownEntityPattern = regexp.MustCompile("(?:^|\\W)(?:\\*[^*\\n]+\\*|_[^_\\n]+_)(?:\\W|$)|`[^`\\n]+`|\\[[^\\]\\n]+\\]\\([^)\\n]+\\)")
+)
+
+// resolveParseMode returns the Telegram parse mode to send message with,
+// running detection when the caller asked for auto.
+func resolveParseMode(opts SendOptions, message string) string {
+	if opts.ParseMode == parseModeAuto {
+		return detectParseMode(message)
+	}
+	return opts.ParseMode
+}
+
+// detectParseMode guesses whether message is HTML or Markdown. When the
+// message looks like both, or like neither, it returns "" so Telegram
+// treats it as plain text instead of rejecting unbalanced markup.
+func detectParseMode(message string) string {
+	looksHTML := htmlTagPattern.MatchString(message)
+	looksMarkdown := markdownEntityPattern.MatchString(message) && balancedMarkdown(message)
+
+	switch {
+	case looksHTML && !looksMarkdown:
+		return "HTML"
+	case looksMarkdown && !looksHTML:
+		return "Markdown"
+	default:
+		return ""
+	}
+}
+
+// balancedMarkdown reports whether every legacy Markdown marker is paired,
+// which Telegram requires to accept the message.
+func balancedMarkdown(message string) bool {
+	for _, marker := range []string{"*", "_", "`"} {
+		if strings.Count(message, marker)%2 != 0 {
+			return false
+		}
+	}
+	return true
+}
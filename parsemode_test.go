@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestDetectParseMode(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"<b>Deploy</b> finished", "HTML"},
+		{`See <a href="https://example.com">the log</a>`, "HTML"},
+		{"*Deploy* finished in `12s`", "Markdown"},
+		{"[the log](https://example.com)", "Markdown"},
+		{"Deploy finished", ""},
+		{"2 < 3 and 5 > 4", ""},
+		{"_italic_ text", "Markdown"},
+		{"snake_case_name", ""},
+		{"2*3*4", ""},
+		{"an *unclosed marker", ""},
+		{"<b>mixed</b> and *markdown*", ""},
+	}
+	for _, tt := range tests {
+		if got := detectParseMode(tt.message); got != tt.want {
+			t.Errorf("detectParseMode(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestResolveParseModeOnlyDetectsForAuto(t *testing.T) {
+	if got := resolveParseMode(SendOptions{ParseMode: "HTML"}, "*bold*"); got != "HTML" {
+		t.Errorf("explicit mode = %q, want it kept", got)
+	}
+	if got := resolveParseMode(SendOptions{ParseMode: parseModeAuto}, "*bold*"); got != "Markdown" {
+		t.Errorf("auto = %q, want Markdown", got)
+	}
+}
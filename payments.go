@@ -0,0 +1,416 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	stripeWebhookMaxBytes          = 1 << 20
+	defaultStripeWebhookTolerance  = 5 * time.Minute
+	stripeEventDedupTTL            = 72 * time.Hour // how long Stripe keeps retrying a delivery
+	defaultStripeLineItemsToReport = 3
+)
+
+var defaultStripeEvents = []string{"checkout.session.completed", "invoice.paid", "charge.refunded"}
+
+// stripeEvents remembers delivered event IDs, since Stripe may deliver an
+// event more than once.
+var stripeEvents = newDedupStore("stripe_events")
+
+// zeroDecimalCurrencies are the currencies Stripe amounts are not in
+// hundredths of.
+var zeroDecimalCurrencies = []string{
+	"bif", "clp", "djf", "gnf", "jpy", "kmf", "krw", "mga", "pyg", "rwf",
+	"ugx", "vnd", "vuv", "xaf", "xof", "xpf",
+}
+
+// payment is what a payment notification reports, whichever provider it
+// came from.
+type payment struct {
+	Title    string
+	Amount   string
+	Interval string
+	Customer string
+	Product  string
+	Test     bool
+}
+
+type stripeEvent struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Livemode bool   `json:"livemode"`
+	Data     struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+type stripeCheckoutSession struct {
+	ID              string `json:"id"`
+	AmountTotal     int64  `json:"amount_total"`
+	Currency        string `json:"currency"`
+	Mode            string `json:"mode"`
+	PaymentStatus   string `json:"payment_status"`
+	CustomerDetails *struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	} `json:"customer_details"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+type stripeInvoice struct {
+	AmountPaid    int64  `json:"amount_paid"`
+	Currency      string `json:"currency"`
+	CustomerName  string `json:"customer_name"`
+	CustomerEmail string `json:"customer_email"`
+	BillingReason string `json:"billing_reason"`
+	Lines         struct {
+		Data []struct {
+			Description string `json:"description"`
+		} `json:"data"`
+	} `json:"lines"`
+}
+
+type stripeCharge struct {
+	AmountRefunded int64  `json:"amount_refunded"`
+	Currency       string `json:"currency"`
+	Description    string `json:"description"`
+	BillingDetails struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	} `json:"billing_details"`
+}
+
+type gitHubSponsorshipEvent struct {
+	Action      string `json:"action"`
+	Sponsorship struct {
+		Sponsor      gitHubUser `json:"sponsor"`
+		PrivacyLevel string     `json:"privacy_level"`
+		Tier         struct {
+			Name                  string `json:"name"`
+			MonthlyPriceInDollars int    `json:"monthly_price_in_dollars"`
+			IsOneTime             bool   `json:"is_one_time"`
+		} `json:"tier"`
+	} `json:"sponsorship"`
+}
+
+// validStripeSignature checks a Stripe-Signature header, "t=<unix>,v1=<hex
+// HMAC-SHA256 of "<t>.<body>">" with possibly several v1 entries while a
+// secret is rolled, and rejects timestamps outside STRIPE_WEBHOOK_TOLERANCE.
+func validStripeSignature(body []byte, header string, config Config) bool {
+	var timestamp string
+	var signatures [][]byte
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			if sig, err := hex.DecodeString(value); err == nil {
+				signatures = append(signatures, sig)
+			}
+		}
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := time.Since(time.Unix(ts, 0))
+	if tolerance := config.StripeWebhookTolerance; age > tolerance || age < -tolerance {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(config.StripeWebhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	want := mac.Sum(nil)
+	return slices.ContainsFunc(signatures, func(sig []byte) bool { return hmac.Equal(sig, want) })
+}
+
+// handleStripeWebhook announces completed checkouts, paid invoices and
+// refunds from a Stripe webhook signed with STRIPE_WEBHOOK_SECRET. Event
+// types missing from STRIPE_EVENTS are acknowledged without sending, and
+// an event Stripe delivers again is not announced twice.
+func handleStripeWebhook(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, stripeWebhookMaxBytes))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "body_too_large", "Request body too large")
+		return
+	}
+	if !validStripeSignature(body, r.Header.Get("Stripe-Signature"), config) {
+		writeError(w, http.StatusUnauthorized, "invalid_signature", "Invalid signature")
+		return
+	}
+
+	var event stripeEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	if !slices.Contains(config.StripeEvents, event.Type) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ignored"})
+		return
+	}
+
+	p, err := stripePayment(r.Context(), config, event)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	if p == nil {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ignored"})
+		return
+	}
+
+	if !stripeEvents.reserve(event.ID, stripeEventDedupTTL) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "duplicate"})
+		return
+	}
+	if !notifyPayment(w, r, config, *p) {
+		stripeEvents.release(event.ID)
+	}
+}
+
+// stripePayment reads the payment out of event, or returns nil for events
+// not worth a message such as an unpaid checkout.
+func stripePayment(ctx context.Context, config Config, event stripeEvent) (*payment, error) {
+	switch event.Type {
+	case "checkout.session.completed":
+		var session stripeCheckoutSession
+		if err := json.Unmarshal(event.Data.Object, &session); err != nil {
+			return nil, err
+		}
+		if session.PaymentStatus == "unpaid" {
+			return nil, nil
+		}
+		p := &payment{
+			Title:   "Payment received",
+			Amount:  formatAmount(session.AmountTotal, session.Currency),
+			Product: session.Metadata["product"],
+			Test:    !event.Livemode,
+		}
+		if session.Mode == "subscription" {
+			p.Title = "New subscription"
+		}
+		if d := session.CustomerDetails; d != nil {
+			p.Customer = cmp.Or(d.Name, d.Email)
+		}
+		if p.Product == "" && config.StripeSecretKey != "" {
+			product, err := stripeLineItems(ctx, session.ID, config.StripeSecretKey)
+			if err != nil {
+				log.Printf("Warning: cannot fetch Stripe line items: %v", err)
+			}
+			p.Product = product
+		}
+		return p, nil
+
+	case "invoice.paid":
+		var invoice stripeInvoice
+		if err := json.Unmarshal(event.Data.Object, &invoice); err != nil {
+			return nil, err
+		}
+		// A new subscription's first invoice is already announced by its
+		// checkout.
+		if invoice.AmountPaid == 0 || invoice.BillingReason == "subscription_create" {
+			return nil, nil
+		}
+		p := &payment{
+			Title:    "Invoice paid",
+			Amount:   formatAmount(invoice.AmountPaid, invoice.Currency),
+			Customer: cmp.Or(invoice.CustomerName, invoice.CustomerEmail),
+			Test:     !event.Livemode,
+		}
+		if len(invoice.Lines.Data) > 0 {
+			p.Product = invoice.Lines.Data[0].Description
+		}
+		return p, nil
+
+	case "charge.refunded":
+		var charge stripeCharge
+		if err := json.Unmarshal(event.Data.Object, &charge); err != nil {
+			return nil, err
+		}
+		return &payment{
+			Title:    "Refund issued",
+			Amount:   formatAmount(charge.AmountRefunded, charge.Currency),
+			Customer: cmp.Or(charge.BillingDetails.Name, charge.BillingDetails.Email),
+			Product:  charge.Description,
+			Test:     !event.Livemode,
+		}, nil
+	}
+	return nil, nil
+}
+
+// stripeLineItems names what a checkout session sold, e.g. "2 × T-shirt,
+// Sticker", from its line items.
+func stripeLineItems(ctx context.Context, sessionID, secretKey string) (string, error) {
+	endpoint := fmt.Sprintf("%s/v1/checkout/sessions/%s/line_items?limit=%d",
+		stripeAPIBaseURL, url.PathEscape(sessionID), defaultStripeLineItemsToReport)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %v", err)
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+secretKey)
+
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("error fetching line items: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", newUpstreamError(resp)
+	}
+
+	var result struct {
+		Data []struct {
+			Description string `json:"description"`
+			Quantity    int    `json:"quantity"`
+		} `json:"data"`
+		HasMore bool `json:"has_more"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("error decoding response: %v", err)
+	}
+
+	var items []string
+	for _, item := range result.Data {
+		if item.Quantity > 1 {
+			items = append(items, fmt.Sprintf("%d × %s", item.Quantity, item.Description))
+		} else {
+			items = append(items, item.Description)
+		}
+	}
+	if result.HasMore {
+		items = append(items, "…")
+	}
+	return strings.Join(items, ", "), nil
+}
+
+// handleSponsorsWebhook announces new, changed and cancelled GitHub
+// Sponsors sponsorships from a webhook signed with SPONSORS_WEBHOOK_SECRET.
+// Pending changes and edits are acknowledged without sending.
+func handleSponsorsWebhook(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(config.GitHubWebhookMaxBytes)))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "body_too_large", "Request body too large")
+		return
+	}
+	if !validGitHubSignature(body, r.Header.Get("X-Hub-Signature-256"), config.SponsorsWebhookSecret) {
+		writeError(w, http.StatusUnauthorized, "invalid_signature", "Invalid signature")
+		return
+	}
+
+	switch r.Header.Get("X-GitHub-Event") {
+	case "ping":
+		writeJSON(w, http.StatusOK, map[string]string{"status": "pong"})
+		return
+	case "sponsorship":
+	default:
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ignored"})
+		return
+	}
+
+	var event gitHubSponsorshipEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	s := event.Sponsorship
+	p := payment{
+		Amount:   formatAmount(int64(s.Tier.MonthlyPriceInDollars)*100, "usd"),
+		Customer: s.Sponsor.Login,
+		Product:  s.Tier.Name,
+	}
+	if s.PrivacyLevel == "private" {
+		p.Customer += " (private)"
+	}
+	if !s.Tier.IsOneTime {
+		p.Interval = "month"
+	}
+	switch event.Action {
+	case "created":
+		p.Title = "New sponsor"
+	case "tier_changed":
+		p.Title = "Sponsorship changed"
+	case "cancelled":
+		p.Title = "Sponsorship cancelled"
+	default:
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ignored"})
+		return
+	}
+	notifyPayment(w, r, config, p)
+}
+
+// notifyPayment sends p through PAYMENTS_NOTIFY_CHANNEL, a /send channel
+// that defaults to Telegram, and writes the response. It reports whether
+// any channel got the message; when none did the webhook answers with an
+// error so the provider retries.
+func notifyPayment(w http.ResponseWriter, r *http.Request, config Config, p payment) bool {
+	text, err := renderTemplate("payment", map[string]interface{}{
+		"title":    p.Title,
+		"amount":   p.Amount,
+		"interval": p.Interval,
+		"customer": p.Customer,
+		"product":  p.Product,
+		"test":     p.Test,
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Cannot render the notification")
+		return false
+	}
+
+	notifiers, _ := notifiersFor(config.PaymentsNotifyChannel, config)
+	if notifiers == nil {
+		notifiers = []Notifier{telegramNotifier{config: config}}
+	}
+	results, err := notifyAll(r.Context(), notifiers, text, SendOptions{ParseMode: "HTML"})
+	if err != nil {
+		writeUpstreamError(w, err)
+		return false
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  "Notification sent",
+		"results": results,
+	})
+	return true
+}
+
+// formatAmount renders a Stripe-style amount in the currency's minor unit,
+// e.g. 1250 "usd" as "12.50 USD".
+func formatAmount(amount int64, currency string) string {
+	code := strings.ToUpper(currency)
+	if slices.Contains(zeroDecimalCurrencies, strings.ToLower(currency)) {
+		return fmt.Sprintf("%d %s", amount, code)
+	}
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	return fmt.Sprintf("%s%d.%02d %s", sign, amount/100, amount%100, code)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stripeSignature signs body as Stripe does at ts.
+func stripeSignature(secret string, ts time.Time, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.%s", ts.Unix(), body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidStripeSignature(t *testing.T) {
+	config := configWith(t, "STRIPE_WEBHOOK_SECRET", "whsec_test")
+	body := `{"id":"evt_1","type":"invoice.paid"}`
+	now := time.Now()
+	good := stripeSignature("whsec_test", now, body)
+	wrong := stripeSignature("whsec_other", now, body)
+
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{"valid", fmt.Sprintf("t=%d,v1=%s", now.Unix(), good), true},
+		{"spaces around entries", fmt.Sprintf("t=%d, v1=%s", now.Unix(), good), true},
+		{"wrong secret", fmt.Sprintf("t=%d,v1=%s", now.Unix(), wrong), false},
+		{"rolled secret, new one second", fmt.Sprintf("t=%d,v1=%s,v1=%s", now.Unix(), wrong, good), true},
+		{"several entries, none valid", fmt.Sprintf("t=%d,v1=%s,v1=%s", now.Unix(), wrong, strings.Repeat("00", 32)), false},
+		{"v0 entries are ignored", fmt.Sprintf("t=%d,v0=%s", now.Unix(), good), false},
+		{"signature for another timestamp", fmt.Sprintf("t=%d,v1=%s", now.Unix()+1, good), false},
+		{"stale", fmt.Sprintf("t=%d,v1=%s", now.Add(-10*time.Minute).Unix(), stripeSignature("whsec_test", now.Add(-10*time.Minute), body)), false},
+		{"from the future", fmt.Sprintf("t=%d,v1=%s", now.Add(10*time.Minute).Unix(), stripeSignature("whsec_test", now.Add(10*time.Minute), body)), false},
+		{"no timestamp", "v1=" + good, false},
+		{"not hex", fmt.Sprintf("t=%d,v1=zz", now.Unix()), false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validStripeSignature([]byte(body), tt.header, config); got != tt.want {
+				t.Errorf("validStripeSignature(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+
+	// A tampered body fails with an otherwise valid header.
+	if validStripeSignature([]byte(body+" "), fmt.Sprintf("t=%d,v1=%s", now.Unix(), good), config) {
+		t.Error("a modified body was accepted")
+	}
+}
+
+func TestStripeWebhookToleranceIsConfigurable(t *testing.T) {
+	config := configWith(t, "STRIPE_WEBHOOK_SECRET", "whsec_test", "STRIPE_WEBHOOK_TOLERANCE", "1h")
+	ts := time.Now().Add(-30 * time.Minute)
+	header := fmt.Sprintf("t=%d,v1=%s", ts.Unix(), stripeSignature("whsec_test", ts, "{}"))
+	if !validStripeSignature([]byte("{}"), header, config) {
+		t.Error("a 30 minute old signature was rejected with a 1h tolerance")
+	}
+}
+
+func TestStripeWebhookRejectsBadSignatures(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+	config := configWith(t, "STRIPE_WEBHOOK_SECRET", "whsec_test")
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/stripe", strings.NewReader(`{"id":"evt_forged","type":"invoice.paid"}`))
+	req.Header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=%s", time.Now().Unix(), strings.Repeat("ab", 32)))
+	rec := httptest.NewRecorder()
+	handleStripeWebhook(rec, req, config)
+
+	if rec.Code != http.StatusUnauthorized || decodeError(t, rec).Code != "invalid_signature" {
+		t.Errorf("got %d %s, want 401 invalid_signature", rec.Code, rec.Body)
+	}
+	if n := len(fake.Calls()); n != 0 {
+		t.Errorf("%d messages sent for a forged event", n)
+	}
+}
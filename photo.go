@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Telegram accepts photos of up to 10 MB when uploaded directly.
+const defaultMaxPhotoBytes = 10 << 20
+
+// Telegram limits captions to 1024 characters, counted in UTF-16 code units.
+const maxCaptionLength = 1024
+
+// fitCaption applies overflow, the CAPTION_OVERFLOW setting, to a caption
+// longer than Telegram allows: "truncate" cuts it to fit and appends an
+// ellipsis, anything else rejects it. It returns the caption to send,
+// whether it was truncated, and false if the caption must be rejected.
+// Truncation works on the raw text, so markup cut in half may still be
+// refused by Telegram.
+func fitCaption(caption, overflow string) (string, bool, bool) {
+	if len(utf16.Encode([]rune(caption))) <= maxCaptionLength {
+		return caption, false, true
+	}
+	if overflow != "truncate" {
+		return "", false, false
+	}
+
+	const ellipsis = "…"
+	n := 0
+	for i, r := range caption {
+		if n += utf16.RuneLen(r); n > maxCaptionLength-1 {
+			return strings.TrimRight(caption[:i], " \t\n") + ellipsis, true, true
+		}
+	}
+	return caption, false, true
+}
+
+type PhotoRequest struct {
+	PhotoURL    string `json:"photo_url,omitempty"`
+	PhotoBase64 string `json:"photo_base64,omitempty"`
+	Caption     string `json:"caption,omitempty"`
+	ParseMode   string `json:"parse_mode,omitempty"`
+}
+
+type TelegramPhoto struct {
+	ChatID    string `json:"chat_id"`
+	Photo     string `json:"photo"`
+	Caption   string `json:"caption,omitempty"`
+	ParseMode string `json:"parse_mode,omitempty"`
+}
+
+// sendTelegramPhoto sends photo by URL, letting Telegram fetch it, or uploads
+// data as multipart form data when it is set. photo goes to config.ChatID.
+func sendTelegramPhoto(ctx context.Context, config Config, photo TelegramPhoto, data []byte) (*TelegramSentMessage, error) {
+	if err := paceChat(ctx, config); err != nil {
+		return nil, err
+	}
+
+	var result json.RawMessage
+	var err error
+	if data == nil {
+		result, err = callTelegram(ctx, config, "sendPhoto", photo)
+	} else {
+		result, err = uploadTelegramPhoto(ctx, config, photo, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var sent TelegramSentMessage
+	if err := json.Unmarshal(result, &sent); err != nil {
+		return nil, fmt.Errorf("error decoding response: %v", err)
+	}
+	sent.Raw = result
+	return &sent, nil
+}
+
+func uploadTelegramPhoto(ctx context.Context, config Config, photo TelegramPhoto, data []byte) (json.RawMessage, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("chat_id", photo.ChatID)
+	if photo.Caption != "" {
+		form.WriteField("caption", photo.Caption)
+		form.WriteField("parse_mode", photo.ParseMode)
+	}
+	part, err := form.CreateFormFile("photo", "photo")
+	if err != nil {
+		return nil, fmt.Errorf("error creating upload: %v", err)
+	}
+	part.Write(data)
+	if err := form.Close(); err != nil {
+		return nil, fmt.Errorf("error creating upload: %v", err)
+	}
+
+	return postTelegram(ctx, config, "sendPhoto", form.FormDataContentType(), body.Bytes())
+}
+
+func handleSendPhoto(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	maxPhotoBytes := config.MaxPhotoBytes
+	var req PhotoRequest
+	if !decodeBodyLimit(w, r, &req, int64(base64.StdEncoding.EncodedLen(maxPhotoBytes))+int64(config.MaxBodyBytes)) {
+		return
+	}
+
+	if (req.PhotoURL == "") == (req.PhotoBase64 == "") {
+		writeError(w, http.StatusBadRequest, "photo_required", "Exactly one of photo_url or photo_base64 is required")
+		return
+	}
+
+	var data []byte
+	if req.PhotoURL != "" {
+		if u, err := url.Parse(req.PhotoURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			writeError(w, http.StatusBadRequest, "invalid_photo_url", "Photo URL must be an http or https URL")
+			return
+		}
+	} else {
+		encoded := req.PhotoBase64
+		// Accept data URLs as produced by FileReader.readAsDataURL.
+		if strings.HasPrefix(encoded, "data:") {
+			if _, after, ok := strings.Cut(encoded, ","); ok {
+				encoded = after
+			}
+		}
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(decoded) == 0 {
+			writeError(w, http.StatusBadRequest, "invalid_photo", "Photo is not valid base64")
+			return
+		}
+		if len(decoded) > maxPhotoBytes {
+			writeJSON(w, http.StatusRequestEntityTooLarge, ErrorResponse{Message: "Photo is too large", Code: "body_too_large"})
+			return
+		}
+		data = decoded
+	}
+
+	caption, truncated, ok := fitCaption(req.Caption, config.CaptionOverflow)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Message: fmt.Sprintf("Caption is longer than %d characters", maxCaptionLength), Code: "caption_too_long"})
+		return
+	}
+	req.Caption = caption
+
+	opts, msg := sendOptionsFor(MessageRequest{Message: req.Caption, ParseMode: req.ParseMode}, config)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", msg)
+		return
+	}
+
+	photo := TelegramPhoto{ChatID: config.ChatID, Photo: req.PhotoURL, Caption: req.Caption}
+	if req.Caption != "" {
+		photo.ParseMode = resolveParseMode(opts, req.Caption)
+	}
+
+	sent, err := sendTelegramPhoto(r.Context(), config, photo, data)
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+
+	auditLog.record("send_photo", map[string]string{
+		"chat_id":    config.ChatID,
+		"message_id": strconv.FormatInt(sent.MessageID, 10),
+	})
+
+	resp := map[string]interface{}{
+		"status":     "Photo sent successfully",
+		"message_id": sent.MessageID,
+	}
+	if truncated {
+		resp["caption_truncated"] = true
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCaptionLengthBoundary(t *testing.T) {
+	const emoji = "😀" // two UTF-16 code units
+	tests := []struct {
+		name      string
+		overflow  string
+		caption   string
+		status    int
+		truncated bool
+	}{
+		{"at the limit", "", strings.Repeat("a", maxCaptionLength), http.StatusOK, false},
+		{"one over, rejected", "", strings.Repeat("a", maxCaptionLength+1), http.StatusBadRequest, false},
+		{"at the limit in UTF-16", "", strings.Repeat(emoji, maxCaptionLength/2), http.StatusOK, false},
+		{"one over in UTF-16, rejected", "", strings.Repeat(emoji, maxCaptionLength/2+1), http.StatusBadRequest, false},
+		{"at the limit, truncate", "truncate", strings.Repeat("a", maxCaptionLength), http.StatusOK, false},
+		{"one over, truncated", "truncate", strings.Repeat("a", maxCaptionLength+1), http.StatusOK, true},
+		{"one over in UTF-16, truncated", "truncate", strings.Repeat(emoji, maxCaptionLength/2+1), http.StatusOK, true},
+	}
+
+	endpoints := []struct {
+		name    string
+		handler func(http.ResponseWriter, *http.Request, Config)
+		body    func(caption string) string
+	}{
+		{"/send/photo", handleSendPhoto,
+			func(caption string) string {
+				return fmt.Sprintf(`{"photo_url":"https://example.com/a.png","caption":%q}`, caption)
+			}},
+		{"/send", handleSendMessage,
+			func(caption string) string {
+				return fmt.Sprintf(`{"photo_url":"https://example.com/a.png","message":%q}`, caption)
+			}},
+	}
+
+	for _, ep := range endpoints {
+		for _, tt := range tests {
+			t.Run(ep.name+"/"+tt.name, func(t *testing.T) {
+				config := configWith(t, "CAPTION_OVERFLOW", tt.overflow)
+				fake, _ := useFakeUpstreams(t)
+
+				rec := serve(bind(ep.handler, config), http.MethodPost, ep.name, ep.body(tt.caption))
+				if rec.Code != tt.status {
+					t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+				}
+				if tt.status != http.StatusOK {
+					if resp := decodeError(t, rec); resp.Code != "caption_too_long" {
+						t.Errorf("code = %q, want caption_too_long", resp.Code)
+					}
+					if n := len(fake.Calls()); n != 0 {
+						t.Errorf("%d Telegram calls, want none", n)
+					}
+					return
+				}
+
+				calls := fake.Calls()
+				if len(calls) != 1 || calls[0].Method != "sendPhoto" {
+					t.Fatalf("calls = %+v, want one sendPhoto", calls)
+				}
+				var sent struct {
+					Caption string `json:"caption"`
+				}
+				if err := json.Unmarshal(calls[0].Body, &sent); err != nil {
+					t.Fatal(err)
+				}
+				if n := messageLength(sent.Caption); n > maxCaptionLength {
+					t.Errorf("sent a caption of %d code units, over the limit", n)
+				}
+				if tt.truncated {
+					if !strings.HasSuffix(sent.Caption, "…") || !strings.HasPrefix(tt.caption, strings.TrimSuffix(sent.Caption, "…")) {
+						t.Errorf("caption %q is not a prefix of the original with an ellipsis", sent.Caption)
+					}
+				} else if sent.Caption != tt.caption {
+					t.Errorf("caption was changed to %q", sent.Caption)
+				}
+				if ep.name == "/send/photo" {
+					var resp map[string]interface{}
+					json.Unmarshal(rec.Body.Bytes(), &resp)
+					if got := resp["caption_truncated"] == true; got != tt.truncated {
+						t.Errorf("caption_truncated = %v, want %v", resp["caption_truncated"], tt.truncated)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// TelegramAPI calls Bot API methods. body is the encoded request, sent as
+// contentType, and the method's "result" field is returned. Failed calls
+// return an *UpstreamError so callers can retry them.
+type TelegramAPI interface {
+	Call(ctx context.Context, token, method, contentType string, body []byte) (json.RawMessage, error)
+}
+
+// BeehiivAPI calls the Beehiiv API for one publication. path is relative to
+// the publication, e.g. "/subscriptions", and payload, when not nil, is sent
+// as JSON. It returns the response body, or an *UpstreamError for a non-2xx
+// response.
+type BeehiivAPI interface {
+	Do(ctx context.Context, method, path string, payload interface{}) ([]byte, error)
+}
+
+// telegram and beehiiv are the clients every handler goes through. main
+// replaces them once the environment has been read, with fakes under
+// API_MODE=dry-run; tests can swap in their own.
+var (
+	telegram TelegramAPI = newBotAPIClient(telegramAPIBaseURL, telegramClient)
+	beehiiv  BeehiivAPI  = newBeehiivAPIClient(beehiivAPIBaseURL, "", "", "", beehiivClient)
+)
+
+// newUpstreamAPIs returns the Telegram and Beehiiv APIs for API_MODE: the
+// real ones, or under dry-run in-memory fakes that log each call instead.
+func newUpstreamAPIs(settings *Settings) (TelegramAPI, BeehiivAPI) {
+	if settings.APIMode == "dry-run" {
+		return newFakeTelegram(), newFakeBeehiiv()
+	}
+	return newBotAPIClient(telegramAPIBaseURL, telegramClient), newBeehiivAPI(settings)
+}
+
+// botAPIClient is the real Bot API, at baseURL.
+type botAPIClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func newBotAPIClient(baseURL string, client *http.Client) *botAPIClient {
+	return &botAPIClient{baseURL: baseURL, client: client}
+}
+
+func (c *botAPIClient) Call(ctx context.Context, token, method, contentType string, body []byte) (json.RawMessage, error) {
+	endpoint := fmt.Sprintf("%s/bot%s/%s", c.baseURL, token, method)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", contentType)
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		// The endpoint embeds the bot token, so keep it out of the error.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("error sending message: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newUpstreamError(resp)
+	}
+
+	var telegramResp TelegramResponse
+	if err := json.NewDecoder(resp.Body).Decode(&telegramResp); err != nil {
+		return nil, fmt.Errorf("error decoding response: %v", err)
+	}
+	return telegramResp.Result, nil
+}
+
+// beehiivAPIClient is the real Beehiiv API for publicationID, authenticated
+// with apiKey. An empty version means v2.
+type beehiivAPIClient struct {
+	baseURL       string
+	version       string
+	publicationID string
+	apiKey        string
+	client        *http.Client
+}
+
+func newBeehiivAPIClient(baseURL, version, publicationID, apiKey string, client *http.Client) *beehiivAPIClient {
+	if version == "" {
+		version = "v2"
+	}
+	return &beehiivAPIClient{
+		baseURL:       baseURL,
+		version:       version,
+		publicationID: publicationID,
+		apiKey:        apiKey,
+		client:        client,
+	}
+}
+
+// newBeehiivAPI returns the configured Beehiiv API, or under
+// BEEHIIV_SANDBOX an in-memory fake that never reaches Beehiiv.
+func newBeehiivAPI(settings *Settings) BeehiivAPI {
+	if settings.BeehiivSandbox {
+		return newFakeBeehiiv()
+	}
+	return newBeehiivAPIClient(beehiivAPIBaseURL, settings.BeehiivAPIVersion, settings.BeehiivPublicationID, settings.BeehiivAPIKey, beehiivClient)
+}
+
+func (c *beehiivAPIClient) Do(ctx context.Context, method, path string, payload interface{}) ([]byte, error) {
+	if c.publicationID == "" {
+		return nil, fmt.Errorf("BEEHIIV_PUBLICATION_ID environment variable is required")
+	}
+	if c.apiKey == "" {
+		return nil, fmt.Errorf("BEEHIIV_API_KEY environment variable is required")
+	}
+
+	var body io.Reader
+	if payload != nil {
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling payload: %v", err)
+		}
+		body = bytes.NewReader(jsonData)
+	}
+
+	endpoint := fmt.Sprintf("%s/%s/publications/%s%s", c.baseURL, c.version, c.publicationID, path)
+	httpReq, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	if payload != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, newUpstreamError(resp)
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %v", err)
+	}
+	return respBody, nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBeehiivAPIVersionInURL(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+	}{
+		{"", "/v2/publications/pub_test/subscriptions"},
+		{"v3", "/v3/publications/pub_test/subscriptions"},
+	}
+	for _, tt := range tests {
+		t.Run("BEEHIIV_API_VERSION="+tt.version, func(t *testing.T) {
+			var got string
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.URL.Path
+				w.Write([]byte(`{"data":{"id":"sub_1"}}`))
+			}))
+			defer upstream.Close()
+
+			defer func(orig string) { beehiivAPIBaseURL = orig }(beehiivAPIBaseURL)
+			beehiivAPIBaseURL = upstream.URL
+			settings := configWith(t,
+				"BEEHIIV_API_VERSION", tt.version,
+				"BEEHIIV_PUBLICATION_ID", "pub_test",
+				"BEEHIIV_API_KEY", "key",
+				"BEEHIIV_SANDBOX", "",
+			).Settings
+
+			if _, err := newBeehiivAPI(settings).Do(context.Background(), http.MethodPost, "/subscriptions", map[string]string{"email": "v@example.com"}); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("path = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBeehiivRedirects(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxRedirects int
+		status       int
+		followed     bool
+	}{
+		{"not followed by default", 0, http.StatusPermanentRedirect, false},
+		{"followed when allowed", 1, http.StatusPermanentRedirect, true},
+		{"307 followed when allowed", 1, http.StatusTemporaryRedirect, true},
+		{"POST turned into GET", 1, http.StatusFound, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var moved []string
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/v2/publications/pub_test/subscriptions" {
+					http.Redirect(w, r, "/v3/publications/pub_test/subscriptions", tt.status)
+					return
+				}
+				body, _ := io.ReadAll(r.Body)
+				moved = append(moved, r.Method+" "+string(body))
+				w.Write([]byte(`{"data":{"id":"sub_moved"}}`))
+			}))
+			defer upstream.Close()
+
+			client := newBeehiivAPIClient(upstream.URL, "", "pub_test", "key", newBeehiivClient(upstream.Client(), tt.maxRedirects))
+			body, err := client.Do(context.Background(), http.MethodPost, "/subscriptions", map[string]string{"email": "moved@example.com"})
+
+			if tt.followed {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(string(body), "sub_moved") {
+					t.Errorf("body = %s", body)
+				}
+				if len(moved) != 1 || moved[0] != `POST {"email":"moved@example.com"}` {
+					t.Errorf("the new location got %q, want the same POST", moved)
+				}
+				return
+			}
+
+			var upErr *UpstreamError
+			if !errors.As(err, &upErr) || upErr.StatusCode != tt.status {
+				t.Fatalf("err = %v, want an UpstreamError with status %d", err, tt.status)
+			}
+			if upErr.Message != "redirected to /v3/publications/pub_test/subscriptions" {
+				t.Errorf("message = %q, want it to name the new location", upErr.Message)
+			}
+			if len(moved) != 0 {
+				t.Errorf("the redirect was followed: %q", moved)
+			}
+		})
+	}
+}
+
+func TestDryRunModeUsesFakes(t *testing.T) {
+	var upstreamCalls atomic.Int32
+	count := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			upstreamCalls.Add(1)
+			w.Write([]byte(body))
+		}
+	}
+	useUpstreamServers(t,
+		count(`{"ok":true,"result":{"message_id":7,"chat":{"id":5}}}`),
+		count(`{"data":{"id":"sub_live"}}`))
+	t.Setenv("BEEHIIV_PUBLICATION_ID", "pub_test")
+	t.Setenv("BEEHIIV_API_KEY", "key")
+	t.Setenv("UPSTREAM_MAX_ATTEMPTS", "1")
+
+	tests := []struct {
+		mode     string
+		upstream bool
+	}{
+		{"live", true},
+		{"dry-run", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			config := configWith(t, "API_MODE", tt.mode)
+			upstreamCalls.Store(0)
+			telegram, beehiiv = newUpstreamAPIs(config.Settings)
+
+			if rec := serve(bind(handleSendMessage, config), http.MethodPost, "/send", `{"message":"mode `+tt.mode+`"}`); rec.Code != http.StatusOK {
+				t.Errorf("send: status = %d: %s", rec.Code, rec.Body)
+			}
+			if rec := serve(bind(handleSubscribe, config), http.MethodPost, "/subscribe", `{"email":"mode-`+tt.mode+`@example.com"}`); rec.Code != http.StatusOK {
+				t.Errorf("subscribe: status = %d: %s", rec.Code, rec.Body)
+			}
+
+			if got := upstreamCalls.Load() > 0; got != tt.upstream {
+				t.Errorf("upstreams called = %v, want %v", got, tt.upstream)
+			}
+			if tt.upstream {
+				return
+			}
+			fakeTG, ok := telegram.(*fakeTelegram)
+			if !ok {
+				t.Fatalf("telegram is a %T, want the fake", telegram)
+			}
+			if got := sentText(t, fakeTG); got != "mode dry-run" {
+				t.Errorf("fake Telegram recorded %q", got)
+			}
+			fakeBH, ok := beehiiv.(*fakeBeehiiv)
+			if !ok {
+				t.Fatalf("beehiiv is a %T, want the fake", beehiiv)
+			}
+			if beehiivCalls(fakeBH, http.MethodPost, "/subscriptions") != 1 {
+				t.Errorf("fake Beehiiv calls = %v, want one subscription", fakeBH.Calls())
+			}
+		})
+	}
+}
+
+func TestFakeBeehiivKeepsSubscriptions(t *testing.T) {
+	_, fakeBH := useFakeUpstreams(t)
+	ctx := context.Background()
+
+	if _, err := lookupBeehiivSubscriber(ctx, "fake@example.com"); !errors.Is(err, errSubscriberNotFound) {
+		t.Fatalf("lookup before subscribing: %v, want errSubscriberNotFound", err)
+	}
+	id, err := subscribeToBeehiiv(ctx, testConfig, SubscribeRequest{Email: "fake@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscriber, err := lookupBeehiivSubscriber(ctx, "fake@example.com")
+	if err != nil || subscriber.ID != id || subscriber.Status != "active" {
+		t.Fatalf("lookup after subscribing = %+v, %v, want %s active", subscriber, err, id)
+	}
+
+	if _, err := fakeBH.Do(ctx, http.MethodPatch, "/subscriptions/"+id, map[string]bool{"unsubscribe": true}); err != nil {
+		t.Fatal(err)
+	}
+	if subscriber, err := lookupBeehiivSubscriber(ctx, "fake@example.com"); err != nil || subscriber.Status != "inactive" {
+		t.Errorf("lookup after unsubscribing = %+v, %v, want inactive", subscriber, err)
+	}
+}
+
+func TestDryRunNeedsNoBeehiivCredentials(t *testing.T) {
+	t.Setenv("TELEGRAM_BOT_TOKEN", "1:test")
+	t.Setenv("TELEGRAM_CHAT_ID", "-100")
+	t.Setenv("BEEHIIV_API_KEY", "")
+	t.Setenv("BEEHIIV_PUBLICATION_ID", "")
+	t.Setenv("BEEHIIV_SANDBOX", "")
+
+	mentions := func(problems []string, s string) bool {
+		for _, p := range problems {
+			if strings.Contains(p, s) {
+				return true
+			}
+		}
+		return false
+	}
+
+	t.Setenv("API_MODE", "live")
+	if _, problems := loadSettings(); !mentions(problems, "BEEHIIV_API_KEY") {
+		t.Error("live mode: missing BEEHIIV_API_KEY not reported")
+	}
+	t.Setenv("API_MODE", "dry-run")
+	if _, problems := loadSettings(); mentions(problems, "BEEHIIV") {
+		t.Errorf("dry-run mode: %v, want no Beehiiv problems", problems)
+	}
+	t.Setenv("API_MODE", "staging")
+	if _, problems := loadSettings(); !mentions(problems, "API_MODE must be live or dry-run") {
+		t.Error("an unknown API_MODE was accepted")
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const defaultRateLimitPerMinute = 60
+
+// publicRequests limits /send, /subscribe and the other unauthenticated
+// endpoints per client IP. It is nil when RATE_LIMIT_PER_MINUTE is 0.
+var publicRequests *rateLimiter
+
+// routeRequests holds the RATE_LIMIT_ROUTES limiters by path, applied on
+// top of publicRequests.
+var routeRequests map[string]*rateLimiter
+
+// bucket is one key's tokens as of updated.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimitRedis, when RATE_LIMIT_REDIS_URL is set, holds the buckets of
+// every limiter created afterwards so instances share their budgets.
+var rateLimitRedis *redis.Client
+
+const rateLimitRedisTimeout = 250 * time.Millisecond
+
+// redisBucketScript refills the key's bucket by the time elapsed on the
+// Redis clock and takes a token if there is one. It returns 1 and 0 if a
+// token was taken, or 0 and the milliseconds until one will be available.
+// The key expires once its bucket would be full again.
+var redisBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local perMs = tonumber(ARGV[2])
+local t = redis.call("TIME")
+local now = t[1] * 1000 + t[2] / 1000
+local state = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(state[1]) or capacity
+local updated = tonumber(state[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - updated) * perMs)
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) / perMs)
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil((capacity - tokens) / perMs) + 1)
+if wait > 0 then
+	return {0, wait}
+end
+return {1, 0}
+`)
+
+// rateLimiter is a token bucket per key: each key may burst up to limit
+// events and then gets limit more spread evenly over every period. Buckets
+// are kept in memory or, when rateLimitRedis is set, in Redis.
+type rateLimiter struct {
+	mu      sync.Mutex
+	name    string
+	limit   int
+	period  time.Duration
+	buckets *ttlCache[*bucket]
+	redis   *redis.Client
+}
+
+func newRateLimiter(name string, limit int, period time.Duration) *rateLimiter {
+	return &rateLimiter{name: name, limit: limit, period: period, buckets: newTTLCache[*bucket](name, 0), redis: rateLimitRedis}
+}
+
+// refillTime is how long a bucket takes to gain one token.
+func (l *rateLimiter) refillTime() time.Duration {
+	return l.period / time.Duration(l.limit)
+}
+
+// refill brings b's tokens up to now.
+func (l *rateLimiter) refill(b *bucket, now time.Time) {
+	b.tokens = math.Min(float64(l.limit), b.tokens+float64(now.Sub(b.updated))/float64(l.refillTime()))
+	b.updated = now
+}
+
+// untilFull is how long b takes to refill completely, after which it is
+// the same as having no bucket at all.
+func (l *rateLimiter) untilFull(b *bucket) time.Duration {
+	return time.Duration((float64(l.limit) - b.tokens) * float64(l.refillTime()))
+}
+
+// allow takes a token from key's bucket. When the bucket is empty it
+// returns false and how long until the next token.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	if l.redis != nil {
+		return l.allowRedis(key)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets.get(key)
+	if !ok {
+		b = &bucket{tokens: float64(l.limit), updated: now}
+	}
+	l.refill(b, now)
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) * float64(l.refillTime()))
+	}
+	b.tokens--
+	l.buckets.set(key, b, l.untilFull(b))
+	return true, 0
+}
+
+// allowRedis takes the token in Redis. If Redis can't be reached the
+// request is allowed, so an outage of the limiter doesn't take the API down
+// with it.
+func (l *rateLimiter) allowRedis(key string) (bool, time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), rateLimitRedisTimeout)
+	defer cancel()
+
+	perMs := float64(l.limit) / float64(l.period.Milliseconds())
+	res, err := redisBucketScript.Run(ctx, l.redis, []string{"ratelimit:" + l.name + ":" + key}, l.limit, perMs).Int64Slice()
+	if err != nil || len(res) != 2 {
+		log.Printf("Warning: rate limiter %s unavailable, allowing request: %v", l.name, err)
+		return true, 0
+	}
+
+	if res[0] == 0 {
+		return false, time.Duration(res[1]) * time.Millisecond
+	}
+	return true, 0
+}
+
+type LimiterKeyState struct {
+	KeyHash   string  `json:"key_hash"`
+	Used      int     `json:"used"`
+	Remaining int     `json:"remaining"`
+	FullIn    float64 `json:"full_in_seconds"`
+}
+
+type LimiterState struct {
+	Backend       string            `json:"backend"`
+	Limit         int               `json:"limit"`
+	PeriodSeconds float64           `json:"period_seconds"`
+	ActiveKeys    []LimiterKeyState `json:"active_keys"`
+}
+
+// snapshot reports the buckets that aren't full: the whole tokens left in
+// each and how long until it is full again. Keys are client IPs, so they
+// are hashed before leaving the process. Buckets kept in Redis aren't
+// listed.
+func (l *rateLimiter) snapshot() LimiterState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	state := LimiterState{
+		Backend:       "memory",
+		Limit:         l.limit,
+		PeriodSeconds: l.period.Seconds(),
+		ActiveKeys:    []LimiterKeyState{},
+	}
+	if l.redis != nil {
+		state.Backend = "redis"
+		return state
+	}
+	l.buckets.each(func(key string, b *bucket, expires time.Time) {
+		current := *b
+		l.refill(&current, now)
+		remaining := int(current.tokens)
+		state.ActiveKeys = append(state.ActiveKeys, LimiterKeyState{
+			KeyHash:   hashIP(key),
+			Used:      l.limit - remaining,
+			Remaining: remaining,
+			FullIn:    l.untilFull(&current).Seconds(),
+		})
+	})
+	return state
+}
+
+// limitByIP rejects a client with 429 once it exceeds limiter's budget. A nil
+// limiter disables the check.
+func limitByIP(limiter *rateLimiter, next http.HandlerFunc) http.HandlerFunc {
+	if limiter == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, retryAfter := limiter.allow(clientIP(r)); !ok {
+			writeTooManyRequests(w, retryAfter)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// parseRouteLimits parses RATE_LIMIT_ROUTES, a comma-separated list of
+// path=requests-per-minute entries such as "/send=10,/subscribe=5". Entries
+// with a missing or non-positive limit are skipped.
+func parseRouteLimits(value string) map[string]*rateLimiter {
+	limiters := make(map[string]*rateLimiter)
+	for _, entry := range splitList(value) {
+		path, limit, ok := strings.Cut(entry, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(limit))
+		if !ok || path == "" || err != nil || n <= 0 {
+			log.Printf("Warning: ignoring invalid RATE_LIMIT_ROUTES entry %q", entry)
+			continue
+		}
+		limiters[path] = newRateLimiter("route_rate_limit:"+path, n, time.Minute)
+	}
+	return limiters
+}
+
+// limitRoutes applies the per-route limiters on top of any shared budget, so
+// a route like /subscribe can be held tighter than the rest of the API.
+func limitRoutes(next http.Handler, limiters map[string]*rateLimiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limiter, ok := limiters[r.URL.Path]; ok {
+			if ok, retryAfter := limiter.allow(clientIP(r)); !ok {
+				writeTooManyRequests(w, retryAfter)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withRouteLimit applies path's RATE_LIMIT_ROUTES limiter to a handler
+// reached by another path, like a /batch operation, so batching doesn't
+// multiply the route's budget.
+func withRouteLimit(path string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if limiter, ok := routeRequests[path]; ok {
+			if ok, retryAfter := limiter.allow(clientIP(r)); !ok {
+				writeTooManyRequests(w, retryAfter)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+func writeTooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writeError(w, http.StatusTooManyRequests, "rate_limited", "Too many requests")
+}
+
+// handleRateLimitDebug reports every limiter's buckets: the public and
+// subscriber lookup limiters, and each RATE_LIMIT_ROUTES limiter as
+// "route:<path>".
+func handleRateLimitDebug(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	states := map[string]LimiterState{
+		"subscriber_lookup": subscriberLookups.snapshot(),
+	}
+	if publicRequests != nil {
+		states["public"] = publicRequests.snapshot()
+	}
+	for path, limiter := range routeRequests {
+		states["route:"+path] = limiter.snapshot()
+	}
+	writeJSON(w, http.StatusOK, states)
+}
+
+type LimitsResponse struct {
+	RequestsPerMinute          int            `json:"requests_per_minute,omitempty"`
+	RouteRequestsPerMinute     map[string]int `json:"route_requests_per_minute,omitempty"`
+	SubscriberLookupsPerMinute int            `json:"subscriber_lookups_per_minute"`
+	ChatMinIntervalSeconds     float64        `json:"chat_min_interval_seconds"`
+	MaxInFlightRequests        int            `json:"max_in_flight_requests,omitempty"`
+	MaxRequestTimeoutMs        int64          `json:"max_request_timeout_ms"`
+	MaxBatchSize               int            `json:"max_batch_size"`
+}
+
+func handleLimits(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	var requestsPerMinute int
+	if publicRequests != nil {
+		requestsPerMinute = publicRequests.limit
+	}
+
+	var routeRequestsPerMinute map[string]int
+	if len(routeRequests) > 0 {
+		routeRequestsPerMinute = make(map[string]int, len(routeRequests))
+		for path, limiter := range routeRequests {
+			routeRequestsPerMinute[path] = limiter.limit
+		}
+	}
+
+	writeJSON(w, http.StatusOK, LimitsResponse{
+		RequestsPerMinute:          requestsPerMinute,
+		RouteRequestsPerMinute:     routeRequestsPerMinute,
+		SubscriberLookupsPerMinute: subscriberLookups.limit,
+		ChatMinIntervalSeconds:     config.ChatMinInterval.Seconds(),
+		MaxInFlightRequests:        config.MaxInFlightRequests,
+		MaxRequestTimeoutMs:        config.RequestTimeoutMax.Milliseconds(),
+		MaxBatchSize:               config.MaxBatchSize,
+	})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useLimiters replaces the public and subscriber lookup limiters until the
+// test ends.
+func useLimiters(t *testing.T, public, lookups int) {
+	t.Helper()
+	origPublic, origLookups := publicRequests, subscriberLookups
+	t.Cleanup(func() { publicRequests, subscriberLookups = origPublic, origLookups })
+	publicRequests = newRateLimiter("public_rate_limit", public, time.Minute)
+	subscriberLookups = newRateLimiter("subscriber_lookup_rate_limit", lookups, time.Minute)
+}
+
+func TestRateLimitDebugReflectsActivity(t *testing.T) {
+	useLimiters(t, 3, 5)
+	publicRequests.allow("192.0.2.1")
+	publicRequests.allow("192.0.2.1")
+	publicRequests.allow("192.0.2.2")
+
+	rec := serve(handleRateLimitDebug, http.MethodGet, "/debug/ratelimit", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), "192.0.2.") {
+		t.Errorf("response exposes client IPs: %s", rec.Body)
+	}
+
+	var states struct {
+		Public           LimiterState `json:"public"`
+		SubscriberLookup LimiterState `json:"subscriber_lookup"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &states); err != nil {
+		t.Fatal(err)
+	}
+	public := states.Public
+	if public.Backend != "memory" || public.Limit != 3 || public.PeriodSeconds != 60 {
+		t.Errorf("public limiter = %+v", public)
+	}
+	used := make(map[string]LimiterKeyState)
+	for _, k := range public.ActiveKeys {
+		used[k.KeyHash] = k
+	}
+	first, second := used[hashIP("192.0.2.1")], used[hashIP("192.0.2.2")]
+	if len(used) != 2 || first.Used != 2 || first.Remaining != 1 || second.Used != 1 || second.Remaining != 2 {
+		t.Errorf("active keys = %+v", public.ActiveKeys)
+	}
+	// Two tokens at one per 20 seconds.
+	if first.FullIn <= 39 || first.FullIn > 40 {
+		t.Errorf("full in %v seconds, want just under 40", first.FullIn)
+	}
+	if lookups := states.SubscriberLookup; lookups.Limit != 5 || len(lookups.ActiveKeys) != 0 {
+		t.Errorf("subscriber lookup limiter = %+v", lookups)
+	}
+}
+
+func TestRateLimitDebugIncludesRouteLimiters(t *testing.T) {
+	useLimiters(t, 30, 5)
+	origRoutes := routeRequests
+	t.Cleanup(func() { routeRequests = origRoutes })
+	routeRequests = parseRouteLimits("/send=10, /subscribe=3")
+	routeRequests["/subscribe"].allow("192.0.2.1")
+
+	rec := serve(handleRateLimitDebug, http.MethodGet, "/debug/ratelimit", "")
+	var states struct {
+		Public    *LimiterState `json:"public"`
+		Send      LimiterState  `json:"route:/send"`
+		Subscribe LimiterState  `json:"route:/subscribe"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &states); err != nil {
+		t.Fatal(err)
+	}
+	send, subscribe := states.Send, states.Subscribe
+	if send.Limit != 10 || len(send.ActiveKeys) != 0 {
+		t.Errorf("/send limiter = %+v", send)
+	}
+	if subscribe.Limit != 3 || len(subscribe.ActiveKeys) != 1 || subscribe.ActiveKeys[0].Remaining != 2 {
+		t.Errorf("/subscribe limiter = %+v", subscribe)
+	}
+	if states.Public == nil {
+		t.Error("the public limiter is missing alongside the route limiters")
+	}
+}
+
+func TestRateLimiterAllowsBurstThenRefills(t *testing.T) {
+	limiter := newRateLimiter("test_rate_limit", 3, 300*time.Millisecond)
+
+	for i := range 3 {
+		if ok, _ := limiter.allow("192.0.2.1"); !ok {
+			t.Fatalf("request %d of the burst was refused", i+1)
+		}
+	}
+	ok, retryAfter := limiter.allow("192.0.2.1")
+	if ok {
+		t.Fatal("a fourth request was allowed with the bucket empty")
+	}
+	if retryAfter <= 0 || retryAfter > 100*time.Millisecond {
+		t.Errorf("retry after %v, want at most one token's refill time", retryAfter)
+	}
+	if ok, _ := limiter.allow("192.0.2.2"); !ok {
+		t.Error("another client shares the first one's bucket")
+	}
+
+	// One token comes back, not a whole new window's worth.
+	time.Sleep(retryAfter + 10*time.Millisecond)
+	if ok, _ := limiter.allow("192.0.2.1"); !ok {
+		t.Fatal("the refilled token was refused")
+	}
+	if ok, _ := limiter.allow("192.0.2.1"); ok {
+		t.Error("more than one token refilled")
+	}
+}
+
+func TestRateLimiterHasNoBurstAtWindowBoundaries(t *testing.T) {
+	limiter := newRateLimiter("test_rate_limit", 10, time.Second)
+
+	// A fixed window would allow 10 more right after a boundary. A bucket
+	// only gets back what has refilled since.
+	allowed := 0
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		if ok, _ := limiter.allow("192.0.2.1"); ok {
+			allowed++
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if allowed < 10 || allowed > 16 {
+		t.Errorf("allowed %d requests in half a period, want the burst of 10 plus about 5 refilled", allowed)
+	}
+}
+
+func TestLimitsMatchConfiguration(t *testing.T) {
+	useLimiters(t, 30, 5)
+	origRoutes := routeRequests
+	t.Cleanup(func() { routeRequests = origRoutes })
+	routeRequests = parseRouteLimits("/send=10, /subscribe=3")
+	limitsHandler := bind(handleLimits, configWith(t, "CHAT_MIN_INTERVAL", "2s", "MAX_IN_FLIGHT_REQUESTS", "50", "MAX_BATCH_SIZE", "7"))
+
+	rec := serve(limitsHandler, http.MethodGet, "/limits", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var limits LimitsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &limits); err != nil {
+		t.Fatal(err)
+	}
+	if limits.RequestsPerMinute != 30 || limits.SubscriberLookupsPerMinute != 5 || limits.ChatMinIntervalSeconds != 2 ||
+		limits.MaxInFlightRequests != 50 || limits.MaxBatchSize != 7 {
+		t.Errorf("limits = %+v", limits)
+	}
+	if routes := limits.RouteRequestsPerMinute; len(routes) != 2 || routes["/send"] != 10 || routes["/subscribe"] != 3 {
+		t.Errorf("route limits = %v, want /send=10 and /subscribe=3", routes)
+	}
+
+	// Without RATE_LIMIT_ROUTES there are no route budgets to report.
+	routeRequests = nil
+	rec = serve(limitsHandler, http.MethodGet, "/limits", "")
+	if strings.Contains(rec.Body.String(), "route_requests_per_minute") {
+		t.Errorf("response lists route limits that aren't configured: %s", rec.Body)
+	}
+}
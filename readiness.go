@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultReadinessProbeInterval = 30 * time.Second
+
+// DependencyStatus is the last probe of one dependency, as reported by
+// /readyz.
+type DependencyStatus struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMs int64     `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+type ReadinessResponse struct {
+	Status       string                      `json:"status"`
+	Dependencies map[string]DependencyStatus `json:"dependencies,omitempty"`
+}
+
+// readiness holds the latest dependency probes. draining is set once
+// shutdown starts, so load balancers stop sending traffic before the
+// listener closes.
+var readiness struct {
+	mu       sync.Mutex
+	deps     map[string]DependencyStatus
+	draining atomic.Bool
+}
+
+// dependencyProbes are the checks behind /readyz: Telegram's getMe confirms
+// the bot token, and fetching the publication confirms the Beehiiv key and
+// publication ID. Beehiiv isn't probed in sandbox mode.
+func dependencyProbes(config Config) map[string]func(context.Context) error {
+	probes := map[string]func(context.Context) error{
+		"telegram": func(ctx context.Context) error {
+			_, err := callTelegram(ctx, config, "getMe", struct{}{})
+			return err
+		},
+	}
+	if !config.BeehiivSandbox {
+		probes["beehiiv"] = func(ctx context.Context) error {
+			return doBeehiivRequest(ctx, http.MethodGet, "", nil)
+		}
+	}
+	return probes
+}
+
+// probeDependencies runs every probe in parallel, each bounded by
+// config.HealthUpstreamTimeout, and stores the results.
+func probeDependencies(ctx context.Context, config Config) {
+	probes := dependencyProbes(config)
+	results := make(map[string]DependencyStatus, len(probes))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, probe := range probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			probeCtx, cancel := context.WithTimeout(ctx, config.HealthUpstreamTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := probe(probeCtx)
+			status := DependencyStatus{Status: "ok", LatencyMs: time.Since(start).Milliseconds(), CheckedAt: start.UTC()}
+			if err != nil {
+				status.Status = "error"
+				status.Error = err.Error()
+			}
+			mu.Lock()
+			results[name] = status
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	readiness.mu.Lock()
+	readiness.deps = results
+	readiness.mu.Unlock()
+}
+
+// watchReadiness probes the dependencies at startup and then every
+// config.ReadinessProbeInterval until ctx is done, which marks the instance as
+// draining.
+func watchReadiness(ctx context.Context, config Config) {
+	ticker := time.NewTicker(config.ReadinessProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		probeDependencies(ctx, config)
+		select {
+		case <-ctx.Done():
+			readiness.draining.Store(true)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// handleHealthz is the liveness probe. It only shows the process is
+// serving requests, so a restart can't be triggered by an upstream outage.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReadyz is the readiness probe. It answers 200 only once every
+// dependency passed its latest probe, and 503 with the failing ones while
+// starting, draining or degraded. The probes run in the background, so
+// load balancer checks never wait on Telegram or Beehiiv.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	readiness.mu.Lock()
+	resp := ReadinessResponse{Status: "ready", Dependencies: readiness.deps}
+	readiness.mu.Unlock()
+
+	switch {
+	case readiness.draining.Load():
+		resp.Status = "draining"
+	case resp.Dependencies == nil:
+		resp.Status = "starting"
+	default:
+		for _, dep := range resp.Dependencies {
+			if dep.Status != "ok" {
+				resp.Status = "not_ready"
+			}
+		}
+	}
+
+	status := http.StatusOK
+	if resp.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, resp)
+}
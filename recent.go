@@ -0,0 +1,91 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultRecentLimit = 20
+	maxRecentLimit     = 100
+)
+
+// RecentMessage is a sent message as last tracked, so an edited message
+// shows its current text.
+type RecentMessage struct {
+	ChatID    string    `json:"chat_id"`
+	MessageID int64     `json:"message_id"`
+	Text      string    `json:"text"`
+	SentAt    time.Time `json:"sent_at"`
+}
+
+// RecentMessagesResponse is one page of /recent. NextOffset is null on the
+// last page.
+type RecentMessagesResponse struct {
+	Messages   []RecentMessage `json:"messages"`
+	Total      int             `json:"total"`
+	Limit      int             `json:"limit"`
+	Offset     int             `json:"offset"`
+	NextOffset *int            `json:"next_offset"`
+}
+
+// recent returns the tracked messages, newest first.
+func (t *messageTracker) recent() []RecentMessage {
+	var messages []RecentMessage
+	t.messages.each(func(_ string, msg trackedMessage, _ time.Time) {
+		messages = append(messages, RecentMessage{ChatID: msg.chatID, MessageID: msg.messageID, Text: msg.text, SentAt: msg.sent.UTC()})
+	})
+	slices.SortStableFunc(messages, func(a, b RecentMessage) int {
+		return cmp.Or(b.SentAt.Compare(a.SentAt), cmp.Compare(b.MessageID, a.MessageID))
+	})
+	return messages
+}
+
+// handleRecentMessages pages through the messages sent recently, newest
+// first, with ?limit= (1 to 100, default 20) and ?offset=. Only the last
+// maxTrackedMessages are kept, in memory.
+func handleRecentMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	var errs fieldErrors
+	query := r.URL.Query()
+	limit := pageParam(&errs, query, "limit", defaultRecentLimit, 1, maxRecentLimit)
+	offset := pageParam(&errs, query, "offset", 0, 0, maxTrackedMessages)
+	if errs.write(w) {
+		return
+	}
+
+	messages := sentMessages.recent()
+	resp := RecentMessagesResponse{Messages: []RecentMessage{}, Total: len(messages), Limit: limit, Offset: offset}
+	if offset < len(messages) {
+		end := min(offset+limit, len(messages))
+		resp.Messages = messages[offset:end]
+		if end < len(messages) {
+			resp.NextOffset = &end
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// pageParam reads an integer query parameter between lo and hi, defaulting
+// to def when it is absent.
+func pageParam(errs *fieldErrors, query url.Values, name string, def, lo, hi int) int {
+	value := query.Get(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < lo || n > hi {
+		errs.add(name, fmt.Sprintf("%s must be an integer from %d to %d", name, lo, hi))
+		return def
+	}
+	return n
+}
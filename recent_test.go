@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// useSentMessages starts the test with no tracked messages.
+func useSentMessages(t *testing.T) {
+	t.Helper()
+	orig := sentMessages
+	sentMessages = &messageTracker{messages: newTTLCache[trackedMessage]("sent_messages_test", maxTrackedMessages)}
+	t.Cleanup(func() { sentMessages = orig })
+}
+
+func TestRecentMessagesPagination(t *testing.T) {
+	useSentMessages(t)
+	for id := int64(1); id <= 5; id++ {
+		sentMessages.remember("5", id, "message "+strconv.FormatInt(id, 10), "1:test")
+	}
+
+	tests := []struct {
+		query string
+		ids   []int64
+		next  *int
+	}{
+		{"", []int64{5, 4, 3, 2, 1}, nil},
+		{"?limit=2", []int64{5, 4}, ptr(2)},
+		{"?limit=2&offset=2", []int64{3, 2}, ptr(4)},
+		{"?limit=2&offset=4", []int64{1}, nil},
+		{"?limit=5", []int64{5, 4, 3, 2, 1}, nil},
+		{"?limit=4&offset=1", []int64{4, 3, 2, 1}, nil},
+		{"?offset=5", nil, nil},
+		{"?offset=9", nil, nil},
+		{"?limit=100", []int64{5, 4, 3, 2, 1}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := serve(handleRecentMessages, http.MethodGet, "/recent"+tt.query, "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+			}
+			var resp RecentMessagesResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+
+			var ids []int64
+			for _, msg := range resp.Messages {
+				ids = append(ids, msg.MessageID)
+			}
+			if !slices.Equal(ids, tt.ids) {
+				t.Errorf("messages = %v, want %v", ids, tt.ids)
+			}
+			if resp.Total != 5 {
+				t.Errorf("total = %d, want 5", resp.Total)
+			}
+			if (resp.NextOffset == nil) != (tt.next == nil) || (tt.next != nil && *resp.NextOffset != *tt.next) {
+				t.Errorf("next_offset = %v, want %v", deref(resp.NextOffset), deref(tt.next))
+			}
+			if resp.Messages == nil {
+				t.Error("messages is null, want an empty list")
+			}
+		})
+	}
+}
+
+func TestRecentMessagesRejectsBadParams(t *testing.T) {
+	useSentMessages(t)
+
+	for _, query := range []string{"?limit=0", "?limit=101", "?limit=-1", "?limit=ten", "?offset=-1", "?offset=1.5", "?offset=10001"} {
+		rec := serve(handleRecentMessages, http.MethodGet, "/recent"+query, "")
+		if rec.Code != http.StatusBadRequest || decodeError(t, rec).Code != "validation_failed" {
+			t.Errorf("%s: status = %d, want 400 validation_failed: %s", query, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestRecentMessagesShowEdits(t *testing.T) {
+	useFakeUpstreams(t)
+	useSentMessages(t)
+
+	sent, err := sendTelegramMessage(context.Background(), testConfig, "draft", SendOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	later, err := sendTelegramMessage(context.Background(), testConfig, "later", SendOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := editTelegramMessage(context.Background(), testConfig, sent.MessageID, "final", SendOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// An edit updates the text without moving the message to the top.
+	got := sentMessages.recent()
+	if len(got) != 2 || got[0].MessageID != later.MessageID || got[1].MessageID != sent.MessageID || got[1].Text != "final" || got[1].ChatID != testConfig.ChatID {
+		t.Errorf("recent = %+v, want the later message, then the edited one", got)
+	}
+}
+
+func ptr(n int) *int { return &n }
+
+func deref(p *int) any {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const maxLoggedErrorBody = 4 << 10
+
+// requestLogRecorder captures the status and, for error responses, enough of
+// the body to log the ErrorResponse message.
+type requestLogRecorder struct {
+	http.ResponseWriter
+	status  int
+	errBody bytes.Buffer
+}
+
+func (rec *requestLogRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *requestLogRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if rec.status >= 400 && rec.errBody.Len() < maxLoggedErrorBody {
+		rec.errBody.Write(b[:min(len(b), maxLoggedErrorBody-rec.errBody.Len())])
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *requestLogRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// withRequestLogging assigns every request an ID, taken from X-Request-ID
+// when the client sends a usable one, echoes it back in the same header and
+// logs one structured line per request once it completes, with the client
+// IP as resolved under TRUST_PROXY.
+func withRequestLogging(next http.Handler, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		start := time.Now()
+		rec := &requestLogRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		attrs := []any{
+			slog.String("request_id", id),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("client_ip", clientIP(r)),
+			slog.Int("status", rec.status),
+			slog.Int64("duration_ms", time.Since(start).Milliseconds()),
+		}
+		level := slog.LevelInfo
+		if rec.status >= 400 {
+			var errResp ErrorResponse
+			if json.Unmarshal(rec.errBody.Bytes(), &errResp) == nil && errResp.Message != "" {
+				attrs = append(attrs, slog.String("error", errResp.Message))
+			}
+			if rec.status >= 500 {
+				level = slog.LevelError
+			} else {
+				level = slog.LevelWarn
+			}
+		}
+		logger.Log(r.Context(), level, "request", attrs...)
+	})
+}
+
+// validRequestID accepts client IDs that are short and made of characters
+// that are safe to log and echo back.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
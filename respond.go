@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// appLocation is the APP_TIMEZONE that timestamps shown to users are
+// rendered in. Logs and the audit trail stay in UTC.
+var appLocation = time.UTC
+
+// writeJSON sets the status code before encoding v, so the code isn't
+// silently dropped once the body has started.
+//
+// Responses carry the X-Request-ID set by withRequestLogging so clients can
+// correlate them and quote it in support requests. Success objects also get
+// a server timestamp.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	requestID := w.Header().Get("X-Request-ID")
+	if errResp, ok := v.(ErrorResponse); ok {
+		if errResp.RequestID == "" {
+			errResp.RequestID = requestID
+		}
+		if errResp.Code == "" {
+			errResp.Code = defaultErrorCode(status)
+		}
+		v = errResp
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to encode response")
+		return
+	}
+	if status < 400 {
+		data = appendResponseMeta(data, requestID, time.Now().In(appLocation))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
+
+// appendResponseMeta adds request_id and timestamp to a JSON object, keeping
+// the object's own field order. Non-object values are returned unchanged.
+func appendResponseMeta(data []byte, requestID string, now time.Time) []byte {
+	if len(data) < 2 || data[0] != '{' {
+		return data
+	}
+
+	var meta bytes.Buffer
+	if requestID != "" {
+		id, _ := json.Marshal(requestID)
+		meta.WriteString(`"request_id":`)
+		meta.Write(id)
+		meta.WriteByte(',')
+	}
+	meta.WriteString(`"timestamp":"`)
+	meta.WriteString(now.Format(time.RFC3339))
+	meta.WriteString(`"}`)
+
+	out := append([]byte(nil), data[:len(data)-1]...)
+	if len(data) > 2 {
+		out = append(out, ',')
+	}
+	return append(out, meta.Bytes()...)
+}
+
+// writeError writes an ErrorResponse with a stable code for clients to
+// branch on and a message for people to read.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, ErrorResponse{Message: message, Code: code})
+}
+
+// defaultErrorCode is the code for errors whose handler didn't pick a more
+// specific one.
+func defaultErrorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid_request"
+	case http.StatusTooManyRequests:
+		return "rate_limited"
+	case http.StatusBadGateway:
+		return "upstream_error"
+	case http.StatusServiceUnavailable:
+		return "unavailable"
+	}
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+}
+
+const defaultMaxBodyBytes = 16 << 10
+
+// decodeBody decodes a JSON request body of at most MAX_BODY_BYTES into v,
+// rejecting unknown fields. On failure it writes the error response and
+// returns false.
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}, config Config) bool {
+	return decodeBodyLimit(w, r, v, int64(config.MaxBodyBytes))
+}
+
+// A body that ends before its declared Content-Length is reported as
+// truncated rather than as invalid JSON, since the client's upload was cut
+// short rather than malformed.
+func decodeBodyLimit(w http.ResponseWriter, r *http.Request, v interface{}, limit int64) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	body := &countingReader{r: r.Body}
+
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, ErrorResponse{Message: "Request body is too large", Code: "body_too_large"})
+			return false
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) && r.ContentLength > 0 && body.n < r.ContentLength {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Message: "Request body is shorter than its Content-Length", Code: "body_truncated"})
+			return false
+		}
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{
+				Message: "Unknown field " + field,
+				Code:    "unknown_field",
+				Details: map[string]string{"field": strings.Trim(field, `"`)},
+			})
+			return false
+		}
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return false
+	}
+	return true
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendResponseMeta(t *testing.T) {
+	now := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+	tests := []struct {
+		data      string
+		requestID string
+		want      string
+	}{
+		{`{"status":"ok"}`, "req-1", `{"status":"ok","request_id":"req-1","timestamp":"2025-03-04T05:06:07Z"}`},
+		{`{}`, "req-1", `{"request_id":"req-1","timestamp":"2025-03-04T05:06:07Z"}`},
+		{`{"status":"ok"}`, "", `{"status":"ok","timestamp":"2025-03-04T05:06:07Z"}`},
+		{`[1,2]`, "req-1", `[1,2]`},
+		{`true`, "req-1", `true`},
+	}
+	for _, tt := range tests {
+		if got := string(appendResponseMeta([]byte(tt.data), tt.requestID, now)); got != tt.want {
+			t.Errorf("appendResponseMeta(%s) = %s, want %s", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestSuccessResponsesCarryRequestIDAndTimestamp(t *testing.T) {
+	useFakeUpstreams(t)
+	defer func(orig bool) { warmedUp.Store(orig) }(warmedUp.Load())
+	warmedUp.Store(true)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/send", sendHandler)
+	mux.HandleFunc("/subscribe", subscribeHandler)
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		handleHealth(w, r, testConfig)
+	})
+	handler := newHandler(mux, testSettings())
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodPost, "/send", `{"message":"correlate me"}`},
+		{http.MethodPost, "/subscribe", `{"email":"correlate@example.com"}`},
+		{http.MethodGet, "/health", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Request-ID", "client-"+strings.TrimPrefix(tt.path, "/"))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code >= 300 {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+
+			var resp struct {
+				RequestID string `json:"request_id"`
+				Timestamp string `json:"timestamp"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.RequestID != req.Header.Get("X-Request-ID") || resp.RequestID != rec.Header().Get("X-Request-ID") {
+				t.Errorf("request_id = %q, want the request's ID %q", resp.RequestID, req.Header.Get("X-Request-ID"))
+			}
+			if ts, err := time.Parse(time.RFC3339, resp.Timestamp); err != nil || time.Since(ts) > time.Minute {
+				t.Errorf("timestamp = %q, want the current time in RFC 3339", resp.Timestamp)
+			}
+		})
+	}
+}
+
+func TestTruncatedBodyIsReported(t *testing.T) {
+	useFakeUpstreams(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/send", sendHandler)
+	mux.HandleFunc("/subscribe", subscribeHandler)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		path string
+		body string
+	}{
+		{"/send", `{"message":"cut short`},
+		{"/subscribe", `{"email":"cut@exa`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			// Declare more bytes than are sent, then stop sending.
+			fmt.Fprintf(conn, "POST %s HTTP/1.1\r\nHost: test\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", tt.path, len(tt.body)+50, tt.body)
+			conn.(*net.TCPConn).CloseWrite()
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var errResp ErrorResponse
+			json.NewDecoder(resp.Body).Decode(&errResp)
+			if resp.StatusCode != http.StatusBadRequest || errResp.Code != "body_truncated" {
+				t.Errorf("got %d %+v, want 400 body_truncated", resp.StatusCode, errResp)
+			}
+		})
+	}
+
+	// Malformed JSON of the declared length is still an invalid body.
+	resp, err := http.Post(srv.URL+"/send", "application/json", strings.NewReader(`{"message":`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var errResp ErrorResponse
+	json.NewDecoder(resp.Body).Decode(&errResp)
+	if resp.StatusCode != http.StatusBadRequest || errResp.Code != "invalid_request" {
+		t.Errorf("malformed JSON: got %d %+v, want 400 invalid_request", resp.StatusCode, errResp)
+	}
+}
+
+func TestTimestampsRenderInAppTimezone(t *testing.T) {
+	defer func(orig *time.Location) { appLocation = orig }(appLocation)
+	appLocation = time.FixedZone("ACST", 9*60*60+30*60)
+	fake, _ := useFakeUpstreams(t)
+
+	rec := serve(sendHandler, http.MethodPost, "/send", `{"message":"what time is it"}`)
+	var resp struct {
+		Timestamp string `json:"timestamp"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(resp.Timestamp, "+09:30") {
+		t.Errorf("timestamp = %q, want it in APP_TIMEZONE (+09:30)", resp.Timestamp)
+	}
+
+	if err := sendDigest(context.Background(), testConfig); err != nil {
+		t.Fatal(err)
+	}
+	if text := sentText(t, fake); !strings.Contains(text, " ACST") {
+		t.Errorf("digest %q does not show its period in APP_TIMEZONE", text)
+	}
+}
+
+func TestErrorResponsesHaveMessageAndCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		body    string
+		status  int
+		code    string
+	}{
+		{"wrong method", sendHandler, http.MethodGet, "", http.StatusMethodNotAllowed, "method_not_allowed"},
+		{"malformed JSON", sendHandler, http.MethodPost, `{"message":`, http.StatusBadRequest, "invalid_request"},
+		{"empty message", sendHandler, http.MethodPost, `{"message":""}`, http.StatusBadRequest, "message_empty"},
+		{"unknown job", handleJob, http.MethodGet, "", http.StatusNotFound, "job_not_found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeUpstreams(t)
+			rec := serve(tt.handler, tt.method, "/jobs/missing", tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			var body map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body["code"] != tt.code || body["message"] == "" || body["message"] == nil {
+				t.Errorf("body = %s, want code %s and a message", rec.Body, tt.code)
+			}
+			if _, ok := body["error"]; ok {
+				t.Errorf("body %s still has the old error field", rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultUpstreamMaxAttempts = 3
+	defaultUpstreamRetryBase   = 500 * time.Millisecond
+	maxUpstreamRetryDelay      = 30 * time.Second
+)
+
+var defaultRetryStatuses = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// retryPolicy says how calls to one upstream are retried.
+type retryPolicy struct {
+	attempts int
+	base     time.Duration
+	statuses []int
+	jitter   bool
+}
+
+// retryUpstreams are the upstreams with their own retry settings. The
+// webhook notifiers are named by Notifier.Name.
+var retryUpstreams = []string{"TELEGRAM", "BEEHIIV", "DISCORD", "SLACK"}
+
+// readRetryPolicies reads the retry policy of each upstream, and of
+// background jobs, keyed by prefix; see readRetryPolicy.
+func readRetryPolicies(e *envReader) map[string]retryPolicy {
+	upstream := readRetryPolicy(e, "UPSTREAM", retryPolicy{
+		attempts: defaultUpstreamMaxAttempts,
+		base:     defaultUpstreamRetryBase,
+		statuses: defaultRetryStatuses,
+		jitter:   true,
+	})
+	policies := map[string]retryPolicy{"UPSTREAM": upstream}
+	for _, prefix := range retryUpstreams {
+		policies[prefix] = readRetryPolicy(e, prefix, upstream)
+	}
+
+	// Jobs follow the shared statuses and jitter, but retry in the
+	// background, so their attempts and backoff default much higher than an
+	// in-request retry would.
+	jobs := upstream
+	jobs.attempts, jobs.base = defaultJobMaxAttempts, defaultJobRetryBase
+	policies["JOBS"] = readRetryPolicy(e, "JOBS", jobs)
+	return policies
+}
+
+// readRetryPolicy reads the policy for an upstream from <PREFIX>_MAX_ATTEMPTS,
+// <PREFIX>_RETRY_BASE, <PREFIX>_RETRY_STATUSES and <PREFIX>_RETRY_JITTER
+// (e.g. TELEGRAM_ or BEEHIIV_), each falling back to def.
+func readRetryPolicy(e *envReader, prefix string, def retryPolicy) retryPolicy {
+	policy := retryPolicy{
+		attempts: e.number(prefix+"_MAX_ATTEMPTS", def.attempts),
+		base:     e.duration(prefix+"_RETRY_BASE", def.base),
+		statuses: def.statuses,
+		jitter:   e.flag(prefix+"_RETRY_JITTER", def.jitter),
+	}
+	e.parse(prefix+"_RETRY_STATUSES", func(value string) error {
+		var statuses []int
+		for _, item := range splitList(value) {
+			status, err := strconv.Atoi(item)
+			if err != nil {
+				return fmt.Errorf("%q is not a status code", item)
+			}
+			statuses = append(statuses, status)
+		}
+		policy.statuses = statuses
+		return nil
+	})
+	return policy
+}
+
+// retryPolicyFor returns the retry policy for an upstream prefix, or the
+// shared UPSTREAM_ policy for one without its own settings.
+func (s *Settings) retryPolicyFor(prefix string) retryPolicy {
+	if policy, ok := s.retryPolicies[prefix]; ok {
+		return policy
+	}
+	return s.retryPolicies["UPSTREAM"]
+}
+
+// delay returns how long to wait out a backoff window. With jitter it is
+// anywhere from zero to the whole window ("full jitter"), so clients that
+// failed together don't all retry together; without it, the whole window.
+func (p retryPolicy) delay(backoff time.Duration) time.Duration {
+	if !p.jitter || backoff <= 0 {
+		return backoff
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// retryUpstream calls fn up to policy.attempts times while it fails with a
+// retryable error, backing off exponentially from policy.base.
+// An upstream Retry-After takes precedence over the backoff. It gives up
+// early if the wait would outlast ctx.
+func retryUpstream(ctx context.Context, policy retryPolicy, fn func() error) error {
+	backoff := policy.base
+
+	// A cancelled request never reaches the upstream, so a cancelled job
+	// can tell it is safe to requeue.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	noteUpstreamCall(ctx)
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= policy.attempts || !policy.retryable(err) {
+			return err
+		}
+
+		delay := policy.delay(backoff)
+		var upErr *UpstreamError
+		if errors.As(err, &upErr) && upErr.RetryAfter > 0 {
+			delay = upErr.RetryAfter
+		}
+		delay = min(delay, maxUpstreamRetryDelay)
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// retryable reports whether err is worth another attempt: an upstream
+// status in the policy's list or a network failure, but not the caller's
+// own cancellation.
+func (p retryPolicy) retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var upErr *UpstreamError
+	if errors.As(err, &upErr) {
+		return slices.Contains(p.statuses, upErr.StatusCode)
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDNSFailuresAreRetriedAndReportedAsUnreachable(t *testing.T) {
+	dials := 0
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dials++
+			return nil, &net.DNSError{Err: "no such host", Name: "api.telegram.org", IsTemporary: true}
+		},
+	}}
+
+	policy := retryPolicy{attempts: 3, base: time.Millisecond, statuses: defaultRetryStatuses}
+	err := retryUpstream(context.Background(), policy, func() error {
+		_, err := newBotAPIClient("http://api.telegram.org", client).Call(context.Background(), "1:test", "sendMessage", "application/json", []byte("{}"))
+		return err
+	})
+	if err == nil {
+		t.Fatal("call succeeded, want a DNS error")
+	}
+	if !policy.retryable(err) || !isUnreachable(err) {
+		t.Errorf("err %v: retryable = %v, unreachable = %v, want both", err, policy.retryable(err), isUnreachable(err))
+	}
+	if dials != policy.attempts {
+		t.Errorf("dialed %d times, want %d", dials, policy.attempts)
+	}
+
+	rec := httptest.NewRecorder()
+	writeUpstreamError(rec, err)
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want 502", rec.Code)
+	}
+	if got := decodeError(t, rec).Code; got != "upstream_unreachable" {
+		t.Errorf("code = %q, want upstream_unreachable", got)
+	}
+}
+
+func TestRetryDelayFullJitterBounds(t *testing.T) {
+	policy := retryPolicy{jitter: true}
+	for _, backoff := range []time.Duration{time.Millisecond, 500 * time.Millisecond, 30 * time.Second} {
+		lowest, highest := backoff, time.Duration(0)
+		for i := 0; i < 1000; i++ {
+			d := policy.delay(backoff)
+			if d < 0 || d > backoff {
+				t.Fatalf("delay(%v) = %v, want within [0, %v]", backoff, d, backoff)
+			}
+			lowest, highest = min(lowest, d), max(highest, d)
+		}
+		// Full jitter spreads over the whole window, not just its top half.
+		if lowest >= backoff/4 || highest <= backoff*3/4 {
+			t.Errorf("delay(%v) ranged over [%v, %v], want most of [0, %v]", backoff, lowest, highest, backoff)
+		}
+	}
+
+	policy.jitter = false
+	if d := policy.delay(500 * time.Millisecond); d != 500*time.Millisecond {
+		t.Errorf("delay without jitter = %v, want the whole backoff", d)
+	}
+}
+
+func TestRetryPolicyJitterSetting(t *testing.T) {
+	tests := []struct {
+		upstream, telegram string
+		want               bool
+	}{
+		{"", "", true},
+		{"false", "", false},
+		{"false", "true", true},
+		{"", "false", false},
+	}
+	for _, tt := range tests {
+		settings := configWith(t, "UPSTREAM_RETRY_JITTER", tt.upstream, "TELEGRAM_RETRY_JITTER", tt.telegram).Settings
+		if got := settings.retryPolicyFor("TELEGRAM").jitter; got != tt.want {
+			t.Errorf("UPSTREAM_RETRY_JITTER=%q TELEGRAM_RETRY_JITTER=%q: jitter = %v, want %v", tt.upstream, tt.telegram, got, tt.want)
+		}
+	}
+}
+
+func TestRetryUpstreamWithoutJitterIsDeterministic(t *testing.T) {
+	policy := retryPolicy{attempts: 3, base: 20 * time.Millisecond, statuses: defaultRetryStatuses}
+	var calls []time.Time
+	retryUpstream(context.Background(), policy, func() error {
+		calls = append(calls, time.Now())
+		return &UpstreamError{StatusCode: http.StatusServiceUnavailable}
+	})
+	if len(calls) != 3 {
+		t.Fatalf("called %d times, want 3", len(calls))
+	}
+	for i, want := range []time.Duration{20 * time.Millisecond, 40 * time.Millisecond} {
+		if gap := calls[i+1].Sub(calls[i]); gap < want {
+			t.Errorf("retry %d came after %v, want the full %v backoff", i+1, gap, want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// apiVersionPrefix is where the API is mounted. Routes are registered
+// without it and withAPIVersion strips it, so path-keyed settings such as
+// CAPTCHA_ROUTES, API_KEY_ROUTES and RATE_LIMIT_ROUTES keep naming routes
+// by their unversioned path.
+const apiVersionPrefix = "/v1"
+
+// legacyRoutesDeprecatedAt is when the unversioned aliases were
+// deprecated, sent as the Deprecation header (RFC 9745).
+const legacyRoutesDeprecatedAt = "@1792022400" // 2026-10-15T00:00:00Z
+
+// unversionedRoutes are served at their own paths without deprecation:
+// probes, metrics scrapes, short links and webhooks are configured in
+// other systems rather than called by API clients, and the API docs
+// describe every version.
+var unversionedRoutes = []string{
+	"/health", "/healthz", "/readyz", "/metrics", "/l/",
+	"/telegram/webhook", "/webhooks/", "/openapi.json", "/docs",
+}
+
+var legacyRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "api_legacy_requests_total",
+	Help: "Requests to deprecated unversioned routes, by route.",
+}, []string{"path"})
+
+// middleware wraps a route's handler, e.g. requireAdmin or withCaptcha.
+type middleware func(http.HandlerFunc) http.HandlerFunc
+
+// routeGroup registers routes on mux behind a shared middleware chain.
+// The zero chain registers handlers as they are. Every group made from
+// one router shares its routes list, which /openapi.json is built from.
+type routeGroup struct {
+	mux    *http.ServeMux
+	chain  []middleware
+	auth   string
+	routes *[]registeredRoute
+}
+
+// registeredRoute is a route as registered: its mux pattern, the methods
+// it allows (none means any) and the security scheme guarding it.
+type registeredRoute struct {
+	path    string
+	methods []string
+	auth    string
+}
+
+func newRouter(mux *http.ServeMux) routeGroup {
+	return routeGroup{mux: mux, routes: &[]registeredRoute{}}
+}
+
+// with returns a group whose routes also pass through mw, nested inside
+// g's own middleware in the order given.
+func (g routeGroup) with(mw ...middleware) routeGroup {
+	g.chain = append(slices.Clone(g.chain), mw...)
+	return g
+}
+
+// requiring returns a group whose routes are documented as needing the
+// named OpenAPI security scheme. It only documents; the check itself is
+// one of the group's middleware.
+func (g routeGroup) requiring(scheme string) routeGroup {
+	g.auth = scheme
+	return g
+}
+
+func (g routeGroup) get(path string, h http.HandlerFunc) {
+	g.handle(path, h, http.MethodGet)
+}
+
+func (g routeGroup) post(path string, h http.HandlerFunc) {
+	g.handle(path, h, http.MethodPost)
+}
+
+// handle registers h for path, allowing only methods when any are given.
+// Other methods get the JSON 405 before any middleware runs, so they don't
+// use up rate limits or captcha checks; OPTIONS still reaches h, as CORS
+// preflights are answered before routing.
+func (g routeGroup) handle(path string, h http.HandlerFunc, methods ...string) {
+	for i := len(g.chain) - 1; i >= 0; i-- {
+		h = g.chain[i](h)
+	}
+	if len(methods) > 0 {
+		next := h
+		h = func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodOptions && !slices.Contains(methods, r.Method) {
+				writeMethodNotAllowed(w, methods...)
+				return
+			}
+			next(w, r)
+		}
+	}
+	g.mux.HandleFunc(path, h)
+	*g.routes = append(*g.routes, registeredRoute{path: path, methods: methods, auth: g.auth})
+}
+
+// rateLimited adapts limitByIP to a route group middleware.
+func rateLimited(limiter *rateLimiter) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return limitByIP(limiter, next)
+	}
+}
+
+// withAPIVersion serves /v1/... by stripping the prefix before routing.
+// Unversioned paths still work as deprecated aliases, answered with
+// Deprecation and a Link to their /v1 successor and counted in
+// api_legacy_requests_total, unless legacy (LEGACY_ROUTES) is off.
+// It runs before everything else that looks at the path.
+func withAPIVersion(next http.Handler, mux *http.ServeMux, legacy bool) http.Handler {
+	versioned := http.StripPrefix(apiVersionPrefix, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, apiVersionPrefix+"/") {
+			versioned.ServeHTTP(w, r)
+			return
+		}
+		if slices.ContainsFunc(unversionedRoutes, func(route string) bool { return matchRoute(route, r.URL.Path) }) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		_, pattern := mux.Handler(r)
+		if pattern == "" || pattern == "/" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !legacy {
+			writeError(w, http.StatusNotFound, "not_found", "Not found")
+			return
+		}
+		legacyRequests.WithLabelValues(pattern).Inc()
+		w.Header().Set("Deprecation", legacyRoutesDeprecatedAt)
+		w.Header().Set("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", apiVersionPrefix, r.URL.EscapedPath()))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withRecovery turns a handler panic into a logged 500 instead of a
+// dropped connection. http.ErrAbortHandler is passed on, since it is how a
+// handler asks net/http to abort the response.
+func withRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			log.Printf("Error: panic serving %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+			writeError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
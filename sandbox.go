@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sandboxSubscriptionID returns a fake, clearly marked subscription ID for
+// BEEHIIV_SANDBOX mode.
+func sandboxSubscriptionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "sub_sandbox_" + hex.EncodeToString(b)
+}
+
+// fakeCall is one call a fake client received. Body is the JSON request,
+// or nil for uploads and requests without one.
+type fakeCall struct {
+	Method string
+	Path   string
+	Body   json.RawMessage
+}
+
+// fakeTelegram stands in for the Bot API under API_MODE=dry-run: calls are
+// logged and recorded instead of sent. Send and edit methods answer with a
+// Message carrying the next message ID, sendMediaGroup with one per item,
+// getMe with a stand-in bot, and everything else with true.
+type fakeTelegram struct {
+	mu     sync.Mutex
+	nextID int64
+	calls  []fakeCall
+}
+
+func newFakeTelegram() *fakeTelegram {
+	return &fakeTelegram{}
+}
+
+func (f *fakeTelegram) Call(ctx context.Context, token, method, contentType string, body []byte) (json.RawMessage, error) {
+	call := fakeCall{Method: method}
+	if contentType == "application/json" {
+		call.Body = body
+		log.Printf("Dry run: Telegram %s %s", method, body)
+	} else {
+		log.Printf("Dry run: Telegram %s with a %d byte upload", method, len(body))
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+
+	switch {
+	case method == "getMe":
+		return json.RawMessage(`{"id":1,"is_bot":true,"first_name":"Dry run","username":"dry_run_bot"}`), nil
+	case method == "sendMediaGroup":
+		messages := make([]map[string]interface{}, fakeMediaGroupSize(contentType, body))
+		for i := range messages {
+			f.nextID++
+			messages[i] = map[string]interface{}{"message_id": f.nextID, "date": time.Now().Unix()}
+		}
+		return json.Marshal(messages)
+	case strings.HasPrefix(method, "send"), strings.HasPrefix(method, "edit"), method == "copyMessage", method == "forwardMessage":
+		var req struct {
+			ChatID    json.RawMessage `json:"chat_id"`
+			MessageID int64           `json:"message_id"`
+			Text      string          `json:"text"`
+		}
+		json.Unmarshal(call.Body, &req)
+		id := req.MessageID
+		if id == 0 {
+			f.nextID++
+			id = f.nextID
+		}
+		msg := map[string]interface{}{"message_id": id, "date": time.Now().Unix()}
+		if len(req.ChatID) > 0 {
+			msg["chat"] = map[string]json.RawMessage{"id": req.ChatID}
+		}
+		if req.Text != "" {
+			msg["text"] = req.Text
+		}
+		return json.Marshal(msg)
+	}
+	return json.RawMessage("true"), nil
+}
+
+// fakeMediaGroupSize counts the items of a sendMediaGroup request, whether
+// sent as JSON or as an upload.
+func fakeMediaGroupSize(contentType string, body []byte) int {
+	media := []byte(nil)
+	if contentType == "application/json" {
+		var req struct {
+			Media json.RawMessage `json:"media"`
+		}
+		json.Unmarshal(body, &req)
+		media = req.Media
+	} else if _, params, err := mime.ParseMediaType(contentType); err == nil {
+		form, err := multipart.NewReader(bytes.NewReader(body), params["boundary"]).ReadForm(int64(len(body)))
+		if err == nil && len(form.Value["media"]) > 0 {
+			media = []byte(form.Value["media"][0])
+		}
+	}
+	var items []json.RawMessage
+	json.Unmarshal(media, &items)
+	return len(items)
+}
+
+// Calls returns the calls received so far, oldest first.
+func (f *fakeTelegram) Calls() []fakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.calls)
+}
+
+// fakeBeehiiv stands in for Beehiiv under API_MODE=dry-run and
+// BEEHIIV_SANDBOX: calls are logged and recorded, and subscriptions are
+// kept in memory so lookups, updates and unsubscribes see earlier signups.
+type fakeBeehiiv struct {
+	mu          sync.Mutex
+	subscribers []*BeehiivSubscriber
+	calls       []fakeCall
+}
+
+func newFakeBeehiiv() *fakeBeehiiv {
+	return &fakeBeehiiv{}
+}
+
+func (f *fakeBeehiiv) Do(ctx context.Context, method, path string, payload interface{}) ([]byte, error) {
+	call := fakeCall{Method: method, Path: path}
+	if payload != nil {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		call.Body = body
+		log.Printf("Dry run: Beehiiv %s %q %s", method, path, body)
+	} else {
+		log.Printf("Dry run: Beehiiv %s %q", method, path)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+
+	var req struct {
+		Email        string               `json:"email"`
+		CustomFields []BeehiivCustomField `json:"custom_fields"`
+		Tags         []string             `json:"tags"`
+		Unsubscribe  bool                 `json:"unsubscribe"`
+	}
+	json.Unmarshal(call.Body, &req)
+
+	resource, _, _ := strings.Cut(path, "?")
+	parts := strings.Split(strings.Trim(resource, "/"), "/")
+	switch {
+	case resource == "" && method == http.MethodGet:
+		active := 0
+		for _, s := range f.subscribers {
+			if s.Status == "active" {
+				active++
+			}
+		}
+		return json.Marshal(map[string]interface{}{"data": map[string]interface{}{
+			"id":    "pub_dry_run",
+			"stats": map[string]int{"active_subscriptions": active},
+		}})
+
+	case len(parts) == 1 && parts[0] == "subscriptions" && method == http.MethodPost:
+		s := f.find(func(s *BeehiivSubscriber) bool { return strings.EqualFold(s.Email, req.Email) })
+		if s == nil {
+			s = &BeehiivSubscriber{ID: sandboxSubscriptionID(), Email: req.Email}
+			f.subscribers = append(f.subscribers, s)
+		}
+		s.Status = "active"
+		s.CustomFields = req.CustomFields
+		return json.Marshal(map[string]interface{}{"data": s})
+
+	case len(parts) == 3 && parts[0] == "subscriptions" && parts[1] == "by_email" && method == http.MethodGet:
+		email, _ := url.PathUnescape(parts[2])
+		if s := f.find(func(s *BeehiivSubscriber) bool { return strings.EqualFold(s.Email, email) }); s != nil {
+			return json.Marshal(map[string]interface{}{"data": s})
+		}
+
+	case len(parts) >= 2 && parts[0] == "subscriptions":
+		id, _ := url.PathUnescape(parts[1])
+		s := f.find(func(s *BeehiivSubscriber) bool { return s.ID == id })
+		if s == nil {
+			break
+		}
+		switch {
+		case len(parts) == 2 && method == http.MethodPatch:
+			if req.Unsubscribe {
+				s.Status = "inactive"
+			}
+			for _, field := range req.CustomFields {
+				i := slices.IndexFunc(s.CustomFields, func(f BeehiivCustomField) bool { return f.Name == field.Name })
+				if i < 0 {
+					s.CustomFields = append(s.CustomFields, field)
+				} else {
+					s.CustomFields[i] = field
+				}
+			}
+			return json.Marshal(map[string]interface{}{"data": s})
+		case len(parts) == 3 && parts[2] == "tags" && method == http.MethodPost:
+			for _, tag := range req.Tags {
+				if !slices.Contains(s.Tags, tag) {
+					s.Tags = append(s.Tags, tag)
+				}
+			}
+			return json.Marshal(map[string]interface{}{"data": s})
+		}
+	}
+	return nil, &UpstreamError{StatusCode: http.StatusNotFound, Message: "Not found"}
+}
+
+func (f *fakeBeehiiv) find(match func(*BeehiivSubscriber) bool) *BeehiivSubscriber {
+	if i := slices.IndexFunc(f.subscribers, match); i >= 0 {
+		return f.subscribers[i]
+	}
+	return nil
+}
+
+// Calls returns the calls received so far, oldest first.
+func (f *fakeBeehiiv) Calls() []fakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.calls)
+}
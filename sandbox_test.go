@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBeehiivSandboxMakesNoUpstreamCall(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`{"data":{"id":"sub_real"}}`))
+	}))
+	defer upstream.Close()
+
+	defer func(origURL string, orig BeehiivAPI) { beehiivAPIBaseURL, beehiiv = origURL, orig }(beehiivAPIBaseURL, beehiiv)
+	beehiivAPIBaseURL = upstream.URL
+	t.Setenv("BEEHIIV_PUBLICATION_ID", "pub_test")
+	t.Setenv("BEEHIIV_API_KEY", "key")
+	config := configWith(t, "BEEHIIV_SANDBOX", "true")
+	beehiiv = newBeehiivAPI(config.Settings)
+	subscribe := bind(handleSubscribe, config)
+
+	before := len(auditEvents(t, "subscribe"))
+	rec := serve(subscribe, http.MethodPost, "/subscribe", `{"email":"sandbox@example.com"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(resp.ID, "sub_sandbox_") {
+		t.Errorf("id = %q, want a sandbox subscription ID", resp.ID)
+	}
+	if len(auditEvents(t, "subscribe")) != before+1 {
+		t.Error("the sandbox subscription wasn't audited")
+	}
+
+	// Validation still applies.
+	if rec := serve(subscribe, http.MethodPost, "/subscribe", `{"email":"not-an-email"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid email: status = %d, want 400", rec.Code)
+	}
+
+	if n := calls.Load(); n != 0 {
+		t.Errorf("Beehiiv was called %d times in sandbox mode", n)
+	}
+
+	// Without the sandbox the same setup reaches Beehiiv.
+	config = configWith(t, "BEEHIIV_SANDBOX", "")
+	beehiiv = newBeehiivAPI(config.Settings)
+	if rec := serve(bind(handleSubscribe, config), http.MethodPost, "/subscribe", `{"email":"sandbox-off@example.com"}`); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if calls.Load() == 0 {
+		t.Error("Beehiiv wasn't called outside sandbox mode")
+	}
+}
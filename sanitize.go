@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// sanitizeControlChars removes (mode "strip") or replaces with a space
+// (mode "replace") control characters Telegram would reject, keeping
+// newlines and tabs. Any other mode leaves the message untouched.
+func sanitizeControlChars(message, mode string) string {
+	if mode != "strip" && mode != "replace" {
+		return message
+	}
+
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' || !unicode.IsControl(r) {
+			return r
+		}
+		if mode == "replace" {
+			return ' '
+		}
+		return -1
+	}, message)
+}
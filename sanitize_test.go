@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestSanitizeControlChars(t *testing.T) {
+	const input = "log\x00 line\x1b[0m\r\n\tnext\x7f"
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{"", input},
+		{"off", input},
+		{"strip", "log line[0m\n\tnext"},
+		{"replace", "log  line [0m \n\tnext "},
+	}
+	for _, tt := range tests {
+		if got := sanitizeControlChars(input, tt.mode); got != tt.want {
+			t.Errorf("mode %q: got %q, want %q", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestSendMessageSanitizesControlChars(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+	send := bind(handleSendMessage, configWith(t, "SANITIZE_CONTROL_CHARS", "strip"))
+
+	body, _ := json.Marshal(MessageRequest{Message: "disk\x00 full\x07\nretrying", ParseMode: "none"})
+	rec := serve(send, http.MethodPost, "/send", string(body))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if got, want := sentText(t, fake), "disk full\nretrying"; got != want {
+		t.Errorf("sent %q, want %q", got, want)
+	}
+
+	// Nothing is left once the control characters are gone.
+	before := len(fake.Calls())
+	body, _ = json.Marshal(MessageRequest{Message: "\x00\x01 \x02", ParseMode: "none"})
+	rec = serve(send, http.MethodPost, "/send", string(body))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400: %s", rec.Code, rec.Body)
+	}
+	if got := decodeError(t, rec).Code; got != "message_empty_after_processing" {
+		t.Errorf("code = %q, want message_empty_after_processing", got)
+	}
+	if len(fake.Calls()) != before {
+		t.Error("an empty message was sent")
+	}
+}
+
+func TestSendMessageEmptyAfterProcessing(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+	tests := []struct {
+		mode    string
+		message string
+	}{
+		{"strip", "\x00\x1b\x7f"},
+		{"strip", "\x07\x00\x07"},
+		{"replace", "\x00\x01\x02"},
+		{"replace", "\x1b\t\x7f"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			send := bind(handleSendMessage, configWith(t, "SANITIZE_CONTROL_CHARS", tt.mode))
+			before := len(fake.Calls())
+			body, _ := json.Marshal(MessageRequest{Message: tt.message})
+			rec := serve(send, http.MethodPost, "/send", string(body))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("%q: status = %d, want 400: %s", tt.message, rec.Code, rec.Body)
+			}
+			if got := decodeError(t, rec); got.Code != "message_empty_after_processing" || got.Message == "" {
+				t.Errorf("%q: error = %+v, want message_empty_after_processing", tt.message, got)
+			}
+			if len(fake.Calls()) != before {
+				t.Errorf("%q: Telegram was called", tt.message)
+			}
+		})
+	}
+}
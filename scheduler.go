@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// cronSchedule is a parsed five-field cron expression: minute, hour, day of
+// month, month and day of week, each a bit set of the values it matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+var cronAliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// parseCron parses a standard cron expression such as "0 9 * * 1-5". Fields
+// take *, single values, ranges, comma-separated lists and /steps; day of
+// week runs from 0 (Sunday) to 7 (Sunday again). The @hourly, @daily,
+// @weekly and @monthly shorthands are accepted too.
+func parseCron(expr string) (*cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if alias, ok := cronAliases[expr]; ok {
+		expr = alias
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid field %q: %v", field, err)
+		}
+		sets[i] = set
+	}
+	// 7 is another name for Sunday.
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+
+	return &cronSchedule{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		domAny: fields[2] == "*",
+		dowAny: fields[4] == "*",
+	}, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepText)
+			}
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(from); err != nil {
+				return 0, fmt.Errorf("invalid value %q", from)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(to); err != nil {
+					return 0, fmt.Errorf("invalid value %q", to)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max {
+			return 0, fmt.Errorf("%s is outside %d-%d", rng, min, max)
+		}
+		if lo > hi {
+			return 0, fmt.Errorf("invalid range %s", rng)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// matches reports whether the schedule fires in t's minute. As in cron,
+// when both day fields are restricted a day matching either one fires.
+func (s *cronSchedule) matches(t time.Time) bool {
+	if s.minute&(1<<t.Minute()) == 0 || s.hour&(1<<t.Hour()) == 0 || s.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	domOK := s.dom&(1<<t.Day()) != 0
+	dowOK := s.dow&(1<<int(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return domOK && dowOK
+	}
+	return domOK || dowOK
+}
+
+// next returns the first minute after t the schedule fires in, searching up
+// to five years ahead for expressions like "0 0 29 2 *".
+func (s *cronSchedule) next(t time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.matches(t) {
+			return t, true
+		}
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}, false
+}
+
+// scheduledTask is a recurring job declared in code. schedule is its cron
+// expression, from a setting such as DIGEST_SCHEDULE, and a task without
+// one doesn't run.
+type scheduledTask struct {
+	name     string
+	schedule string
+	run      func(ctx context.Context) error
+	running  atomic.Bool
+}
+
+// runScheduler starts every task that has a schedule. A run that is still
+// going when the task is next due is skipped rather than started
+// alongside it.
+func runScheduler(ctx context.Context, tasks []*scheduledTask) {
+	for _, task := range tasks {
+		if task.schedule == "" {
+			continue
+		}
+		schedule, err := parseCron(task.schedule)
+		if err != nil {
+			log.Printf("Warning: not scheduling %s, invalid schedule: %v", task.name, err)
+			continue
+		}
+		go task.loop(ctx, schedule)
+	}
+}
+
+func (t *scheduledTask) loop(ctx context.Context, schedule *cronSchedule) {
+	for {
+		next, ok := schedule.next(time.Now().In(appLocation))
+		if !ok {
+			log.Printf("Warning: schedule %q never matches, not scheduling %s", t.schedule, t.name)
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if !t.running.CompareAndSwap(false, true) {
+			log.Printf("Warning: skipping scheduled %s, the previous run is still going", t.name)
+			continue
+		}
+		go func() {
+			defer t.running.Store(false)
+			if err := t.run(ctx); err != nil {
+				log.Printf("Error running scheduled %s: %v", t.name, err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	at := func(s string) time.Time {
+		tm, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	// 2024-03-04 is a Monday.
+	tests := []struct {
+		expr  string
+		from  string
+		want  string
+		never bool
+	}{
+		{expr: "* * * * *", from: "2024-03-04 10:00", want: "2024-03-04 10:01"},
+		{expr: "0 9 * * 1-5", from: "2024-03-04 09:00", want: "2024-03-05 09:00"},
+		{expr: "0 9 * * 1-5", from: "2024-03-08 09:00", want: "2024-03-11 09:00"},
+		{expr: "*/15 * * * *", from: "2024-03-04 10:01", want: "2024-03-04 10:15"},
+		{expr: "5/20 * * * *", from: "2024-03-04 10:26", want: "2024-03-04 10:45"},
+		{expr: "0 8-18/4 * * *", from: "2024-03-04 12:00", want: "2024-03-04 16:00"},
+		{expr: "30 6,18 * * *", from: "2024-03-04 07:00", want: "2024-03-04 18:30"},
+		{expr: "0 0 1 */3 *", from: "2024-03-04 00:00", want: "2024-04-01 00:00"},
+		{expr: "@hourly", from: "2024-03-04 10:30", want: "2024-03-04 11:00"},
+		{expr: "@daily", from: "2024-03-04 10:30", want: "2024-03-05 00:00"},
+		{expr: "@weekly", from: "2024-03-04 10:30", want: "2024-03-10 00:00"},
+		{expr: "@monthly", from: "2024-03-04 10:30", want: "2024-04-01 00:00"},
+		{expr: "  0 0 * * 0  ", from: "2024-03-04 00:00", want: "2024-03-10 00:00"},
+		// 7 is Sunday too.
+		{expr: "0 0 * * 7", from: "2024-03-04 00:00", want: "2024-03-10 00:00"},
+		{expr: "0 0 * * 5-7", from: "2024-03-04 00:00", want: "2024-03-08 00:00"},
+		// Both day fields restricted: either one matches.
+		{expr: "0 0 15 * 3", from: "2024-03-04 00:00", want: "2024-03-06 00:00"},
+		{expr: "0 0 15 * 3", from: "2024-03-13 00:00", want: "2024-03-15 00:00"},
+		// Only in leap years.
+		{expr: "0 0 29 2 *", from: "2024-03-01 00:00", want: "2028-02-29 00:00"},
+		{expr: "0 0 31 2 *", from: "2024-03-01 00:00", never: true},
+	}
+	for _, tt := range tests {
+		s, err := parseCron(tt.expr)
+		if err != nil {
+			t.Errorf("%q: %v", tt.expr, err)
+			continue
+		}
+		got, ok := s.next(at(tt.from))
+		switch {
+		case tt.never && ok:
+			t.Errorf("%q from %s = %s, want never", tt.expr, tt.from, got)
+		case !tt.never && (!ok || !got.Equal(at(tt.want))):
+			t.Errorf("%q from %s = %s %t, want %s", tt.expr, tt.from, got, ok, tt.want)
+		}
+	}
+}
+
+func TestParseCronRejectsInvalidExpressions(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"@yearly",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/-1 * * * *",
+		"a * * * *",
+		"1- * * * *",
+		"1,,2 * * * *",
+		"MON * * * *",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("%q was accepted", expr)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const defaultSelfTestMessage = "Self-test: canary alert"
+
+type SelfTestStage struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+type SelfTestResponse struct {
+	OK        bool            `json:"ok"`
+	DryRun    bool            `json:"dry_run"`
+	MessageID int64           `json:"message_id,omitempty"`
+	Stages    []SelfTestStage `json:"stages"`
+}
+
+// handleSelfTest pushes a canary message through validation, chat pacing and
+// the Telegram call, reporting each stage. With ?dry_run=true the upstream
+// stage is skipped. Stages after a failure are reported as skipped.
+func handleSelfTest(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	message := config.SelfTestMessage
+
+	resp := SelfTestResponse{OK: true, DryRun: r.URL.Query().Get("dry_run") == "true"}
+	run := func(name string, fn func() error) {
+		stage := SelfTestStage{Name: name, Status: "skipped"}
+		if resp.OK {
+			start := time.Now()
+			err := fn()
+			stage.DurationMs = time.Since(start).Milliseconds()
+			stage.Status = "ok"
+			if err != nil {
+				stage.Status = "failed"
+				stage.Error = err.Error()
+				resp.OK = false
+			}
+		}
+		resp.Stages = append(resp.Stages, stage)
+	}
+
+	var opts SendOptions
+	run("validation", func() error {
+		var msg string
+		opts, msg = sendOptionsFor(MessageRequest{Message: message}, config)
+		if msg != "" {
+			return errors.New(msg)
+		}
+		return nil
+	})
+
+	run("rate_limiter", func() error {
+		return paceChat(r.Context(), config)
+	})
+
+	if resp.DryRun {
+		resp.Stages = append(resp.Stages, SelfTestStage{Name: "upstream", Status: "skipped"})
+	} else {
+		run("upstream", func() error {
+			result, err := callTelegram(r.Context(), config, "sendMessage", TelegramMessage{
+				ChatID:    config.ChatID,
+				Text:      message,
+				ParseMode: resolveParseMode(opts, message),
+			})
+			if err != nil {
+				return err
+			}
+			var sent TelegramSentMessage
+			if err := json.Unmarshal(result, &sent); err != nil {
+				return fmt.Errorf("error decoding response: %v", err)
+			}
+			resp.MessageID = sent.MessageID
+			return nil
+		})
+	}
+
+	auditLog.record("selftest", map[string]string{
+		"chat_id": config.ChatID,
+		"ok":      strconv.FormatBool(resp.OK),
+	})
+
+	status := http.StatusOK
+	if !resp.OK {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, resp)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSelfTestStages(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		business string
+		upstream error
+		status   int
+		stages   []string
+	}{
+		{"all ok", "", "", nil, http.StatusOK, []string{"ok", "ok", "ok"}},
+		{"dry run", "?dry_run=true", "", nil, http.StatusOK, []string{"ok", "ok", "skipped"}},
+		{"upstream fails", "", "", &UpstreamError{StatusCode: http.StatusBadGateway}, http.StatusServiceUnavailable, []string{"ok", "ok", "failed"}},
+		{"validation fails", "", "true", nil, http.StatusServiceUnavailable, []string{"failed", "skipped", "skipped"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, _ := useFakeUpstreams(t)
+			if tt.upstream != nil {
+				telegram = failingTelegram{tt.upstream}
+			}
+			config := configWith(t, "TELEGRAM_BUSINESS_MODE", tt.business)
+			config.ChatID = "selftest"
+
+			rec := httptest.NewRecorder()
+			handleSelfTest(rec, httptest.NewRequest(http.MethodPost, "/admin/selftest"+tt.query, nil), config)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			var resp SelfTestResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.OK != (tt.status == http.StatusOK) {
+				t.Errorf("ok = %v with status %d", resp.OK, rec.Code)
+			}
+
+			names := []string{"validation", "rate_limiter", "upstream"}
+			if len(resp.Stages) != len(names) {
+				t.Fatalf("stages = %+v, want %v", resp.Stages, names)
+			}
+			for i, stage := range resp.Stages {
+				if stage.Name != names[i] || stage.Status != tt.stages[i] {
+					t.Errorf("stage %d = %s %s, want %s %s", i, stage.Name, stage.Status, names[i], tt.stages[i])
+				}
+				if (stage.Status == "failed") != (stage.Error != "") {
+					t.Errorf("stage %s: status %s with error %q", stage.Name, stage.Status, stage.Error)
+				}
+			}
+
+			sent := len(fake.Calls()) > 0
+			if wantSent := tt.stages[2] == "ok"; sent != wantSent || (wantSent && resp.MessageID == 0) {
+				t.Errorf("sent = %v with message_id %d, want sent = %v", sent, resp.MessageID, wantSent)
+			}
+		})
+	}
+}
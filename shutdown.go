@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+var errShutdownInterrupted = errors.New("shutdown interrupted by a second signal")
+
+// shutdownServer drains srv until ctx is done. Another signal on signals
+// while it drains stops the server at once instead, closing every open
+// connection, and shutdownServer returns errShutdownInterrupted. Further
+// signals are ignored: the hard stop runs only once.
+func shutdownServer(ctx context.Context, srv *http.Server, signals <-chan os.Signal) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var interrupted atomic.Bool
+	hardStop := sync.OnceFunc(func() {
+		interrupted.Store(true)
+		cancel()
+		srv.Close()
+	})
+	go func() {
+		for {
+			select {
+			case <-signals:
+				hardStop()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	err := srv.Shutdown(ctx)
+	if interrupted.Load() {
+		return errShutdownInterrupted
+	}
+	return err
+}
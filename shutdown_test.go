@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startBlockedServer serves requests that block until release is closed,
+// and returns once one of them is in flight.
+func startBlockedServer(t *testing.T, release chan struct{}) (*http.Server, <-chan error) {
+	t.Helper()
+	inFlight := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(inFlight)
+		<-release
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+
+	clientErr := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+		}
+		clientErr <- err
+	}()
+	<-inFlight
+	return srv, clientErr
+}
+
+func TestShutdownServerDrains(t *testing.T) {
+	release := make(chan struct{})
+	srv, clientErr := startBlockedServer(t, release)
+
+	done := make(chan error, 1)
+	go func() { done <- shutdownServer(context.Background(), srv, make(chan os.Signal)) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("shutdown returned %v with a request in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("shutdown = %v, want nil", err)
+	}
+	if err := <-clientErr; err != nil {
+		t.Errorf("in-flight request failed: %v", err)
+	}
+}
+
+func TestShutdownServerSecondSignalStopsImmediately(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	srv, clientErr := startBlockedServer(t, release)
+
+	// The first signal is the one main waits for before shutting down.
+	signals := make(chan os.Signal, 1)
+	signals <- syscall.SIGTERM
+	<-signals
+
+	done := make(chan error, 1)
+	go func() { done <- shutdownServer(context.Background(), srv, signals) }()
+	time.Sleep(50 * time.Millisecond)
+
+	// A second and third signal stop the drain once, without waiting for
+	// the in-flight request.
+	signals <- os.Interrupt
+	signals <- os.Interrupt
+	select {
+	case err := <-done:
+		if !errors.Is(err, errShutdownInterrupted) {
+			t.Errorf("shutdown = %v, want errShutdownInterrupted", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("shutdown kept draining after a second signal")
+	}
+	if err := <-clientErr; err == nil {
+		t.Error("in-flight request succeeded, want its connection closed")
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSignupFeedWindow      = 30 * time.Second
+	defaultSignupFeedDetailLimit = 10
+)
+
+type signupEntry struct {
+	emailHash     string
+	utmSource     string
+	utmMedium     string
+	referringSite string
+}
+
+// signupFeed posts subscribe summaries to a Telegram channel. Signups that
+// arrive within window of the first pending one are collected into a
+// single message so a burst doesn't flood the channel.
+type signupFeed struct {
+	mu      sync.Mutex
+	config  Config
+	window  time.Duration
+	pending []signupEntry
+	timer   *time.Timer
+}
+
+// signupFeedPoster is nil unless SIGNUP_FEED_CHAT_ID is set.
+var signupFeedPoster *signupFeed
+
+func newSignupFeed(config Config, chatID string, window time.Duration) *signupFeed {
+	config.ChatID = chatID
+	return &signupFeed{config: config, window: window}
+}
+
+func (f *signupFeed) add(req SubscribeRequest) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.pending = append(f.pending, signupEntry{
+		emailHash:     hashEmail(req.Email),
+		utmSource:     req.UTMSource,
+		utmMedium:     req.UTMMedium,
+		referringSite: req.ReferringSite,
+	})
+	if f.timer == nil {
+		f.timer = time.AfterFunc(f.window, f.flush)
+	}
+}
+
+func (f *signupFeed) flush() {
+	f.mu.Lock()
+	entries := f.pending
+	f.pending = nil
+	f.timer = nil
+	f.mu.Unlock()
+
+	if len(entries) == 0 {
+		return
+	}
+
+	_, err := callTelegram(context.Background(), f.config, "sendMessage", TelegramMessage{
+		ChatID: f.config.ChatID,
+		Text:   formatSignupSummary(entries, f.window, f.config.SignupFeedDetailLimit),
+	})
+	if err != nil {
+		log.Printf("Warning: cannot post signup feed: %v", err)
+	}
+}
+
+// formatSignupSummary lists each signup, or only the counts once a burst
+// exceeds detailLimit so a launch produces one readable summary instead of
+// a wall of lines.
+func formatSignupSummary(entries []signupEntry, window time.Duration, detailLimit int) string {
+	var b strings.Builder
+	if len(entries) == 1 {
+		b.WriteString("New signup\n")
+	} else {
+		fmt.Fprintf(&b, "%d new subscribers in the last %s\n", len(entries), window)
+	}
+
+	sources := make(map[string]int)
+	for _, e := range entries {
+		source := orDash(e.utmSource)
+		sources[source]++
+		if len(entries) <= detailLimit {
+			fmt.Fprintf(&b, "\n• %s  source: %s, medium: %s, referrer: %s", e.emailHash, source, orDash(e.utmMedium), orDash(e.referringSite))
+		}
+	}
+
+	if len(entries) > 1 {
+		names := make([]string, 0, len(sources))
+		for name := range sources {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			if sources[names[i]] != sources[names[j]] {
+				return sources[names[i]] > sources[names[j]]
+			}
+			return names[i] < names[j]
+		})
+
+		if len(entries) <= detailLimit {
+			b.WriteString("\n")
+		}
+		b.WriteString("\nBy source:")
+		for _, name := range names {
+			fmt.Fprintf(&b, "\n%s: %d", name, sources[name])
+		}
+	}
+
+	return b.String()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// hashEmail returns a short salted hash so signups can be told apart in the
+// channel without exposing the address.
+func hashEmail(email string) string {
+	sum := sha256.Sum256([]byte(hashSalt() + strings.ToLower(email)))
+	return hex.EncodeToString(sum[:])[:12]
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatSignupSummary(t *testing.T) {
+	single := formatSignupSummary([]signupEntry{{emailHash: "abc123", utmSource: "twitter", referringSite: "https://t.co"}}, time.Minute, 10)
+	if want := "New signup\n\n• abc123  source: twitter, medium: -, referrer: https://t.co"; single != want {
+		t.Errorf("single signup:\n%s\nwant:\n%s", single, want)
+	}
+
+	entries := []signupEntry{
+		{emailHash: "h1", utmSource: "twitter", utmMedium: "social"},
+		{emailHash: "h2", utmSource: "newsletter"},
+		{emailHash: "h3", utmSource: "twitter"},
+	}
+	batch := formatSignupSummary(entries, 30*time.Second, 10)
+	want := "3 new subscribers in the last 30s\n" +
+		"\n• h1  source: twitter, medium: social, referrer: -" +
+		"\n• h2  source: newsletter, medium: -, referrer: -" +
+		"\n• h3  source: twitter, medium: -, referrer: -\n" +
+		"\nBy source:\ntwitter: 2\nnewsletter: 1"
+	if batch != want {
+		t.Errorf("batch:\n%s\nwant:\n%s", batch, want)
+	}
+
+	// Past the detail limit only the counts are listed.
+	burst := formatSignupSummary(entries, 30*time.Second, 2)
+	if strings.Contains(burst, "•") || !strings.HasSuffix(burst, "\nBy source:\ntwitter: 2\nnewsletter: 1") {
+		t.Errorf("burst:\n%s", burst)
+	}
+}
+
+func TestHashEmailHidesAddress(t *testing.T) {
+	hash := hashEmail("Reader@Example.com")
+	if len(hash) != 12 || strings.Contains(hash, "example") {
+		t.Errorf("hashEmail = %q", hash)
+	}
+	if hashEmail("reader@example.com") != hash {
+		t.Error("hashEmail depends on the address's case")
+	}
+}
+
+func TestSignupFeedBatchesRapidSignups(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+	feed := newSignupFeed(testConfig, "feed-chat", 50*time.Millisecond)
+
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		feed.add(SubscribeRequest{Email: email, UTMSource: "launch"})
+	}
+	if n := len(fake.Calls()); n != 0 {
+		t.Fatalf("%d messages posted before the window closed", n)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(fake.Calls()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	calls := fake.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("posted %d messages, want one for the batch", len(calls))
+	}
+	var msg TelegramMessage
+	if err := json.Unmarshal(calls[0].Body, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.ChatID != "feed-chat" || !strings.HasPrefix(msg.Text, "3 new subscribers") || strings.Contains(msg.Text, "@example.com") {
+		t.Errorf("posted %+v", msg)
+	}
+
+	// Nothing pending means nothing to post.
+	feed.flush()
+	if n := len(fake.Calls()); n != 1 {
+		t.Errorf("an empty flush posted a message")
+	}
+}
+
+func TestSubscribeNotifyTeamOverride(t *testing.T) {
+	useFakeUpstreams(t)
+	defer func(orig *signupFeed) { signupFeedPoster = orig }(signupFeedPoster)
+
+	tests := []struct {
+		name       string
+		global     string
+		notifyTeam string
+		want       bool
+	}{
+		{"default", "", "", true},
+		{"override off", "", `,"notify_team":false`, false},
+		{"global off", "false", "", false},
+		{"override on", "false", `,"notify_team":true`, true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := configWith(t, "NOTIFY_ON_SUBSCRIBE", tt.global)
+			feed := newSignupFeed(config, "feed-chat", time.Hour)
+			signupFeedPoster = feed
+			defer func() {
+				feed.mu.Lock()
+				defer feed.mu.Unlock()
+				if feed.timer != nil {
+					feed.timer.Stop()
+				}
+			}()
+
+			body := `{"email":"notify-` + strconv.Itoa(i) + `@example.com"` + tt.notifyTeam + `}`
+			if rec := serve(bind(handleSubscribe, config), http.MethodPost, "/subscribe", body); rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			feed.mu.Lock()
+			notified := len(feed.pending) == 1
+			feed.mu.Unlock()
+			if notified != tt.want {
+				t.Errorf("team notified = %v, want %v", notified, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignupFeedCoalescesBurstsPerWindow(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+	feed := newSignupFeed(configWith(t, "SIGNUP_FEED_DETAIL_LIMIT", "5"), "feed-chat", 50*time.Millisecond)
+
+	waitForPosts := func(n int) []string {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for len(fake.Calls()) < n && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		var texts []string
+		for _, call := range fake.Calls() {
+			var msg TelegramMessage
+			json.Unmarshal(call.Body, &msg)
+			texts = append(texts, msg.Text)
+		}
+		return texts
+	}
+
+	// A burst past the detail limit becomes one count summary.
+	for i := 0; i < 12; i++ {
+		feed.add(SubscribeRequest{Email: "burst-" + strconv.Itoa(i) + "@example.com", UTMSource: "launch"})
+	}
+	posts := waitForPosts(1)
+	if len(posts) != 1 || !strings.HasPrefix(posts[0], "12 new subscribers in the last 50ms") || strings.Contains(posts[0], "•") {
+		t.Fatalf("posts = %q, want one count summary", posts)
+	}
+	if !strings.HasSuffix(posts[0], "By source:\nlaunch: 12") {
+		t.Errorf("summary = %q, want the source breakdown", posts[0])
+	}
+
+	// A signup after the window closed starts a new message.
+	feed.add(SubscribeRequest{Email: "late@example.com"})
+	posts = waitForPosts(2)
+	if len(posts) != 2 || !strings.HasPrefix(posts[1], "New signup") {
+		t.Errorf("posts = %q, want a second message for the late signup", posts)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	defaultSpamHoneypotField = "website"
+	defaultSpamMinSubmitTime = 3 * time.Second
+
+	// spamRenderedAtField carries the Unix time, in seconds, at which the
+	// form was shown, for the minimum submit time check.
+	spamRenderedAtField = "rendered_at"
+)
+
+// disposableEmailDomains is a short list of the throwaway mail services
+// most often seen in signups; SPAM_DISPOSABLE_DOMAINS adds to it.
+var disposableEmailDomains = []string{
+	"10minutemail.com", "dispostable.com", "fakeinbox.com", "getnada.com",
+	"guerrillamail.com", "maildrop.cc", "mailinator.com", "mintemail.com",
+	"sharklasers.com", "temp-mail.org", "tempmail.com", "throwawaymail.com",
+	"trashmail.com", "yopmail.com",
+}
+
+var spamRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "api_spam_rejected_total",
+	Help: "Form submissions rejected as spam, by route and the check that caught them.",
+}, []string{"route", "check"})
+
+// spamSubmission is what the spam checks see of a form post. Author and
+// Content gather whichever of the known name and text fields it has.
+type spamSubmission struct {
+	Route      string
+	Email      string
+	Author     string
+	Content    string
+	Honeypot   string
+	RenderedAt time.Time
+	IP         string
+	UserAgent  string
+	Referrer   string
+}
+
+// spamCheck reports whether sub looks like spam; name labels the metric.
+// Checks that depend on an upstream fail open: an error is logged and the
+// submission is let through.
+type spamCheck struct {
+	name  string
+	check func(ctx context.Context, config Config, sub spamSubmission) (bool, error)
+}
+
+// spamChecks run in order and stop at the first match, so the cheap local
+// checks come before Akismet.
+var spamChecks = []spamCheck{
+	{"honeypot", checkHoneypot},
+	{"too_fast", checkSubmitTime},
+	{"disposable_email", checkDisposableEmail},
+	{"keyword", checkKeywords},
+	{"akismet", checkAkismet},
+}
+
+// withSpamFilter runs spamChecks over a JSON form post before next sees
+// it. The honeypot (config.SpamHoneypotField) and rendered_at fields are
+// removed from the body, so the request types need not declare them. A
+// rejected submission is counted in api_spam_rejected_total and answered
+// with 400 spam_rejected without reaching next.
+func withSpamFilter(route string, next http.HandlerFunc, config Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next(w, r)
+			return
+		}
+
+		sub, err := takeSpamFields(r, config.SpamHoneypotField)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+			return
+		}
+		if sub == nil {
+			next(w, r)
+			return
+		}
+		sub.Route = route
+		sub.IP = clientIP(r)
+		sub.UserAgent = r.UserAgent()
+		sub.Referrer = r.Referer()
+
+		for _, c := range spamChecks {
+			spam, err := c.check(r.Context(), config, *sub)
+			if err != nil {
+				log.Printf("Warning: spam check %s failed: %v", c.name, err)
+				continue
+			}
+			if spam {
+				spamRejected.WithLabelValues(route, c.name).Inc()
+				writeJSON(w, http.StatusBadRequest, ErrorResponse{Message: "Submission was rejected", Code: "spam_rejected"})
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// takeSpamFields reads a JSON object body into a spamSubmission and puts
+// the body back without the honeypot and rendered_at fields. It returns
+// nil for bodies that are not JSON objects or are larger than
+// captchaPeekLimit, which are left for next to reject or accept.
+func takeSpamFields(r *http.Request, honeypotField string) (*spamSubmission, error) {
+	peeked, err := io.ReadAll(io.LimitReader(r.Body, captchaPeekLimit+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(peeked) > captchaPeekLimit {
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(peeked), r.Body))
+		return nil, nil
+	}
+	r.Body = io.NopCloser(bytes.NewReader(peeked))
+
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(peeked, &fields) != nil {
+		return nil, nil
+	}
+	text := func(name string) string {
+		var s string
+		json.Unmarshal(fields[name], &s)
+		return strings.TrimSpace(s)
+	}
+	join := func(names ...string) string {
+		var parts []string
+		for _, name := range names {
+			if s := text(name); s != "" {
+				parts = append(parts, s)
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+
+	sub := &spamSubmission{
+		Email:    text("email"),
+		Author:   join("name", "author", "first_name", "last_name"),
+		Content:  join("subject", "message", "body"),
+		Honeypot: text(honeypotField),
+	}
+
+	_, hasHoneypot := fields[honeypotField]
+	raw, hasRenderedAt := fields[spamRenderedAtField]
+	if hasRenderedAt {
+		var seconds int64
+		if err := json.Unmarshal(raw, &seconds); err != nil {
+			return nil, err
+		}
+		sub.RenderedAt = time.Unix(seconds, 0)
+	}
+	if !hasHoneypot && !hasRenderedAt {
+		return sub, nil
+	}
+
+	delete(fields, honeypotField)
+	delete(fields, spamRenderedAtField)
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	return sub, nil
+}
+
+// checkHoneypot catches bots that fill in every field, including the one
+// the form hides from people.
+func checkHoneypot(_ context.Context, _ Config, sub spamSubmission) (bool, error) {
+	return sub.Honeypot != "", nil
+}
+
+// checkSubmitTime catches forms posted sooner than SpamMinSubmitTime after
+// rendered_at. Forms that don't send rendered_at are not checked.
+func checkSubmitTime(_ context.Context, config Config, sub spamSubmission) (bool, error) {
+	if sub.RenderedAt.IsZero() {
+		return false, nil
+	}
+	return time.Since(sub.RenderedAt) < config.SpamMinSubmitTime, nil
+}
+
+// checkDisposableEmail catches addresses at, or under, a throwaway mail
+// domain unless SPAM_BLOCK_DISPOSABLE is false.
+func checkDisposableEmail(_ context.Context, config Config, sub spamSubmission) (bool, error) {
+	if !config.SpamBlockDisposable {
+		return false, nil
+	}
+	_, domain, ok := strings.Cut(strings.ToLower(sub.Email), "@")
+	if !ok {
+		return false, nil
+	}
+	blocked := append(slices.Clone(disposableEmailDomains), config.SpamDisposableDomains...)
+	for _, d := range blocked {
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkKeywords catches submissions whose name or text contains one of the
+// comma-separated SPAM_KEYWORDS, ignoring case.
+func checkKeywords(_ context.Context, config Config, sub spamSubmission) (bool, error) {
+	text := strings.ToLower(sub.Author + "\n" + sub.Content)
+	for _, keyword := range config.SpamKeywords {
+		if strings.Contains(text, strings.ToLower(keyword)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// akismetCommentTypes maps routes to Akismet's comment_type values.
+var akismetCommentTypes = map[string]string{
+	"subscribe": "signup",
+	"contact":   "contact-form",
+	"comment":   "comment",
+}
+
+// checkAkismet asks Akismet's comment-check API when AKISMET_API_KEY and
+// AKISMET_BLOG_URL are set.
+func checkAkismet(ctx context.Context, config Config, sub spamSubmission) (bool, error) {
+	apiKey := config.AkismetAPIKey
+	if apiKey == "" {
+		return false, nil
+	}
+
+	form := url.Values{
+		"api_key":              {apiKey},
+		"blog":                 {config.AkismetBlogURL},
+		"user_ip":              {sub.IP},
+		"user_agent":           {sub.UserAgent},
+		"referrer":             {sub.Referrer},
+		"comment_type":         {akismetCommentTypes[sub.Route]},
+		"comment_author":       {sub.Author},
+		"comment_author_email": {sub.Email},
+		"comment_content":      {sub.Content},
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, akismetAPIBaseURL+"/1.1/comment-check", strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, fmt.Errorf("error creating request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return false, fmt.Errorf("error calling Akismet: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, newUpstreamError(resp)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64))
+	if err != nil {
+		return false, fmt.Errorf("error reading response: %v", err)
+	}
+	switch strings.TrimSpace(string(body)) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	default:
+		// "invalid" comes with the reason in X-akismet-debug-help.
+		return false, fmt.Errorf("unexpected Akismet response %q: %s", body, resp.Header.Get("X-akismet-debug-help"))
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// spamFiltered is a form handler behind withSpamFilter that answers with
+// the body it was given.
+func spamFiltered(config Config) http.HandlerFunc {
+	return withSpamFilter("contact", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}, config)
+}
+
+func spamRequest(config Config, body string) *httptest.ResponseRecorder {
+	return serve(spamFiltered(config), http.MethodPost, "/contact", body)
+}
+
+// useAkismet points Akismet at handler until the test ends and returns a
+// config with a key for it.
+func useAkismet(t *testing.T, handler http.HandlerFunc) Config {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	orig := akismetAPIBaseURL
+	t.Cleanup(func() { akismetAPIBaseURL = orig })
+	akismetAPIBaseURL = srv.URL
+	return configWith(t, "AKISMET_API_KEY", "akismet-key", "AKISMET_BLOG_URL", "https://example.com")
+}
+
+func TestSpamFilterRejectsSpam(t *testing.T) {
+	config := configWith(t, "SPAM_KEYWORDS", "casino, Crypto Deal")
+	renderedNow := strconv.FormatInt(time.Now().Unix(), 10)
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"honeypot", `{"email":"ada@example.com","message":"hi","website":"http://spam.example"}`},
+		{"too fast", `{"email":"ada@example.com","message":"hi","rendered_at":` + renderedNow + `}`},
+		{"disposable", `{"email":"bot@mailinator.com","message":"hi"}`},
+		{"disposable subdomain", `{"email":"bot@eu.yopmail.com","message":"hi"}`},
+		{"keyword", `{"email":"ada@example.com","subject":"Best CASINO in town","message":"hi"}`},
+		{"keyword in name", `{"email":"ada@example.com","name":"crypto deal","message":"hi"}`},
+	}
+	for _, tt := range tests {
+		rec := spamRequest(config, tt.body)
+		if rec.Code != http.StatusBadRequest || decodeError(t, rec).Code != "spam_rejected" {
+			t.Errorf("%s: got %d %s, want 400 spam_rejected", tt.name, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestSpamFilterPassesCleanSubmissions(t *testing.T) {
+	config := configWith(t, "SPAM_KEYWORDS", "casino")
+	renderedEarlier := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+
+	rec := spamRequest(config, `{"email":"ada@example.com","message":"Hello","website":"","rendered_at":`+renderedEarlier+`}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &fields); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fields["website"]; ok {
+		t.Errorf("the honeypot reached the handler: %v", fields)
+	}
+	if _, ok := fields["rendered_at"]; ok {
+		t.Errorf("rendered_at reached the handler: %v", fields)
+	}
+	if fields["message"] != "Hello" || fields["email"] != "ada@example.com" {
+		t.Errorf("fields = %v", fields)
+	}
+
+	// Bodies the filter can't read are left for the handler to judge.
+	if rec := spamRequest(config, `not json`); rec.Code != http.StatusOK || rec.Body.String() != "not json" {
+		t.Errorf("non-JSON body: %d %s", rec.Code, rec.Body)
+	}
+	if rec := spamRequest(config, `{"rendered_at":"yesterday"}`); rec.Code != http.StatusBadRequest || decodeError(t, rec).Code != "invalid_request" {
+		t.Errorf("bad rendered_at: %d %s, want 400 invalid_request", rec.Code, rec.Body)
+	}
+}
+
+func TestSpamFilterSettings(t *testing.T) {
+	config := configWith(t, "SPAM_HONEYPOT_FIELD", "fax", "SPAM_BLOCK_DISPOSABLE", "false", "SPAM_DISPOSABLE_DOMAINS", "spam.example")
+
+	if rec := spamRequest(config, `{"email":"bot@mailinator.com","website":"kept"}`); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "kept") {
+		t.Errorf("with the defaults replaced: %d %s, want it passed on unchanged", rec.Code, rec.Body)
+	}
+	if rec := spamRequest(config, `{"email":"ada@example.com","fax":"555"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("SPAM_HONEYPOT_FIELD: status = %d, want 400", rec.Code)
+	}
+
+	config = configWith(t, "SPAM_BLOCK_DISPOSABLE", "")
+	if rec := spamRequest(config, `{"email":"bot@spam.example"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("SPAM_DISPOSABLE_DOMAINS: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestSpamFilterAsksAkismet(t *testing.T) {
+	config := useAkismet(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("api_key") != "akismet-key" || r.Form.Get("comment_type") != "contact-form" || r.Form.Get("user_ip") == "" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, strconv.FormatBool(strings.Contains(r.Form.Get("comment_content"), "spam")))
+	})
+
+	if rec := spamRequest(config, `{"email":"ada@example.com","message":"buy spam"}`); rec.Code != http.StatusBadRequest || decodeError(t, rec).Code != "spam_rejected" {
+		t.Errorf("spam: got %d %s, want 400 spam_rejected", rec.Code, rec.Body)
+	}
+	if rec := spamRequest(config, `{"email":"ada@example.com","message":"hello"}`); rec.Code != http.StatusOK {
+		t.Errorf("ham: status = %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestSpamFilterFailsOpenWhenAkismetFails(t *testing.T) {
+	var calls atomic.Int32
+	config := useAkismet(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("X-akismet-debug-help", "Invalid key")
+		io.WriteString(w, "invalid")
+	})
+
+	if rec := spamRequest(config, `{"email":"ada@example.com","message":"hello"}`); rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want the submission let through: %s", rec.Code, rec.Body)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Akismet called %d times, want 1", calls.Load())
+	}
+
+	// The local checks still run first and don't need Akismet.
+	if rec := spamRequest(config, `{"email":"ada@example.com","website":"x"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("honeypot: status = %d, want 400", rec.Code)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Akismet called after a local check matched")
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultNowPlayingCacheTTL = 15 * time.Second
+
+// NowPlaying is the /now-playing response. Only IsPlaying is set when
+// nothing is playing.
+type NowPlaying struct {
+	IsPlaying  bool   `json:"is_playing"`
+	Title      string `json:"title,omitempty"`
+	Artist     string `json:"artist,omitempty"`
+	Album      string `json:"album,omitempty"`
+	AlbumArt   string `json:"album_art,omitempty"`
+	URL        string `json:"url,omitempty"`
+	ProgressMs int    `json:"progress_ms,omitempty"`
+	DurationMs int    `json:"duration_ms,omitempty"`
+}
+
+type spotifyCurrentlyPlaying struct {
+	IsPlaying  bool `json:"is_playing"`
+	ProgressMs int  `json:"progress_ms"`
+	Item       *struct {
+		Name         string `json:"name"`
+		DurationMs   int    `json:"duration_ms"`
+		ExternalURLs struct {
+			Spotify string `json:"spotify"`
+		} `json:"external_urls"`
+		Artists []struct {
+			Name string `json:"name"`
+		} `json:"artists"`
+		Album struct {
+			Name   string `json:"name"`
+			Images []struct {
+				URL string `json:"url"`
+			} `json:"images"`
+		} `json:"album"`
+		// Podcast episodes have a show instead of artists and album.
+		Show *struct {
+			Name   string `json:"name"`
+			Images []struct {
+				URL string `json:"url"`
+			} `json:"images"`
+		} `json:"show"`
+	} `json:"item"`
+}
+
+// spotifyAuth holds the access token obtained with SPOTIFY_REFRESH_TOKEN,
+// so only the server ever sees the app's credentials.
+var spotifyAuth struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// nowPlayingCache keeps the last answer for NOW_PLAYING_CACHE_TTL, so a
+// busy page doesn't spend Spotify's rate limit. nowPlayingMu lets only one
+// request refresh it at a time.
+var (
+	nowPlayingCache = newTTLCache[NowPlaying]("now_playing", 1)
+	nowPlayingMu    sync.Mutex
+)
+
+// spotifyAccessToken returns a cached access token, refreshing it shortly
+// before it expires or when force is set after Spotify rejected it.
+func spotifyAccessToken(ctx context.Context, config Config, force bool) (string, error) {
+	spotifyAuth.mu.Lock()
+	defer spotifyAuth.mu.Unlock()
+
+	if !force && spotifyAuth.token != "" && time.Until(spotifyAuth.expires) > time.Minute {
+		return spotifyAuth.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {config.SpotifyRefreshToken},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, spotifyAccountsBaseURL+"/api/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(config.SpotifyClientID, config.SpotifyClientSecret)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error refreshing Spotify token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", newUpstreamError(resp)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil || token.AccessToken == "" {
+		return "", fmt.Errorf("error decoding Spotify token response: %v", err)
+	}
+	spotifyAuth.token = token.AccessToken
+	spotifyAuth.expires = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return spotifyAuth.token, nil
+}
+
+// fetchNowPlaying asks Spotify for the current track, refreshing the access
+// token once if it was revoked or expired early.
+func fetchNowPlaying(ctx context.Context, config Config) (NowPlaying, error) {
+	for attempt := 0; ; attempt++ {
+		token, err := spotifyAccessToken(ctx, config, attempt > 0)
+		if err != nil {
+			return NowPlaying{}, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, spotifyAPIBaseURL+"/v1/me/player/currently-playing?additional_types=track,episode", nil)
+		if err != nil {
+			return NowPlaying{}, fmt.Errorf("error creating request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return NowPlaying{}, fmt.Errorf("error fetching now playing: %w", err)
+		}
+		defer resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized && attempt == 0:
+			continue
+		case resp.StatusCode == http.StatusNoContent:
+			return NowPlaying{}, nil
+		case resp.StatusCode != http.StatusOK:
+			return NowPlaying{}, newUpstreamError(resp)
+		}
+
+		var current spotifyCurrentlyPlaying
+		if err := json.NewDecoder(resp.Body).Decode(&current); err != nil {
+			return NowPlaying{}, fmt.Errorf("error decoding now playing: %v", err)
+		}
+		return nowPlayingFrom(current), nil
+	}
+}
+
+func nowPlayingFrom(current spotifyCurrentlyPlaying) NowPlaying {
+	item := current.Item
+	if !current.IsPlaying || item == nil {
+		return NowPlaying{}
+	}
+
+	np := NowPlaying{
+		IsPlaying:  true,
+		Title:      item.Name,
+		URL:        item.ExternalURLs.Spotify,
+		ProgressMs: current.ProgressMs,
+		DurationMs: item.DurationMs,
+	}
+	var artists []string
+	for _, a := range item.Artists {
+		artists = append(artists, a.Name)
+	}
+	np.Artist = strings.Join(artists, ", ")
+	np.Album = item.Album.Name
+	if len(item.Album.Images) > 0 {
+		np.AlbumArt = item.Album.Images[0].URL
+	}
+	if item.Show != nil {
+		np.Artist = item.Show.Name
+		np.Album = item.Show.Name
+		if len(item.Show.Images) > 0 {
+			np.AlbumArt = item.Show.Images[0].URL
+		}
+	}
+	return np
+}
+
+// handleNowPlaying serves the listener's current Spotify track. Anything
+// other than a track or episode that is actually playing, including an
+// idle player, is reported as {"is_playing": false}.
+func handleNowPlaying(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	ttl := config.NowPlayingCacheTTL
+	np, ok := nowPlayingCache.get("current")
+	if !ok {
+		nowPlayingMu.Lock()
+		if np, ok = nowPlayingCache.get("current"); !ok {
+			var err error
+			np, err = fetchNowPlaying(r.Context(), config)
+			if err != nil {
+				nowPlayingMu.Unlock()
+				writeUpstreamError(w, err)
+				return
+			}
+			nowPlayingCache.set("current", np, ttl)
+		}
+		nowPlayingMu.Unlock()
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(ttl.Seconds())))
+	writeJSON(w, http.StatusOK, np)
+}
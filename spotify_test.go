@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeSpotify serves the token and currently-playing endpoints. Each
+// token it issues is numbered; player answers for the current one.
+// nowPlaying is /now-playing configured with the fake's credentials.
+type fakeSpotify struct {
+	tokens     atomic.Int32
+	plays      atomic.Int32
+	player     func(w http.ResponseWriter, token string)
+	refresh    string
+	nowPlaying http.HandlerFunc
+}
+
+func (f *fakeSpotify) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/api/token":
+		r.ParseForm()
+		id, secret, _ := r.BasicAuth()
+		if id != "client" || secret != "client-secret" || r.Form.Get("grant_type") != "refresh_token" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusBadRequest)
+			return
+		}
+		f.refresh = r.Form.Get("refresh_token")
+		fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":3600}`, f.tokens.Add(1))
+	case "/v1/me/player/currently-playing":
+		f.plays.Add(1)
+		f.player(w, r.Header.Get("Authorization"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// useSpotify points both Spotify hosts at a fake whose player answers with
+// player, starting without a token or a cached answer.
+func useSpotify(t *testing.T, player func(w http.ResponseWriter, token string)) *fakeSpotify {
+	t.Helper()
+	fake := &fakeSpotify{player: player}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	origAccounts, origAPI := spotifyAccountsBaseURL, spotifyAPIBaseURL
+	reset := func() {
+		spotifyAuth.token, spotifyAuth.expires = "", time.Time{}
+		nowPlayingCache.delete("current")
+	}
+	t.Cleanup(func() {
+		spotifyAccountsBaseURL, spotifyAPIBaseURL = origAccounts, origAPI
+		reset()
+	})
+	spotifyAccountsBaseURL, spotifyAPIBaseURL = srv.URL, srv.URL
+	reset()
+	fake.nowPlaying = bind(handleNowPlaying, configWith(t,
+		"SPOTIFY_CLIENT_ID", "client",
+		"SPOTIFY_CLIENT_SECRET", "client-secret",
+		"SPOTIFY_REFRESH_TOKEN", "refresh"))
+	return fake
+}
+
+func nowPlaying(t *testing.T, fake *fakeSpotify) NowPlaying {
+	t.Helper()
+	rec := serve(fake.nowPlaying, http.MethodGet, "/now-playing", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var np NowPlaying
+	if err := json.Unmarshal(rec.Body.Bytes(), &np); err != nil {
+		t.Fatal(err)
+	}
+	return np
+}
+
+const playingTrack = `{"is_playing":true,"progress_ms":1000,"item":{"name":"Song","duration_ms":200000,
+	"external_urls":{"spotify":"https://open.spotify.com/track/1"},
+	"artists":[{"name":"One"},{"name":"Two"}],
+	"album":{"name":"Album","images":[{"url":"https://i.scdn.co/large"},{"url":"https://i.scdn.co/small"}]}}}`
+
+func TestNowPlayingTrack(t *testing.T) {
+	fake := useSpotify(t, func(w http.ResponseWriter, token string) {
+		w.Write([]byte(playingTrack))
+	})
+
+	rec := serve(fake.nowPlaying, http.MethodGet, "/now-playing", "")
+	if cc := rec.Header().Get("Cache-Control"); cc != "public, max-age=15" {
+		t.Errorf("Cache-Control = %q", cc)
+	}
+	want := NowPlaying{
+		IsPlaying: true, Title: "Song", Artist: "One, Two", Album: "Album",
+		AlbumArt: "https://i.scdn.co/large", URL: "https://open.spotify.com/track/1",
+		ProgressMs: 1000, DurationMs: 200000,
+	}
+	if np := nowPlaying(t, fake); np != want {
+		t.Errorf("now playing = %+v, want %+v", np, want)
+	}
+	if fake.refresh != "refresh" {
+		t.Errorf("refresh token = %q", fake.refresh)
+	}
+	// The first request's answer is cached for the second.
+	if n := fake.plays.Load(); n != 1 {
+		t.Errorf("%d Spotify calls for two requests, want 1", n)
+	}
+}
+
+func TestNowPlayingEpisode(t *testing.T) {
+	fake := useSpotify(t, func(w http.ResponseWriter, token string) {
+		w.Write([]byte(`{"is_playing":true,"item":{"name":"Episode 1","duration_ms":60000,
+			"show":{"name":"The Show","images":[{"url":"https://i.scdn.co/show"}]}}}`))
+	})
+
+	np := nowPlaying(t, fake)
+	if np.Title != "Episode 1" || np.Artist != "The Show" || np.Album != "The Show" || np.AlbumArt != "https://i.scdn.co/show" {
+		t.Errorf("now playing = %+v, want the show in place of artist and album", np)
+	}
+}
+
+func TestNowPlayingIdle(t *testing.T) {
+	for name, player := range map[string]func(w http.ResponseWriter, token string){
+		"nothing playing": func(w http.ResponseWriter, token string) { w.WriteHeader(http.StatusNoContent) },
+		"paused": func(w http.ResponseWriter, token string) {
+			w.Write([]byte(`{"is_playing":false,"item":{"name":"Song"}}`))
+		},
+		"ad break": func(w http.ResponseWriter, token string) { w.Write([]byte(`{"is_playing":true,"item":null}`)) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			fake := useSpotify(t, player)
+			rec := serve(fake.nowPlaying, http.MethodGet, "/now-playing", "")
+			if np := nowPlaying(t, fake); np != (NowPlaying{}) {
+				t.Errorf("now playing = %+v, want only is_playing false", np)
+			}
+			var fields map[string]any
+			json.Unmarshal(rec.Body.Bytes(), &fields)
+			if fields["is_playing"] != false || fields["title"] != nil {
+				t.Errorf("body = %s", rec.Body)
+			}
+		})
+	}
+}
+
+func TestNowPlayingRefreshesRevokedToken(t *testing.T) {
+	fake := useSpotify(t, func(w http.ResponseWriter, token string) {
+		if token != "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(playingTrack))
+	})
+
+	if np := nowPlaying(t, fake); !np.IsPlaying {
+		t.Errorf("now playing = %+v", np)
+	}
+	if n := fake.tokens.Load(); n != 2 {
+		t.Errorf("%d tokens issued, want one refresh after the 401", n)
+	}
+}
+
+func TestNowPlayingGivesUpAfterSecondRejection(t *testing.T) {
+	fake := useSpotify(t, func(w http.ResponseWriter, token string) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	if rec := serve(fake.nowPlaying, http.MethodGet, "/now-playing", ""); rec.Code == http.StatusOK {
+		t.Errorf("status = 200, want an error: %s", rec.Body)
+	}
+	if n := fake.plays.Load(); n != 2 {
+		t.Errorf("%d Spotify calls, want 2", n)
+	}
+}
+
+func TestNowPlayingRateLimited(t *testing.T) {
+	fake := useSpotify(t, func(w http.ResponseWriter, token string) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	rec := serve(fake.nowPlaying, http.MethodGet, "/now-playing", "")
+	if rec.Code != http.StatusServiceUnavailable || decodeError(t, rec).Code != "upstream_rate_limited" {
+		t.Errorf("got %d %s, want 503 upstream_rate_limited", rec.Code, rec.Body)
+	}
+	if ra := rec.Header().Get("Retry-After"); ra != "30" {
+		t.Errorf("Retry-After = %q, want Spotify's", ra)
+	}
+}
+
+func TestNowPlayingMethods(t *testing.T) {
+	fake := useSpotify(t, func(w http.ResponseWriter, token string) { w.WriteHeader(http.StatusNoContent) })
+
+	if rec := serve(fake.nowPlaying, http.MethodHead, "/now-playing", ""); rec.Code != http.StatusOK {
+		t.Errorf("HEAD: status = %d", rec.Code)
+	}
+	if rec := serve(fake.nowPlaying, http.MethodPost, "/now-playing", ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status = %d, want 405", rec.Code)
+	}
+}
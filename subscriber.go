@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errSubscriberNotFound = errors.New("subscriber not found")
+
+const (
+	unsubscribeLinkTimeout    = 30 * time.Second
+	defaultSubscriberTokenTTL = 30 * 24 * time.Hour
+	subscriberTokenClockSkew  = time.Minute
+
+	// defaultSubscriberLookupLimit is how many /subscriber lookups each IP
+	// gets a minute.
+	defaultSubscriberLookupLimit = 30
+)
+
+var subscriberLookups *rateLimiter
+
+type BeehiivCustomField struct {
+	Name  string      `json:"name"`
+	Kind  string      `json:"kind,omitempty"`
+	Value interface{} `json:"value"`
+}
+
+type BeehiivSubscriber struct {
+	ID           string               `json:"id"`
+	Email        string               `json:"email"`
+	Status       string               `json:"status"`
+	Tags         []string             `json:"tags"`
+	CustomFields []BeehiivCustomField `json:"custom_fields"`
+}
+
+// validateSubscribeOptions checks the Beehiiv options a signup may carry.
+// SUBSCRIBE_CUSTOM_FIELDS and SUBSCRIBE_TAGS, when set, list the custom
+// field names and tags a public form may use. Premium tier signups need the
+// admin token, since the endpoint is public.
+func validateSubscribeOptions(r *http.Request, req SubscribeRequest, config Config) string {
+	if allowed := config.SubscribeCustomFields; len(allowed) > 0 {
+		for _, f := range req.CustomFields {
+			if !slices.Contains(allowed, f.Name) {
+				return fmt.Sprintf("Custom field %q is not allowed", f.Name)
+			}
+		}
+	}
+	for _, f := range req.CustomFields {
+		if f.Name == "" {
+			return "Custom field name cannot be empty"
+		}
+	}
+
+	if allowed := config.SubscribeTags; len(allowed) > 0 {
+		for _, tag := range req.Tags {
+			if !slices.Contains(allowed, tag) {
+				return fmt.Sprintf("Tag %q is not allowed", tag)
+			}
+		}
+	}
+
+	switch req.Tier {
+	case "", "free":
+	case "premium":
+		if !isAdmin(r, config) {
+			return "Premium tier requires authorization"
+		}
+	default:
+		return "Tier must be free or premium"
+	}
+	return ""
+}
+
+func lookupBeehiivSubscriber(ctx context.Context, email string) (*BeehiivSubscriber, error) {
+	path := "/subscriptions/by_email/" + url.PathEscape(email) + "?expand[]=custom_fields"
+	body, err := beehiiv.Do(ctx, http.MethodGet, path, nil)
+	var upErr *UpstreamError
+	if errors.As(err, &upErr) && upErr.StatusCode == http.StatusNotFound {
+		return nil, errSubscriberNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Data BeehiivSubscriber `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("error decoding response: %v", err)
+	}
+	return &resp.Data, nil
+}
+
+func handleGetSubscriber(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	if ok, retryAfter := subscriberLookups.allow(clientIP(r)); !ok {
+		writeTooManyRequests(w, retryAfter)
+		return
+	}
+
+	var errs fieldErrors
+	email := errs.email("email", r.URL.Query().Get("email"))
+	if errs.write(w) {
+		return
+	}
+
+	auditLog.record("subscriber_lookup", map[string]string{
+		"email":   email,
+		"ip_hash": hashIP(clientIP(r)),
+	})
+
+	subscriber, err := lookupBeehiivSubscriber(r.Context(), email)
+	if errors.Is(err, errSubscriberNotFound) {
+		writeError(w, http.StatusNotFound, "subscriber_not_found", "Subscriber not found")
+		return
+	}
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, subscriber)
+}
+
+type SubscriberUpdateRequest struct {
+	Email        string               `json:"email"`
+	CustomFields []BeehiivCustomField `json:"custom_fields,omitempty"`
+	Tags         []string             `json:"tags,omitempty"`
+}
+
+func updateBeehiivSubscriber(ctx context.Context, subscriptionID string, req SubscriberUpdateRequest) error {
+	if len(req.CustomFields) > 0 {
+		fields := make([]map[string]interface{}, 0, len(req.CustomFields))
+		for _, f := range req.CustomFields {
+			fields = append(fields, map[string]interface{}{"name": f.Name, "value": f.Value})
+		}
+
+		path := "/subscriptions/" + url.PathEscape(subscriptionID)
+		if err := doBeehiivRequest(ctx, http.MethodPatch, path, map[string]interface{}{"custom_fields": fields}); err != nil {
+			return err
+		}
+	}
+
+	if len(req.Tags) > 0 {
+		path := "/subscriptions/" + url.PathEscape(subscriptionID) + "/tags"
+		if err := doBeehiivRequest(ctx, http.MethodPost, path, map[string]interface{}{"tags": req.Tags}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// doBeehiivRequest sends a request whose response body isn't needed.
+func doBeehiivRequest(ctx context.Context, method, path string, payload interface{}) error {
+	_, err := beehiiv.Do(ctx, method, path, payload)
+	return err
+}
+
+func handleUpdateSubscriber(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodPatch {
+		writeMethodNotAllowed(w, http.MethodPatch)
+		return
+	}
+
+	var req SubscriberUpdateRequest
+	if !decodeBody(w, r, &req, config) {
+		return
+	}
+
+	var errs fieldErrors
+	req.Email = errs.email("email", req.Email)
+	if errs.write(w) {
+		return
+	}
+
+	if len(req.CustomFields) == 0 && len(req.Tags) == 0 {
+		writeError(w, http.StatusBadRequest, "nothing_to_update", "Nothing to update")
+		return
+	}
+
+	subscriber, err := lookupBeehiivSubscriber(r.Context(), req.Email)
+	if errors.Is(err, errSubscriberNotFound) {
+		writeError(w, http.StatusNotFound, "subscriber_not_found", "Subscriber not found")
+		return
+	}
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+
+	if err := updateBeehiivSubscriber(r.Context(), subscriber.ID, req); err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+
+	auditLog.record("subscriber_update", map[string]string{
+		"email":   req.Email,
+		"ip_hash": hashIP(clientIP(r)),
+	})
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "Subscriber updated successfully", "id": subscriber.ID})
+}
+
+// handleUnsubscribe lets subscribers leave the list from the site itself.
+// POST takes {"email": ..., "token": ...}; DELETE takes ?email=&token=.
+// The token is the address's subscriberToken, so nobody can unsubscribe
+// someone else. A POST without a token emails the address a link carrying
+// it instead, when UNSUBSCRIBE_URL is set. The subscription is marked
+// unsubscribed in Beehiiv rather than deleted, so its history is kept.
+//
+// Neither answer says whether the address was on the list.
+func handleUnsubscribe(w http.ResponseWriter, r *http.Request, config Config) {
+	var email, token string
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			Email string `json:"email"`
+			Token string `json:"token"`
+		}
+		if !decodeBody(w, r, &req, config) {
+			return
+		}
+		email, token = req.Email, req.Token
+	case http.MethodDelete:
+		email, token = r.URL.Query().Get("email"), r.URL.Query().Get("token")
+	default:
+		writeMethodNotAllowed(w, http.MethodPost, http.MethodDelete)
+		return
+	}
+
+	if config.SubscribeTokenSecret == "" {
+		writeError(w, http.StatusServiceUnavailable, "not_configured", "Unsubscribing is not configured")
+		return
+	}
+
+	if ok, retryAfter := subscriberLookups.allow(clientIP(r)); !ok {
+		writeTooManyRequests(w, retryAfter)
+		return
+	}
+
+	email, ok := normalizeEmail(email)
+	if email == "" {
+		writeError(w, http.StatusBadRequest, "email_required", "Email cannot be empty")
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_email", "Email address is invalid")
+		return
+	}
+
+	if token == "" && r.Method == http.MethodPost && config.UnsubscribeURL != "" {
+		go sendUnsubscribeLink(email, config)
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "If the address is subscribed, an unsubscribe link is on its way"})
+		return
+	}
+	if err := checkSubscriberToken(email, token, config); errors.Is(err, errConfirmTokenExpired) {
+		writeJSON(w, http.StatusForbidden, ErrorResponse{Message: "Unsubscribe link has expired", Code: "token_expired"})
+		return
+	} else if err != nil {
+		writeJSON(w, http.StatusForbidden, ErrorResponse{Message: "Unsubscribe link is invalid", Code: "invalid_token"})
+		return
+	}
+
+	subscriber, err := lookupBeehiivSubscriber(r.Context(), email)
+	if err != nil && !errors.Is(err, errSubscriberNotFound) {
+		writeUpstreamError(w, err)
+		return
+	}
+
+	if err == nil && subscriber.Status != "inactive" {
+		path := "/subscriptions/" + url.PathEscape(subscriber.ID)
+		if err := doBeehiivRequest(r.Context(), http.MethodPatch, path, map[string]interface{}{"unsubscribe": true}); err != nil {
+			writeUpstreamError(w, err)
+			return
+		}
+		auditLog.record("unsubscribe", map[string]string{
+			"email":   email,
+			"ip_hash": hashIP(clientIP(r)),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "Unsubscribed successfully"})
+}
+
+// subscriberToken is the token /unsubscribe and /subscription/status take
+// with email, signed with SUBSCRIBE_TOKEN_SECRET like confirmation links.
+// It is "<issued>.<signature>", issued being the Unix time it was made, and
+// is accepted for SUBSCRIBER_TOKEN_TTL, so a link that leaks stops working.
+// Anyone whose link has expired can ask /unsubscribe for a new one.
+func subscriberToken(email string, config Config) string {
+	issued := time.Now().Unix()
+	return strconv.FormatInt(issued, 10) + "." + signSubscriberToken(email, issued, config.SubscribeTokenSecret)
+}
+
+func signSubscriberToken(email string, issued int64, secret string) string {
+	return signConfirmToken("subscriber:"+email+":"+strconv.FormatInt(issued, 10), secret)
+}
+
+// checkSubscriberToken returns errConfirmTokenInvalid for a token that
+// isn't email's and errConfirmTokenExpired for one that is too old.
+func checkSubscriberToken(email, token string, config Config) error {
+	issuedText, sig, ok := strings.Cut(token, ".")
+	issued, err := strconv.ParseInt(issuedText, 10, 64)
+	if !ok || err != nil || !hmac.Equal([]byte(sig), []byte(signSubscriberToken(email, issued, config.SubscribeTokenSecret))) {
+		return errConfirmTokenInvalid
+	}
+	age := time.Since(time.Unix(issued, 0))
+	if age < -subscriberTokenClockSkew {
+		return errConfirmTokenInvalid
+	}
+	if age > config.SubscriberTokenTTL {
+		return errConfirmTokenExpired
+	}
+	return nil
+}
+
+// sendUnsubscribeLink emails UNSUBSCRIBE_URL with email and its token
+// added to the query, if email is subscribed. It runs after the response
+// is written, so how long it takes doesn't tell the caller either.
+func sendUnsubscribeLink(email string, config Config) {
+	ctx, cancel := context.WithTimeout(context.Background(), unsubscribeLinkTimeout)
+	defer cancel()
+
+	subscriber, err := lookupBeehiivSubscriber(ctx, email)
+	if errors.Is(err, errSubscriberNotFound) || (err == nil && subscriber.Status == "inactive") {
+		return
+	}
+	if err != nil {
+		log.Printf("Warning: cannot look up subscriber for an unsubscribe link: %v", err)
+		return
+	}
+
+	link, err := url.Parse(config.UnsubscribeURL)
+	if err != nil {
+		log.Printf("Warning: cannot parse UNSUBSCRIBE_URL: %v", err)
+		return
+	}
+	query := link.Query()
+	query.Set("email", email)
+	query.Set("token", subscriberToken(email, config))
+	link.RawQuery = query.Encode()
+
+	err = sendEmail(ctx, config, emailMessage{
+		to:      email,
+		subject: "Unsubscribe from the newsletter",
+		body: fmt.Sprintf("To unsubscribe, open this link:\n\n%s\n\n"+
+			"If you didn't ask to unsubscribe, you can ignore this email.\n", link),
+	})
+	if err != nil {
+		log.Printf("Warning: cannot email an unsubscribe link: %v", err)
+	}
+}
+
+// handleSubscriptionStatus reports only the Beehiiv status of an address
+// ("active", "inactive", "pending", ...) or "not_subscribed", given the
+// address's subscriberToken; the full record stays behind the admin
+// /subscriber endpoint.
+func handleSubscriptionStatus(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	if config.SubscribeTokenSecret == "" {
+		writeError(w, http.StatusServiceUnavailable, "not_configured", "Subscription status is not configured")
+		return
+	}
+
+	if ok, retryAfter := subscriberLookups.allow(clientIP(r)); !ok {
+		writeTooManyRequests(w, retryAfter)
+		return
+	}
+
+	email, ok := normalizeEmail(r.URL.Query().Get("email"))
+	if email == "" {
+		writeError(w, http.StatusBadRequest, "email_required", "Email cannot be empty")
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_email", "Email address is invalid")
+		return
+	}
+	if err := checkSubscriberToken(email, r.URL.Query().Get("token"), config); errors.Is(err, errConfirmTokenExpired) {
+		writeJSON(w, http.StatusForbidden, ErrorResponse{Message: "Status link has expired", Code: "token_expired"})
+		return
+	} else if err != nil {
+		writeJSON(w, http.StatusForbidden, ErrorResponse{Message: "Status link is invalid", Code: "invalid_token"})
+		return
+	}
+
+	status := "not_subscribed"
+	subscriber, err := lookupBeehiivSubscriber(r.Context(), email)
+	switch {
+	case err == nil:
+		status = subscriber.Status
+	case !errors.Is(err, errSubscriberNotFound):
+		writeUpstreamError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"email": email, "status": status})
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useLookupLimit allows limit subscriber lookups a minute until the test
+// ends.
+func useLookupLimit(t *testing.T, limit int) {
+	t.Helper()
+	orig := subscriberLookups
+	t.Cleanup(func() { subscriberLookups = orig })
+	subscriberLookups = newRateLimiter("subscriber_lookup_rate_limit", limit, time.Minute)
+}
+
+func TestGetSubscriber(t *testing.T) {
+	useFakeUpstreams(t)
+	useLookupLimit(t, 3)
+
+	body := `{"email":"found@example.com","custom_fields":[{"name":"plan","value":"pro"}],"tags":["beta"]}`
+	if rec := serve(subscribeHandler, http.MethodPost, "/subscribe", body); rec.Code != http.StatusOK {
+		t.Fatalf("subscribe: %d %s", rec.Code, rec.Body)
+	}
+	before := len(auditEvents(t, "subscriber_lookup"))
+
+	rec := serve(handleGetSubscriber, http.MethodGet, "/subscriber?email=Found@Example.com", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("found: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var subscriber BeehiivSubscriber
+	if err := json.Unmarshal(rec.Body.Bytes(), &subscriber); err != nil {
+		t.Fatal(err)
+	}
+	if subscriber.Email != "found@example.com" || subscriber.Status != "active" || subscriber.ID == "" {
+		t.Errorf("subscriber = %+v", subscriber)
+	}
+	if len(subscriber.CustomFields) != 1 || subscriber.CustomFields[0].Value != "pro" {
+		t.Errorf("custom fields = %v", subscriber.CustomFields)
+	}
+
+	rec = serve(handleGetSubscriber, http.MethodGet, "/subscriber?email=missing@example.com", "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("not found: status = %d, want 404: %s", rec.Code, rec.Body)
+	}
+
+	rec = serve(handleGetSubscriber, http.MethodGet, "/subscriber?email=not-an-email", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid email: status = %d, want 400", rec.Code)
+	}
+
+	// Lookups are rate limited, and every one that passes validation is
+	// audited.
+	rec = serve(handleGetSubscriber, http.MethodGet, "/subscriber?email=found@example.com", "")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("over the limit: status = %d, want 429", rec.Code)
+	}
+	if got := len(auditEvents(t, "subscriber_lookup")) - before; got != 2 {
+		t.Errorf("audited %d lookups, want 2", got)
+	}
+}
+
+func TestUpdateSubscriber(t *testing.T) {
+	_, fake := useFakeUpstreams(t)
+	if rec := serve(subscribeHandler, http.MethodPost, "/subscribe", `{"email":"update@example.com","custom_fields":[{"name":"plan","value":"free"}]}`); rec.Code != http.StatusOK {
+		t.Fatalf("subscribe: %d %s", rec.Code, rec.Body)
+	}
+
+	rec := serve(bind(handleUpdateSubscriber, testConfig), http.MethodPatch, "/subscriber/update", `{"email":"update@example.com","custom_fields":[{"name":"plan","value":"pro"},{"name":"team","value":"core"}],"tags":["beta"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	subscriber, err := lookupBeehiivSubscriber(context.Background(), "update@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := make(map[string]interface{})
+	for _, f := range subscriber.CustomFields {
+		fields[f.Name] = f.Value
+	}
+	if fields["plan"] != "pro" || fields["team"] != "core" || len(fields) != 2 {
+		t.Errorf("custom fields = %v, want plan updated and team added", fields)
+	}
+	if len(subscriber.Tags) != 1 || subscriber.Tags[0] != "beta" {
+		t.Errorf("tags = %v, want [beta]", subscriber.Tags)
+	}
+
+	// An unknown email is reported without calling the update endpoints.
+	before := len(fake.Calls())
+	rec = serve(bind(handleUpdateSubscriber, testConfig), http.MethodPatch, "/subscriber/update", `{"email":"nobody@example.com","tags":["beta"]}`)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("not found: status = %d, want 404: %s", rec.Code, rec.Body)
+	}
+	if calls := fake.Calls()[before:]; len(calls) != 1 || calls[0].Method != http.MethodGet {
+		t.Errorf("calls = %v, want only the lookup", calls)
+	}
+
+	rec = serve(bind(handleUpdateSubscriber, testConfig), http.MethodPatch, "/subscriber/update", `{"email":"update@example.com"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("nothing to update: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestUnsubscribeRequiresToken(t *testing.T) {
+	useFakeUpstreams(t)
+	useLookupLimit(t, 100)
+	config := configWith(t, "SUBSCRIBE_TOKEN_SECRET", "test-secret")
+
+	if rec := serve(bind(handleSubscribe, config), http.MethodPost, "/subscribe", `{"email":"leaving@example.com"}`); rec.Code != http.StatusOK {
+		t.Fatalf("subscribe: %d %s", rec.Code, rec.Body)
+	}
+	member, stranger := "leaving@example.com", "stranger@example.com"
+
+	status := func(email, token string) *httptest.ResponseRecorder {
+		query := url.Values{"email": {email}, "token": {token}}
+		return serve(bind(handleSubscriptionStatus, config), http.MethodGet, "/subscription/status?"+query.Encode(), "")
+	}
+	unsubscribe := func(email, token string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"email": email, "token": token})
+		return serve(bind(handleUnsubscribe, config), http.MethodPost, "/unsubscribe", string(body))
+	}
+
+	for name, token := range map[string]string{
+		"no token":                "",
+		"wrong token":             "not-the-token",
+		"another address's token": subscriberToken(stranger, config),
+	} {
+		if rec := status(member, token); rec.Code != http.StatusForbidden {
+			t.Errorf("status, %s: %d, want 403: %s", name, rec.Code, rec.Body)
+		}
+		if rec := unsubscribe(member, token); rec.Code != http.StatusForbidden {
+			t.Errorf("unsubscribe, %s: %d, want 403: %s", name, rec.Code, rec.Body)
+		}
+	}
+	if rec := status(member, subscriberToken(member, config)); !strings.Contains(rec.Body.String(), `"status":"active"`) {
+		t.Fatalf("the member is no longer subscribed: %d %s", rec.Code, rec.Body)
+	}
+
+	// With a valid token, members and strangers get the same answer.
+	memberRec := unsubscribe(member, subscriberToken(member, config))
+	strangerRec := unsubscribe(stranger, subscriberToken(stranger, config))
+	for name, rec := range map[string]*httptest.ResponseRecorder{"member": memberRec, "stranger": strangerRec} {
+		if rec.Code != http.StatusOK {
+			t.Errorf("unsubscribe %s: %d, want 200: %s", name, rec.Code, rec.Body)
+		}
+		if resp := decodeError(t, rec); resp.Message != "" {
+			t.Errorf("unsubscribe %s: error %q", name, resp.Message)
+		}
+	}
+	if rec := status(member, subscriberToken(member, config)); !strings.Contains(rec.Body.String(), `"status":"inactive"`) {
+		t.Errorf("after unsubscribing: %d %s, want inactive", rec.Code, rec.Body)
+	}
+
+	token := subscriberToken(member, config)
+	config = configWith(t, "SUBSCRIBE_TOKEN_SECRET", "")
+	if rec := unsubscribe(member, token); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("without SUBSCRIBE_TOKEN_SECRET: %d, want 503", rec.Code)
+	}
+}
+
+func TestUnsubscribeEmailsLink(t *testing.T) {
+	_, fakeBH := useFakeUpstreams(t)
+	useLookupLimit(t, 100)
+	config := configWith(t,
+		"SUBSCRIBE_TOKEN_SECRET", "test-secret",
+		"UNSUBSCRIBE_URL", "https://example.com/unsubscribe?lang=en",
+		"EMAIL_PROVIDER", "resend")
+
+	emails := make(chan map[string]interface{}, 2)
+	resend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var email map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&email)
+		emails <- email
+		w.Write([]byte(`{"id":"email_1"}`))
+	}))
+	defer resend.Close()
+	defer func(orig string) { resendAPIBaseURL = orig }(resendAPIBaseURL)
+	resendAPIBaseURL = resend.URL
+
+	if _, err := fakeBH.Do(context.Background(), http.MethodPost, "/subscriptions", map[string]string{"email": "linked@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var bodies []string
+	for _, email := range []string{"unknown@example.com", "linked@example.com"} {
+		rec := serve(bind(handleUnsubscribe, config), http.MethodPost, "/unsubscribe", `{"email":"`+email+`"}`)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("%s: status = %d, want 202: %s", email, rec.Code, rec.Body)
+		}
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		bodies = append(bodies, fmt.Sprint(resp["status"]))
+	}
+	if bodies[0] != bodies[1] {
+		t.Errorf("answers differ for a subscriber and a stranger: %q", bodies)
+	}
+
+	var email map[string]interface{}
+	select {
+	case email = <-emails:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no unsubscribe link was emailed")
+	}
+	if to, _ := email["to"].([]interface{}); len(to) != 1 || to[0] != "linked@example.com" {
+		t.Fatalf("emailed %v, want only the subscriber", email["to"])
+	}
+	text, _ := email["text"].(string)
+	const want = "https://example.com/unsubscribe?email=linked%40example.com&lang=en&token="
+	start := strings.Index(text, want)
+	if start < 0 {
+		t.Fatalf("email %q does not carry the link %s...", text, want)
+	}
+	token, _, _ := strings.Cut(text[start+len(want):], "\n")
+	token, _ = url.QueryUnescape(token)
+	if err := checkSubscriberToken("linked@example.com", token, config); err != nil {
+		t.Errorf("emailed token %q: %v", token, err)
+	}
+}
+
+func TestSubscriberTokenExpires(t *testing.T) {
+	useLookupLimit(t, 100)
+	config := configWith(t, "SUBSCRIBE_TOKEN_SECRET", "test-secret", "SUBSCRIBER_TOKEN_TTL", "1h")
+	email := "expiring@example.com"
+	issuedAt := func(ago time.Duration) string {
+		issued := time.Now().Add(-ago).Unix()
+		return strconv.FormatInt(issued, 10) + "." + signSubscriberToken(email, issued, config.SubscribeTokenSecret)
+	}
+
+	if err := checkSubscriberToken(email, subscriberToken(email, config), config); err != nil {
+		t.Errorf("fresh token: %v", err)
+	}
+	if err := checkSubscriberToken(email, issuedAt(2*time.Hour), config); !errors.Is(err, errConfirmTokenExpired) {
+		t.Errorf("old token: %v, want it expired", err)
+	}
+	if err := checkSubscriberToken(email, issuedAt(-time.Hour), config); !errors.Is(err, errConfirmTokenInvalid) {
+		t.Errorf("token from the future: %v, want it invalid", err)
+	}
+
+	// The issue time is signed, so it can't be moved forward.
+	_, sig, _ := strings.Cut(issuedAt(2*time.Hour), ".")
+	forged := strconv.FormatInt(time.Now().Unix(), 10) + "." + sig
+	if err := checkSubscriberToken(email, forged, config); !errors.Is(err, errConfirmTokenInvalid) {
+		t.Errorf("re-dated token: %v, want it invalid", err)
+	}
+
+	query := url.Values{"email": {email}, "token": {issuedAt(2 * time.Hour)}}
+	rec := serve(bind(handleSubscriptionStatus, config), http.MethodGet, "/subscription/status?"+query.Encode(), "")
+	if rec.Code != http.StatusForbidden || decodeError(t, rec).Code != "token_expired" {
+		t.Errorf("status with an expired token: %d %s, want 403 token_expired", rec.Code, rec.Body)
+	}
+	rec = serve(bind(handleUnsubscribe, config), http.MethodDelete, "/unsubscribe?"+query.Encode(), "")
+	if rec.Code != http.StatusForbidden || decodeError(t, rec).Code != "token_expired" {
+		t.Errorf("unsubscribe with an expired token: %d %s, want 403 token_expired", rec.Code, rec.Body)
+	}
+}
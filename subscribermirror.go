@@ -0,0 +1,121 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// SubscriberRecord is one successful subscription as kept in the local
+// mirror.
+type SubscriberRecord struct {
+	Time          time.Time `json:"time"`
+	Email         string    `json:"email"`
+	BeehiivID     string    `json:"beehiiv_id,omitempty"`
+	UTMSource     string    `json:"utm_source,omitempty"`
+	UTMMedium     string    `json:"utm_medium,omitempty"`
+	ReferringSite string    `json:"referring_site,omitempty"`
+	Source        string    `json:"source,omitempty"`
+}
+
+// subscriberMirror keeps every subscription in the database, so the list
+// has a copy independent of Beehiiv.
+type subscriberMirror struct {
+	db *sql.DB
+}
+
+var subscribers = &subscriberMirror{db: database}
+
+func (m *subscriberMirror) record(req SubscribeRequest, beehiivID, source string) {
+	_, err := m.db.Exec(`INSERT INTO subscribers
+		(subscribed_at, email, beehiiv_id, utm_source, utm_medium, referring_site, source)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		time.Now().UnixMilli(), req.Email, beehiivID, req.UTMSource, req.UTMMedium, req.ReferringSite, source)
+	if err != nil {
+		log.Printf("Error writing subscriber mirror: %v", err)
+	}
+}
+
+// each calls fn for every record subscribed within [from, to), oldest
+// first. A zero from or to leaves that side of the range open.
+func (m *subscriberMirror) each(from, to time.Time, fn func(SubscriberRecord) error) error {
+	query := `SELECT subscribed_at, email, beehiiv_id, utm_source, utm_medium, referring_site, source
+		FROM subscribers WHERE 1 = 1`
+	var args []interface{}
+	if !from.IsZero() {
+		query += ` AND subscribed_at >= ?`
+		args = append(args, from.UnixMilli())
+	}
+	if !to.IsZero() {
+		query += ` AND subscribed_at < ?`
+		args = append(args, to.UnixMilli())
+	}
+	rows, err := m.db.Query(query+` ORDER BY subscribed_at, id`, args...)
+	if err != nil {
+		return fmt.Errorf("error reading subscriber mirror: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rec SubscriberRecord
+		var at int64
+		if err := rows.Scan(&at, &rec.Email, &rec.BeehiivID, &rec.UTMSource, &rec.UTMMedium, &rec.ReferringSite, &rec.Source); err != nil {
+			return fmt.Errorf("error reading subscriber mirror: %v", err)
+		}
+		rec.Time = fromUnixMilli(at)
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func handleSubscriberExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	from, err := parseExportTime(r.URL.Query().Get("from"), false)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_date", "Invalid from date")
+		return
+	}
+	to, err := parseExportTime(r.URL.Query().Get("to"), true)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_date", "Invalid to date")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="subscribers.csv"`)
+	flusher, _ := w.(http.Flusher)
+	out := csv.NewWriter(w)
+	out.Write([]string{"subscribed_at", "email", "beehiiv_id", "utm_source", "utm_medium", "referring_site", "source"})
+
+	n := 0
+	err = subscribers.each(from, to, func(rec SubscriberRecord) error {
+		out.Write([]string{
+			rec.Time.Format(time.RFC3339),
+			rec.Email,
+			rec.BeehiivID,
+			rec.UTMSource,
+			rec.UTMMedium,
+			rec.ReferringSite,
+			rec.Source,
+		})
+		if n++; n%100 == 0 {
+			out.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return out.Error()
+	})
+	out.Flush()
+	if err != nil {
+		log.Printf("Error exporting subscribers: %v", err)
+	}
+}
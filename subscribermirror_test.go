@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSubscriberExportStreamsCSV(t *testing.T) {
+	useDatabase(t)
+	subscribers.record(SubscribeRequest{Email: "first@example.com", UTMSource: "newsletter", ReferringSite: "example.org"}, "sub_1", "subscribe")
+	subscribers.record(SubscribeRequest{Email: "second@example.com"}, "sub_2", "csv_import")
+
+	rec := serve(handleSubscriberExport, http.MethodGet, "/admin/subscribers/export", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[0][1] != "email" {
+		t.Fatalf("rows = %v, want a header and two subscribers", rows)
+	}
+	if got := rows[1][1:]; strings.Join(got, ",") != "first@example.com,sub_1,newsletter,,example.org,subscribe" {
+		t.Errorf("first row = %v", got)
+	}
+	if rows[2][1] != "second@example.com" || rows[2][6] != "csv_import" {
+		t.Errorf("second row = %v", rows[2])
+	}
+}
+
+func TestSubscriberExportFiltersByDate(t *testing.T) {
+	useDatabase(t)
+	subscribers.record(SubscribeRequest{Email: "today@example.com"}, "sub_1", "subscribe")
+	tomorrow := time.Now().UTC().Add(24 * time.Hour).Format("2006-01-02")
+
+	rec := serve(handleSubscriberExport, http.MethodGet, "/admin/subscribers/export?from="+tomorrow, "")
+	if rows, _ := csv.NewReader(rec.Body).ReadAll(); len(rows) != 1 {
+		t.Errorf("rows = %v, want only the header", rows)
+	}
+	rec = serve(handleSubscriberExport, http.MethodGet, "/admin/subscribers/export?to="+tomorrow, "")
+	if rows, _ := csv.NewReader(rec.Body).ReadAll(); len(rows) != 2 {
+		t.Errorf("rows = %v, want the header and today's subscriber", rows)
+	}
+	if rec := serve(handleSubscriberExport, http.MethodGet, "/admin/subscribers/export?from=yesterday", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad date: status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type TelegramUpdate struct {
+	UpdateID      int64                    `json:"update_id"`
+	Message       *TelegramIncomingMessage `json:"message,omitempty"`
+	CallbackQuery *TelegramCallbackQuery   `json:"callback_query,omitempty"`
+}
+
+type TelegramUser struct {
+	ID        int64  `json:"id"`
+	IsBot     bool   `json:"is_bot"`
+	FirstName string `json:"first_name,omitempty"`
+	Username  string `json:"username,omitempty"`
+}
+
+// name is how the user is shown in the chat: @username, or their first
+// name when they have none.
+func (u *TelegramUser) name() string {
+	if u.Username != "" {
+		return "@" + u.Username
+	}
+	if u.FirstName != "" {
+		return u.FirstName
+	}
+	return strconv.FormatInt(u.ID, 10)
+}
+
+type TelegramIncomingMessage struct {
+	MessageID int64         `json:"message_id"`
+	From      *TelegramUser `json:"from,omitempty"`
+	Chat      struct {
+		ID   int64  `json:"id"`
+		Type string `json:"type"`
+	} `json:"chat"`
+	Text string `json:"text,omitempty"`
+}
+
+// TelegramCallbackQuery is a press of an inline keyboard button. Message
+// is the message the button is attached to.
+type TelegramCallbackQuery struct {
+	ID      string                   `json:"id"`
+	From    *TelegramUser            `json:"from"`
+	Message *TelegramIncomingMessage `json:"message,omitempty"`
+	Data    string                   `json:"data,omitempty"`
+}
+
+// ackCallbackData is the callback_data of an "Acknowledge" button, e.g.
+// {"text":"Acknowledge","callback_data":"ack"} in a /send's buttons.
+// ackedCallbackData replaces it once the message is acknowledged.
+const (
+	ackCallbackData   = "ack"
+	ackedCallbackData = "acked"
+)
+
+// botCommand answers a bot command with the HTML reply to post back into
+// the chat. args is the text after the command.
+type botCommand func(ctx context.Context, args string) (string, error)
+
+var botCommands = map[string]botCommand{
+	"/ping":        commandPing,
+	"/stats":       commandStats,
+	"/subscribers": commandSubscribers,
+}
+
+// handleTelegramWebhook receives Bot API updates for the webhook registered
+// with TELEGRAM_WEBHOOK_SECRET as its secret_token, runs bot commands sent
+// from the configured chat or TELEGRAM_ADMIN_CHAT_IDS, handles inline
+// button presses and optionally auto-replies to other messages. Everything
+// else is acknowledged and ignored, since any other answer makes Telegram
+// redeliver the update. Messages from bots are always ignored so two bots
+// can't keep answering each other.
+func handleTelegramWebhook(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	secret := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(config.TelegramWebhookSecret)) != 1 {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+
+	var update TelegramUpdate
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, int64(config.MaxBodyBytes))).Decode(&update); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if update.CallbackQuery != nil {
+		handleCallbackQuery(r.Context(), config, update.CallbackQuery)
+		return
+	}
+
+	msg := update.Message
+	if msg == nil || msg.From == nil || msg.From.IsBot {
+		return
+	}
+	if !strings.HasPrefix(msg.Text, "/") {
+		autoReply(r.Context(), config, msg)
+		return
+	}
+	chatID := strconv.FormatInt(msg.Chat.ID, 10)
+	if !isAdminChat(chatID, config) {
+		return
+	}
+
+	name, args, _ := strings.Cut(msg.Text, " ")
+	// In groups commands may be addressed as /ping@SomeBot.
+	name, _, _ = strings.Cut(name, "@")
+	command, ok := botCommands[strings.ToLower(name)]
+	if !ok {
+		return
+	}
+
+	reply, err := command(r.Context(), strings.TrimSpace(args))
+	if err != nil {
+		log.Printf("Warning: bot command %s failed: %v", name, err)
+		reply = "Command failed: " + html.EscapeString(err.Error())
+	}
+
+	config.ChatID = chatID
+	if _, err := sendTelegramMessage(r.Context(), config, reply, SendOptions{ParseMode: "HTML"}); err != nil {
+		log.Printf("Warning: cannot reply to bot command %s: %v", name, err)
+	}
+}
+
+// autoReply acknowledges a message sent to the bot in a private chat with
+// the TELEGRAM_AUTO_REPLY_TEMPLATE template ("auto_reply" by default) when
+// TELEGRAM_AUTO_REPLY is on. Group chats get no reply, since every member's
+// message would draw one.
+func autoReply(ctx context.Context, config Config, msg *TelegramIncomingMessage) {
+	if !config.TelegramAutoReply || msg.Chat.Type != "private" {
+		return
+	}
+
+	name := config.TelegramAutoReplyTemplate
+	if name == "" {
+		name = "auto_reply"
+	}
+	reply, err := renderTemplate(name, map[string]interface{}{
+		"first_name": msg.From.FirstName,
+		"username":   msg.From.Username,
+		"text":       msg.Text,
+	})
+	if err != nil {
+		log.Printf("Warning: cannot render auto-reply template %s: %v", name, err)
+		return
+	}
+
+	config.ChatID = strconv.FormatInt(msg.Chat.ID, 10)
+	if _, err := sendTelegramMessage(ctx, config, reply, SendOptions{ParseMode: "HTML"}); err != nil {
+		log.Printf("Warning: cannot send auto-reply: %v", err)
+	}
+}
+
+// handleCallbackQuery answers a button press, which Telegram expects for
+// every one, so the client stops showing a spinner. Pressing an "ack"
+// button on a message in the configured chat or TELEGRAM_ADMIN_CHAT_IDS
+// records who acknowledged it in the audit log, and with
+// TELEGRAM_ACK_EDIT=true replaces the message's buttons with one naming
+// them.
+func handleCallbackQuery(ctx context.Context, config Config, query *TelegramCallbackQuery) {
+	answer := map[string]string{"callback_query_id": query.ID}
+	msg := query.Message
+	acked := false
+	if msg != nil && query.From != nil && !query.From.IsBot && isAdminChat(strconv.FormatInt(msg.Chat.ID, 10), config) {
+		switch query.Data {
+		case ackCallbackData:
+			acked = true
+			answer["text"] = "Acknowledged"
+			auditLog.record("acknowledge", map[string]string{
+				"chat_id":    strconv.FormatInt(msg.Chat.ID, 10),
+				"message_id": strconv.FormatInt(msg.MessageID, 10),
+				"user_id":    strconv.FormatInt(query.From.ID, 10),
+				"user":       query.From.name(),
+			})
+		case ackedCallbackData:
+			answer["text"] = "Already acknowledged"
+		}
+	}
+
+	if _, err := callTelegram(ctx, config, "answerCallbackQuery", answer); err != nil {
+		log.Printf("Warning: cannot answer callback query: %v", err)
+	}
+	if !acked || !config.TelegramAckEdit {
+		return
+	}
+
+	config.ChatID = strconv.FormatInt(msg.Chat.ID, 10)
+	if bot := sentMessages.bot(config.ChatID, msg.MessageID); bot != "" {
+		config = config.withBot(bot)
+	}
+	_, err := callTelegram(ctx, config, "editMessageReplyMarkup", map[string]interface{}{
+		"chat_id":    config.ChatID,
+		"message_id": msg.MessageID,
+		"reply_markup": replyMarkup([][]InlineButton{{
+			{Text: "✅ Acknowledged by " + query.From.name(), CallbackData: ackedCallbackData},
+		}}),
+	})
+	if err != nil {
+		log.Printf("Warning: cannot mark message %d acknowledged: %v", msg.MessageID, err)
+	}
+}
+
+func isAdminChat(chatID string, config Config) bool {
+	if chatID == config.ChatID {
+		return true
+	}
+	for _, allowed := range config.TelegramAdminChatIDs {
+		if chatID == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func commandPing(ctx context.Context, args string) (string, error) {
+	return fmt.Sprintf("pong (up %s)", time.Since(startedAt).Round(time.Second)), nil
+}
+
+// commandStats counts the last 24 hours of audit events by type.
+func commandStats(ctx context.Context, args string) (string, error) {
+	counts := make(map[string]int)
+	err := auditLog.each(time.Now().Add(-24*time.Hour), time.Time{}, func(e AuditEvent) error {
+		counts[e.Type]++
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(counts) == 0 {
+		return "No activity in the last 24 hours", nil
+	}
+
+	types := make([]string, 0, len(counts))
+	for t := range counts {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	var b strings.Builder
+	b.WriteString("<b>Last 24 hours</b>")
+	for _, t := range types {
+		fmt.Fprintf(&b, "\n%s: %d", html.EscapeString(t), counts[t])
+	}
+	return b.String(), nil
+}
+
+func commandSubscribers(ctx context.Context, args string) (string, error) {
+	resp, err := beehiiv.Do(ctx, http.MethodGet, "?expand[]=stats", nil)
+	if err != nil {
+		return "", err
+	}
+
+	var body struct {
+		Data struct {
+			Stats struct {
+				ActiveSubscriptions int `json:"active_subscriptions"`
+			} `json:"stats"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(resp, &body); err != nil {
+		return "", fmt.Errorf("error decoding response: %v", err)
+	}
+	return fmt.Sprintf("Active subscribers: %d", body.Data.Stats.ActiveSubscriptions), nil
+}
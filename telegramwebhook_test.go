@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postUpdate delivers update to the Telegram webhook with a valid secret,
+// configured from the environment the test has set.
+func postUpdate(t *testing.T, update string) {
+	t.Helper()
+	config := configWith(t, "TELEGRAM_WEBHOOK_SECRET", "hook-secret")
+	req := httptest.NewRequest(http.MethodPost, "/webhook/telegram", strings.NewReader(update))
+	req.Header.Set("X-Telegram-Bot-Api-Secret-Token", "hook-secret")
+	rec := httptest.NewRecorder()
+	handleTelegramWebhook(rec, req, config)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+}
+
+// telegramCalls returns the bodies of the calls fake received for method.
+func telegramCalls(t *testing.T, fake *fakeTelegram, method string) []map[string]json.RawMessage {
+	t.Helper()
+	var bodies []map[string]json.RawMessage
+	for _, call := range fake.Calls() {
+		if call.Method != method {
+			continue
+		}
+		var body map[string]json.RawMessage
+		if err := json.Unmarshal(call.Body, &body); err != nil {
+			t.Fatal(err)
+		}
+		bodies = append(bodies, body)
+	}
+	return bodies
+}
+
+func callbackUpdate(id, chatID, messageID, data string) string {
+	return `{"update_id":1,"callback_query":{"id":"` + id + `","from":{"id":42,"is_bot":false,"first_name":"Ann","username":"ann"},` +
+		`"message":{"message_id":` + messageID + `,"chat":{"id":` + chatID + `,"type":"group"},"text":"Disk full"},"data":"` + data + `"}}`
+}
+
+func TestTelegramWebhookCallbackQuery(t *testing.T) {
+	tests := []struct {
+		name      string
+		chatID    string
+		messageID string
+		data      string
+		edit      string
+		answer    string
+		recorded  bool
+		edited    bool
+	}{
+		{"ack", testConfig.ChatID, "9101", "ack", "", "Acknowledged", true, false},
+		{"ack and edit", testConfig.ChatID, "9102", "ack", "true", "Acknowledged", true, true},
+		{"already acknowledged", testConfig.ChatID, "9103", "acked", "true", "Already acknowledged", false, false},
+		{"other chat", "777", "9104", "ack", "true", "", false, false},
+		{"other button", testConfig.ChatID, "9105", "snooze", "true", "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, _ := useFakeUpstreams(t)
+			t.Setenv("TELEGRAM_ACK_EDIT", tt.edit)
+			start := time.Now()
+
+			postUpdate(t, callbackUpdate("cb-"+tt.messageID, tt.chatID, tt.messageID, tt.data))
+
+			answers := telegramCalls(t, fake, "answerCallbackQuery")
+			if len(answers) != 1 {
+				t.Fatalf("answered %d times, want once", len(answers))
+			}
+			if id := string(answers[0]["callback_query_id"]); id != `"cb-`+tt.messageID+`"` {
+				t.Errorf("answered callback %s", id)
+			}
+			var text string
+			json.Unmarshal(answers[0]["text"], &text)
+			if text != tt.answer {
+				t.Errorf("answer text = %q, want %q", text, tt.answer)
+			}
+
+			var acks []AuditEvent
+			for _, e := range auditEvents(t, "acknowledge") {
+				if e.Fields["message_id"] == tt.messageID && !e.Time.Before(start) {
+					acks = append(acks, e)
+				}
+			}
+			if !tt.recorded {
+				if len(acks) != 0 {
+					t.Errorf("recorded %v", acks)
+				}
+			} else if len(acks) != 1 || acks[0].Fields["user_id"] != "42" || acks[0].Fields["user"] != "@ann" || acks[0].Time.IsZero() {
+				t.Errorf("acknowledgements = %+v, want one by @ann", acks)
+			}
+
+			edits := telegramCalls(t, fake, "editMessageReplyMarkup")
+			if !tt.edited {
+				if len(edits) != 0 {
+					t.Errorf("message was edited: %v", edits)
+				}
+				return
+			}
+			if len(edits) != 1 || string(edits[0]["message_id"]) != tt.messageID || !strings.Contains(string(edits[0]["reply_markup"]), "Acknowledged by @ann") {
+				t.Errorf("edits = %v, want the buttons replaced with who acknowledged", edits)
+			}
+		})
+	}
+}
+
+func messageUpdate(chatType string, isBot bool, text string) string {
+	from, _ := json.Marshal(TelegramUser{ID: 4242, IsBot: isBot, FirstName: "Ann"})
+	body, _ := json.Marshal(text)
+	return `{"update_id":2,"message":{"message_id":77,"from":` + string(from) +
+		`,"chat":{"id":4242,"type":"` + chatType + `"},"text":` + string(body) + `}}`
+}
+
+func TestTelegramWebhookAutoReply(t *testing.T) {
+	tests := []struct {
+		name     string
+		enabled  string
+		chatType string
+		isBot    bool
+		text     string
+		reply    string
+	}{
+		{"user message", "true", "private", false, "Hello?", "Thanks, Ann, we got your message."},
+		{"disabled", "", "private", false, "Hello?", ""},
+		{"from a bot", "true", "private", true, "Thanks, Bot, we got your message.", ""},
+		{"group chat", "true", "group", false, "Hello?", ""},
+		{"unknown command", "true", "private", false, "/start", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, _ := useFakeUpstreams(t)
+			t.Setenv("TELEGRAM_AUTO_REPLY", tt.enabled)
+
+			postUpdate(t, messageUpdate(tt.chatType, tt.isBot, tt.text))
+
+			sends := telegramCalls(t, fake, "sendMessage")
+			if tt.reply == "" {
+				if len(sends) != 0 {
+					t.Errorf("sent %d replies, want none", len(sends))
+				}
+				return
+			}
+			if len(sends) != 1 {
+				t.Fatalf("sent %d replies, want one", len(sends))
+			}
+			var chatID, text string
+			json.Unmarshal(sends[0]["chat_id"], &chatID)
+			json.Unmarshal(sends[0]["text"], &text)
+			if chatID != "4242" || text != tt.reply {
+				t.Errorf("replied %q to chat %s, want %q to the sender", text, chatID, tt.reply)
+			}
+		})
+	}
+}
+
+func TestTelegramWebhookAutoReplyTemplate(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+	t.Setenv("TELEGRAM_AUTO_REPLY", "true")
+	t.Setenv("TELEGRAM_AUTO_REPLY_TEMPLATE", "custom_reply")
+	dir := useTemplatesDir(t)
+	writeTemplate(t, dir, "custom_reply", `Got "{{.text}}", replying within a day.`)
+	if err := reloadTemplates(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	postUpdate(t, messageUpdate("private", false, "<b>hi</b>"))
+	sends := telegramCalls(t, fake, "sendMessage")
+	if len(sends) != 1 {
+		t.Fatalf("sent %d replies, want one", len(sends))
+	}
+	var text string
+	json.Unmarshal(sends[0]["text"], &text)
+	if want := `Got "&lt;b&gt;hi&lt;/b&gt;", replying within a day.`; text != want {
+		t.Errorf("reply = %q, want %q", text, want)
+	}
+}
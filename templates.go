@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var errUnknownTemplate = errors.New("unknown template")
+
+// builtinTemplates are the message templates available without a
+// TEMPLATES_DIR. They render Telegram's HTML subset; html/template escapes
+// every value, so data can't inject markup.
+var builtinTemplates = map[string]string{
+	"new_signup": `<b>New signup</b>
+{{.email}}{{with .utm_source}}
+Source: {{.}}{{end}}{{with .utm_medium}}
+Medium: {{.}}{{end}}{{with .referring_site}}
+Referrer: {{.}}{{end}}`,
+
+	"contact": `<b>Contact form: {{.subject}}</b>
+From: {{.name}} &lt;{{.email}}&gt;
+
+{{.message}}`,
+
+	"new_comment": `<b>New {{if .reply}}reply{{else}}comment{{end}} on {{.slug}}</b>{{if eq .status "pending"}} (awaiting approval){{end}}
+From: {{.author}}
+
+{{.body}}
+
+<code>{{.id}}</code>`,
+
+	"digest": `<b>📊 Digest</b>
+{{.period}}
+
+Subscribers: {{.subscribes}} new{{with .pending}}, {{.}} awaiting confirmation{{end}}{{with .unsubscribes}}, {{.}} unsubscribed{{end}}
+Contact messages: {{.contacts}}
+Comments: {{.comments}}
+Messages sent: {{.messages}}{{if .views}}
+
+Page views: {{.views}} from {{.visitors}} visitors{{range .top_paths}}
+• {{.Value}}: {{.Count}}{{end}}{{end}}`,
+
+	"payment": `<b>{{if .test}}[test] {{end}}💰 {{.title}}</b>
+{{.amount}}{{with .interval}} / {{.}}{{end}}{{with .customer}}
+From: {{.}}{{end}}{{with .product}}
+For: {{.}}{{end}}`,
+
+	"error_alert": `<b>⚠️ {{or .service "Error"}}</b>
+{{.message}}{{with .details}}
+
+<pre>{{.}}</pre>{{end}}`,
+
+	"auto_reply": `Thanks{{with .first_name}}, {{.}}{{end}}, we got your message.`,
+}
+
+var (
+	templatesMu sync.RWMutex
+	templates   map[string]*template.Template
+)
+
+// loadTemplates parses the built-in templates and then every *.tmpl file
+// in dir, named after the file, so a file can override a built-in. It
+// fails if any file doesn't parse rather than serving a partial set.
+func loadTemplates(dir string) (map[string]*template.Template, error) {
+	loaded := make(map[string]*template.Template)
+	for name, text := range builtinTemplates {
+		loaded[name] = template.Must(template.New(name).Parse(text))
+	}
+	if dir == "" {
+		return loaded, nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", dir, err)
+	}
+	for _, path := range paths {
+		t, err := template.New(filepath.Base(path)).ParseFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		loaded[strings.TrimSuffix(filepath.Base(path), ".tmpl")] = t
+	}
+	return loaded, nil
+}
+
+// reloadTemplates replaces the templates with a fresh load of dir,
+// TEMPLATES_DIR. On error the current templates stay in use.
+func reloadTemplates(dir string) error {
+	loaded, err := loadTemplates(dir)
+	if err != nil {
+		return err
+	}
+	templatesMu.Lock()
+	templates = loaded
+	templatesMu.Unlock()
+	return nil
+}
+
+// messageTemplates returns the current templates: those main loaded from
+// TEMPLATES_DIR at startup, or the built-ins until anything is loaded.
+func messageTemplates() map[string]*template.Template {
+	templatesMu.RLock()
+	current := templates
+	templatesMu.RUnlock()
+	if current != nil {
+		return current
+	}
+
+	builtins, _ := loadTemplates("")
+	templatesMu.Lock()
+	if templates == nil {
+		templates = builtins
+	}
+	templatesMu.Unlock()
+	templatesMu.RLock()
+	defer templatesMu.RUnlock()
+	return templates
+}
+
+// reloadTemplatesOn reloads the templates each time a signal arrives on
+// hangups, so dir, TEMPLATES_DIR, can be edited without a restart. A
+// reload with any template that fails to parse is rejected and logged.
+func reloadTemplatesOn(ctx context.Context, hangups <-chan os.Signal, dir string) {
+	for {
+		select {
+		case <-hangups:
+			if err := reloadTemplates(dir); err != nil {
+				log.Printf("Warning: template reload rejected, keeping the current templates: %v", err)
+				continue
+			}
+			log.Printf("Reloaded message templates")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// renderTemplate renders the named template with data. Missing keys render
+// as empty, so optional fields can be left out.
+func renderTemplate(name string, data map[string]interface{}) (string, error) {
+	t, ok := messageTemplates()[name]
+	if !ok {
+		return "", errUnknownTemplate
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("error rendering template %s: %v", name, err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
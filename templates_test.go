@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// useTemplatesDir returns a fresh templates directory and clears the
+// loaded templates until the test ends.
+func useTemplatesDir(t *testing.T) string {
+	t.Helper()
+	templatesMu.Lock()
+	orig := templates
+	templates = nil
+	templatesMu.Unlock()
+	t.Cleanup(func() {
+		templatesMu.Lock()
+		templates = orig
+		templatesMu.Unlock()
+	})
+
+	return t.TempDir()
+}
+
+func writeTemplate(t *testing.T, dir, name, text string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name+".tmpl"), []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func render(t *testing.T, name string, data map[string]interface{}) string {
+	t.Helper()
+	text, err := renderTemplate(name, data)
+	if err != nil {
+		t.Fatalf("rendering %s: %v", name, err)
+	}
+	return text
+}
+
+func TestTemplatesInitialLoad(t *testing.T) {
+	dir := useTemplatesDir(t)
+	writeTemplate(t, dir, "greeting", "Hello, {{.name}}")
+	writeTemplate(t, dir, "contact", "Contact from {{.name}}")
+	if err := reloadTemplates(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := render(t, "greeting", map[string]interface{}{"name": "<Ann>"}); got != "Hello, &lt;Ann&gt;" {
+		t.Errorf("greeting = %q", got)
+	}
+	if got := render(t, "contact", map[string]interface{}{"name": "Bo"}); got != "Contact from Bo" {
+		t.Errorf("a file didn't override the built-in contact template: %q", got)
+	}
+	if _, ok := messageTemplates()["new_signup"]; !ok {
+		t.Error("built-in templates weren't loaded")
+	}
+}
+
+func TestTemplatesInitialLoadWithBrokenFile(t *testing.T) {
+	dir := useTemplatesDir(t)
+	writeTemplate(t, dir, "greeting", "Hello, {{.name}}")
+	writeTemplate(t, dir, "broken", "{{.name")
+
+	if err := reloadTemplates(dir); err == nil {
+		t.Error("loading a directory with a broken template succeeded")
+	}
+
+	// Without a valid directory only the built-ins are served.
+	if _, err := renderTemplate("greeting", nil); err != errUnknownTemplate {
+		t.Errorf("rendering a template from a rejected directory: %v", err)
+	}
+	if _, ok := messageTemplates()["new_signup"]; !ok {
+		t.Error("built-in templates weren't loaded")
+	}
+}
+
+func TestTemplatesReloadOnSignal(t *testing.T) {
+	dir := useTemplatesDir(t)
+	writeTemplate(t, dir, "greeting", "Hello, {{.name}}")
+	if err := reloadTemplates(dir); err != nil {
+		t.Fatal(err)
+	}
+	if got := render(t, "greeting", map[string]interface{}{"name": "Ann"}); got != "Hello, Ann" {
+		t.Fatalf("greeting = %q", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hangups := make(chan os.Signal)
+	go reloadTemplatesOn(ctx, hangups, dir)
+
+	// A change is picked up.
+	writeTemplate(t, dir, "greeting", "Hi, {{.name}}")
+	writeTemplate(t, dir, "farewell", "Bye, {{.name}}")
+	hangups <- syscall.SIGHUP
+	waitForTemplate(t, "farewell")
+	if got := render(t, "greeting", map[string]interface{}{"name": "Ann"}); got != "Hi, Ann" {
+		t.Errorf("greeting after reload = %q, want %q", got, "Hi, Ann")
+	}
+
+	// A reload with a broken file keeps what was loaded.
+	writeTemplate(t, dir, "greeting", "Hey, {{.name}}")
+	writeTemplate(t, dir, "broken", "{{if}}")
+	hangups <- syscall.SIGHUP
+	hangups <- syscall.SIGHUP // returns once the first reload is done
+	if got := render(t, "greeting", map[string]interface{}{"name": "Ann"}); got != "Hi, Ann" {
+		t.Errorf("greeting after a rejected reload = %q, want %q", got, "Hi, Ann")
+	}
+	if _, ok := messageTemplates()["broken"]; ok {
+		t.Error("a broken template was loaded")
+	}
+}
+
+// waitForTemplate waits until a template named name is loaded.
+func waitForTemplate(t *testing.T, name string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := messageTemplates()[name]; ok {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("template %s was never loaded", name)
+}
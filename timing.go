@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type timingsKey struct{}
+
+// requestTimings accumulates how long a request spent in each phase. Phases
+// recorded more than once, such as upstream calls that were retried, are
+// summed. durations are measured with time.Since, which uses the monotonic
+// clock.
+type requestTimings struct {
+	start time.Time
+
+	mu        sync.Mutex
+	names     []string
+	durations map[string]time.Duration
+}
+
+func withTimings(ctx context.Context) (context.Context, *requestTimings) {
+	t := &requestTimings{start: time.Now(), durations: make(map[string]time.Duration)}
+	return context.WithValue(ctx, timingsKey{}, t), t
+}
+
+// timingsFrom returns the request's timings, or nil when the request isn't
+// being timed. All methods are no-ops on a nil receiver.
+func timingsFrom(ctx context.Context) *requestTimings {
+	t, _ := ctx.Value(timingsKey{}).(*requestTimings)
+	return t
+}
+
+// track starts timing phase name and returns the function that stops it.
+func (t *requestTimings) track(name string) func() {
+	if t == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		d := time.Since(start)
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if _, ok := t.durations[name]; !ok {
+			t.names = append(t.names, name)
+		}
+		t.durations[name] += d
+	}
+}
+
+// breakdown reports each phase and the total so far in milliseconds.
+func (t *requestTimings) breakdown() map[string]float64 {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make(map[string]float64, len(t.durations)+1)
+	for name, d := range t.durations {
+		out[name] = milliseconds(d)
+	}
+	out["total"] = milliseconds(time.Since(t.start))
+	return out
+}
+
+// header formats the breakdown in Server-Timing syntax, e.g.
+// "validation;dur=0.12, upstream;dur=183.4, total;dur=183.9".
+func (t *requestTimings) header() string {
+	if t == nil {
+		return ""
+	}
+
+	t.mu.Lock()
+	parts := make([]string, 0, len(t.names)+1)
+	for _, name := range t.names {
+		parts = append(parts, fmt.Sprintf("%s;dur=%.2f", name, milliseconds(t.durations[name])))
+	}
+	t.mu.Unlock()
+
+	parts = append(parts, fmt.Sprintf("total;dur=%.2f", milliseconds(time.Since(t.start))))
+	return strings.Join(parts, ", ")
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimingBreakdownSumsToTotal(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+	defer func(orig TelegramAPI) { telegram = orig }(telegram)
+	telegram = telegramFunc(func(method string, body []byte) (json.RawMessage, error) {
+		time.Sleep(40 * time.Millisecond)
+		return fake.Call(context.Background(), "", method, "application/json", body)
+	})
+
+	rec := serve(sendHandler, http.MethodPost, "/send", `{"message":"timed","verbose":true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Timing map[string]float64 `json:"timing"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	var phases float64
+	for _, name := range []string{"validation", "rate_limit", "upstream"} {
+		d, ok := resp.Timing[name]
+		if !ok {
+			t.Errorf("timing = %v, missing %s", resp.Timing, name)
+		}
+		phases += d
+	}
+	total := resp.Timing["total"]
+	if resp.Timing["upstream"] < 40 {
+		t.Errorf("upstream = %.2fms, want at least the 40ms Telegram took", resp.Timing["upstream"])
+	}
+	// The phases cover nearly all of the request; what's left is decoding
+	// and building the response.
+	if phases > total || total-phases > 10 {
+		t.Errorf("phases sum to %.2fms of a %.2fms total", phases, total)
+	}
+
+	// X-Timing carries the same phases in Server-Timing syntax.
+	header := rec.Header().Get("X-Timing")
+	var headerTotal float64
+	for _, part := range strings.Split(header, ", ") {
+		name, dur, ok := strings.Cut(part, ";dur=")
+		if !ok {
+			t.Fatalf("X-Timing = %q, malformed part %q", header, part)
+		}
+		if name == "total" {
+			headerTotal, _ = strconv.ParseFloat(dur, 64)
+		}
+	}
+	if headerTotal < phases {
+		t.Errorf("X-Timing total = %.2fms, want at least the %.2fms of phases", headerTotal, phases)
+	}
+}
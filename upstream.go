@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UpstreamError is returned when Telegram or Beehiiv answers with a non-success
+// status code. Message is the upstream's own explanation, when it gave one.
+type UpstreamError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Message    string
+
+	body string
+}
+
+func (e *UpstreamError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("unexpected status code: %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+}
+
+// isClientError reports whether the upstream rejected the request itself,
+// as opposed to our credentials, its rate limit or its own availability.
+func (e *UpstreamError) isClientError() bool {
+	switch e.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		return false
+	}
+	return e.StatusCode >= 400 && e.StatusCode < 500
+}
+
+// newUpstreamError reads the failed response's body, so callers must not
+// have consumed it.
+func newUpstreamError(resp *http.Response) *UpstreamError {
+	upErr := &UpstreamError{StatusCode: resp.StatusCode}
+	if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+		upErr.RetryAfter = d
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err == nil {
+		upErr.body = string(body)
+		upErr.Message = upstreamErrorMessage(body)
+	}
+	if location := resp.Header.Get("Location"); upErr.Message == "" && resp.StatusCode >= 300 && resp.StatusCode < 400 && location != "" {
+		upErr.Message = "redirected to " + location
+	}
+	return upErr
+}
+
+// upstreamErrorMessage extracts the human-readable error from a Telegram
+// ({"description": ...}) or Beehiiv ({"errors": [{"message": ...}]}) error
+// body.
+func upstreamErrorMessage(body []byte) string {
+	var parsed struct {
+		Description string `json:"description"`
+		Message     string `json:"message"`
+		Errors      []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return ""
+	}
+
+	var messages []string
+	for _, e := range parsed.Errors {
+		if e.Message != "" {
+			messages = append(messages, e.Message)
+		}
+	}
+	switch {
+	case len(messages) > 0:
+		return strings.Join(messages, "; ")
+	case parsed.Description != "":
+		return parsed.Description
+	default:
+		return parsed.Message
+	}
+}
+
+// parseRetryAfter accepts both forms allowed by RFC 9110: a number of
+// seconds or an HTTP-date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		d := t.Sub(now)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+
+	return 0, false
+}
+
+// writeUpstreamError reports a failed upstream call to the client as 502.
+// An upstream 503 or 429 is reported as 503 with its Retry-After, so callers
+// can back off: the upstream's rate limit is ours to wait out, not the
+// client's mistake. A request that ran past its deadline is reported as
+// 503 too. When the upstream rejected the request itself its message is
+// shown with a 400, while upstream server errors only tell the client to
+// retry later.
+//
+// Other errors are logged rather than shown: they can carry the request
+// URL, and with it the bot token.
+func writeUpstreamError(w http.ResponseWriter, err error) {
+	var circuitErr *CircuitOpenError
+	if errors.As(err, &circuitErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(circuitErr.RetryAfter.Seconds()))))
+		writeJSON(w, http.StatusServiceUnavailable, ErrorResponse{Message: "Upstream service is temporarily unavailable", Code: "upstream_circuit_open"})
+		return
+	}
+
+	if isUnreachable(err) {
+		writeJSON(w, http.StatusBadGateway, ErrorResponse{Message: "Upstream service is unreachable", Code: "upstream_unreachable"})
+		return
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeJSON(w, http.StatusServiceUnavailable, ErrorResponse{Message: "Upstream service did not respond in time", Code: "upstream_timeout"})
+		return
+	}
+
+	var upErr *UpstreamError
+	if errors.As(err, &upErr) && (upErr.StatusCode == http.StatusServiceUnavailable || upErr.StatusCode == http.StatusTooManyRequests) {
+		if upErr.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(upErr.RetryAfter.Seconds()))))
+		}
+		if upErr.StatusCode == http.StatusTooManyRequests {
+			writeJSON(w, http.StatusServiceUnavailable, ErrorResponse{Message: "Upstream rate limit reached, please retry later", Code: "upstream_rate_limited"})
+			return
+		}
+		writeJSON(w, http.StatusServiceUnavailable, ErrorResponse{Message: "Upstream service is temporarily unavailable", Code: "upstream_unavailable"})
+		return
+	}
+
+	if upErr != nil && upErr.isClientError() && upErr.Message != "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{
+			Message: upErr.Message,
+			Code:    "upstream_rejected",
+			Details: map[string]int{"upstream_status": upErr.StatusCode},
+		})
+		return
+	}
+	if upErr != nil && upErr.StatusCode >= 500 {
+		writeJSON(w, http.StatusBadGateway, ErrorResponse{Message: "Upstream service error, please retry later", Code: "upstream_unavailable"})
+		return
+	}
+
+	log.Printf("Warning: upstream request failed: %v", err)
+	writeError(w, http.StatusBadGateway, "upstream_error", "Upstream request failed")
+}
+
+// isUnreachable reports whether err means the upstream host could not be
+// resolved, as opposed to the upstream answering with an error.
+func isUnreachable(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+var errAlreadySubscribed = errors.New("email is already subscribed")
+
+// isAlreadySubscribed reports whether a failed Beehiiv response says the
+// email already has a subscription.
+func isAlreadySubscribed(upErr *UpstreamError) bool {
+	if upErr.StatusCode != http.StatusBadRequest && upErr.StatusCode != http.StatusConflict && upErr.StatusCode != http.StatusUnprocessableEntity {
+		return false
+	}
+
+	text := strings.ToLower(upErr.body)
+	return strings.Contains(text, "already subscribed") || strings.Contains(text, "already exists")
+}
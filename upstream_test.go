@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteUpstreamErrorHidesBotToken(t *testing.T) {
+	const token = "123456:secret-bot-token"
+
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name    string
+		baseURL string
+		status  int
+	}{
+		{"deadline", slow.URL, http.StatusServiceUnavailable},
+		{"connection refused", closed.URL, http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			_, err := newBotAPIClient(tt.baseURL, http.DefaultClient).Call(ctx, token, "sendMessage", "application/json", []byte("{}"))
+			if err == nil {
+				t.Fatal("Call succeeded, want an error")
+			}
+			if strings.Contains(err.Error(), token) {
+				t.Errorf("error %q contains the bot token", err)
+			}
+
+			rec := httptest.NewRecorder()
+			writeUpstreamError(rec, err)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if body := rec.Body.String(); strings.Contains(body, token) || strings.Contains(body, tt.baseURL) {
+				t.Errorf("response %s leaks the upstream URL", body)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{" 0 ", 0, true},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, true},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{"", 0, false},
+		{"-5", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestWriteUpstreamErrorPassesRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter string
+		want       string
+	}{
+		{"seconds", "30", "30"},
+		{"HTTP-date", time.Now().Add(2 * time.Minute).UTC().Format(http.TimeFormat), "120"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Retry-After", tt.retryAfter)
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`{"ok":false,"description":"Service Unavailable"}`))
+			}))
+			defer upstream.Close()
+
+			_, err := newBotAPIClient(upstream.URL, upstream.Client()).Call(context.Background(), "1:test", "sendMessage", "application/json", []byte("{}"))
+			rec := httptest.NewRecorder()
+			writeUpstreamError(rec, err)
+
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Errorf("status = %d, want 503", rec.Code)
+			}
+			// An HTTP-date is rounded up to whole seconds from now, so it
+			// may come out a second short.
+			got := rec.Header().Get("Retry-After")
+			if got != tt.want && !(tt.name == "HTTP-date" && got == "119") {
+				t.Errorf("Retry-After = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteUpstreamErrorMapsStatuses(t *testing.T) {
+	tests := []struct {
+		upstream   int
+		retryAfter time.Duration
+		status     int
+		code       string
+	}{
+		{http.StatusTooManyRequests, 7 * time.Second, http.StatusServiceUnavailable, "upstream_rate_limited"},
+		{http.StatusServiceUnavailable, 0, http.StatusServiceUnavailable, "upstream_unavailable"},
+		{http.StatusBadRequest, 0, http.StatusBadRequest, "upstream_rejected"},
+		{http.StatusUnauthorized, 0, http.StatusBadGateway, "upstream_error"},
+		{http.StatusInternalServerError, 0, http.StatusBadGateway, "upstream_unavailable"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		writeUpstreamError(rec, &UpstreamError{StatusCode: tt.upstream, RetryAfter: tt.retryAfter, Message: "Too Many Requests: retry after 7"})
+		if rec.Code != tt.status || decodeError(t, rec).Code != tt.code {
+			t.Errorf("upstream %d: got %d %s, want %d %s", tt.upstream, rec.Code, rec.Body, tt.status, tt.code)
+		}
+		if got := rec.Header().Get("Retry-After"); tt.retryAfter > 0 && got != "7" {
+			t.Errorf("upstream %d: Retry-After = %q, want 7", tt.upstream, got)
+		}
+	}
+}
+
+// useUpstreamServers points the Telegram and Beehiiv clients at httptest
+// servers running the given handlers until the test ends.
+func useUpstreamServers(t *testing.T, telegramHandler, beehiivHandler http.HandlerFunc) {
+	t.Helper()
+	tg := httptest.NewServer(telegramHandler)
+	bh := httptest.NewServer(beehiivHandler)
+	t.Cleanup(func() {
+		tg.Close()
+		bh.Close()
+	})
+
+	origTelegram, origBeehiiv := telegram, beehiiv
+	origTelegramURL, origBeehiivURL := telegramAPIBaseURL, beehiivAPIBaseURL
+	t.Cleanup(func() {
+		telegram, beehiiv = origTelegram, origBeehiiv
+		telegramAPIBaseURL, beehiivAPIBaseURL = origTelegramURL, origBeehiivURL
+	})
+	telegramAPIBaseURL, beehiivAPIBaseURL = tg.URL, bh.URL
+	telegram = newBotAPIClient(telegramAPIBaseURL, tg.Client())
+	beehiiv = newBeehiivAPIClient(beehiivAPIBaseURL, "", "pub_test", "key", bh.Client())
+}
+
+// respondWith answers every request with status and body.
+func respondWith(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+func TestSendAndSubscribeAgainstUpstreams(t *testing.T) {
+	config := configWith(t, "UPSTREAM_MAX_ATTEMPTS", "1")
+	send, subscribe := bind(handleSendMessage, config), bind(handleSubscribe, config)
+	telegramOK := respondWith(http.StatusOK, `{"ok":true,"result":{"message_id":42,"chat":{"id":5}}}`)
+	telegramDown := respondWith(http.StatusInternalServerError, `{"ok":false,"error_code":500,"description":"Internal Server Error"}`)
+	beehiivOK := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/v2/publications/pub_test/subscriptions" {
+			respondWith(http.StatusCreated, `{"data":{"id":"sub_42"}}`)(w, r)
+			return
+		}
+		respondWith(http.StatusNotFound, `{"errors":[{"message":"Not found"}]}`)(w, r)
+	}
+	beehiivDown := respondWith(http.StatusInternalServerError, `{"errors":[{"message":"Internal error"}]}`)
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		telegram http.HandlerFunc
+		beehiiv  http.HandlerFunc
+		body     string
+		status   int
+		want     map[string]interface{}
+	}{
+		{"send", send, telegramOK, beehiivOK, `{"message":"hello"}`, http.StatusOK,
+			map[string]interface{}{"status": "Message sent successfully", "message_id": 42.0}},
+		{"send, empty message", send, telegramOK, beehiivOK, `{"message":""}`, http.StatusBadRequest,
+			map[string]interface{}{"message": "Message cannot be empty", "code": "message_empty"}},
+		{"send, malformed JSON", send, telegramOK, beehiivOK, `{"message":"hello"`, http.StatusBadRequest,
+			map[string]interface{}{"message": "Invalid request body", "code": "invalid_request"}},
+		{"send, Telegram down", send, telegramDown, beehiivOK, `{"message":"hello"}`, http.StatusBadGateway,
+			map[string]interface{}{"code": "upstream_unavailable"}},
+		{"subscribe", subscribe, telegramOK, beehiivOK, `{"email":"table@example.com"}`, http.StatusOK,
+			map[string]interface{}{"status": "Subscription successful", "id": "sub_42"}},
+		{"subscribe, empty email", subscribe, telegramOK, beehiivOK, `{"email":""}`, http.StatusBadRequest,
+			map[string]interface{}{"message": "Email cannot be empty", "code": "validation_failed"}},
+		{"subscribe, malformed JSON", subscribe, telegramOK, beehiivOK, `{"email":`, http.StatusBadRequest,
+			map[string]interface{}{"message": "Invalid request body", "code": "invalid_request"}},
+		{"subscribe, Beehiiv down", subscribe, telegramOK, beehiivDown, `{"email":"table-down@example.com"}`, http.StatusBadGateway,
+			map[string]interface{}{"code": "upstream_unavailable"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useUpstreamServers(t, tt.telegram, tt.beehiiv)
+
+			rec := serve(tt.handler, http.MethodPost, "/", tt.body)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+
+			var body map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body %s: %v", rec.Body, err)
+			}
+			for key, want := range tt.want {
+				if body[key] != want {
+					t.Errorf("%s = %v, want %v in %s", key, body[key], want, rec.Body)
+				}
+			}
+			if _, ok := body["code"]; ok != (tt.status >= 400) {
+				t.Errorf("body %s: code field present = %v with status %d", rec.Body, ok, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// filterUserAgents rejects requests whose User-Agent is empty or contains
+// any of the blocklisted substrings (matched case-insensitively).
+func filterUserAgents(next http.Handler, blocklist []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !userAgentAllowed(r.UserAgent(), blocklist) {
+			writeError(w, http.StatusForbidden, "forbidden", "Forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func userAgentAllowed(ua string, blocklist []string) bool {
+	ua = strings.ToLower(strings.TrimSpace(ua))
+	if ua == "" {
+		return false
+	}
+	for _, blocked := range blocklist {
+		if strings.Contains(ua, blocked) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseUserAgentBlocklist(value string) []string {
+	var blocklist []string
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry != "" {
+			blocklist = append(blocklist, entry)
+		}
+	}
+	return blocklist
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFilterUserAgents(t *testing.T) {
+	handler := filterUserAgents(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), parseUserAgentBlocklist(" curl , Python-Requests,,"))
+
+	tests := []struct {
+		name      string
+		userAgent string
+		status    int
+	}{
+		{"browser", "Mozilla/5.0 (X11; Linux x86_64) Firefox/128.0", http.StatusNoContent},
+		{"empty", "", http.StatusForbidden},
+		{"blank", "   ", http.StatusForbidden},
+		{"blocklisted", "curl/8.5.0", http.StatusForbidden},
+		{"blocklisted, other case", "python-requests/2.31", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("User-Agent", tt.userAgent)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
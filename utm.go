@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// mergeQueryUTM fills in UTM parameters and referring_site for /subscribe
+// from the query string. Precedence is: a non-empty value in the JSON body wins; otherwise
+// the first non-empty query value is used. Values are trimmed, so repeated
+// or padded duplicates (e.g. ?utm_source=x&utm_source=x) collapse to one.
+func mergeQueryUTM(req *SubscribeRequest, query url.Values) {
+	req.UTMSource = pickUTM(req.UTMSource, query["utm_source"])
+	req.UTMMedium = pickUTM(req.UTMMedium, query["utm_medium"])
+	req.ReferringSite = pickUTM(req.ReferringSite, query["referring_site"])
+}
+
+func pickUTM(body string, query []string) string {
+	if body = strings.TrimSpace(body); body != "" {
+		return body
+	}
+	for _, v := range query {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
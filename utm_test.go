@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestMergeQueryUTM(t *testing.T) {
+	tests := []struct {
+		name                    string
+		body                    SubscribeRequest
+		query                   string
+		source, medium, referer string
+	}{
+		{"body only", SubscribeRequest{UTMSource: "news", UTMMedium: "email"}, "", "news", "email", ""},
+		{"query only", SubscribeRequest{}, "utm_source=ads&utm_medium=cpc&referring_site=example.org", "ads", "cpc", "example.org"},
+		{"body wins", SubscribeRequest{UTMSource: "news", ReferringSite: "blog.example"}, "utm_source=ads&utm_medium=cpc&referring_site=example.org", "news", "cpc", "blog.example"},
+		{"blank body falls back", SubscribeRequest{UTMSource: "  ", ReferringSite: " "}, "utm_source=ads&referring_site=example.org", "ads", "", "example.org"},
+		{"duplicates collapse", SubscribeRequest{}, "utm_source=+ads+&utm_source=ads&utm_source=other", "ads", "", ""},
+		{"first non-empty query value", SubscribeRequest{}, "utm_source=&utm_source=ads&referring_site=&referring_site=example.org", "ads", "", "example.org"},
+		{"trimmed", SubscribeRequest{UTMSource: " news ", UTMMedium: "\temail\n", ReferringSite: " example.org "}, "", "news", "email", "example.org"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := tt.body
+			mergeQueryUTM(&req, query)
+			if req.UTMSource != tt.source || req.UTMMedium != tt.medium {
+				t.Errorf("utm_source = %q, utm_medium = %q, want %q and %q", req.UTMSource, req.UTMMedium, tt.source, tt.medium)
+			}
+			if req.ReferringSite != tt.referer {
+				t.Errorf("referring_site = %q, want %q", req.ReferringSite, tt.referer)
+			}
+		})
+	}
+}
+
+func TestSubscribeForwardsMergedUTM(t *testing.T) {
+	_, fake := useFakeUpstreams(t)
+
+	rec := serve(subscribeHandler, http.MethodPost, "/subscribe?utm_source=ads&utm_source=ads&utm_medium=cpc&referring_site=example.org", `{"email":"utm@example.com","utm_source":"news"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	calls := fake.Calls()
+	var sent struct {
+		UTMSource     string `json:"utm_source"`
+		UTMMedium     string `json:"utm_medium"`
+		ReferringSite string `json:"referring_site"`
+	}
+	if err := json.Unmarshal(calls[len(calls)-1].Body, &sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent.UTMSource != "news" || sent.UTMMedium != "cpc" || sent.ReferringSite != "example.org" {
+		t.Errorf("sent utm_source = %q, utm_medium = %q, referring_site = %q, want news, cpc and example.org", sent.UTMSource, sent.UTMMedium, sent.ReferringSite)
+	}
+}
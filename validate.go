@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// defaultMaxRequestBytes caps every request body, whatever the route. It
+// is as large as the largest per-route limit (API_SIGNATURE_MAX_BYTES and
+// IDEMPOTENCY_MAX_BYTES), which still apply on top.
+const defaultMaxRequestBytes = 16 << 20
+
+// maxMessageLength is the most UTF-16 code units Telegram takes in a
+// message's text.
+const maxMessageLength = 4096
+
+// withBodyLimit caps request bodies at limit bytes. A declared
+// Content-Length over the cap is answered with 413 before any handler
+// reads the body; bodies of unknown length fail once they pass it.
+func withBodyLimit(next http.Handler, limit int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > int64(limit) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, ErrorResponse{Message: "Request body is too large", Code: "body_too_large"})
+			return
+		}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = http.MaxBytesReader(w, r.Body, int64(limit))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// FieldError is one invalid field of a request, listed under "fields" in
+// a validation_failed error's details.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// fieldErrors collects every problem with a request instead of stopping
+// at the first, so a form can flag all of its bad fields at once.
+type fieldErrors []FieldError
+
+func (e *fieldErrors) add(field, message string) {
+	*e = append(*e, FieldError{Field: field, Message: message})
+}
+
+// text checks that a required field is present and at most max characters
+// long. label names the field in messages, e.g. "Name".
+func (e *fieldErrors) text(field, label, value string, max int) {
+	switch {
+	case value == "":
+		e.add(field, label+" cannot be empty")
+	case utf8.RuneCountInString(value) > max:
+		e.add(field, fmt.Sprintf("%s is longer than %d characters", label, max))
+	}
+}
+
+// email checks a required email address and returns it normalized.
+func (e *fieldErrors) email(field, value string) string {
+	if value == "" {
+		e.add(field, "Email cannot be empty")
+		return ""
+	}
+	email, ok := normalizeEmail(value)
+	if !ok {
+		e.add(field, "Email address is invalid")
+	}
+	return email
+}
+
+// message checks that a message text fits max, e.g. maxMessageLength.
+func (e *fieldErrors) message(field, value string, max int) {
+	if messageLength(value) > max {
+		e.add(field, fmt.Sprintf("Message is longer than %d characters", max))
+	}
+}
+
+// messageLength counts s in UTF-16 code units, which is how Telegram,
+// Slack and Discord all measure their limits.
+func messageLength(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+// write answers with 400 validation_failed when there are errors, with
+// the first one as the message, and reports whether it did.
+func (e fieldErrors) write(w http.ResponseWriter) bool {
+	if len(e) == 0 {
+		return false
+	}
+	writeJSON(w, http.StatusBadRequest, ErrorResponse{
+		Message: e[0].Message,
+		Code:    "validation_failed",
+		Details: map[string][]FieldError{"fields": e},
+	})
+	return true
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// VenueRequest goes to the chat named by ChatID or Target, resolved like
+// /send's, or to the configured chat.
+type VenueRequest struct {
+	ChatID        string   `json:"chat_id,omitempty"`
+	Target        string   `json:"target,omitempty"`
+	Latitude      *float64 `json:"latitude"`
+	Longitude     *float64 `json:"longitude"`
+	Title         string   `json:"title"`
+	Address       string   `json:"address"`
+	FoursquareID  string   `json:"foursquare_id,omitempty"`
+	GooglePlaceID string   `json:"google_place_id,omitempty"`
+}
+
+type TelegramVenue struct {
+	ChatID        string  `json:"chat_id"`
+	Latitude      float64 `json:"latitude"`
+	Longitude     float64 `json:"longitude"`
+	Title         string  `json:"title"`
+	Address       string  `json:"address"`
+	FoursquareID  string  `json:"foursquare_id,omitempty"`
+	GooglePlaceID string  `json:"google_place_id,omitempty"`
+}
+
+type ContactRequest struct {
+	PhoneNumber string `json:"phone_number"`
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name,omitempty"`
+	VCard       string `json:"vcard,omitempty"`
+}
+
+type TelegramContact struct {
+	ChatID      string `json:"chat_id"`
+	PhoneNumber string `json:"phone_number"`
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name,omitempty"`
+	VCard       string `json:"vcard,omitempty"`
+}
+
+func validateVenue(req VenueRequest) string {
+	switch {
+	case req.Latitude == nil || req.Longitude == nil:
+		return "Latitude and longitude are required"
+	case *req.Latitude < -90 || *req.Latitude > 90:
+		return "Latitude must be between -90 and 90"
+	case *req.Longitude < -180 || *req.Longitude > 180:
+		return "Longitude must be between -180 and 180"
+	case req.Title == "":
+		return "Title cannot be empty"
+	case req.Address == "":
+		return "Address cannot be empty"
+	}
+	return ""
+}
+
+func handleSendVenue(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var req VenueRequest
+	if !decodeBody(w, r, &req, config) {
+		return
+	}
+
+	req.Title = strings.TrimSpace(req.Title)
+	req.Address = strings.TrimSpace(req.Address)
+	if msg := validateVenue(req); msg != "" {
+		writeError(w, http.StatusBadRequest, "invalid_venue", msg)
+		return
+	}
+
+	chatID, warning, msg := chatIDFor(MessageRequest{ChatID: req.ChatID, Target: req.Target}, config)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, "invalid_chat_target", msg)
+		return
+	}
+	config.ChatID = chatID
+
+	if err := paceChat(r.Context(), config); err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+
+	_, err := callTelegram(r.Context(), config, "sendVenue", TelegramVenue{
+		ChatID:        config.ChatID,
+		Latitude:      *req.Latitude,
+		Longitude:     *req.Longitude,
+		Title:         req.Title,
+		Address:       req.Address,
+		FoursquareID:  req.FoursquareID,
+		GooglePlaceID: req.GooglePlaceID,
+	})
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+
+	resp := map[string]string{"status": "Venue sent successfully"}
+	if warning != "" {
+		resp["warning"] = warning
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func handleSendContact(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var req ContactRequest
+	if !decodeBody(w, r, &req, config) {
+		return
+	}
+
+	if req.PhoneNumber == "" {
+		writeError(w, http.StatusBadRequest, "phone_number_required", "Phone number cannot be empty")
+		return
+	}
+
+	if req.FirstName == "" {
+		writeError(w, http.StatusBadRequest, "first_name_required", "First name cannot be empty")
+		return
+	}
+
+	if err := paceChat(r.Context(), config); err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+
+	_, err := callTelegram(r.Context(), config, "sendContact", TelegramContact{
+		ChatID:      config.ChatID,
+		PhoneNumber: req.PhoneNumber,
+		FirstName:   req.FirstName,
+		LastName:    req.LastName,
+		VCard:       req.VCard,
+	})
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "Contact sent successfully"})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestSendVenueAndContact(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+
+	venue := func(w http.ResponseWriter, r *http.Request) { handleSendVenue(w, r, testConfig) }
+	contact := func(w http.ResponseWriter, r *http.Request) { handleSendContact(w, r, testConfig) }
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+		status  int
+		method  string
+		message string
+	}{
+		{"venue", venue, `{"latitude":52.37,"longitude":4.89,"title":"Depot","address":"Main St 1"}`, http.StatusOK, "sendVenue", ""},
+		{"venue without coordinates", venue, `{"title":"Depot","address":"Main St 1"}`, http.StatusBadRequest, "", "Latitude and longitude are required"},
+		{"venue out of range", venue, `{"latitude":91,"longitude":4.89,"title":"Depot","address":"Main St 1"}`, http.StatusBadRequest, "", "Latitude must be between -90 and 90"},
+		{"venue without title", venue, `{"latitude":0,"longitude":0,"address":"Main St 1"}`, http.StatusBadRequest, "", "Title cannot be empty"},
+		{"venue without address", venue, `{"latitude":0,"longitude":0,"title":"Depot"}`, http.StatusBadRequest, "", "Address cannot be empty"},
+		{"venue with blank title", venue, `{"latitude":0,"longitude":0,"title":"  ","address":"Main St 1"}`, http.StatusBadRequest, "", "Title cannot be empty"},
+		{"venue with blank address", venue, `{"latitude":0,"longitude":0,"title":"Depot","address":"\t"}`, http.StatusBadRequest, "", "Address cannot be empty"},
+		{"contact", contact, `{"phone_number":"+31 20 123 4567","first_name":"Ada"}`, http.StatusOK, "sendContact", ""},
+		{"contact without phone", contact, `{"first_name":"Ada"}`, http.StatusBadRequest, "", "Phone number cannot be empty"},
+		{"contact without name", contact, `{"phone_number":"+31 20 123 4567"}`, http.StatusBadRequest, "", "First name cannot be empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(fake.Calls())
+			rec := serve(tt.handler, http.MethodPost, "/", tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+
+			calls := fake.Calls()[before:]
+			if tt.method == "" {
+				if got := decodeError(t, rec).Message; got != tt.message {
+					t.Errorf("error = %q, want %q", got, tt.message)
+				}
+				if len(calls) != 0 {
+					t.Errorf("an invalid request was sent to Telegram: %v", calls)
+				}
+				return
+			}
+			if len(calls) != 1 || calls[0].Method != tt.method {
+				t.Fatalf("calls = %v, want one %s", calls, tt.method)
+			}
+			var sent struct {
+				ChatID string `json:"chat_id"`
+			}
+			json.Unmarshal(calls[0].Body, &sent)
+			if sent.ChatID != testConfig.ChatID {
+				t.Errorf("sent to chat %q, want %q", sent.ChatID, testConfig.ChatID)
+			}
+		})
+	}
+}
+
+func TestSendVenueResolvesChat(t *testing.T) {
+	fake, _ := useFakeUpstreams(t)
+	venue := bind(handleSendVenue, configWith(t, "TELEGRAM_CHATS", "events:-100200", "TELEGRAM_ALLOWED_CHAT_IDS", "777,-100200"))
+	const place = `"latitude":52.37,"longitude":4.89,"title":" Depot ","address":"Main St 1"`
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		sentTo string
+	}{
+		{"target", `{"target":"events",` + place + `}`, http.StatusOK, "-100200"},
+		{"allowed chat", `{"chat_id":"777",` + place + `}`, http.StatusOK, "777"},
+		{"disallowed chat", `{"chat_id":"666",` + place + `}`, http.StatusBadRequest, ""},
+		{"unknown target", `{"target":"nope",` + place + `}`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(fake.Calls())
+			rec := serve(venue, http.MethodPost, "/send-venue", tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+
+			calls := fake.Calls()[before:]
+			if tt.status != http.StatusOK {
+				if got := decodeError(t, rec).Code; got != "invalid_chat_target" {
+					t.Errorf("code = %q, want invalid_chat_target", got)
+				}
+				if len(calls) != 0 {
+					t.Errorf("a rejected venue was sent: %v", calls)
+				}
+				return
+			}
+			var sent struct {
+				ChatID string `json:"chat_id"`
+				Title  string `json:"title"`
+			}
+			json.Unmarshal(calls[0].Body, &sent)
+			if sent.ChatID != tt.sentTo || sent.Title != "Depot" {
+				t.Errorf("sent %q to chat %q, want \"Depot\" to %q", sent.Title, sent.ChatID, tt.sentTo)
+			}
+		})
+	}
+}